@@ -22,6 +22,9 @@ type agentData struct {
 	Agent  agentConfig `json:"agent"`
 	Data   FraudDecision `json:"data"`
 	Reason string        `json:"reason"`
+	// Candidates are the Finya accounts the identifiers matched when the
+	// lookup found more than one, for the moderator to choose from.
+	Candidates []UserData `json:"candidates,omitempty"`
 }
 
 type agentError struct {
@@ -30,7 +33,12 @@ type agentError struct {
 }
 
 var providerRunners = map[string]agentRunner{
-	"openai": extractDataFromAttachment,
+	"openai":    extractDataFromAttachment,
+	"claude":    extractDataWithClaude,
+	"anthropic": extractDataWithClaude,
+	"gemini":    extractDataWithGemini,
+	"ollama":    extractDataWithLocalModel,
+	"local":     extractDataWithLocalModel,
 }
 
 func parseAgentList(raw string) []agentConfig {
@@ -148,11 +156,12 @@ func parseDecisionJSON(raw string) (*FraudDecision, error) {
 	}
 
 	errors := []string{}
-	if decision.Username == "" {
-		errors = append(errors, "missing username")
-	}
-	if decision.Email == "" {
-		errors = append(errors, "missing email")
+	// the single account fields are optional; an order that names no account
+	// is sent back for more information by checkRequiredInfo
+	for i, account := range decision.Accounts {
+		if account.Username == "" && account.Email == "" && account.UserID == "" {
+			errors = append(errors, fmt.Sprintf("account %d has no identifier", i+1))
+		}
 	}
 	if decision.AgencyName == "" {
 		errors = append(errors, "missing agencyName")
@@ -168,5 +177,121 @@ func parseDecisionJSON(raw string) (*FraudDecision, error) {
 		return nil, fmt.Errorf("invalid decision format: %s", strings.Join(errors, ", "))
 	}
 
+	for i := range decision.Evidence {
+		// some models answer in percent
+		if c := decision.Evidence[i].Confidence; c > 1 && c <= 100 {
+			decision.Evidence[i].Confidence = c / 100
+		}
+	}
+
+	if len(decision.Accounts) == 0 {
+		decision.Accounts = []TargetAccount{{Username: decision.Username, Email: decision.Email, UserID: decision.UserID}}
+	} else if decision.Username == "" && decision.Email == "" && decision.UserID == "" {
+		decision.Username = decision.Accounts[0].Username
+		decision.Email = decision.Accounts[0].Email
+		decision.UserID = decision.Accounts[0].UserID
+	}
+
+	decision.RawOutput = clean
+
 	return &decision, nil
 }
+
+// extractionOutputSchema is the JSON schema the models must answer with.
+func extractionOutputSchema() map[string]interface{} {
+	stringProperties := func(names ...string) map[string]interface{} {
+		properties := map[string]interface{}{}
+		for _, name := range names {
+			properties[name] = map[string]interface{}{"type": "string"}
+		}
+		return properties
+	}
+	object := func(names ...string) map[string]interface{} {
+		return map[string]interface{}{
+			"type":                 "object",
+			"required":             names,
+			"properties":           stringProperties(names...),
+			"additionalProperties": false,
+		}
+	}
+
+	properties := stringProperties("agencyName", "referenceNumber", "impossibilityReason", "impossibilityExplanation")
+	properties["date"] = map[string]interface{}{
+		"type":   "string",
+		"format": "date-time",
+	}
+	properties["accounts"] = map[string]interface{}{
+		"type":  "array",
+		"items": object("username", "email", "userId", "profileUrl"),
+	}
+	properties["contentItems"] = map[string]interface{}{
+		"type":  "array",
+		"items": object("kind", "url", "messageId", "pictureId", "description", "account"),
+	}
+	evidence := object("field", "value", "confidence", "snippet", "page", "source")
+	evidenceProperties := evidence["properties"].(map[string]interface{})
+	evidenceProperties["confidence"] = map[string]interface{}{"type": "number"}
+	evidenceProperties["page"] = map[string]interface{}{"type": "integer"}
+	properties["evidence"] = map[string]interface{}{
+		"type":  "array",
+		"items": evidence,
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"required":             []string{"agencyName", "referenceNumber", "date", "accounts", "contentItems", "evidence", "impossibilityReason", "impossibilityExplanation"},
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// splitByAccount turns every decision into one entry per targeted account so
+// each account is looked up, banned and reported on its own. Content items go
+// with the account they name; items that name none stay with the first
+// account so every item is reported once.
+func splitByAccount(data []agentData) []agentData {
+	var split []agentData
+	for _, item := range data {
+		accounts := item.Data.Accounts
+		if len(accounts) == 0 {
+			accounts = []TargetAccount{{Username: item.Data.Username, Email: item.Data.Email, UserID: item.Data.UserID}}
+		}
+
+		itemsByAccount := make([][]ContentItem, len(accounts))
+		for _, content := range item.Data.ContentItems {
+			owner := 0
+			for i, account := range accounts {
+				if account.matches(content.Account) {
+					owner = i
+					break
+				}
+			}
+			itemsByAccount[owner] = append(itemsByAccount[owner], content)
+		}
+
+		for i, account := range accounts {
+			entry := item
+			entry.Data.Username = account.Username
+			entry.Data.Email = account.Email
+			entry.Data.UserID = account.UserID
+			entry.Data.Accounts = []TargetAccount{account}
+			entry.Data.ContentItems = itemsByAccount[i]
+			split = append(split, entry)
+		}
+	}
+	return split
+}
+
+// matches reports whether identifier names this account.
+func (a TargetAccount) matches(identifier string) bool {
+	identifier = strings.TrimSpace(identifier)
+	if identifier == "" {
+		return false
+	}
+	for _, candidate := range []string{a.Username, a.Email, a.UserID, a.ProfileURL} {
+		if candidate != "" && strings.EqualFold(candidate, identifier) {
+			return true
+		}
+	}
+	return false
+}
@@ -101,9 +101,66 @@ func TestParseDecisionJSONErrorsOnMissingFields(t *testing.T) {
 		t.Fatal("expected error for missing fields, got nil")
 	}
 
-	for _, expected := range []string{"missing username", "missing email", "missing agencyName", "missing referenceNumber", "missing date"} {
+	for _, expected := range []string{"missing agencyName", "missing referenceNumber", "missing date"} {
 		if !strings.Contains(err.Error(), expected) {
 			t.Errorf("error %q does not contain expected substring %q", err.Error(), expected)
 		}
 	}
+	for _, unexpected := range []string{"missing username", "missing email"} {
+		if strings.Contains(err.Error(), unexpected) {
+			t.Errorf("error %q must not require %q", err.Error(), unexpected)
+		}
+	}
+}
+
+func TestParseDecisionJSONLeavesMissingAccountToMoreInfo(t *testing.T) {
+	emailOnly, err := parseDecisionJSON(`{"username":"","email":"jane@example.com","agencyName":"A","referenceNumber":"R","date":"D"}`)
+	if err != nil || emailOnly.Email != "jane@example.com" {
+		t.Fatalf("expected an order naming only an email to parse, got %+v (%v)", emailOnly, err)
+	}
+
+	decision, err := parseDecisionJSON(`{"username":"","email":"","agencyName":"A","referenceNumber":"R","date":"D","accounts":[],"contentItems":[{"url":"https://finya.de/p/1","messageId":"","pictureId":"","description":"","account":""}]}`)
+	if err != nil {
+		t.Fatalf("expected an order without an account to parse, got %v", err)
+	}
+	if ok, reason := checkRequiredInfo(agentData{Data: *decision}); ok || reason != "email and username are required" {
+		t.Fatalf("expected the missing account to need more info, got %v %q", ok, reason)
+	}
+}
+
+func TestParseDecisionJSONWithAccounts(t *testing.T) {
+	raw := `{"agencyName":"Agency","referenceNumber":"ref-2","date":"2024-01-02T03:04:05Z",
+		"accounts":[{"username":"first","email":"","userId":"","profileUrl":""},{"username":"","email":"second@example.com","userId":"","profileUrl":""}],
+		"contentItems":[{"url":"https://finya.de/p/1","messageId":"","description":"","account":"second@example.com"},{"url":"","messageId":"m-7","description":"","account":""}]}`
+
+	decision, err := parseDecisionJSON(raw)
+	if err != nil {
+		t.Fatalf("parseDecisionJSON returned error: %v", err)
+	}
+	if decision.Username != "first" || len(decision.Accounts) != 2 || len(decision.ContentItems) != 2 {
+		t.Fatalf("unexpected decision: %+v", decision)
+	}
+
+	split := splitByAccount([]agentData{{Data: *decision}})
+	if len(split) != 2 {
+		t.Fatalf("expected one entry per account, got %+v", split)
+	}
+	if split[0].Data.Username != "first" || len(split[0].Data.ContentItems) != 1 || split[0].Data.ContentItems[0].MessageID != "m-7" {
+		t.Fatalf("unassigned content must stay with the first account, got %+v", split[0].Data)
+	}
+	if split[1].Data.Email != "second@example.com" || len(split[1].Data.ContentItems) != 1 || split[1].Data.ContentItems[0].URL != "https://finya.de/p/1" {
+		t.Fatalf("content must go with the account it names, got %+v", split[1].Data)
+	}
+	if split[1].Data.ReferenceNumber != "ref-2" {
+		t.Fatalf("order details must be kept per account, got %+v", split[1].Data)
+	}
+
+	if _, err := parseDecisionJSON(`{"agencyName":"A","referenceNumber":"R","date":"D","accounts":[{"username":"","email":"","userId":"","profileUrl":""}]}`); err == nil || !strings.Contains(err.Error(), "account 1 has no identifier") {
+		t.Fatalf("expected account identifier error, got %v", err)
+	}
+
+	contentOnly, err := parseDecisionJSON(`{"agencyName":"A","referenceNumber":"R","date":"D","accounts":[],"contentItems":[{"url":"","messageId":"m-1","pictureId":"","description":"","account":""}]}`)
+	if err != nil || len(contentOnly.ContentItems) != 1 {
+		t.Fatalf("expected a content-only order to parse, got %+v (%v)", contentOnly, err)
+	}
 }
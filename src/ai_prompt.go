@@ -1,3 +1,3 @@
 package tco_vo_agent
 
-const defaultSystemPrompt = `{"job":"extract username, email, agencyName, referenceNumber from this ticket"}`
+const defaultSystemPrompt = `{"job":"extract agencyName, referenceNumber, date and every targeted account (username, email, userId, profileUrl) and content item (kind: message, profile_text or picture; url, messageId, pictureId, description, account) from this ticket; use empty strings for unknown values","evidence":"for every non-empty agencyName, referenceNumber, date, username, email and userId add an evidence entry with the field name, the value, your confidence between 0 and 1 that the value is written in the documents, the verbatim snippet it was read from, the page (0 if unknown) and the source document name; never guess values","impossibility":"set impossibilityReason to force_majeure, technical or manifest_error only when the order cannot be carried out as written, e.g. it contains manifest errors or names content that cannot exist on our service, and explain why in impossibilityExplanation; otherwise use empty strings"}`
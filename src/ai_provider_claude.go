@@ -0,0 +1,177 @@
+package tco_vo_agent
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultClaudeModel = "claude-sonnet-4-5"
+	claudeAPIVersion   = "2023-06-01"
+	claudeMaxTokens    = 4096
+	// claudeToolName is the tool the model is forced to call; its input is the decision.
+	claudeToolName = "record_removal_order"
+)
+
+type claudeResponse struct {
+	Content []struct {
+		Type  string          `json:"type"`
+		Name  string          `json:"name,omitempty"`
+		Input json.RawMessage `json:"input,omitempty"`
+		Text  string          `json:"text,omitempty"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+}
+
+func claudeBaseURL() string {
+	base := strings.TrimSpace(os.Getenv("CLAUDE_BASE_URL"))
+	if base == "" {
+		base = strings.TrimSpace(os.Getenv("ANTHROPIC_BASE_URL"))
+	}
+	if base == "" {
+		return "https://api.anthropic.com"
+	}
+	return strings.TrimRight(base, "/")
+}
+
+// extractDataWithClaude runs the extraction against the Anthropic Messages API.
+// The output schema is enforced by forcing the model to call a single tool.
+func extractDataWithClaude(systemPrompt string, attachmentPaths []string, model string) (*FraudDecision, error) {
+	apiKey := os.Getenv("CLAUDE_API_KEY")
+	if apiKey == "" {
+		apiKey = os.Getenv("ANTHROPIC_API_KEY")
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("CLAUDE_API_KEY environment variable not set")
+	}
+
+	model = strings.TrimSpace(model)
+	if model == "" {
+		model = os.Getenv("CLAUDE_MODEL")
+	}
+	if model == "" {
+		model = defaultClaudeModel
+	}
+	if systemPrompt == "" {
+		systemPrompt = defaultSystemPrompt
+	}
+
+	documents, err := loadOrderDocuments(attachmentPaths)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachments: %w", err)
+	}
+
+	content := []map[string]interface{}{}
+	for _, document := range documents {
+		switch document.Kind {
+		case documentKindPDF:
+			content = append(content, map[string]interface{}{
+				"type": "document",
+				"source": map[string]interface{}{
+					"type":       "base64",
+					"media_type": document.MimeType,
+					"data":       base64.StdEncoding.EncodeToString(document.Data),
+				},
+			})
+		case documentKindImage:
+			content = append(content, map[string]interface{}{
+				"type": "image",
+				"source": map[string]interface{}{
+					"type":       "base64",
+					"media_type": document.MimeType,
+					"data":       base64.StdEncoding.EncodeToString(document.Data),
+				},
+			})
+		default:
+			content = append(content, map[string]interface{}{
+				"type": "document",
+				"source": map[string]interface{}{
+					"type":       "text",
+					"media_type": "text/plain",
+					"data":       document.Text(),
+				},
+				"title": document.Name,
+			})
+		}
+	}
+	content = append(content, map[string]interface{}{
+		"type": "text",
+		"text": "Record the removal order contained in the documents above.",
+	})
+
+	requestBody := map[string]interface{}{
+		"model":      model,
+		"max_tokens": claudeMaxTokens,
+		"system":     systemPrompt,
+		"messages": []map[string]interface{}{
+			{"role": "user", "content": content},
+		},
+		"tools": []map[string]interface{}{{
+			"name":         claudeToolName,
+			"description":  "Records the details extracted from a TCO removal order.",
+			"input_schema": extractionOutputSchema(),
+		}},
+		"tool_choice": map[string]interface{}{"type": "tool", "name": claudeToolName},
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	client := &http.Client{
+		Timeout: 120 * time.Second,
+	}
+
+	const retries = 3
+	var resp *http.Response
+	for range retries {
+		req, err := http.NewRequest("POST", claudeBaseURL()+"/v1/messages", bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("x-api-key", apiKey)
+		req.Header.Set("anthropic-version", claudeAPIVersion)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err = client.Do(req)
+		if err == nil {
+			break
+		}
+		time.Sleep(1 * time.Second)
+	}
+
+	if resp == nil {
+		return nil, fmt.Errorf("no response from Claude")
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("Claude API returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var claudeResp claudeResponse
+	if err := json.Unmarshal(respBody, &claudeResp); err != nil {
+		return nil, fmt.Errorf("failed to parse Claude response: %w", err)
+	}
+
+	for _, block := range claudeResp.Content {
+		if block.Type == "tool_use" && block.Name == claudeToolName && len(block.Input) > 0 {
+			return parseDecisionJSON(string(block.Input))
+		}
+	}
+
+	return nil, fmt.Errorf("no %s tool call found in Claude response (stop_reason %s)", claudeToolName, claudeResp.StopReason)
+}
@@ -0,0 +1,103 @@
+package tco_vo_agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newFakeClaudeServer(t *testing.T, toolInput string, captured *map[string]interface{}) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" || r.Method != http.MethodPost {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("x-api-key"); got != "test-key" {
+			t.Errorf("x-api-key header = %q, want test-key", got)
+		}
+		if got := r.Header.Get("anthropic-version"); got != claudeAPIVersion {
+			t.Errorf("anthropic-version header = %q, want %s", got, claudeAPIVersion)
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, captured)
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"content":[{"type":"tool_use","id":"toolu_1","name":%q,"input":%s}],"stop_reason":"tool_use"}`, claudeToolName, toolInput)
+	}))
+}
+
+func TestExtractDataWithClaude(t *testing.T) {
+	var request map[string]interface{}
+	server := newFakeClaudeServer(t, `{"agencyName":"BKA","referenceNumber":"REF-1","date":"2024-12-01T10:00:00Z","accounts":[{"username":"jane_doe","email":"","userId":"","profileUrl":""}],"contentItems":[]}`, &request)
+	defer server.Close()
+
+	t.Setenv("CLAUDE_API_KEY", "test-key")
+	t.Setenv("CLAUDE_BASE_URL", server.URL)
+
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "order.pdf"), filepath.Join(dir, "scan.png"), filepath.Join(dir, "ticket.txt")}
+	os.WriteFile(paths[0], []byte("%PDF-1.4"), 0o600)
+	os.WriteFile(paths[1], []byte("\x89PNG"), 0o600)
+	os.WriteFile(paths[2], []byte("Username: jane_doe"), 0o600)
+
+	decision, err := extractDataWithClaude(defaultSystemPrompt, paths, "claude-test")
+	if err != nil {
+		t.Fatalf("extractDataWithClaude returned error: %v", err)
+	}
+	if decision.Username != "jane_doe" || decision.ReferenceNumber != "REF-1" {
+		t.Fatalf("unexpected decision: %+v", decision)
+	}
+
+	if request["model"] != "claude-test" || request["system"] != defaultSystemPrompt {
+		t.Fatalf("unexpected model or system prompt: %v / %v", request["model"], request["system"])
+	}
+	toolChoice, _ := request["tool_choice"].(map[string]interface{})
+	if toolChoice["type"] != "tool" || toolChoice["name"] != claudeToolName {
+		t.Fatalf("the tool must be forced, got tool_choice %v", request["tool_choice"])
+	}
+
+	raw, _ := json.Marshal(request["messages"])
+	for _, expected := range []string{
+		`"media_type":"application/pdf"`,
+		`"type":"image"`,
+		`"media_type":"image/png"`,
+		`"data":"Username: jane_doe"`,
+	} {
+		if !strings.Contains(string(raw), expected) {
+			t.Fatalf("messages missing %s: %s", expected, raw)
+		}
+	}
+}
+
+func TestExtractDataWithClaudeValidatesToolInput(t *testing.T) {
+	var request map[string]interface{}
+	server := newFakeClaudeServer(t, `{"agencyName":"","referenceNumber":"","date":"","accounts":[],"contentItems":[]}`, &request)
+	defer server.Close()
+
+	t.Setenv("CLAUDE_API_KEY", "")
+	t.Setenv("ANTHROPIC_API_KEY", "test-key")
+	t.Setenv("CLAUDE_MODEL", "")
+	t.Setenv("CLAUDE_BASE_URL", server.URL)
+
+	path := writeSampleEmail(t, sampleEmailMissingInfo)
+	t.Cleanup(func() { os.Remove(path) })
+
+	_, err := extractDataWithClaude("", []string{path}, "")
+	if err == nil || !strings.Contains(err.Error(), "missing agencyName") {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+	if request["model"] != defaultClaudeModel {
+		t.Fatalf("model = %v, want default %s", request["model"], defaultClaudeModel)
+	}
+	if _, ok := providerRunners["claude"]; !ok {
+		t.Fatal("claude provider is not registered")
+	}
+}
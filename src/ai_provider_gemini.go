@@ -0,0 +1,320 @@
+package tco_vo_agent
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultGeminiModel = "gemini-2.5-flash"
+	// requests are limited to 20 MB; larger attachments go through the file API
+	defaultGeminiInlineMaxBytes = 15 << 20
+	geminiFilePollAttempts      = 10
+)
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content struct {
+			Parts []struct {
+				Text string `json:"text"`
+			} `json:"parts"`
+		} `json:"content"`
+		FinishReason string `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+}
+
+type geminiFile struct {
+	Name     string `json:"name"`
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType"`
+	State    string `json:"state"`
+}
+
+func geminiBaseURL() string {
+	base := strings.TrimSpace(os.Getenv("GEMINI_BASE_URL"))
+	if base == "" {
+		return "https://generativelanguage.googleapis.com"
+	}
+	return strings.TrimRight(base, "/")
+}
+
+func geminiInlineMaxBytes() int {
+	raw := strings.TrimSpace(os.Getenv("GEMINI_INLINE_MAX_BYTES"))
+	if raw == "" {
+		return defaultGeminiInlineMaxBytes
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		log.Printf("Invalid GEMINI_INLINE_MAX_BYTES %q, using %d", raw, defaultGeminiInlineMaxBytes)
+		return defaultGeminiInlineMaxBytes
+	}
+	return n
+}
+
+// extractDataWithGemini runs the extraction against the Gemini generateContent API
+// with a response schema, so the answer is the decision JSON itself.
+func extractDataWithGemini(systemPrompt string, attachmentPaths []string, model string) (*FraudDecision, error) {
+	apiKey := os.Getenv("GEMINI_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("GEMINI_API_KEY environment variable not set")
+	}
+
+	model = strings.TrimSpace(model)
+	if model == "" {
+		model = os.Getenv("GEMINI_MODEL")
+	}
+	if model == "" {
+		model = defaultGeminiModel
+	}
+	if systemPrompt == "" {
+		systemPrompt = defaultSystemPrompt
+	}
+
+	documents, err := loadOrderDocuments(attachmentPaths)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachments: %w", err)
+	}
+
+	client := &http.Client{
+		Timeout: 120 * time.Second,
+	}
+
+	inlineBudget := geminiInlineMaxBytes()
+	parts := []map[string]interface{}{}
+	for _, document := range documents {
+		if document.Kind == documentKindText {
+			parts = append(parts, map[string]interface{}{"text": document.Text()})
+			continue
+		}
+
+		if len(document.Data) <= inlineBudget {
+			inlineBudget -= len(document.Data)
+			parts = append(parts, map[string]interface{}{
+				"inline_data": map[string]interface{}{
+					"mime_type": document.MimeType,
+					"data":      base64.StdEncoding.EncodeToString(document.Data),
+				},
+			})
+			continue
+		}
+
+		file, err := uploadGeminiFile(client, apiKey, document)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload file: %w", err)
+		}
+		// the prompt is done with the file once generateContent returned
+		defer deleteGeminiFile(client, apiKey, file.Name)
+		parts = append(parts, map[string]interface{}{
+			"file_data": map[string]interface{}{
+				"mime_type": file.MimeType,
+				"file_uri":  file.URI,
+			},
+		})
+	}
+
+	requestBody := map[string]interface{}{
+		"system_instruction": map[string]interface{}{
+			"parts": []map[string]interface{}{{"text": systemPrompt}},
+		},
+		"contents": []map[string]interface{}{
+			{"role": "user", "parts": parts},
+		},
+		"generationConfig": map[string]interface{}{
+			"responseMimeType": "application/json",
+			"responseSchema":   toGeminiSchema(extractionOutputSchema()),
+		},
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/v1beta/models/%s:generateContent", geminiBaseURL(), url.PathEscape(model))
+	respBody, err := doGeminiRequest(client, apiKey, "POST", endpoint, jsonData, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var geminiResp geminiResponse
+	if err := json.Unmarshal(respBody, &geminiResp); err != nil {
+		return nil, fmt.Errorf("failed to parse Gemini response: %w", err)
+	}
+	if geminiResp.PromptFeedback.BlockReason != "" {
+		return nil, fmt.Errorf("Gemini blocked the prompt: %s", geminiResp.PromptFeedback.BlockReason)
+	}
+
+	var buf []string
+	for _, candidate := range geminiResp.Candidates {
+		for _, part := range candidate.Content.Parts {
+			buf = append(buf, part.Text)
+		}
+		if len(buf) > 0 {
+			break
+		}
+	}
+	text := strings.Join(buf, "")
+	if text == "" {
+		return nil, fmt.Errorf("no decision text found in Gemini response")
+	}
+
+	return parseDecisionJSON(text)
+}
+
+// uploadGeminiFile stores a document with the resumable file API and waits
+// until it can be referenced from a prompt.
+func uploadGeminiFile(client *http.Client, apiKey string, document orderDocument) (*geminiFile, error) {
+	metadata, err := json.Marshal(map[string]interface{}{
+		"file": map[string]string{"display_name": document.Name},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	startURL := geminiBaseURL() + "/upload/v1beta/files"
+	req, err := http.NewRequest("POST", startURL, bytes.NewReader(metadata))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-goog-api-key", apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Goog-Upload-Protocol", "resumable")
+	req.Header.Set("X-Goog-Upload-Command", "start")
+	req.Header.Set("X-Goog-Upload-Header-Content-Length", strconv.Itoa(len(document.Data)))
+	req.Header.Set("X-Goog-Upload-Header-Content-Type", document.MimeType)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	uploadURL := resp.Header.Get("X-Goog-Upload-URL")
+	if resp.StatusCode >= 400 || uploadURL == "" {
+		return nil, fmt.Errorf("starting upload returned status %d without an upload URL", resp.StatusCode)
+	}
+
+	respBody, err := doGeminiRequest(client, apiKey, "POST", uploadURL, document.Data, map[string]string{
+		"X-Goog-Upload-Offset":  "0",
+		"X-Goog-Upload-Command": "upload, finalize",
+	})
+	if err != nil {
+		return nil, err
+	}
+	var uploaded struct {
+		File geminiFile `json:"file"`
+	}
+	if err := json.Unmarshal(respBody, &uploaded); err != nil {
+		return nil, fmt.Errorf("failed to parse upload response: %w", err)
+	}
+	file := uploaded.File
+
+	for attempt := 0; file.State == "PROCESSING" && attempt < geminiFilePollAttempts; attempt++ {
+		time.Sleep(1 * time.Second)
+		respBody, err := doGeminiRequest(client, apiKey, "GET", geminiBaseURL()+"/v1beta/"+file.Name, nil, nil)
+		if err != nil {
+			deleteGeminiFile(client, apiKey, uploaded.File.Name)
+			return nil, err
+		}
+		if err := json.Unmarshal(respBody, &file); err != nil {
+			deleteGeminiFile(client, apiKey, uploaded.File.Name)
+			return nil, fmt.Errorf("failed to parse file state: %w", err)
+		}
+	}
+	if file.State != "" && file.State != "ACTIVE" {
+		deleteGeminiFile(client, apiKey, file.Name)
+		return nil, fmt.Errorf("file %s is %s", file.Name, file.State)
+	}
+	if file.MimeType == "" {
+		file.MimeType = document.MimeType
+	}
+	return &file, nil
+}
+
+// deleteGeminiFile removes an uploaded file. Failures are only logged, the
+// file API drops files after 48 hours anyway.
+func deleteGeminiFile(client *http.Client, apiKey, name string) {
+	if _, err := doGeminiRequest(client, apiKey, "DELETE", geminiBaseURL()+"/v1beta/"+name, nil, nil); err != nil {
+		log.Printf("Failed to delete Gemini file %s: %v", name, err)
+	}
+}
+
+func doGeminiRequest(client *http.Client, apiKey, method, endpoint string, body []byte, headers map[string]string) ([]byte, error) {
+	const retries = 3
+	var resp *http.Response
+	for range retries {
+		req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("x-goog-api-key", apiKey)
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+
+		resp, err = client.Do(req)
+		if err == nil {
+			break
+		}
+		time.Sleep(1 * time.Second)
+	}
+
+	if resp == nil {
+		return nil, fmt.Errorf("no response from Gemini")
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("Gemini API returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+	return respBody, nil
+}
+
+// toGeminiSchema converts a JSON schema to the OpenAPI subset Gemini accepts:
+// upper-case types and no additionalProperties.
+func toGeminiSchema(schema map[string]interface{}) map[string]interface{} {
+	converted := map[string]interface{}{}
+	for key, value := range schema {
+		switch key {
+		case "additionalProperties":
+			continue
+		case "type":
+			if t, ok := value.(string); ok {
+				value = strings.ToUpper(t)
+			}
+		case "items":
+			if items, ok := value.(map[string]interface{}); ok {
+				value = toGeminiSchema(items)
+			}
+		case "properties":
+			if properties, ok := value.(map[string]interface{}); ok {
+				convertedProperties := map[string]interface{}{}
+				for name, property := range properties {
+					if p, ok := property.(map[string]interface{}); ok {
+						convertedProperties[name] = toGeminiSchema(p)
+					}
+				}
+				value = convertedProperties
+			}
+		}
+		converted[key] = value
+	}
+	return converted
+}
@@ -0,0 +1,140 @@
+package tco_vo_agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const geminiDecision = `{"agencyName":"BKA","referenceNumber":"REF-9","date":"2024-12-01T10:00:00Z","accounts":[{"username":"jane_doe","email":"","userId":"","profileUrl":""}],"contentItems":[]}`
+
+type fakeGemini struct {
+	t        *testing.T
+	url      string
+	request  map[string]interface{}
+	uploaded []string
+	deleted  []string
+	// failGenerate makes generateContent answer with an error
+	failGenerate bool
+}
+
+func (f *fakeGemini) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if got := r.Header.Get("x-goog-api-key"); got != "test-key" {
+		f.t.Errorf("x-goog-api-key header = %q, want test-key", got)
+	}
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.URL.Path == "/upload/v1beta/files" && r.Header.Get("X-Goog-Upload-Command") == "start":
+		w.Header().Set("X-Goog-Upload-URL", f.url+"/upload/session/1")
+		fmt.Fprint(w, `{}`)
+	case r.URL.Path == "/upload/session/1":
+		f.uploaded = append(f.uploaded, string(body))
+		fmt.Fprint(w, `{"file":{"name":"files/abc","uri":"https://files.example/abc","mimeType":"application/pdf","state":"ACTIVE"}}`)
+	case r.URL.Path == "/v1beta/files/abc" && r.Method == http.MethodDelete:
+		f.deleted = append(f.deleted, "files/abc")
+		fmt.Fprint(w, `{}`)
+	case r.URL.Path == "/v1beta/models/gemini-test:generateContent":
+		json.Unmarshal(body, &f.request)
+		if f.failGenerate {
+			http.Error(w, `{"error":{"message":"overloaded"}}`, http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, `{"candidates":[{"content":{"parts":[{"text":%q}]},"finishReason":"STOP"}]}`, geminiDecision)
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+	}
+}
+
+func useFakeGemini(t *testing.T) *fakeGemini {
+	t.Helper()
+	fake := &fakeGemini{t: t}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	fake.url = server.URL
+
+	t.Setenv("GEMINI_API_KEY", "test-key")
+	t.Setenv("GEMINI_BASE_URL", server.URL)
+	return fake
+}
+
+func writeGeminiFixtures(t *testing.T) []string {
+	t.Helper()
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "order.pdf"), filepath.Join(dir, "ticket.txt")}
+	os.WriteFile(paths[0], []byte("%PDF-1.4 order"), 0o600)
+	os.WriteFile(paths[1], []byte("Username: jane_doe"), 0o600)
+	return paths
+}
+
+func TestExtractDataWithGeminiInline(t *testing.T) {
+	fake := useFakeGemini(t)
+	t.Setenv("GEMINI_INLINE_MAX_BYTES", "")
+
+	decision, err := extractDataWithGemini(defaultSystemPrompt, writeGeminiFixtures(t), "gemini-test")
+	if err != nil {
+		t.Fatalf("extractDataWithGemini returned error: %v", err)
+	}
+	if decision.Username != "jane_doe" || decision.ReferenceNumber != "REF-9" {
+		t.Fatalf("unexpected decision: %+v", decision)
+	}
+	if len(fake.uploaded) != 0 {
+		t.Fatalf("small attachments must be sent inline, got %d uploads", len(fake.uploaded))
+	}
+
+	raw, _ := json.Marshal(fake.request)
+	for _, expected := range []string{
+		`"inline_data":{"data":"JVBERi0xLjQgb3JkZXI=","mime_type":"application/pdf"}`,
+		`"text":"Username: jane_doe"`,
+		`"responseMimeType":"application/json"`,
+		`"type":"OBJECT"`,
+	} {
+		if !strings.Contains(string(raw), expected) {
+			t.Fatalf("request missing %s: %s", expected, raw)
+		}
+	}
+	if strings.Contains(string(raw), "additionalProperties") {
+		t.Fatalf("response schema must not contain additionalProperties: %s", raw)
+	}
+}
+
+func TestExtractDataWithGeminiFileAPI(t *testing.T) {
+	fake := useFakeGemini(t)
+	t.Setenv("GEMINI_INLINE_MAX_BYTES", "0")
+
+	if _, err := extractDataWithGemini(defaultSystemPrompt, writeGeminiFixtures(t), "gemini-test"); err != nil {
+		t.Fatalf("extractDataWithGemini returned error: %v", err)
+	}
+	if len(fake.uploaded) != 1 || fake.uploaded[0] != "%PDF-1.4 order" {
+		t.Fatalf("expected the PDF to be uploaded, got %q", fake.uploaded)
+	}
+
+	raw, _ := json.Marshal(fake.request)
+	if !strings.Contains(string(raw), `"file_data":{"file_uri":"https://files.example/abc","mime_type":"application/pdf"}`) {
+		t.Fatalf("request must reference the uploaded file: %s", raw)
+	}
+	if len(fake.deleted) != 1 {
+		t.Fatalf("expected the uploaded file to be deleted, got %q", fake.deleted)
+	}
+}
+
+func TestExtractDataWithGeminiDeletesFileOnError(t *testing.T) {
+	fake := useFakeGemini(t)
+	fake.failGenerate = true
+	t.Setenv("GEMINI_INLINE_MAX_BYTES", "0")
+
+	if _, err := extractDataWithGemini(defaultSystemPrompt, writeGeminiFixtures(t), "gemini-test"); err == nil {
+		t.Fatal("expected the failed generateContent call to return an error")
+	}
+	if len(fake.uploaded) != 1 || len(fake.deleted) != 1 {
+		t.Fatalf("expected the uploaded file to be deleted after the error, got uploads %d, deletes %q", len(fake.uploaded), fake.deleted)
+	}
+}
@@ -0,0 +1,168 @@
+package tco_vo_agent
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLocalModel   = "llama3.1"
+	defaultLocalBaseURL = "http://localhost:11434"
+)
+
+type chatCompletionResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+}
+
+func localModelBaseURL() string {
+	base := strings.TrimSpace(os.Getenv("LOCAL_LLM_BASE_URL"))
+	if base == "" {
+		return defaultLocalBaseURL
+	}
+	return strings.TrimRight(base, "/")
+}
+
+// extractDataWithLocalModel runs the extraction against a self-hosted model
+// (Ollama, vLLM, llama.cpp, ...) through an OpenAI-compatible chat completions
+// endpoint. PDFs are converted to text on this machine, so no file upload or
+// Responses API support is needed and the documents never leave our infrastructure.
+func extractDataWithLocalModel(systemPrompt string, attachmentPaths []string, model string) (*FraudDecision, error) {
+	model = strings.TrimSpace(model)
+	if model == "" {
+		model = os.Getenv("LOCAL_LLM_MODEL")
+	}
+	if model == "" {
+		model = defaultLocalModel
+	}
+	if systemPrompt == "" {
+		systemPrompt = defaultSystemPrompt
+	}
+
+	documents, err := loadOrderDocuments(attachmentPaths)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachments: %w", err)
+	}
+
+	vision := strings.EqualFold(strings.TrimSpace(os.Getenv("LOCAL_LLM_VISION")), "true")
+	content := []map[string]interface{}{}
+	for _, document := range documents {
+		switch document.Kind {
+		case documentKindPDF:
+			text := strings.TrimSpace(extractPDFText(document.Data))
+			if text == "" {
+				log.Printf("No text found in %s; scanned PDFs need OCR before a local model can read them", document.Name)
+				continue
+			}
+			content = append(content, map[string]interface{}{"type": "text", "text": fmt.Sprintf("Source: %s\n\n%s", document.Name, text)})
+		case documentKindImage:
+			if !vision {
+				log.Printf("Skipping image %s: LOCAL_LLM_VISION is not enabled", document.Name)
+				continue
+			}
+			dataURL := "data:" + document.MimeType + ";base64," + base64.StdEncoding.EncodeToString(document.Data)
+			content = append(content, map[string]interface{}{"type": "image_url", "image_url": map[string]string{"url": dataURL}})
+		default:
+			content = append(content, map[string]interface{}{"type": "text", "text": document.Text()})
+		}
+	}
+	if len(content) == 0 {
+		return nil, fmt.Errorf("no readable content in %d attachments", len(documents))
+	}
+
+	schema, err := json.Marshal(extractionOutputSchema())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal schema: %w", err)
+	}
+	// not every server enforces response_format, so the schema is spelled out as well
+	instructions := fmt.Sprintf("%s\n\nAnswer with a single JSON object matching this JSON schema and nothing else:\n%s", systemPrompt, schema)
+
+	requestBody := map[string]interface{}{
+		"model": model,
+		"messages": []map[string]interface{}{
+			{"role": "system", "content": instructions},
+			{"role": "user", "content": content},
+		},
+		"temperature": 0,
+	}
+	switch format := strings.ToLower(strings.TrimSpace(os.Getenv("LOCAL_LLM_RESPONSE_FORMAT"))); format {
+	case "", "json_object":
+		requestBody["response_format"] = map[string]interface{}{"type": "json_object"}
+	case "json_schema":
+		requestBody["response_format"] = map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   "ExtractedData",
+				"strict": true,
+				"schema": extractionOutputSchema(),
+			},
+		}
+	case "none":
+	default:
+		return nil, fmt.Errorf("unsupported LOCAL_LLM_RESPONSE_FORMAT %q", format)
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	client := &http.Client{
+		// local models on CPU can be slow
+		Timeout: 300 * time.Second,
+	}
+
+	const retries = 3
+	var resp *http.Response
+	for range retries {
+		req, err := http.NewRequest("POST", localModelBaseURL()+"/v1/chat/completions", bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if apiKey := os.Getenv("LOCAL_LLM_API_KEY"); apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+
+		resp, err = client.Do(req)
+		if err == nil {
+			break
+		}
+		time.Sleep(1 * time.Second)
+	}
+
+	if resp == nil {
+		return nil, fmt.Errorf("no response from local model at %s", localModelBaseURL())
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("local model returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var chatResp chatCompletionResponse
+	if err := json.Unmarshal(respBody, &chatResp); err != nil {
+		return nil, fmt.Errorf("failed to parse local model response: %w", err)
+	}
+	if len(chatResp.Choices) == 0 || strings.TrimSpace(chatResp.Choices[0].Message.Content) == "" {
+		return nil, fmt.Errorf("no decision text found in local model response")
+	}
+
+	return parseDecisionJSON(chatResp.Choices[0].Message.Content)
+}
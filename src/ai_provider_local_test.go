@@ -0,0 +1,67 @@
+package tco_vo_agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtractDataWithLocalModel(t *testing.T) {
+	var request map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected request %s %s; only chat completions are available", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &request)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"choices":[{"message":{"role":"assistant","content":%q},"finish_reason":"stop"}]}`,
+			`{"agencyName":"BKA","referenceNumber":"REF-1","date":"2024-12-01T10:00:00Z","accounts":[{"username":"jane","email":"","userId":"","profileUrl":""}],"contentItems":[]}`)
+	}))
+	defer server.Close()
+
+	t.Setenv("LOCAL_LLM_BASE_URL", server.URL)
+	t.Setenv("LOCAL_LLM_MODEL", "")
+	t.Setenv("LOCAL_LLM_RESPONSE_FORMAT", "")
+	t.Setenv("LOCAL_LLM_VISION", "")
+
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "order.pdf"), filepath.Join(dir, "scan.png")}
+	os.WriteFile(paths[0], buildTestPDF(t, "BT /F1 12 Tf 72 720 Td (Reference: REF-1 Username: jane) Tj ET", true), 0o600)
+	os.WriteFile(paths[1], []byte("\x89PNG"), 0o600)
+
+	decision, err := extractDataWithLocalModel(defaultSystemPrompt, paths, "")
+	if err != nil {
+		t.Fatalf("extractDataWithLocalModel returned error: %v", err)
+	}
+	if decision.Username != "jane" || decision.ReferenceNumber != "REF-1" {
+		t.Fatalf("unexpected decision: %+v", decision)
+	}
+
+	raw, _ := json.Marshal(request)
+	if request["model"] != defaultLocalModel {
+		t.Fatalf("model = %v, want %s", request["model"], defaultLocalModel)
+	}
+	for _, expected := range []string{"Reference: REF-1 Username: jane", `"response_format":{"type":"json_object"}`, "accounts"} {
+		if !strings.Contains(string(raw), expected) {
+			t.Fatalf("request missing %s: %s", expected, raw)
+		}
+	}
+	if strings.Contains(string(raw), "image_url") {
+		t.Fatalf("images must not be sent unless LOCAL_LLM_VISION is enabled: %s", raw)
+	}
+
+	for _, provider := range []string{"ollama", "local"} {
+		if _, ok := providerRunners[provider]; !ok {
+			t.Fatalf("%s provider is not registered", provider)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package tco_vo_agent
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -28,40 +29,32 @@ func extractDataFromAttachment(systemPrompt string, attachmentPaths []string, mo
 		systemPrompt = defaultSystemPrompt
 	}
 
-	input := []map[string]interface{}{}
+	documents, err := loadOrderDocuments(attachmentPaths)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachments: %w", err)
+	}
 
-	for _, attachmentPath := range attachmentPaths {
-		fileRef, err := UploadFile(apiKey, attachmentPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to upload file: %w", err)
+	content := []map[string]interface{}{}
+	for _, document := range documents {
+		switch document.Kind {
+		case documentKindPDF:
+			fileRef, err := UploadFile(apiKey, document.Path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to upload file: %w", err)
+			}
+			content = append(content, map[string]interface{}{"type": "input_file", "file_id": fileRef})
+		case documentKindImage:
+			dataURL := "data:" + document.MimeType + ";base64," + base64.StdEncoding.EncodeToString(document.Data)
+			content = append(content, map[string]interface{}{"type": "input_image", "image_url": dataURL})
+		default:
+			content = append(content, map[string]interface{}{"type": "input_text", "text": document.Text()})
 		}
-		input = append(input, map[string]interface{}{"type": "input_file", "file_id": fileRef})
-	}
-
-	outputSchema := map[string]interface{}{
-		"type":     "object",
-		"required": []string{"username", "email", "agencyName", "referenceNumber", "date"},
-		"properties": map[string]interface{}{
-			"username": map[string]interface{}{
-				"type": "string",
-			},
-			"email": map[string]interface{}{
-				"type": "string",
-			},
-			"agencyName": map[string]interface{}{
-				"type": "string",
-			},
-			"referenceNumber": map[string]interface{}{
-				"type": "string",
-			},
-			"date": map[string]interface{}{
-				"type":   "string",
-				"format": "date-time",
-			},
-		},
-		"additionalProperties": false,
 	}
 
+	input := []map[string]interface{}{{"role": "user", "content": content}}
+
+	outputSchema := extractionOutputSchema()
+
 	outputFormat := map[string]interface{}{
 		"format": map[string]interface{}{
 			"type":   "json_schema",
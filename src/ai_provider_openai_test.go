@@ -2,9 +2,11 @@ package tco_vo_agent
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -80,7 +82,7 @@ func TestExtractDataFromAttachment_WithAndWithoutRequiredInfo(t *testing.T) {
 			t.Fatalf("expected error for missing fields, got decision %+v", decision)
 		}
 
-		for _, expected := range []string{"missing email", "missing agencyName", "missing referenceNumber", "missing date"} {
+		for _, expected := range []string{"missing agencyName", "missing referenceNumber", "missing date"} {
 			if !strings.Contains(err.Error(), expected) {
 				t.Fatalf("error %q missing expected substring %q", err.Error(), expected)
 			}
@@ -136,3 +138,52 @@ func newFakeOpenAIServer(t *testing.T, outputText string) *httptest.Server {
 
 	return httptest.NewServer(mux)
 }
+
+func TestExtractDataFromAttachment_RoutesByDocumentKind(t *testing.T) {
+	var uploads int
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/files":
+			uploads++
+			fmt.Fprint(w, `{"id":"file-test-id"}`)
+		case "/v1/responses":
+			raw, _ := io.ReadAll(r.Body)
+			body = string(raw)
+			fmt.Fprintf(w, `{"output_text": %q}`, `{"username":"jane_doe","email":"jane.doe@example.com","agencyName":"A","referenceNumber":"R","date":"2024-12-01T10:00:00Z"}`)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_BASE_URL", server.URL)
+
+	dir := t.TempDir()
+	paths := []string{
+		filepath.Join(dir, "order.pdf"),
+		filepath.Join(dir, "scan.png"),
+		filepath.Join(dir, "ticket.txt"),
+	}
+	os.WriteFile(paths[0], []byte("%PDF-1.4"), 0o600)
+	os.WriteFile(paths[1], []byte("\x89PNG"), 0o600)
+	os.WriteFile(paths[2], []byte("Username: jane_doe"), 0o600)
+
+	if _, err := extractDataFromAttachment(defaultSystemPrompt, paths, "gpt-4o"); err != nil {
+		t.Fatalf("extractDataFromAttachment returned error: %v", err)
+	}
+
+	if uploads != 1 {
+		t.Fatalf("expected only the PDF to be uploaded, got %d uploads", uploads)
+	}
+	for _, expected := range []string{
+		`"file_id":"file-test-id","type":"input_file"`,
+		`"image_url":"data:image/png;base64,`,
+		`"text":"Username: jane_doe","type":"input_text"`,
+		`"role":"user"`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("request body missing %s: %s", expected, body)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package tco_vo_agent
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// annexIITag asks for the Annex II form of an order that was already carried out.
+	annexIITag = "tco-vo-annex-ii"
+
+	annexIIModeAttach    = "attach"
+	annexIIModeOnRequest = "on_request"
+
+	defaultHostingProviderName = "Finya"
+)
+
+// Closing sentences of the completion reply, depending on ANNEX_II_MODE.
+const (
+	annexIIAttachedNote  = "Our feedback in the Annex II format is attached."
+	annexIIOnRequestNote = "If you need confirmation in the Annex II format, please let us know."
+)
+
+const annexIIMessage = `Subject: TCO removal order – Annex II feedback (Ref: %s)
+
+Hello %s,
+
+As requested, please find attached our feedback on the removal order in the format of Annex II to Regulation (EU) 2021/784.
+
+Thank you.
+`
+
+var replyWithAttachmentsFn = ReplyToTicketWithAttachments
+
+var errNoRemoval = errors.New("no removal was recorded for the ticket")
+
+// removalRecord keeps what was removed for an order, so the Annex II form can
+// be produced again when the authority asks for it later.
+type removalRecord struct {
+	Decisions []removedDecision `json:"decisions"`
+	RemovedAt time.Time         `json:"removedAt"`
+}
+
+// removedDecision is a decision that was carried out and how. Records written
+// before content measures existed have no measure; those were bans.
+type removedDecision struct {
+	FraudDecision
+	Measure contentMeasure `json:"measure,omitempty"`
+}
+
+// annexIIMode reads ANNEX_II_MODE: "attach" sends the form with every
+// completion reply, "on_request" (default) only when a moderator adds the
+// tco-vo-annex-ii tag.
+func annexIIMode() string {
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("ANNEX_II_MODE")), annexIIModeAttach) {
+		return annexIIModeAttach
+	}
+	return annexIIModeOnRequest
+}
+
+func annexIINote() string {
+	if annexIIMode() == annexIIModeAttach {
+		return annexIIAttachedNote
+	}
+	return annexIIOnRequestNote
+}
+
+// recordRemovals stores what was carried out for every ticket in the ledger.
+// Failures are logged; the ban already happened.
+func recordRemovals(actioned map[contentMeasure][]agentData) {
+	var all []agentData
+	for _, measure := range contentMeasures {
+		all = append(all, actioned[measure]...)
+	}
+	if len(all) == 0 {
+		return
+	}
+	ledger, err := openLedgerFn()
+	if err != nil {
+		log.Printf("Error opening processing ledger: %v", err)
+		return
+	}
+	records := map[string]*removalRecord{}
+	for _, measure := range contentMeasures {
+		for _, data := range actioned[measure] {
+			record := records[data.Data.TicketID]
+			if record == nil {
+				record = &removalRecord{RemovedAt: nowFn().UTC()}
+				records[data.Data.TicketID] = record
+			}
+			record.Decisions = append(record.Decisions, removedDecision{FraudDecision: data.Data, Measure: measure})
+		}
+	}
+	for _, group := range groupByTicket(all) {
+		ticketID := group[0].Data.TicketID
+		record := records[ticketID]
+		_, err := ledger.Update(ticketID, func(entry *ledgerEntry) (*ledgerEntry, error) {
+			if entry == nil {
+				entry = &ledgerEntry{TicketID: ticketID, CreatedAt: record.RemovedAt, UpdatedAt: record.RemovedAt}
+			}
+			entry.Removal = record
+			return entry, nil
+		})
+		if err != nil {
+			log.Printf("Error recording removal of ticket %s: %v", ticketID, err)
+		}
+	}
+}
+
+// annexIIAttachment fills in the Annex II form for the removed accounts of one
+// ticket and the measures taken for them.
+func annexIIAttachment(group []agentData, removedAt time.Time, measures ...contentMeasure) ticketAttachment {
+	return annexAttachment("annex-ii", group, annexIILines(group, orderReceivedAt(group[0].Data.TicketID), removedAt, measures))
+}
+
+// annexAttachment renders the lines of an annex form as a PDF named after the order.
+func annexAttachment(name string, group []agentData, lines []pdfLine) ticketAttachment {
+	reference := fallbackValue(group[0].Data.ReferenceNumber, "order")
+	return ticketAttachment{
+		Name:        name + "-" + safeFileName(reference) + ".pdf",
+		ContentType: "application/pdf",
+		Data:        renderTextPDF(lines),
+	}
+}
+
+// orderReceivedAt returns when the order of a ticket arrived, or the zero time if unknown.
+func orderReceivedAt(ticketID string) time.Time {
+	ledger, err := openLedgerFn()
+	if err != nil {
+		return time.Time{}
+	}
+	entry, err := ledger.Get(ticketID)
+	if err != nil || entry == nil || entry.Deadline == nil {
+		return time.Time{}
+	}
+	return entry.Deadline.ReceivedAt
+}
+
+func formatAnnexTime(t time.Time) string {
+	if t.IsZero() {
+		return "not recorded"
+	}
+	return t.UTC().Format("2006-01-02 15:04 MST")
+}
+
+// annexOrderLines are the sections on the order and on us that all annex forms share.
+func annexOrderLines(order FraudDecision, receivedAt time.Time) []pdfLine {
+	return []pdfLine{
+		{Text: "SECTION A – Addressee of the removal order", Bold: true},
+		{Text: "Competent authority: " + fallbackValue(order.AgencyName, "not provided")},
+		{Text: "Reference of the removal order: " + fallbackValue(order.ReferenceNumber, "not provided")},
+		{Text: "Date of the removal order: " + fallbackValue(order.Date, "not provided")},
+		{Text: "Date and time of receipt of the removal order: " + formatAnnexTime(receivedAt)},
+		{},
+		{Text: "SECTION B – Hosting service provider", Bold: true},
+		{Text: "Name of the hosting service provider: " + fallbackValue(os.Getenv("HOSTING_PROVIDER_NAME"), defaultHostingProviderName)},
+		{Text: "Contact point: " + fallbackValue(os.Getenv("HOSTING_PROVIDER_CONTACT"), "not provided")},
+		{},
+	}
+}
+
+// annexIILines lays out the feedback form of Annex II (Article 3(6)).
+func annexIILines(group []agentData, receivedAt, removedAt time.Time, measures []contentMeasure) []pdfLine {
+	removed, disabled := false, false
+	for _, measure := range measures {
+		if measure == measureRemoveContent {
+			removed = true
+		} else {
+			// a ban disables access to everything the account published
+			disabled = true
+		}
+	}
+
+	lines := []pdfLine{
+		{Text: "ANNEX II", Bold: true},
+		{Text: "FEEDBACK FORM FOLLOWING REMOVAL OF TERRORIST CONTENT OR DISABLING OF ACCESS THERETO", Bold: true},
+		{Text: "(Article 3(6) of Regulation (EU) 2021/784 of the European Parliament and of the Council)"},
+		{},
+	}
+	lines = append(lines, annexOrderLines(group[0].Data, receivedAt)...)
+	lines = append(lines, pdfLine{Text: "SECTION C – Terrorist content concerned", Bold: true})
+	for _, data := range group {
+		lines = append(lines, pdfLine{Text: "- " + formatIdentifiers(data.Data)})
+	}
+	lines = append(lines,
+		pdfLine{},
+		pdfLine{Text: "SECTION D – Measures taken in compliance with the removal order", Bold: true},
+		pdfCheckbox(removed, "The terrorist content has been removed"),
+		pdfCheckbox(disabled, "Access to the terrorist content has been disabled in all Member States"),
+		pdfLine{Text: "Date and time of the measure: " + formatAnnexTime(removedAt)},
+		pdfLine{Text: "The removed content and related data are preserved for six months in accordance with Article 6."},
+		pdfLine{},
+		pdfLine{Text: "SECTION E – Date", Bold: true},
+		pdfLine{Text: "Date of this feedback: " + formatAnnexTime(nowFn())},
+	)
+	return lines
+}
+
+// handleAnnexIIRequest answers a ticket tagged tco-vo-annex-ii with the Annex
+// II form of the removal recorded for it, then removes the tag.
+func handleAnnexIIRequest(ticket ZendeskTicket) error {
+	ledger, err := openLedgerFn()
+	if err != nil {
+		return fmt.Errorf("opening processing ledger: %w", err)
+	}
+	entry, err := ledger.Get(ticket.ID)
+	if err != nil {
+		return fmt.Errorf("reading processing ledger: %w", err)
+	}
+	if entry == nil || entry.Removal == nil {
+		log.Printf("Ignoring %s tag on ticket %s: %v", annexIITag, ticket.ID, errNoRemoval)
+		if err := addInternalNoteFn(ticket.ID, fmt.Sprintf("%s No Annex II form was sent: %v.", agentNotePrefix, errNoRemoval)); err != nil {
+			log.Printf("Error adding internal note to ticket %s: %v", ticket.ID, err)
+		}
+	} else {
+		var group []agentData
+		var measures []contentMeasure
+		for _, decision := range entry.Removal.Decisions {
+			group = append(group, agentData{Data: decision.FraudDecision})
+			measures = append(measures, fallbackMeasure(decision.Measure))
+		}
+		attachment := annexIIAttachment(group, entry.Removal.RemovedAt, measures...)
+		order := group[0].Data
+		message := fmt.Sprintf(annexIIMessage, fallbackValue(order.ReferenceNumber, "N/A"), fallbackValue(order.AgencyName, "competent authority"))
+		if err := replyWithAttachmentsFn(ticket.ID, message, []ticketAttachment{attachment}); err != nil {
+			return fmt.Errorf("sending Annex II form: %w", err)
+		}
+		recordAudit(ticket.ID, auditReplySent, map[string]interface{}{
+			"template":    "annex_ii",
+			"message":     message,
+			"attachments": []string{attachment.Name},
+		})
+	}
+
+	// one-shot request, like the reprocess tag
+	if err := untagTicketFn(ticket.ID, []string{annexIITag}); err != nil {
+		log.Printf("Error removing %s tag from ticket %s: %v", annexIITag, ticket.ID, err)
+	}
+	return nil
+}
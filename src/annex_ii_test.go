@@ -0,0 +1,108 @@
+package tco_vo_agent
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplyToTicketsAttachesAnnexII(t *testing.T) {
+	t.Setenv("LEDGER_DIR", t.TempDir())
+	t.Setenv("ANNEX_II_MODE", "attach")
+	t.Setenv("HOSTING_PROVIDER_CONTACT", "tco@finya.de")
+	fake := useFakeZendesk(t)
+	fake.addTicket(ZendeskTicket{ID: "9"})
+
+	decision := FraudDecision{TicketID: "9", Username: "jane", AgencyName: "Bundeskriminalamt", ReferenceNumber: "REF/9", Date: "2025-01-08"}
+	if err := ReplyToTickets([]agentData{{Data: decision}}, ReplyToTicketTemplateUserBanned); err != nil {
+		t.Fatalf("ReplyToTickets returned error: %v", err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	comments := fake.comments["9"]
+	if len(comments) != 1 || !strings.Contains(comments[0]["body"].(string), annexIIAttachedNote) {
+		t.Fatalf("expected the completion reply to mention the form, got %+v", comments)
+	}
+	uploads, _ := comments[0]["uploads"].([]interface{})
+	if len(uploads) != 1 || uploads[0] != "token-1-annex-ii-REF_9.pdf" {
+		t.Fatalf("expected the form to be attached, got %+v", comments[0])
+	}
+	text := builtinPDFText(fake.uploads["token-1-annex-ii-REF_9.pdf"])
+	for _, want := range []string{"Competent authority: Bundeskriminalamt", "Reference of the removal order: REF/9", "Contact point: tco@finya.de", "username: jane"} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("form lacks %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestAnnexIITagSendsFormOnRequest(t *testing.T) {
+	t.Setenv("LEDGER_DIR", t.TempDir())
+
+	origReply := replyWithAttachmentsFn
+	origUntag := untagTicketFn
+	origNote := addInternalNoteFn
+	t.Cleanup(func() {
+		replyWithAttachmentsFn = origReply
+		untagTicketFn = origUntag
+		addInternalNoteFn = origNote
+	})
+
+	var sent []ticketAttachment
+	var messages []string
+	replyWithAttachmentsFn = func(ticketId string, message string, attachments []ticketAttachment) error {
+		messages = append(messages, message)
+		sent = append(sent, attachments...)
+		return nil
+	}
+	var untagged []string
+	untagTicketFn = func(ticketId string, tags []string) error {
+		untagged = append(untagged, ticketId+":"+strings.Join(tags, ","))
+		return nil
+	}
+	var notes []string
+	addInternalNoteFn = func(ticketId string, message string) error {
+		notes = append(notes, message)
+		return nil
+	}
+
+	recordRemovals(map[contentMeasure][]agentData{
+		measureBanAccount: {{Data: FraudDecision{TicketID: "7", Email: "jane@example.com", AgencyName: "BKA", ReferenceNumber: "REF-7"}}},
+	})
+	tags := []string{agentTag, decisionTagBanned, annexIITag}
+	if err := processTicketsAsync(ZendeskTicket{ID: "7", Tags: tags, CreatedAt: time.Now().Format(time.RFC3339)}); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	if len(sent) != 1 || sent[0].ContentType != "application/pdf" || !strings.Contains(messages[0], "Ref: REF-7") {
+		t.Fatalf("expected the form to be sent, got %+v", sent)
+	}
+	if !strings.Contains(builtinPDFText(sent[0].Data), "email: jane@example.com") {
+		t.Fatalf("form does not name the removed account:\n%s", builtinPDFText(sent[0].Data))
+	}
+
+	// nothing was removed for this ticket, so the moderator gets a note instead
+	if err := processTicketsAsync(ZendeskTicket{ID: "8", Tags: tags}); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	if len(sent) != 1 || len(notes) != 1 {
+		t.Fatalf("expected only a note for ticket 8, got %d forms and notes %q", len(sent), notes)
+	}
+	if strings.Join(untagged, " ") != "7:"+annexIITag+" 8:"+annexIITag {
+		t.Fatalf("expected the request tag to be removed, got %q", untagged)
+	}
+}
+
+func TestRenderTextPDFWrapsAndPaginates(t *testing.T) {
+	lines := []pdfLine{{Text: "Title (draft)", Bold: true}, {Text: strings.Repeat("word ", 40)}}
+	for i := 0; i < 60; i++ {
+		lines = append(lines, pdfLine{Text: "line"})
+	}
+	pdf := string(renderTextPDF(lines))
+	if !strings.HasPrefix(pdf, "%PDF-1.4") || !strings.HasSuffix(pdf, "%%EOF\n") || !strings.Contains(pdf, "/Count 2") {
+		t.Fatalf("expected a two page PDF, got:\n%s", pdf)
+	}
+	text := builtinPDFText([]byte(pdf))
+	if !strings.Contains(text, "Title (draft)") || strings.Count(text, "word") != 40 {
+		t.Fatalf("unexpected text:\n%s", text)
+	}
+}
@@ -0,0 +1,168 @@
+package tco_vo_agent
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// decisionTagImpossible marks orders answered with Annex III because we cannot carry them out.
+const decisionTagImpossible = "tco-vo-decision-impossible"
+
+// impossibleTagPrefix followed by a reason, e.g. tco-vo-impossible-manifest-error,
+// lets a moderator declare a pending proposal impossible to comply with.
+const impossibleTagPrefix = "tco-vo-impossible-"
+
+// impossibilityReason is why an order cannot be carried out (Article 3(7) and (8)).
+type impossibilityReason string
+
+const (
+	impossibilityForceMajeure  impossibilityReason = "force_majeure"
+	impossibilityTechnical     impossibilityReason = "technical"
+	impossibilityManifestError impossibilityReason = "manifest_error"
+)
+
+var impossibilityReasons = []impossibilityReason{impossibilityForceMajeure, impossibilityTechnical, impossibilityManifestError}
+
+// parseImpossibilityReason accepts the reasons in snake or kebab case.
+func parseImpossibilityReason(raw string) (impossibilityReason, bool) {
+	normalized := strings.NewReplacer("-", "_", " ", "_").Replace(strings.ToLower(strings.TrimSpace(raw)))
+	for _, reason := range impossibilityReasons {
+		if normalized == string(reason) {
+			return reason, true
+		}
+	}
+	return "", false
+}
+
+// describe returns the wording of the reason used in the reply and the form.
+func (r impossibilityReason) describe() string {
+	switch r {
+	case impossibilityForceMajeure:
+		return "force majeure or de facto impossibility not attributable to us"
+	case impossibilityTechnical:
+		return "de facto impossibility for objectively justifiable technical or operational reasons"
+	case impossibilityManifestError:
+		return "the removal order contains manifest errors"
+	default:
+		return "the order cannot be carried out"
+	}
+}
+
+// tag is the moderator tag that selects the reason.
+func (r impossibilityReason) tag() string {
+	return impossibleTagPrefix + strings.ReplaceAll(string(r), "_", "-")
+}
+
+// impossibleTagReason returns the reason of the first impossible tag of a ticket.
+func impossibleTagReason(tags []string) (impossibilityReason, bool) {
+	for _, tag := range tags {
+		if raw, ok := strings.CutPrefix(tag, impossibleTagPrefix); ok {
+			if reason, ok := parseImpossibilityReason(raw); ok {
+				return reason, true
+			}
+		}
+	}
+	return "", false
+}
+
+// splitImpossible separates the decisions the model found impossible to carry out.
+func splitImpossible(data []agentData) ([]agentData, []agentData) {
+	var rest, impossible []agentData
+	for _, entry := range data {
+		raw := strings.TrimSpace(entry.Data.ImpossibilityReason)
+		if raw == "" {
+			rest = append(rest, entry)
+			continue
+		}
+		reason, ok := parseImpossibilityReason(raw)
+		if !ok {
+			log.Printf("Ignoring unknown impossibility reason %q for ticket %s", raw, entry.Data.TicketID)
+			rest = append(rest, entry)
+			continue
+		}
+		entry.Data.ImpossibilityReason = string(reason)
+		entry.Reason = reason.describe()
+		impossible = append(impossible, entry)
+	}
+	return rest, impossible
+}
+
+// markImpossible applies a moderator's reason and explanation to every decision.
+func markImpossible(data []agentData, reason impossibilityReason, explanation string) []agentData {
+	var impossible []agentData
+	for _, entry := range data {
+		entry.Data.ImpossibilityReason = string(reason)
+		if strings.TrimSpace(explanation) != "" {
+			entry.Data.ImpossibilityExplanation = strings.TrimSpace(explanation)
+		}
+		entry.Reason = reason.describe()
+		impossible = append(impossible, entry)
+	}
+	return impossible
+}
+
+// groupImpossibility returns the reason and the explanations of one ticket's decisions.
+func groupImpossibility(group []agentData) (impossibilityReason, string) {
+	reason, _ := parseImpossibilityReason(group[0].Data.ImpossibilityReason)
+	var explanations []string
+	seen := map[string]bool{}
+	for _, data := range group {
+		explanation := strings.TrimSpace(data.Data.ImpossibilityExplanation)
+		if explanation != "" && !seen[explanation] {
+			seen[explanation] = true
+			explanations = append(explanations, explanation)
+		}
+	}
+	return reason, strings.Join(explanations, " ")
+}
+
+// annexIIIAttachment fills in the Annex III form for the decisions of one ticket.
+func annexIIIAttachment(group []agentData) ticketAttachment {
+	return annexAttachment("annex-iii", group, annexIIILines(group, orderReceivedAt(group[0].Data.TicketID)))
+}
+
+// annexIIILines lays out the form of Annex III (Article 3(7) and (8)).
+func annexIIILines(group []agentData, receivedAt time.Time) []pdfLine {
+	reason, explanation := groupImpossibility(group)
+
+	lines := []pdfLine{
+		{Text: "ANNEX III", Bold: true},
+		{Text: "INFORMATION ON THE IMPOSSIBILITY TO EXECUTE THE REMOVAL ORDER", Bold: true},
+		{Text: "(Article 3(7) and (8) of Regulation (EU) 2021/784 of the European Parliament and of the Council)"},
+		{},
+	}
+	lines = append(lines, annexOrderLines(group[0].Data, receivedAt)...)
+	lines = append(lines,
+		pdfLine{Text: "SECTION C – Reasons for non-execution", Bold: true},
+		pdfCheckbox(reason == impossibilityForceMajeure || reason == impossibilityTechnical,
+			"Force majeure or de facto impossibility not attributable to the hosting service provider, including for objectively justifiable technical or operational reasons"),
+		pdfCheckbox(reason == impossibilityManifestError, "The removal order contains manifest errors"),
+		pdfCheckbox(false, "The removal order does not contain sufficient information"),
+		pdfLine{Text: "Explanation: " + fallbackValue(explanation, reason.describe())},
+		pdfLine{},
+		pdfLine{Text: "SECTION D – Content concerned", Bold: true},
+	)
+	for _, data := range group {
+		lines = append(lines, pdfLine{Text: "- " + formatIdentifiers(data.Data)})
+	}
+	lines = append(lines,
+		pdfLine{},
+		pdfLine{Text: "The removal order will be executed without undue delay once the reasons have ceased to exist or a corrected order has been received."},
+		pdfLine{},
+		pdfLine{Text: "SECTION E – Date", Bold: true},
+		pdfLine{Text: "Date of this information: " + formatAnnexTime(nowFn())},
+	)
+	return lines
+}
+
+// formatImpossibility describes the reason of one ticket's decisions for the reply.
+func formatImpossibility(group []agentData) string {
+	reason, explanation := groupImpossibility(group)
+	text := reason.describe()
+	if explanation != "" {
+		text = fmt.Sprintf("%s (%s)", text, explanation)
+	}
+	return text
+}
@@ -0,0 +1,119 @@
+package tco_vo_agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProcessTicketsAsyncAnswersImpossibleOrders(t *testing.T) {
+	stubs := stubApprovalPipeline(t)
+	t.Setenv("APPROVAL_MODE", "false")
+	extractDataFn = func(paths []string, agents []agentConfig) ([]agentData, []agentError) {
+		return []agentData{{Data: FraudDecision{
+			Username:                 "jane",
+			AgencyName:               "Bundeskriminalamt",
+			ReferenceNumber:          "REF-1",
+			ImpossibilityReason:      "Manifest-Error",
+			ImpossibilityExplanation: "The order names a profile URL of another service.",
+		}}}, nil
+	}
+
+	if err := processTicketsAsync(ZendeskTicket{ID: "7"}); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	if len(stubs.banned) != 0 {
+		t.Fatalf("an impossible order must not ban anyone, got %+v", stubs.banned)
+	}
+	if len(stubs.replies) != 1 || stubs.replies[0] != ReplyToTicketTemplateImpossible {
+		t.Fatalf("expected the Annex III reply, got %v", stubs.replies)
+	}
+	if !hasTag(stubs.tags, decisionTagImpossible) {
+		t.Fatalf("expected the impossible decision tag, got %v", stubs.tags)
+	}
+	last := stubs.slack[len(stubs.slack)-1]
+	if len(last.Impossible) != 1 || last.Impossible[0].Data.ImpossibilityReason != string(impossibilityManifestError) {
+		t.Fatalf("expected the impossible order in Slack, got %+v", last)
+	}
+}
+
+func TestReplyToTicketsAttachesAnnexIII(t *testing.T) {
+	t.Setenv("LEDGER_DIR", t.TempDir())
+	fake := useFakeZendesk(t)
+	fake.addTicket(ZendeskTicket{ID: "9"})
+
+	decision := FraudDecision{TicketID: "9", Username: "jane", AgencyName: "Bundeskriminalamt", ReferenceNumber: "REF-9", ImpossibilityReason: string(impossibilityTechnical)}
+	if err := ReplyToTickets([]agentData{{Data: decision}}, ReplyToTicketTemplateImpossible); err != nil {
+		t.Fatalf("ReplyToTickets returned error: %v", err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	comments := fake.comments["9"]
+	if len(comments) != 1 || !strings.Contains(comments[0]["body"].(string), impossibilityTechnical.describe()) {
+		t.Fatalf("expected the reply to name the reason, got %+v", comments)
+	}
+	text := builtinPDFText(fake.uploads["token-1-annex-iii-REF-9.pdf"])
+	for _, want := range []string{"ANNEX III", "[X] Force majeure", "[ ] The removal order contains manifest errors", "username: jane"} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("form lacks %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestApprovalDeclaredImpossible(t *testing.T) {
+	t.Setenv("BEARER_TOKEN", "secret")
+	stubs := stubApprovalPipeline(t)
+
+	if err := processTicketsAsync(ZendeskTicket{ID: "7"}); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	if !strings.Contains(stubs.notes[0], impossibilityManifestError.tag()) {
+		t.Fatalf("expected the proposal note to explain the impossible tags, got %q", stubs.notes[0])
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/approvals", strings.NewReader(`{"ticketId":"7","decision":"impossible","moderator":"alice"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	ProcessTickets(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without a reason, got %d", rec.Code)
+	}
+
+	tags := append(stubs.tags, impossibilityForceMajeure.tag())
+	if err := processTicketsAsync(ZendeskTicket{ID: "7", Tags: tags}); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	if len(stubs.banned) != 0 || len(stubs.replies) != 1 || stubs.replies[0] != ReplyToTicketTemplateImpossible {
+		t.Fatalf("expected only the Annex III reply, banned=%+v replies=%v", stubs.banned, stubs.replies)
+	}
+	if !hasTag(stubs.untags, impossibilityForceMajeure.tag()) || !hasTag(stubs.untags, decisionTagPendingApproval) {
+		t.Fatalf("expected the decision tags to be removed, got %v", stubs.untags)
+	}
+	last := stubs.slack[len(stubs.slack)-1]
+	if last.Decision != approvalImpossible || last.Impossible[0].Data.ImpossibilityReason != string(impossibilityForceMajeure) {
+		t.Fatalf("expected the impossible decision in Slack, got %+v", last)
+	}
+}
+
+func TestBuildConsensusNeedsMajorityForImpossibility(t *testing.T) {
+	a := order(agentA, "REF-1", "2024-12-01", TargetAccount{Username: "jane"})
+	a.Data.ImpossibilityReason = "technical"
+	data := []agentData{a, order(agentB, "REF-1", "2024-12-01", TargetAccount{Username: "jane"})}
+
+	decisions, review, err := buildConsensus(data, nil)
+	if err != nil {
+		t.Fatalf("buildConsensus returned error: %v", err)
+	}
+	if len(decisions) != 0 || len(review) != 1 || !strings.Contains(review[0].Reason, "impossibilityReason") {
+		t.Fatalf("expected a single agent's impossibility to need review, got decisions=%+v review=%+v", decisions, review)
+	}
+
+	b := data[1]
+	b.Data.ImpossibilityReason = "technical"
+	decisions, _, _ = buildConsensus([]agentData{a, b}, nil)
+	if len(decisions) != 1 || decisions[0].Data.ImpossibilityReason != "technical" {
+		t.Fatalf("expected the agreed reason to be kept, got %+v", decisions)
+	}
+}
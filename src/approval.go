@@ -0,0 +1,394 @@
+package tco_vo_agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// decisionTagPendingApproval marks tickets whose proposal waits for a moderator.
+	// It is a decision tag, so the update events caused by the proposal are ignored.
+	decisionTagPendingApproval = "tco-vo-decision-pending-approval"
+	decisionTagRejected        = "tco-vo-decision-rejected"
+
+	// approveTag and rejectTag let moderators decide from within Zendesk.
+	approveTag = "tco-vo-approve"
+	rejectTag  = "tco-vo-reject"
+
+	// ledgerStateAwaitingApproval means a proposal was written and nothing public happened yet.
+	ledgerStateAwaitingApproval ledgerState = "awaiting_approval"
+)
+
+// approvalDecision is what a moderator decided on a proposal.
+type approvalDecision string
+
+const (
+	approvalApprove approvalDecision = "approve"
+	approvalReject  approvalDecision = "reject"
+	// approvalRequestInfo asks the authority for clarification instead of acting.
+	approvalRequestInfo approvalDecision = "request_info"
+	// approvalImpossible answers the order with Annex III for the given reason.
+	approvalImpossible approvalDecision = "impossible"
+)
+
+var (
+	errNoPendingApproval = errors.New("no proposal is awaiting approval for this ticket")
+	errApprovalDecided   = errors.New("the proposal was already decided")
+	errApprovalRunning   = errors.New("the decision is still being carried out")
+)
+
+// approvalResumeAfter is how long a decision may be carried out before a retry
+// of the same decision assumes it was interrupted and finishes it.
+const approvalResumeAfter = 2 * time.Minute
+
+// approvalProposal is what the pipeline would have done, kept in the ledger until a moderator decides.
+type approvalProposal struct {
+	Subject    string           `json:"subject,omitempty"`
+	Ban        []agentData      `json:"ban,omitempty"`
+	MoreInfo   []agentData      `json:"moreInfo,omitempty"`
+	Impossible []agentData      `json:"impossible,omitempty"`
+	Review     []agentData      `json:"review,omitempty"`
+	ReceivedAt time.Time        `json:"receivedAt,omitempty"`
+	ProposedAt time.Time        `json:"proposedAt"`
+	Decision   approvalDecision `json:"decision,omitempty"`
+	DecidedBy  string           `json:"decidedBy,omitempty"`
+	DecidedAt  time.Time        `json:"decidedAt,omitempty"`
+	Note       string           `json:"note,omitempty"`
+	// Reason is the moderator's impossibility reason when the decision is impossible.
+	Reason impossibilityReason `json:"reason,omitempty"`
+	// DecisionJobID is the queued job carrying out the decision, so a
+	// redelivery of that job can finish a decision that was interrupted.
+	DecisionJobID string `json:"decisionJobId,omitempty"`
+}
+
+type approvalRequest struct {
+	TicketID  string `json:"ticketId"`
+	Decision  string `json:"decision"`
+	Moderator string `json:"moderator"`
+	Note      string `json:"note,omitempty"`
+	// Reason is required for the impossible decision.
+	Reason string `json:"reason,omitempty"`
+}
+
+// approvalModeEnabled reads APPROVAL_MODE. When enabled, bans and public
+// replies wait for a moderator to approve the extracted decision.
+func approvalModeEnabled() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("APPROVAL_MODE"))) {
+	case "true", "1", "on", "required":
+		return true
+	}
+	return false
+}
+
+// proposeDecisions writes the proposal as an internal note, tags the ticket
+// as pending approval and stores the proposal in the ledger.
+func proposeDecisions(ledger ticketLedger, ticket ZendeskTicket, ban, moreInfo, impossible, review []agentData) error {
+	proposal := &approvalProposal{
+		Subject:    ticket.Subject,
+		Ban:        ban,
+		MoreInfo:   moreInfo,
+		Impossible: impossible,
+		Review:     review,
+		ReceivedAt: ticketReceivedAt(ticket),
+		ProposedAt: nowFn().UTC(),
+	}
+
+	if err := addInternalNoteFn(ticket.ID, buildProposalNote(ticket.ID, proposal)); err != nil {
+		return err
+	}
+	if err := tagTicketFn(ticket.ID, []string{agentTag, decisionTagPendingApproval}); err != nil {
+		return err
+	}
+
+	_, err := ledger.Update(ticket.ID, func(entry *ledgerEntry) (*ledgerEntry, error) {
+		now := nowFn().UTC()
+		if entry == nil {
+			entry = &ledgerEntry{TicketID: ticket.ID, CreatedAt: now}
+		}
+		entry.Proposal = proposal
+		entry.setState(ledgerStateAwaitingApproval, now, "")
+		return entry, nil
+	})
+	if err == nil {
+		recordAudit(ticket.ID, auditApprovalProposed, proposal)
+	}
+	return err
+}
+
+// buildProposalNote describes the proposal for the moderator.
+func buildProposalNote(ticketID string, proposal *approvalProposal) string {
+	lines := []string{
+		agentNotePrefix + " Proposed decision, awaiting approval",
+		"",
+	}
+	ban, remove, disable := partitionByMeasure(proposal.Ban)
+	for measure, decisions := range [][]agentData{ban, remove, disable} {
+		for _, group := range groupByTicket(decisions) {
+			decision := group[0].Data
+			lines = append(lines, fmt.Sprintf("%s %s on request of %s (reference %s)",
+				contentMeasures[measure].describe(),
+				formatGroupIdentifiers(group),
+				fallbackValue(decision.AgencyName, "N/A"),
+				fallbackValue(decision.ReferenceNumber, "N/A")))
+		}
+	}
+	for _, group := range groupByTicket(proposal.MoreInfo) {
+		lines = append(lines, "Ask the authority for more information: "+formatReasons(group))
+	}
+	for _, group := range groupByTicket(proposal.Impossible) {
+		lines = append(lines, "Answer with Annex III, impossible to comply: "+formatImpossibility(group))
+	}
+	for _, decision := range proposal.Review {
+		lines = append(lines, fmt.Sprintf("Needs review, not part of the proposal: %s (%s)", formatIdentifiers(decision.Data), decision.Reason))
+	}
+	lines = append(lines,
+		"",
+		fmt.Sprintf("Add the tag %s to carry out the proposal or %s to discard it.", approveTag, rejectTag),
+		fmt.Sprintf("To answer with Annex III instead, add %s, %s or %s.", impossibilityForceMajeure.tag(), impossibilityTechnical.tag(), impossibilityManifestError.tag()),
+		fmt.Sprintf("Alternatively POST {\"ticketId\": %q, \"decision\": \"approve\"} to /approvals, or use the buttons in Slack.", ticketID),
+	)
+	return strings.Join(lines, "\n")
+}
+
+// HandleApproval lets a moderator approve or reject a pending proposal.
+func HandleApproval(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := validateBearerToken(r); err != nil {
+		log.Printf("Error validating bearer token: %v", err)
+		http.Error(w, "Invalid bearer token", http.StatusUnauthorized)
+		return
+	}
+
+	var request approvalRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid approval request", http.StatusBadRequest)
+		return
+	}
+	decision := approvalDecision(strings.ToLower(strings.TrimSpace(request.Decision)))
+	var reason impossibilityReason
+	switch decision {
+	case approvalApprove, approvalReject, approvalRequestInfo:
+	case approvalImpossible:
+		var ok bool
+		if reason, ok = parseImpossibilityReason(request.Reason); !ok {
+			http.Error(w, "reason must be force_majeure, technical or manifest_error", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "decision must be approve, reject, request_info or impossible", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(request.TicketID) == "" || strings.TrimSpace(request.Moderator) == "" {
+		http.Error(w, "ticketId and moderator are required", http.StatusBadRequest)
+		return
+	}
+
+	// a retry of the same decision by the same moderator finishes it when the
+	// request that started it was interrupted
+	requestID := fmt.Sprintf("approval-%s-%s", decision, request.Moderator)
+	result, err := decideApprovalJob(requestID, request.TicketID, decision, request.Moderator, request.Note, reason)
+	switch {
+	case errors.Is(err, errNoPendingApproval):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, errApprovalDecided):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, errApprovalRunning):
+		w.Header().Set("Retry-After", strconv.Itoa(int(approvalResumeAfter/time.Second)))
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		log.Printf("Error deciding approval for ticket %s: %v", request.TicketID, err)
+		http.Error(w, "Error deciding approval", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"ticketId":   request.TicketID,
+		"decision":   decision,
+		"banned":     len(result.Banned),
+		"notFound":   len(result.NotFound),
+		"moreInfo":   len(result.MoreInfo),
+		"impossible": len(result.Impossible),
+	}
+	if result.Error != nil {
+		response["error"] = result.Error.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// hasApprovalTag reports whether a moderator tagged the ticket to decide on a proposal.
+func hasApprovalTag(tags []string) bool {
+	_, impossible := impossibleTagReason(tags)
+	return impossible || hasTag(tags, approveTag) || hasTag(tags, rejectTag)
+}
+
+// handleApprovalTags decides a proposal from the approve, reject or impossible
+// tag a moderator added in Zendesk, as part of the queued job with the given ID.
+func handleApprovalTags(jobID string, ticket ZendeskTicket) error {
+	var decisions []approvalDecision
+	if hasTag(ticket.Tags, approveTag) {
+		decisions = append(decisions, approvalApprove)
+	}
+	if hasTag(ticket.Tags, rejectTag) {
+		decisions = append(decisions, approvalReject)
+	}
+	reason, impossible := impossibleTagReason(ticket.Tags)
+	if impossible {
+		decisions = append(decisions, approvalImpossible)
+	}
+	if len(decisions) > 1 {
+		log.Printf("Ticket %s carries tags for %v, waiting until only one is left", ticket.ID, decisions)
+		return nil
+	}
+
+	_, err := decideApprovalJob(jobID, ticket.ID, decisions[0], "Zendesk tag", "", reason)
+	if errors.Is(err, errNoPendingApproval) || errors.Is(err, errApprovalDecided) {
+		// our own tag updates arrive while the decision tag is still present
+		log.Printf("Ignoring approval tag on ticket %s: %v", ticket.ID, err)
+		return nil
+	}
+	return err
+}
+
+// decideApproval records the moderator's decision and carries it out: an
+// approval executes the proposal, a request for information asks the authority
+// for clarification instead, and impossible answers it with Annex III for the
+// given reason. The returned result is also sent to Slack.
+func decideApproval(ticketID string, decision approvalDecision, moderator string, note string, reason impossibilityReason) (*processResult, error) {
+	return decideApprovalJob("", ticketID, decision, moderator, note, reason)
+}
+
+// decideApprovalJob is decideApproval for a decision carried out by a queued
+// job or request with the given ID. When it is delivered again after its
+// instance stopped while acting, the decision it recorded is carried out again
+// instead of being refused; until approvalResumeAfter passed it may still be
+// running, and errApprovalRunning asks the caller to retry later.
+func decideApprovalJob(jobID string, ticketID string, decision approvalDecision, moderator string, note string, reason impossibilityReason) (*processResult, error) {
+	ledger, err := openLedgerFn()
+	if err != nil {
+		return nil, fmt.Errorf("opening processing ledger: %w", err)
+	}
+
+	var proposal *approvalProposal
+	_, err = ledger.Update(ticketID, func(entry *ledgerEntry) (*ledgerEntry, error) {
+		if entry == nil || entry.Proposal == nil {
+			return nil, errNoPendingApproval
+		}
+		now := nowFn().UTC()
+		if jobID != "" && entry.Proposal.DecisionJobID == jobID && entry.State == ledgerStateActing {
+			if now.Sub(entry.UpdatedAt) < approvalResumeAfter {
+				return nil, errApprovalRunning
+			}
+			entry.setState(ledgerStateActing, now, fmt.Sprintf("%s by %s resumed", entry.Proposal.Decision, entry.Proposal.DecidedBy))
+			proposal = entry.Proposal
+			return entry, nil
+		}
+		if entry.State != ledgerStateAwaitingApproval || entry.Proposal.Decision != "" {
+			return nil, errApprovalDecided
+		}
+		entry.Proposal.Decision = decision
+		entry.Proposal.DecidedBy = moderator
+		entry.Proposal.DecidedAt = now
+		entry.Proposal.Note = note
+		entry.Proposal.Reason = reason
+		entry.Proposal.DecisionJobID = jobID
+		if decision == approvalReject {
+			entry.setState(ledgerStateCompleted, now, "rejected by "+moderator)
+		} else {
+			entry.setState(ledgerStateActing, now, fmt.Sprintf("%s by %s", decision, moderator))
+		}
+		proposal = entry.Proposal
+		return entry, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	recordAudit(ticketID, auditApprovalDecided, map[string]interface{}{
+		"decision":  decision,
+		"moderator": moderator,
+		"note":      note,
+		"reason":    reason,
+	})
+
+	result := &processResult{
+		TicketID:   ticketID,
+		Subject:    proposal.Subject,
+		ReceivedAt: proposal.ReceivedAt,
+		Review:     proposal.Review,
+		Decision:   decision,
+		DecidedBy:  moderator,
+	}
+	defer func() {
+		result.Elapsed, _ = orderElapsed(ticketID)
+		if err := notifySlackFn(*result); err != nil {
+			log.Printf("Error sending Slack notification: %v", err)
+		}
+	}()
+
+	if decision == approvalReject {
+		if err := stopDeadline(ledger, ticketID, deadlineOutcomeRejected); err != nil {
+			log.Printf("Error recording deadline of ticket %s: %v", ticketID, err)
+		}
+		message := fmt.Sprintf("%s Proposal rejected by %s; no account was banned and the authority was not answered.", agentNotePrefix, moderator)
+		if strings.TrimSpace(note) != "" {
+			message += "\n\n" + note
+		}
+		if err := addInternalNoteFn(ticketID, message); err != nil {
+			log.Printf("Error adding rejection note to ticket %s: %v", ticketID, err)
+		}
+		if err := tagTicketFn(ticketID, []string{agentTag, decisionTagRejected}); err != nil {
+			log.Printf("Error tagging ticket %s: %v", ticketID, err)
+		}
+		if err := untagTicketFn(ticketID, []string{decisionTagPendingApproval, rejectTag}); err != nil {
+			log.Printf("Error removing approval tags from ticket %s: %v", ticketID, err)
+		}
+		return result, nil
+	}
+
+	doneTags := []string{decisionTagPendingApproval}
+	proposed := append(append(append([]agentData{}, proposal.Ban...), proposal.MoreInfo...), proposal.Impossible...)
+	switch decision {
+	case approvalRequestInfo:
+		// nothing is banned; every account of the order is part of the clarification request
+		var moreInfo []agentData
+		for _, data := range proposed {
+			if strings.TrimSpace(data.Reason) == "" || data.Data.ImpossibilityReason != "" {
+				data.Reason = fallbackValue(strings.TrimSpace(note), "the order needs clarification before it can be carried out")
+			}
+			moreInfo = append(moreInfo, data)
+		}
+		executeDecisions(result, nil, moreInfo, nil)
+	case approvalImpossible:
+		executeDecisions(result, nil, nil, markImpossible(proposed, reason, note))
+		doneTags = append(doneTags, reason.tag())
+	default:
+		executeDecisions(result, proposal.Ban, proposal.MoreInfo, proposal.Impossible)
+		doneTags = append(doneTags, approveTag)
+	}
+
+	state := ledgerStateCompleted
+	if result.Error != nil {
+		state = ledgerStateFailed
+	}
+	if err := markTicketState(ledger, ticketID, state, result.Error); err != nil {
+		log.Printf("Error updating processing ledger for ticket %s: %v", ticketID, err)
+	}
+	if err := untagTicketFn(ticketID, doneTags); err != nil {
+		log.Printf("Error removing approval tags from ticket %s: %v", ticketID, err)
+	}
+	return result, nil
+}
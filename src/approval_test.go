@@ -0,0 +1,335 @@
+package tco_vo_agent
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type approvalStubs struct {
+	banned  []agentData
+	replies []ReplyToTicketTemplate
+	notes   []string
+	tags    []string
+	untags  []string
+	slack   []processResult
+}
+
+func stubApprovalPipeline(t *testing.T) *approvalStubs {
+	t.Helper()
+	t.Setenv("LEDGER_DIR", t.TempDir())
+	t.Setenv("APPROVAL_MODE", "true")
+
+	origGetAttachments := getAttachmentsFn
+	origExtractData := extractDataFn
+	origBanUsers := banUsersFn
+	origReplyToTickets := replyToTicketsFn
+	origTag := tagTicketFn
+	origUntag := untagTicketFn
+	origNote := addInternalNoteFn
+	origNotifySlack := notifySlackFn
+	t.Cleanup(func() {
+		getAttachmentsFn = origGetAttachments
+		extractDataFn = origExtractData
+		banUsersFn = origBanUsers
+		replyToTicketsFn = origReplyToTickets
+		tagTicketFn = origTag
+		untagTicketFn = origUntag
+		addInternalNoteFn = origNote
+		notifySlackFn = origNotifySlack
+	})
+	stubPreservation(t)
+	stubLookup(t)
+
+	stubs := &approvalStubs{}
+	getAttachmentsFn = func(ticketId string) ([]string, error) { return nil, nil }
+	extractDataFn = func(paths []string, agents []agentConfig) ([]agentData, []agentError) {
+		return []agentData{{Data: FraudDecision{
+			Username:        "jane",
+			AgencyName:      "Bundeskriminalamt",
+			ReferenceNumber: "REF-1",
+		}}}, nil
+	}
+	banUsersFn = func(data []agentData) (banResults, error) {
+		stubs.banned = append(stubs.banned, data...)
+		return banResults{Banned: data}, nil
+	}
+	replyToTicketsFn = func(tickets []agentData, messageTemplate ReplyToTicketTemplate) error {
+		if len(tickets) > 0 {
+			stubs.replies = append(stubs.replies, messageTemplate)
+		}
+		return nil
+	}
+	tagTicketFn = func(ticketId string, tags []string) error {
+		stubs.tags = append(stubs.tags, tags...)
+		return nil
+	}
+	untagTicketFn = func(ticketId string, tags []string) error {
+		stubs.untags = append(stubs.untags, tags...)
+		return nil
+	}
+	addInternalNoteFn = func(ticketId string, message string) error {
+		stubs.notes = append(stubs.notes, message)
+		return nil
+	}
+	notifySlackFn = func(result processResult) error {
+		stubs.slack = append(stubs.slack, result)
+		return nil
+	}
+	return stubs
+}
+
+func TestApprovalModeHoldsBansUntilApproved(t *testing.T) {
+	t.Setenv("BEARER_TOKEN", "secret")
+	stubs := stubApprovalPipeline(t)
+
+	if err := processTicketsAsync(ZendeskTicket{ID: "7", Subject: "Order"}); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	if len(stubs.banned) != 0 || len(stubs.replies) != 0 {
+		t.Fatalf("nothing may happen before approval, banned=%+v replies=%v", stubs.banned, stubs.replies)
+	}
+	if len(stubs.notes) != 1 || !strings.HasPrefix(stubs.notes[0], agentNotePrefix) || !strings.Contains(stubs.notes[0], "jane") {
+		t.Fatalf("expected a proposal note, got %q", stubs.notes)
+	}
+	if !hasTag(stubs.tags, decisionTagPendingApproval) {
+		t.Fatalf("expected the pending approval tag, got %v", stubs.tags)
+	}
+	if len(stubs.slack) != 1 || !strings.Contains(buildSlackText(stubs.slack[0]), "awaiting approval") {
+		t.Fatalf("expected an approval prompt in Slack, got %+v", stubs.slack)
+	}
+
+	// the update event caused by the proposal must not start another run
+	if err := processTicketsAsync(ZendeskTicket{ID: "7", Tags: stubs.tags}); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	if len(stubs.notes) != 1 {
+		t.Fatalf("expected the proposal to be written once, got %d notes", len(stubs.notes))
+	}
+
+	approve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/approvals", strings.NewReader(`{"ticketId":"7","decision":"approve","moderator":"alice"}`))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		ProcessTickets(rec, req)
+		return rec
+	}
+	if rec := approve(); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(stubs.banned) != 1 || len(stubs.replies) != 1 || stubs.replies[0] != "user_banned" {
+		t.Fatalf("expected the approved ban and reply, banned=%+v replies=%v", stubs.banned, stubs.replies)
+	}
+	if !hasTag(stubs.untags, decisionTagPendingApproval) {
+		t.Fatalf("expected the pending tag to be removed, got %v", stubs.untags)
+	}
+	if last := stubs.slack[len(stubs.slack)-1]; last.Decision != approvalApprove || last.DecidedBy != "alice" {
+		t.Fatalf("expected the approval in Slack, got %+v", last)
+	}
+
+	if rec := approve(); rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 on a second decision, got %d", rec.Code)
+	}
+	if len(stubs.banned) != 1 {
+		t.Fatalf("the ban must not be repeated, got %+v", stubs.banned)
+	}
+}
+
+func TestApprovalRejectedByTag(t *testing.T) {
+	stubs := stubApprovalPipeline(t)
+
+	if err := processTicketsAsync(ZendeskTicket{ID: "8"}); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	tags := append(stubs.tags, rejectTag)
+	if err := processTicketsAsync(ZendeskTicket{ID: "8", Tags: tags}); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	if len(stubs.banned) != 0 || len(stubs.replies) != 0 {
+		t.Fatalf("a rejected proposal must not act, banned=%+v replies=%v", stubs.banned, stubs.replies)
+	}
+	if !hasTag(stubs.tags, decisionTagRejected) || !hasTag(stubs.untags, rejectTag) {
+		t.Fatalf("expected the rejected tag, tags=%v untags=%v", stubs.tags, stubs.untags)
+	}
+
+	entry, err := (&fileLedger{dir: stateDir("LEDGER_DIR", "ledger")}).Get("8")
+	if err != nil || entry == nil || entry.State != ledgerStateCompleted || entry.Proposal.Decision != approvalReject {
+		t.Fatalf("expected a rejected proposal in the ledger, got %+v (%v)", entry, err)
+	}
+}
+
+func TestHandleApprovalValidatesRequest(t *testing.T) {
+	t.Setenv("BEARER_TOKEN", "secret")
+	t.Setenv("LEDGER_DIR", t.TempDir())
+
+	tests := []struct {
+		name   string
+		auth   string
+		body   string
+		status int
+	}{
+		{"missing token", "", `{"ticketId":"1","decision":"approve","moderator":"a"}`, http.StatusUnauthorized},
+		{"bad decision", "Bearer secret", `{"ticketId":"1","decision":"maybe","moderator":"a"}`, http.StatusBadRequest},
+		{"no moderator", "Bearer secret", `{"ticketId":"1","decision":"approve"}`, http.StatusBadRequest},
+		{"nothing pending", "Bearer secret", `{"ticketId":"1","decision":"approve","moderator":"a"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/approvals", strings.NewReader(tt.body))
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			HandleApproval(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestApprovalRequestInfoAsksAuthority(t *testing.T) {
+	stubs := stubApprovalPipeline(t)
+
+	if err := processTicketsAsync(ZendeskTicket{ID: "9"}); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	result, err := decideApproval("9", approvalRequestInfo, "alice", "Which profile is meant?", "")
+	if err != nil {
+		t.Fatalf("decideApproval returned error: %v", err)
+	}
+	if len(stubs.banned) != 0 {
+		t.Fatalf("nothing may be banned, got %+v", stubs.banned)
+	}
+	if len(stubs.replies) != 1 || stubs.replies[0] != "more_info_required" {
+		t.Fatalf("expected a clarification request, got %v", stubs.replies)
+	}
+	if len(result.MoreInfo) != 1 || result.MoreInfo[0].Reason != "Which profile is meant?" {
+		t.Fatalf("expected the moderator's question as reason, got %+v", result.MoreInfo)
+	}
+}
+
+func TestDecideApprovalJobResumesInterruptedDecision(t *testing.T) {
+	stubs := stubApprovalPipeline(t)
+
+	if err := processTicketsAsync(ZendeskTicket{ID: "10"}); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	// the instance stopped after the decision was claimed but before it was carried out
+	ledger := &fileLedger{dir: stateDir("LEDGER_DIR", "ledger")}
+	_, err := ledger.Update("10", func(entry *ledgerEntry) (*ledgerEntry, error) {
+		entry.Proposal.Decision = approvalApprove
+		entry.Proposal.DecidedBy = "alice"
+		entry.Proposal.DecisionJobID = "job-1"
+		entry.setState(ledgerStateActing, time.Now().UTC(), "approve by alice")
+		return entry, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to prepare ledger: %v", err)
+	}
+
+	// until approvalResumeAfter passed the first delivery may still be running
+	if _, err := decideApprovalJob("job-1", "10", approvalApprove, "alice", "", ""); !errors.Is(err, errApprovalRunning) {
+		t.Fatalf("expected the running decision to be left alone, got %v", err)
+	}
+	origNow := nowFn
+	t.Cleanup(func() { nowFn = origNow })
+	nowFn = func() time.Time { return time.Now().Add(approvalResumeAfter + time.Minute) }
+
+	if _, err := decideApprovalJob("job-2", "10", approvalApprove, "bob", "", ""); !errors.Is(err, errApprovalDecided) {
+		t.Fatalf("expected another job to be refused, got %v", err)
+	}
+	if len(stubs.banned) != 0 {
+		t.Fatalf("nothing may be banned by another job, got %+v", stubs.banned)
+	}
+
+	if _, err := decideApprovalJob("job-1", "10", approvalApprove, "alice", "", ""); err != nil {
+		t.Fatalf("decideApprovalJob returned error: %v", err)
+	}
+	if len(stubs.banned) != 1 || len(stubs.replies) != 1 {
+		t.Fatalf("expected the redelivered decision to be carried out, banned=%+v replies=%v", stubs.banned, stubs.replies)
+	}
+	entry, err := ledger.Get("10")
+	if err != nil || entry == nil || entry.State != ledgerStateCompleted {
+		t.Fatalf("expected a completed ticket, got %+v (%v)", entry, err)
+	}
+
+	if _, err := decideApprovalJob("job-1", "10", approvalApprove, "alice", "", ""); !errors.Is(err, errApprovalDecided) {
+		t.Fatalf("a completed decision must not run again, got %v", err)
+	}
+}
+
+func TestApprovalTagResumesInterruptedDecision(t *testing.T) {
+	stubs := stubApprovalPipeline(t)
+
+	if err := processTicketsAsync(ZendeskTicket{ID: "11"}); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	tags := append(append([]string{}, stubs.tags...), approveTag)
+
+	// the job's first delivery claimed the decision, then its instance stopped
+	ledger := &fileLedger{dir: stateDir("LEDGER_DIR", "ledger")}
+	_, err := ledger.Update("11", func(entry *ledgerEntry) (*ledgerEntry, error) {
+		entry.Proposal.Decision = approvalApprove
+		entry.Proposal.DecidedBy = "Zendesk tag"
+		entry.Proposal.DecisionJobID = "job-7"
+		entry.setState(ledgerStateActing, time.Now().UTC().Add(-2*approvalResumeAfter), "approve by Zendesk tag")
+		return entry, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to prepare ledger: %v", err)
+	}
+
+	if err := processTicketJob("job-7", ZendeskTicket{ID: "11", Tags: tags}); err != nil {
+		t.Fatalf("processTicketJob returned error: %v", err)
+	}
+	if len(stubs.banned) != 1 || len(stubs.replies) != 1 {
+		t.Fatalf("expected the redelivered job to carry out the approval, banned=%+v replies=%v", stubs.banned, stubs.replies)
+	}
+}
+
+func TestApprovalRequestRetryFinishesInterruptedDecision(t *testing.T) {
+	t.Setenv("BEARER_TOKEN", "secret")
+	stubs := stubApprovalPipeline(t)
+
+	if err := processTicketsAsync(ZendeskTicket{ID: "12"}); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	approve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/approvals", strings.NewReader(`{"ticketId":"12","decision":"approve","moderator":"alice"}`))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		HandleApproval(rec, req)
+		return rec
+	}
+
+	// the first request claimed the decision, then its instance stopped
+	ledger := &fileLedger{dir: stateDir("LEDGER_DIR", "ledger")}
+	claimedAt := time.Now().UTC()
+	_, err := ledger.Update("12", func(entry *ledgerEntry) (*ledgerEntry, error) {
+		entry.Proposal.Decision = approvalApprove
+		entry.Proposal.DecidedBy = "alice"
+		entry.Proposal.DecisionJobID = "approval-approve-alice"
+		entry.setState(ledgerStateActing, claimedAt, "approve by alice")
+		return entry, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to prepare ledger: %v", err)
+	}
+
+	if rec := approve(); rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected a retry to wait while the decision may still run, got %d", rec.Code)
+	}
+	origNow := nowFn
+	t.Cleanup(func() { nowFn = origNow })
+	nowFn = func() time.Time { return claimedAt.Add(approvalResumeAfter + time.Second) }
+	if rec := approve(); rec.Code != http.StatusOK {
+		t.Fatalf("expected the retry to finish the decision, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(stubs.banned) != 1 {
+		t.Fatalf("expected the approved ban, got %+v", stubs.banned)
+	}
+}
@@ -0,0 +1,480 @@
+package tco_vo_agent
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// documentKind tells the model runners how to pass a file to the model.
+type documentKind string
+
+const (
+	documentKindPDF   documentKind = "pdf"
+	documentKindImage documentKind = "image"
+	documentKindText  documentKind = "text"
+
+	docxContentType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+)
+
+// agentReplyPrefix and agentNotePrefix mark our own replies and internal
+// notes, which must not be fed back to the model.
+const (
+	agentReplyPrefix = "Subject: TCO removal order"
+	agentNotePrefix  = "[TCO agent]"
+)
+
+var imageExtensions = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// orderDocument is an ingested file ready to be sent to a model.
+type orderDocument struct {
+	Path     string
+	Name     string
+	Kind     documentKind
+	MimeType string
+	Data     []byte
+}
+
+// Text returns the document content for text documents.
+func (d orderDocument) Text() string {
+	return string(d.Data)
+}
+
+// documentKindForPath derives the kind from the extension written by the ingestion layer.
+func documentKindForPath(path string) (documentKind, string) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".pdf" {
+		return documentKindPDF, "application/pdf"
+	}
+	if mimeType, ok := imageExtensions[ext]; ok {
+		return documentKindImage, mimeType
+	}
+	return documentKindText, "text/plain"
+}
+
+// loadOrderDocuments reads the files produced by GetAttachments for a model runner.
+func loadOrderDocuments(paths []string) ([]orderDocument, error) {
+	docs := make([]orderDocument, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		kind, mimeType := documentKindForPath(path)
+		docs = append(docs, orderDocument{
+			Path:     path,
+			Name:     filepath.Base(path),
+			Kind:     kind,
+			MimeType: mimeType,
+			Data:     data,
+		})
+	}
+	return docs, nil
+}
+
+// detectContentType normalises the declared content type, falling back to the
+// file extension and finally to sniffing the content.
+func detectContentType(declared, fileName string, data []byte) string {
+	contentType := strings.ToLower(strings.TrimSpace(declared))
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = mediaType
+	}
+	if contentType != "" && contentType != "application/octet-stream" {
+		return contentType
+	}
+
+	ext := strings.ToLower(filepath.Ext(fileName))
+	switch ext {
+	case ".pdf":
+		return "application/pdf"
+	case ".docx":
+		return docxContentType
+	case ".eml":
+		return "message/rfc822"
+	case ".txt":
+		return "text/plain"
+	case ".html", ".htm":
+		return "text/html"
+	}
+	if mimeType, ok := imageExtensions[ext]; ok {
+		return mimeType
+	}
+
+	// only trust sniffing for binary formats, everything else sniffs as text
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if sniffed == "application/pdf" || strings.HasPrefix(sniffed, "image/") {
+		return sniffed
+	}
+	return "application/octet-stream"
+}
+
+// isIngestible reports whether an attachment may be supported, so unsupported
+// files are not downloaded at all. Unknown types are downloaded and sniffed.
+func isIngestible(contentType, fileName string) bool {
+	switch contentType = detectContentType(contentType, fileName, nil); {
+	case contentType == "application/octet-stream",
+		contentType == "application/pdf",
+		contentType == docxContentType,
+		contentType == "message/rfc822",
+		strings.HasPrefix(contentType, "text/"):
+		return true
+	case strings.HasPrefix(contentType, "image/"):
+		for _, mimeType := range imageExtensions {
+			if mimeType == contentType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ingestAttachment converts an attachment into files the runners understand:
+// PDFs and images are kept as they are, DOCX and text are converted to .txt and
+// EML files are split into their text and their own attachments.
+func ingestAttachment(ticketId, fileName, contentType string, data []byte) ([]string, error) {
+	contentType = detectContentType(contentType, fileName, data)
+
+	switch {
+	case contentType == "application/pdf":
+		path, err := writeIngestedFile(ticketId, ".pdf", data)
+		if err != nil {
+			return nil, err
+		}
+		return []string{path}, nil
+
+	case strings.HasPrefix(contentType, "image/"):
+		ext := ""
+		for candidate, mimeType := range imageExtensions {
+			if mimeType == contentType {
+				ext = candidate
+				break
+			}
+		}
+		if ext == "" {
+			return nil, fmt.Errorf("unsupported image type %s", contentType)
+		}
+		if ext == ".jpeg" {
+			ext = ".jpg"
+		}
+		path, err := writeIngestedFile(ticketId, ext, data)
+		if err != nil {
+			return nil, err
+		}
+		return []string{path}, nil
+
+	case contentType == docxContentType:
+		text, err := docxToText(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert %s: %w", fileName, err)
+		}
+		return writeIngestedText(ticketId, fileName, text)
+
+	case contentType == "message/rfc822":
+		return ingestEmail(ticketId, fileName, data)
+
+	case contentType == "text/html":
+		return writeIngestedText(ticketId, fileName, htmlToText(string(data)))
+
+	case strings.HasPrefix(contentType, "text/"):
+		return writeIngestedText(ticketId, fileName, string(data))
+
+	default:
+		log.Printf("Skipping attachment %s of ticket %s: unsupported content type %s", fileName, ticketId, contentType)
+		return nil, nil
+	}
+}
+
+func writeIngestedFile(ticketId, ext string, data []byte) (string, error) {
+	tempFile, err := os.CreateTemp("", safeFileName(ticketId)+"-attachment-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	defer tempFile.Close()
+	if _, err := tempFile.Write(data); err != nil {
+		os.Remove(tempFile.Name())
+		return "", err
+	}
+	return tempFile.Name(), nil
+}
+
+func writeIngestedText(ticketId, name, text string) ([]string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+	path, err := writeIngestedFile(ticketId, ".txt", []byte(fmt.Sprintf("Source: %s\n\n%s\n", name, text)))
+	if err != nil {
+		return nil, err
+	}
+	return []string{path}, nil
+}
+
+// writeTicketText stores the ticket description and comment bodies so orders
+// sent without any attachment can still be extracted.
+func writeTicketText(ticketId string, comments []ZendeskComment) ([]string, error) {
+	var parts []string
+	for i, comment := range comments {
+		body := strings.TrimSpace(comment.Body)
+		if body == "" || strings.HasPrefix(body, agentReplyPrefix) || strings.HasPrefix(body, agentNotePrefix) {
+			continue
+		}
+		label := "Comment"
+		if i == 0 {
+			label = "Ticket description"
+		}
+		parts = append(parts, fmt.Sprintf("%s (%s):\n%s", label, comment.CreatedAt, body))
+	}
+	if len(parts) == 0 {
+		return nil, nil
+	}
+	return writeIngestedText(ticketId, "ticket "+ticketId, strings.Join(parts, "\n\n"))
+}
+
+// docxToText extracts the paragraphs of word/document.xml.
+func docxToText(data []byte) (string, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+
+	var document *zip.File
+	for _, f := range reader.File {
+		if f.Name == "word/document.xml" {
+			document = f
+			break
+		}
+	}
+	if document == nil {
+		return "", errors.New("word/document.xml not found")
+	}
+
+	rc, err := document.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	var text strings.Builder
+	inText := false
+	decoder := xml.NewDecoder(rc)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				text.WriteString("\t")
+			case "br", "cr":
+				text.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				text.Write(t)
+			}
+		}
+	}
+	return text.String(), nil
+}
+
+// ingestEmail writes the headers and text parts of a forwarded email and
+// ingests its attachments like any other attachment.
+func ingestEmail(ticketId, fileName string, data []byte) ([]string, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse email %s: %w", fileName, err)
+	}
+
+	decoder := new(mime.WordDecoder)
+	var header strings.Builder
+	for _, key := range []string{"From", "To", "Date", "Subject"} {
+		value := msg.Header.Get(key)
+		if decoded, err := decoder.DecodeHeader(value); err == nil {
+			value = decoded
+		}
+		if value != "" {
+			fmt.Fprintf(&header, "%s: %s\n", key, value)
+		}
+	}
+
+	var texts []string
+	var paths []string
+	err = walkEmailPart(msg.Header, msg.Body, func(contentType, partName string, content []byte) error {
+		if partName == "" && strings.HasPrefix(contentType, "text/") {
+			if contentType == "text/html" {
+				texts = append(texts, htmlToText(string(content)))
+			} else {
+				texts = append(texts, string(content))
+			}
+			return nil
+		}
+		if partName == "" {
+			partName = "part" + filepath.Ext(fileName)
+		}
+		nested, err := ingestAttachment(ticketId, partName, contentType, content)
+		if err != nil {
+			log.Printf("Skipping part %s of email %s: %v", partName, fileName, err)
+			return nil
+		}
+		paths = append(paths, nested...)
+		return nil
+	})
+	if err != nil {
+		removeAttachmentFiles(paths)
+		return nil, fmt.Errorf("failed to read email %s: %w", fileName, err)
+	}
+
+	textPaths, err := writeIngestedText(ticketId, fileName, header.String()+"\n"+strings.Join(texts, "\n\n"))
+	if err != nil {
+		removeAttachmentFiles(paths)
+		return nil, err
+	}
+	return append(textPaths, paths...), nil
+}
+
+type partHeader interface {
+	Get(key string) string
+}
+
+// walkEmailPart calls fn for every leaf part with its decoded content. The part
+// name is set for parts that are attachments rather than body text.
+func walkEmailPart(header partHeader, body io.Reader, fn func(contentType, partName string, content []byte) error) error {
+	contentType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		contentType = "text/plain"
+	}
+
+	if strings.HasPrefix(contentType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := walkEmailPart(part.Header, part, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	var decoded io.Reader = body
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		decoded = base64.NewDecoder(base64.StdEncoding, newlineStripper{body})
+	case "quoted-printable":
+		decoded = quotedprintable.NewReader(body)
+	}
+	content, err := io.ReadAll(decoded)
+	if err != nil {
+		return err
+	}
+
+	partName := ""
+	if disposition, dparams, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		partName = dparams["filename"]
+		if partName == "" && disposition == "attachment" {
+			partName = "attachment"
+		}
+	}
+	if partName == "" {
+		partName = params["name"]
+	}
+	if contentType == "message/rfc822" && partName == "" {
+		partName = "forwarded.eml"
+	}
+	return fn(contentType, partName, content)
+}
+
+// newlineStripper drops line breaks so base64 bodies wrapped at 76 columns decode.
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (s newlineStripper) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	out := p[:0]
+	for _, b := range p[:n] {
+		if b != '\r' && b != '\n' {
+			out = append(out, b)
+		}
+	}
+	return len(out), err
+}
+
+// htmlToText strips tags and turns block elements into line breaks.
+func htmlToText(src string) string {
+	var out strings.Builder
+	inTag := false
+	var tag strings.Builder
+	skip := false
+	for _, r := range src {
+		switch {
+		case r == '<':
+			inTag = true
+			tag.Reset()
+		case r == '>' && inTag:
+			inTag = false
+			fields := strings.Fields(tag.String())
+			if len(fields) == 0 {
+				// "<>" or "< >" is no tag
+				continue
+			}
+			name := strings.ToLower(fields[0])
+			switch strings.TrimPrefix(name, "/") {
+			case "br", "p", "div", "tr", "li", "h1", "h2", "h3", "h4", "table":
+				out.WriteString("\n")
+			case "script", "style":
+				skip = !strings.HasPrefix(name, "/")
+			}
+		case inTag:
+			tag.WriteRune(r)
+		case !skip:
+			out.WriteRune(r)
+		}
+	}
+	lines := strings.Split(html.UnescapeString(out.String()), "\n")
+	var kept []string
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
@@ -0,0 +1,140 @@
+package tco_vo_agent
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func buildDocx(t *testing.T, paragraphs ...string) []byte {
+	t.Helper()
+
+	var body strings.Builder
+	for _, p := range paragraphs {
+		body.WriteString(`<w:p><w:r><w:t>` + p + `</w:t></w:r></w:p>`)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("word/document.xml")
+	if err != nil {
+		t.Fatalf("failed to create docx entry: %v", err)
+	}
+	w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` + body.String() + `</w:body></w:document>`))
+	zw.Close()
+	return buf.Bytes()
+}
+
+func readIngested(t *testing.T, paths []string) map[documentKind][]string {
+	t.Helper()
+	t.Cleanup(func() { removeAttachmentFiles(paths) })
+
+	docs, err := loadOrderDocuments(paths)
+	if err != nil {
+		t.Fatalf("loadOrderDocuments returned error: %v", err)
+	}
+	byKind := map[documentKind][]string{}
+	for _, doc := range docs {
+		byKind[doc.Kind] = append(byKind[doc.Kind], doc.Text())
+	}
+	return byKind
+}
+
+func TestIngestAttachmentFormats(t *testing.T) {
+	docx, err := ingestAttachment("1", "order.docx", "application/octet-stream", buildDocx(t, "Username: jane_doe", "Reference: REF-1"))
+	if err != nil {
+		t.Fatalf("docx ingestion returned error: %v", err)
+	}
+	got := readIngested(t, docx)
+	if len(got[documentKindText]) != 1 || !strings.Contains(got[documentKindText][0], "Username: jane_doe\nReference: REF-1") {
+		t.Fatalf("unexpected docx text: %+v", got)
+	}
+
+	image, err := ingestAttachment("1", "scan.JPG", "image/jpeg", []byte("\xff\xd8\xff"))
+	if err != nil || len(image) != 1 || !strings.HasSuffix(image[0], ".jpg") {
+		t.Fatalf("image ingestion = %v, %v", image, err)
+	}
+	readIngested(t, image)
+
+	html, _ := ingestAttachment("1", "order.html", "text/html", []byte("<p>Agency:&nbsp;BKA</p><script>x()</script><br>Ref 7"))
+	got = readIngested(t, html)
+	if len(got[documentKindText]) != 1 || !strings.Contains(got[documentKindText][0], "Agency:\u00a0BKA\nRef 7") {
+		t.Fatalf("unexpected html text: %+v", got)
+	}
+	if text := htmlToText("<p>a<>b< >c</p>"); text != "abc" {
+		t.Fatalf("htmlToText with empty tags = %q, want abc", text)
+	}
+
+	skipped, err := ingestAttachment("1", "clip.mp4", "video/mp4", []byte("...."))
+	if err != nil || len(skipped) != 0 {
+		t.Fatalf("unsupported attachment = %v, %v, want skipped", skipped, err)
+	}
+	if isIngestible("video/mp4", "clip.mp4") || !isIngestible("", "order.eml") {
+		t.Fatal("isIngestible does not match the supported formats")
+	}
+}
+
+func TestIngestEmailWithAttachments(t *testing.T) {
+	eml := strings.ReplaceAll(`From: Authority <orders@authority.example>
+Subject: =?UTF-8?Q?Entfernungsanordnung_f=C3=BCr_jane=5Fdoe?=
+Content-Type: multipart/mixed; boundary="b1"
+
+--b1
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+Please remove the content of jane_doe. Reference REF-=
+42.
+--b1
+Content-Type: application/pdf; name="order.pdf"
+Content-Disposition: attachment; filename="order.pdf"
+Content-Transfer-Encoding: base64
+
+JVBERi0xLjQgb3JkZXI=
+--b1--
+`, "\n", "\r\n")
+
+	paths, err := ingestAttachment("9", "forwarded.eml", "message/rfc822", []byte(eml))
+	if err != nil {
+		t.Fatalf("ingestAttachment returned error: %v", err)
+	}
+	got := readIngested(t, paths)
+
+	if len(got[documentKindText]) != 1 {
+		t.Fatalf("expected one text document, got %+v", got)
+	}
+	text := got[documentKindText][0]
+	for _, expected := range []string{"Subject: Entfernungsanordnung für jane_doe", "From: Authority", "Reference REF-42."} {
+		if !strings.Contains(text, expected) {
+			t.Fatalf("email text missing %q:\n%s", expected, text)
+		}
+	}
+	if len(got[documentKindPDF]) != 1 || got[documentKindPDF][0] != "%PDF-1.4 order" {
+		t.Fatalf("expected the nested PDF to be extracted, got %+v", got[documentKindPDF])
+	}
+}
+
+func TestWriteTicketTextSkipsAgentReplies(t *testing.T) {
+	paths, err := writeTicketText("5", []ZendeskComment{
+		{Body: "Removal order for user jane_doe"},
+		{Body: agentReplyPrefix + " – clarification required"},
+		{Body: "Reference: REF-5"},
+	})
+	if err != nil {
+		t.Fatalf("writeTicketText returned error: %v", err)
+	}
+	got := readIngested(t, paths)
+
+	text := strings.Join(got[documentKindText], "")
+	if !strings.Contains(text, "Ticket description") || !strings.Contains(text, "REF-5") {
+		t.Fatalf("ticket text missing content:\n%s", text)
+	}
+	if strings.Contains(text, "clarification required") {
+		t.Fatalf("agent replies must not be passed to the model:\n%s", text)
+	}
+
+	if paths, _ := writeTicketText("6", []ZendeskComment{{Body: "  "}}); len(paths) != 0 {
+		t.Fatalf("empty tickets must not produce a text file, got %v", paths)
+	}
+}
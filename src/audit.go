@@ -0,0 +1,350 @@
+package tco_vo_agent
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Audit event types, in the order they usually occur for a ticket.
+const (
+	auditWebhookReceived      = "webhook_received"
+	auditAttachments          = "attachments"
+	auditAgentOutput          = "agent_output"
+	auditAgentError           = "agent_error"
+	auditConsensus            = "consensus"
+	auditApprovalProposed     = "approval_proposed"
+	auditApprovalDecided      = "approval_decided"
+	auditLookupRequest        = "lookup_request"
+	auditLookupResponse       = "lookup_response"
+	auditBanRequest           = "ban_request"
+	auditBanResponse          = "ban_response"
+	auditContentRequest       = "content_request"
+	auditContentResponse      = "content_response"
+	auditPreservationRequest  = "preservation_request"
+	auditPreservationResponse = "preservation_response"
+	auditPreserved            = "preserved"
+	auditReplySent            = "reply_sent"
+	auditTagged               = "tagged"
+	auditReinstateRequest     = "reinstate_request"
+	auditReinstateResponse    = "reinstate_response"
+	auditReinstated           = "reinstated"
+)
+
+var (
+	openAuditStoreFn = openAuditStore
+	fileAuditMu      sync.Mutex
+)
+
+// auditEvent is one immutable record of what the service received, decided or sent.
+type auditEvent struct {
+	ID       string          `json:"id"`
+	TicketID string          `json:"ticketId"`
+	Type     string          `json:"type"`
+	At       time.Time       `json:"at"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+// auditFilter selects events for export. Empty fields match everything.
+type auditFilter struct {
+	TicketID string
+	From     time.Time
+	To       time.Time
+}
+
+func (f auditFilter) matches(event auditEvent) bool {
+	if f.TicketID != "" && event.TicketID != f.TicketID {
+		return false
+	}
+	if !f.From.IsZero() && event.At.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !event.At.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// auditStore is an append-only event log. Implementations must never modify
+// or delete events once they were appended.
+type auditStore interface {
+	Append(event auditEvent) error
+	// List returns the matching events ordered by time.
+	List(filter auditFilter) ([]auditEvent, error)
+}
+
+// openAuditStore returns the store selected by AUDIT_BACKEND ("file" or "gcs").
+func openAuditStore() (auditStore, error) {
+	if err := validateAuditConfig(); err != nil {
+		return nil, err
+	}
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("AUDIT_BACKEND")))
+	switch backend {
+	case "", "file":
+		return &fileAuditStore{dir: stateDir("AUDIT_DIR", "audit")}, nil
+	case "gcs":
+		return newGCSAuditStoreFromEnv()
+	default:
+		return nil, fmt.Errorf("unsupported AUDIT_BACKEND %s", backend)
+	}
+}
+
+// validateAuditConfig refuses a production deployment whose audit trail would
+// default to the temporary directory of a single instance: its events would
+// be lost on every recycle and exports would only show what one instance saw.
+func validateAuditConfig() error {
+	if !isProductionMode() {
+		return nil
+	}
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("AUDIT_BACKEND")))
+	if (backend == "" || backend == "file") && strings.TrimSpace(os.Getenv("AUDIT_DIR")) == "" {
+		return errors.New("AUDIT_BACKEND=gcs or an AUDIT_DIR on shared storage is required in production")
+	}
+	return nil
+}
+
+// recordAudit appends an event for the ticket. Audit failures are logged and
+// do not stop the pipeline; the log line still carries the event.
+func recordAudit(ticketID string, eventType string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error encoding audit event %s for ticket %s: %v", eventType, ticketID, err)
+		return
+	}
+	now := nowFn().UTC()
+	event := auditEvent{
+		ID:       newAuditEventID(now, eventType),
+		TicketID: ticketID,
+		Type:     eventType,
+		At:       now,
+		Data:     payload,
+	}
+
+	store, err := openAuditStoreFn()
+	if err == nil {
+		err = store.Append(event)
+	}
+	if err != nil {
+		log.Printf("Error writing audit event %s for ticket %s: %v (data: %s)", eventType, ticketID, err, payload)
+	}
+}
+
+// newAuditEventID returns an ID that sorts by time and never repeats, even for
+// events recorded within the same clock tick.
+func newAuditEventID(at time.Time, eventType string) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%020d-%s-%s", at.UnixNano(), eventType, hex.EncodeToString(suffix))
+}
+
+// recordAuditPerTicket records one event per ticket referenced by the decisions.
+func recordAuditPerTicket(decisions []agentData, eventType string, data func(ticket []agentData) interface{}) {
+	for _, group := range groupByTicket(decisions) {
+		recordAudit(group[0].Data.TicketID, eventType, data(group))
+	}
+}
+
+// auditBody keeps a JSON response as is and anything else as a string.
+func auditBody(body []byte) interface{} {
+	if json.Valid(body) {
+		return json.RawMessage(body)
+	}
+	return string(body)
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+type auditAttachment struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// hashAttachments fingerprints the downloaded files so the evidence can be
+// matched later without keeping personal data around.
+func hashAttachments(paths []string) []auditAttachment {
+	var attachments []auditAttachment
+	for _, path := range paths {
+		attachment := auditAttachment{Name: filepath.Base(path)}
+		f, err := os.Open(path)
+		if err != nil {
+			log.Printf("Error hashing attachment %s: %v", path, err)
+			continue
+		}
+		hash := sha256.New()
+		attachment.Size, err = io.Copy(hash, f)
+		f.Close()
+		if err != nil {
+			log.Printf("Error hashing attachment %s: %v", path, err)
+			continue
+		}
+		attachment.SHA256 = hex.EncodeToString(hash.Sum(nil))
+		attachments = append(attachments, attachment)
+	}
+	return attachments
+}
+
+// ExportAudit returns the audit trail as JSON or JSON lines. Query parameters:
+// ticketId, from and to (RFC 3339) and format (json or jsonl).
+func ExportAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := validateBearerToken(r); err != nil {
+		log.Printf("Error validating bearer token: %v", err)
+		http.Error(w, "Invalid bearer token", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := auditFilter{TicketID: strings.TrimSpace(query.Get("ticketId"))}
+	for key, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if raw := strings.TrimSpace(query.Get(key)); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s: %v", key, err), http.StatusBadRequest)
+				return
+			}
+			*target = parsed
+		}
+	}
+
+	store, err := openAuditStoreFn()
+	if err != nil {
+		log.Printf("Error opening audit store: %v", err)
+		http.Error(w, "Error opening audit store", http.StatusInternalServerError)
+		return
+	}
+	events, err := store.List(filter)
+	if err != nil {
+		log.Printf("Error listing audit events: %v", err)
+		http.Error(w, "Error listing audit events", http.StatusInternalServerError)
+		return
+	}
+
+	switch format := strings.ToLower(query.Get("format")); format {
+	case "", "json":
+		if events == nil {
+			events = []auditEvent{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{"events": events})
+	case "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(w)
+		for _, event := range events {
+			encoder.Encode(event)
+		}
+	default:
+		http.Error(w, "format must be json or jsonl", http.StatusBadRequest)
+	}
+}
+
+// fileAuditStore appends events as JSON lines to one file per ticket.
+type fileAuditStore struct {
+	dir string
+}
+
+func (s *fileAuditStore) path(ticketID string) string {
+	return filepath.Join(s.dir, safeFileName(ticketID)+".jsonl")
+}
+
+func (s *fileAuditStore) Append(event auditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	fileAuditMu.Lock()
+	defer fileAuditMu.Unlock()
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path(event.TicketID), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *fileAuditStore) List(filter auditFilter) ([]auditEvent, error) {
+	var paths []string
+	if filter.TicketID != "" {
+		paths = []string{s.path(filter.TicketID)}
+	} else {
+		matches, err := filepath.Glob(filepath.Join(s.dir, "*.jsonl"))
+		if err != nil {
+			return nil, err
+		}
+		paths = matches
+	}
+
+	var events []auditEvent
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			var event auditEvent
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("corrupt audit record in %s: %w", path, err)
+			}
+			if filter.matches(event) {
+				events = append(events, event)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	sortAuditEvents(events)
+	return events, nil
+}
+
+func sortAuditEvents(events []auditEvent) {
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].At.Equal(events[j].At) {
+			return events[i].At.Before(events[j].At)
+		}
+		return events[i].ID < events[j].ID
+	})
+}
@@ -0,0 +1,130 @@
+package tco_vo_agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// gcsAuditStore writes every event as its own Cloud Storage object. Objects are
+// created with ifGenerationMatch=0, so an event can never be overwritten; use a
+// bucket retention policy to also prevent deletion.
+type gcsAuditStore struct {
+	baseURL string
+	bucket  string
+	prefix  string
+	client  *http.Client
+}
+
+func newGCSAuditStoreFromEnv() (*gcsAuditStore, error) {
+	bucket := strings.TrimSpace(os.Getenv("AUDIT_BUCKET"))
+	if bucket == "" {
+		return nil, errors.New("AUDIT_BUCKET is not set")
+	}
+	baseURL := strings.TrimRight(strings.TrimSpace(os.Getenv("AUDIT_GCS_BASE_URL")), "/")
+	if baseURL == "" {
+		baseURL = "https://storage.googleapis.com"
+	}
+	prefix := strings.Trim(strings.TrimSpace(os.Getenv("AUDIT_PREFIX")), "/")
+	if prefix == "" {
+		prefix = "audit"
+	}
+	return &gcsAuditStore{
+		baseURL: baseURL,
+		bucket:  bucket,
+		prefix:  prefix + "/",
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (s *gcsAuditStore) objectName(event auditEvent) string {
+	return s.prefix + safeFileName(event.TicketID) + "/" + event.ID + ".json"
+}
+
+func (s *gcsAuditStore) do(method, rawURL string, body []byte) ([]byte, error) {
+	respBody, _, err := gcpRequest(s.client, "cloud storage", method, rawURL, body, true)
+	return respBody, err
+}
+
+func (s *gcsAuditStore) Append(event auditEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	query := url.Values{
+		"uploadType":        {"media"},
+		"name":              {s.objectName(event)},
+		"ifGenerationMatch": {"0"},
+	}
+	_, err = s.do("POST", fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", s.baseURL, url.PathEscape(s.bucket), query.Encode()), data)
+	return err
+}
+
+func (s *gcsAuditStore) List(filter auditFilter) ([]auditEvent, error) {
+	prefix := s.prefix
+	if filter.TicketID != "" {
+		prefix += safeFileName(filter.TicketID) + "/"
+	}
+
+	var events []auditEvent
+	pageToken := ""
+	for {
+		query := url.Values{"prefix": {prefix}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		body, err := s.do("GET", fmt.Sprintf("%s/storage/v1/b/%s/o?%s", s.baseURL, url.PathEscape(s.bucket), query.Encode()), nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Items {
+			// event IDs start with the timestamp, so most objects can be skipped without downloading them
+			if !auditObjectInRange(item.Name, filter) {
+				continue
+			}
+			data, err := s.do("GET", fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", s.baseURL, url.PathEscape(s.bucket), url.PathEscape(item.Name)), nil)
+			if err != nil {
+				return nil, err
+			}
+			var event auditEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				return nil, fmt.Errorf("corrupt audit object %s: %w", item.Name, err)
+			}
+			if filter.matches(event) {
+				events = append(events, event)
+			}
+		}
+
+		if page.NextPageToken == "" {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+	sortAuditEvents(events)
+	return events, nil
+}
+
+func auditObjectInRange(name string, filter auditFilter) bool {
+	base := name[strings.LastIndex(name, "/")+1:]
+	var nanos int64
+	if _, err := fmt.Sscanf(base, "%020d-", &nanos); err != nil {
+		return true
+	}
+	at := time.Unix(0, nanos)
+	return filter.matches(auditEvent{TicketID: filter.TicketID, At: at})
+}
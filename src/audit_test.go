@@ -0,0 +1,200 @@
+package tco_vo_agent
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProcessTicketsAsyncWritesAuditTrail(t *testing.T) {
+	t.Setenv("LEDGER_DIR", t.TempDir())
+	t.Setenv("AUDIT_DIR", t.TempDir())
+	t.Setenv("BEARER_TOKEN", "secret")
+
+	origGetAttachments := getAttachmentsFn
+	origExtractData := extractDataFn
+	origBanUsers := banUsersFn
+	origReply := replyToTicketFn
+	origTag := tagTicketFn
+	origNotifySlack := notifySlackFn
+	t.Cleanup(func() {
+		getAttachmentsFn = origGetAttachments
+		extractDataFn = origExtractData
+		banUsersFn = origBanUsers
+		replyToTicketFn = origReply
+		tagTicketFn = origTag
+		notifySlackFn = origNotifySlack
+	})
+	stubPreservation(t)
+	stubLookup(t)
+
+	attachment := filepath.Join(t.TempDir(), "order.pdf")
+	if err := os.WriteFile(attachment, []byte("%PDF-1.4 order"), 0o644); err != nil {
+		t.Fatalf("failed to write attachment: %v", err)
+	}
+	getAttachmentsFn = func(ticketId string) ([]string, error) { return []string{attachment}, nil }
+	extractDataFn = func(paths []string, agents []agentConfig) ([]agentData, []agentError) {
+		decision, err := parseDecisionJSON(`{"username":"jane","email":"jane@example.com","agencyName":"BKA","referenceNumber":"REF-1","date":"2024-12-01"}`)
+		if err != nil {
+			t.Fatalf("parseDecisionJSON returned error: %v", err)
+		}
+		return []agentData{{Agent: agentA, Data: *decision}}, nil
+	}
+	banUsersFn = func(data []agentData) (banResults, error) { return banResults{Banned: data}, nil }
+	replyToTicketFn = func(ticketId string, message string) error { return nil }
+	tagTicketFn = func(ticketId string, tags []string) error { return nil }
+	notifySlackFn = func(result processResult) error { return nil }
+
+	if err := processTicketsAsync(ZendeskTicket{ID: "31"}); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/audit?ticketId=31", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	ProcessTickets(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var export struct {
+		Events []auditEvent `json:"events"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &export); err != nil {
+		t.Fatalf("failed to decode export: %v", err)
+	}
+
+	var types []string
+	byType := map[string]auditEvent{}
+	for _, event := range export.Events {
+		types = append(types, event.Type)
+		byType[event.Type] = event
+	}
+	want := []string{auditAttachments, auditAgentOutput, auditConsensus, auditPreserved, auditTagged, auditReplySent}
+	if strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected events %v, got %v", want, types)
+	}
+	if !strings.Contains(string(byType[auditAttachments].Data), `"sha256":"`) {
+		t.Fatalf("expected attachment hashes, got %s", byType[auditAttachments].Data)
+	}
+	if !strings.Contains(string(byType[auditAgentOutput].Data), `"rawOutput":"{\"username\":\"jane\"`) {
+		t.Fatalf("expected the raw model output, got %s", byType[auditAgentOutput].Data)
+	}
+	if !strings.Contains(string(byType[auditReplySent].Data), "user_banned") {
+		t.Fatalf("expected the sent reply, got %s", byType[auditReplySent].Data)
+	}
+}
+
+func TestFileAuditStoreFilters(t *testing.T) {
+	store := &fileAuditStore{dir: t.TempDir()}
+	base := time.Date(2025, 1, 8, 10, 0, 0, 0, time.UTC)
+	for i, ticket := range []string{"1", "2", "1"} {
+		at := base.Add(time.Duration(i) * time.Hour)
+		if err := store.Append(auditEvent{ID: newAuditEventID(at, "test"), TicketID: ticket, Type: "test", At: at}); err != nil {
+			t.Fatalf("Append returned error: %v", err)
+		}
+	}
+
+	events, err := store.List(auditFilter{From: base.Add(30 * time.Minute)})
+	if err != nil || len(events) != 2 || events[0].TicketID != "2" || events[1].TicketID != "1" {
+		t.Fatalf("expected the later two events in order, got %+v (%v)", events, err)
+	}
+	events, _ = store.List(auditFilter{TicketID: "1", To: base.Add(time.Hour)})
+	if len(events) != 1 || !events[0].At.Equal(base) {
+		t.Fatalf("expected the first event of ticket 1, got %+v", events)
+	}
+}
+
+func TestGCSAuditStore(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer gcs-token" {
+			t.Errorf("missing access token")
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/audit-bucket/o":
+			if r.URL.Query().Get("ifGenerationMatch") != "0" {
+				t.Errorf("events must be created without overwriting")
+			}
+			body, _ := io.ReadAll(r.Body)
+			objects[r.URL.Query().Get("name")] = body
+			w.Write([]byte(`{}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b/audit-bucket/o":
+			var items []map[string]string
+			for name := range objects {
+				if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+					items = append(items, map[string]string{"name": name})
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/storage/v1/b/audit-bucket/o/"):
+			name := strings.TrimPrefix(r.URL.Path, "/storage/v1/b/audit-bucket/o/")
+			w.Write(objects[name])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("AUDIT_BACKEND", "gcs")
+	t.Setenv("AUDIT_BUCKET", "audit-bucket")
+	t.Setenv("AUDIT_GCS_BASE_URL", server.URL)
+	t.Setenv("GCP_ACCESS_TOKEN", "gcs-token")
+
+	recordAudit("5", auditReplySent, map[string]string{"template": "user_banned"})
+	recordAudit("6", auditReplySent, map[string]string{"template": "user_not_found"})
+
+	store, err := openAuditStore()
+	if err != nil {
+		t.Fatalf("openAuditStore returned error: %v", err)
+	}
+	events, err := store.List(auditFilter{TicketID: "5"})
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	if len(events) != 1 || events[0].TicketID != "5" || !strings.Contains(string(events[0].Data), "user_banned") {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if _, ok := objects["audit/5/"+events[0].ID+".json"]; !ok {
+		t.Fatalf("expected one object per event, got %v", objects)
+	}
+}
+
+func TestValidateAuditConfig(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+	t.Setenv("AUDIT_BACKEND", "")
+	t.Setenv("AUDIT_DIR", "")
+
+	if err := validateAuditConfig(); err == nil {
+		t.Fatal("expected production mode without a shared audit trail to be rejected")
+	}
+	if _, err := openAuditStore(); err == nil {
+		t.Fatal("expected openAuditStore to refuse the instance-local audit trail in production")
+	}
+
+	t.Setenv("AUDIT_DIR", "/mnt/shared/audit")
+	if err := validateAuditConfig(); err != nil {
+		t.Fatalf("expected an explicit AUDIT_DIR to be accepted, got %v", err)
+	}
+
+	t.Setenv("AUDIT_DIR", "")
+	t.Setenv("AUDIT_BACKEND", "gcs")
+	if err := validateAuditConfig(); err != nil {
+		t.Fatalf("expected the gcs backend to be accepted, got %v", err)
+	}
+
+	t.Setenv("APP_ENV", "development")
+	t.Setenv("AUDIT_BACKEND", "")
+	if err := validateAuditConfig(); err != nil {
+		t.Fatalf("development mode must not require a shared audit trail, got %v", err)
+	}
+}
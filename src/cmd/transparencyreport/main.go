@@ -0,0 +1,50 @@
+// Command transparencyreport prints the Article 7 transparency report, e.g.
+//
+//	go run ./cmd/transparencyreport -year 2025 -format markdown
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+	tco_vo_agent "gw-interactive.com/finya/tco-vo-agent-cloudfunction"
+)
+
+func main() {
+	year := flag.String("year", "", "calendar year to report on (default: the previous year)")
+	from := flag.String("from", "", "start of the period, YYYY-MM-DD or RFC 3339")
+	to := flag.String("to", "", "end of the period, YYYY-MM-DD (included) or RFC 3339")
+	format := flag.String("format", "markdown", "json, csv or markdown")
+	source := flag.String("source", tco_vo_agent.ReportSourceHistory, "history (ledger and audit trail) or zendesk (decision tags)")
+	out := flag.String("out", "", "write the report to this file instead of stdout")
+	flag.Parse()
+
+	// populate env from .env file, if there is one
+	if err := godotenv.Load("../../.env"); err != nil {
+		log.Printf("env.Load: %v", err)
+	}
+
+	start, end, err := tco_vo_agent.ParseReportPeriod(*year, *from, *to)
+	if err != nil {
+		log.Fatalf("invalid period: %v", err)
+	}
+	report, err := tco_vo_agent.BuildTransparencyReport(*source, start, end)
+	if err != nil {
+		log.Fatalf("building report: %v", err)
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("creating %s: %v", *out, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := report.Write(w, *format); err != nil {
+		log.Fatalf("writing report: %v", err)
+	}
+}
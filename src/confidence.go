@@ -0,0 +1,146 @@
+package tco_vo_agent
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	lowConfidenceMoreInfo = "more_info"
+	lowConfidenceReview   = "review"
+)
+
+// minConfidence reads AI_MIN_CONFIDENCE. Zero disables the check.
+func minConfidence() float64 {
+	raw := strings.TrimSpace(os.Getenv("AI_MIN_CONFIDENCE"))
+	if raw == "" {
+		return 0
+	}
+	threshold, err := strconv.ParseFloat(raw, 64)
+	if err != nil || threshold < 0 || threshold > 1 {
+		log.Printf("Invalid AI_MIN_CONFIDENCE %q, confidence check disabled", raw)
+		return 0
+	}
+	return threshold
+}
+
+// lowConfidenceAction reads AI_LOW_CONFIDENCE_ACTION: low confidence fields
+// either trigger a clarification request to the authority or a manual review.
+func lowConfidenceAction() string {
+	action := strings.ToLower(strings.TrimSpace(os.Getenv("AI_LOW_CONFIDENCE_ACTION")))
+	switch action {
+	case "", lowConfidenceMoreInfo:
+		return lowConfidenceMoreInfo
+	case lowConfidenceReview:
+		return lowConfidenceReview
+	default:
+		log.Printf("Invalid AI_LOW_CONFIDENCE_ACTION %q, using %s", action, lowConfidenceMoreInfo)
+		return lowConfidenceMoreInfo
+	}
+}
+
+// fieldConfidence returns the highest confidence given for a field value. The
+// second result is false when the model gave no evidence for it.
+func (d FraudDecision) fieldConfidence(field, value string) (float64, bool) {
+	best, found := 0.0, false
+	for _, evidence := range d.Evidence {
+		if evidence.Field != field {
+			continue
+		}
+		if evidence.Value != "" && normalizeText(evidence.Value) != normalizeText(value) {
+			continue
+		}
+		if !found || evidence.Confidence > best {
+			best, found = evidence.Confidence, true
+		}
+	}
+	return best, found
+}
+
+// lowConfidenceFields lists the required fields whose value is below the
+// configured confidence, or has no evidence at all, formatted for humans.
+func lowConfidenceFields(decision FraudDecision) []string {
+	threshold := minConfidence()
+	if threshold <= 0 {
+		return nil
+	}
+
+	var low []string
+	for _, field := range []struct{ name, value string }{
+		{"username", decision.Username},
+		{"email", decision.Email},
+		{"userId", decision.UserID},
+		{"agencyName", decision.AgencyName},
+		{"referenceNumber", decision.ReferenceNumber},
+	} {
+		if field.value == "" {
+			continue
+		}
+		confidence, ok := decision.fieldConfidence(field.name, field.value)
+		switch {
+		case !ok:
+			low = append(low, fmt.Sprintf("%s (no evidence)", field.name))
+		case confidence < threshold:
+			low = append(low, fmt.Sprintf("%s (%.2f)", field.name, confidence))
+		}
+	}
+	return low
+}
+
+// isConfident reports whether a field value may be acted on.
+func isConfident(decision FraudDecision, field, value string) bool {
+	if value == "" {
+		return false
+	}
+	threshold := minConfidence()
+	if threshold <= 0 {
+		return true
+	}
+	confidence, ok := decision.fieldConfidence(field, value)
+	return ok && confidence >= threshold
+}
+
+// splitLowConfidence moves decisions that lack information only because of
+// low confidence values to the review bucket.
+func splitLowConfidence(noRequiredInfo []agentData) (moreInfo []agentData, review []agentData) {
+	for _, data := range noRequiredInfo {
+		low := lowConfidenceFields(data.Data)
+		if len(low) == 0 || !hasRequiredValues(data.Data) {
+			moreInfo = append(moreInfo, data)
+			continue
+		}
+		data.Reason = "low confidence: " + strings.Join(low, ", ")
+		review = append(review, data)
+	}
+	return moreInfo, review
+}
+
+// hasRequiredValues reports whether all required fields have a value, however confident.
+func hasRequiredValues(decision FraudDecision) bool {
+	hasTarget := hasAccountIdentifier(decision) || hasAddressableContent(decision)
+	return hasTarget && decision.AgencyName != "" && decision.ReferenceNumber != ""
+}
+
+// dropUnsureIdentifiers clears identifiers below the confidence threshold, so
+// an account is only looked up by what was actually read from the order.
+func dropUnsureIdentifiers(decision FraudDecision) FraudDecision {
+	if minConfidence() <= 0 {
+		return decision
+	}
+	if !isConfident(decision, "username", decision.Username) {
+		decision.Username = ""
+	}
+	if !isConfident(decision, "email", decision.Email) {
+		decision.Email = ""
+	}
+	if !isConfident(decision, "userId", decision.UserID) {
+		decision.UserID = ""
+	}
+	if len(decision.Accounts) == 1 {
+		decision.Accounts = []TargetAccount{{Username: decision.Username, Email: decision.Email, UserID: decision.UserID, ProfileURL: decision.Accounts[0].ProfileURL}}
+	}
+	return decision
+}
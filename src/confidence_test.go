@@ -0,0 +1,82 @@
+package tco_vo_agent
+
+import (
+	"strings"
+	"testing"
+)
+
+func confidentOrder(referenceConfidence float64) agentData {
+	return agentData{Data: FraudDecision{
+		TicketID:        "1",
+		Username:        "jane",
+		Email:           "guessed@example.com",
+		AgencyName:      "BKA",
+		ReferenceNumber: "REF-1",
+		Accounts:        []TargetAccount{{Username: "jane", Email: "guessed@example.com"}},
+		Evidence: []FieldEvidence{
+			{Field: "username", Value: "jane", Confidence: 0.95, Snippet: "Nutzer: jane", Page: 1},
+			{Field: "email", Value: "guessed@example.com", Confidence: 0.2},
+			{Field: "agencyName", Value: "BKA", Confidence: 0.9},
+			{Field: "referenceNumber", Value: "REF-1", Confidence: referenceConfidence, Snippet: "Az. REF-1"},
+		},
+	}}
+}
+
+func TestParseDecisionJSONEvidence(t *testing.T) {
+	decision, err := parseDecisionJSON(`{"agencyName":"A","referenceNumber":"R","date":"2024-01-01","accounts":[{"username":"u","email":"","userId":"","profileUrl":""}],"contentItems":[],
+		"evidence":[{"field":"referenceNumber","value":"R","confidence":87,"snippet":"Ref: R","page":2,"source":"order.pdf"}]}`)
+	if err != nil {
+		t.Fatalf("parseDecisionJSON returned error: %v", err)
+	}
+	confidence, ok := decision.fieldConfidence("referenceNumber", "r")
+	if !ok || confidence != 0.87 || decision.Evidence[0].Page != 2 {
+		t.Fatalf("unexpected evidence: %+v", decision.Evidence)
+	}
+}
+
+func TestCheckRequiredInfoUsesConfidence(t *testing.T) {
+	t.Setenv("AI_MIN_CONFIDENCE", "")
+	if ok, _ := checkRequiredInfo(confidentOrder(0.1)); !ok {
+		t.Fatal("confidence must be ignored when no threshold is set")
+	}
+
+	t.Setenv("AI_MIN_CONFIDENCE", "0.7")
+	ok, reason := checkRequiredInfo(confidentOrder(0.1))
+	if ok || reason != "the reference number could not be read with certainty" {
+		t.Fatalf("checkRequiredInfo = %v, %q; want low confidence reference", ok, reason)
+	}
+
+	hasRequired, _ := partitionDataByHasRequiredInfo([]agentData{confidentOrder(0.8)})
+	if len(hasRequired) != 1 {
+		t.Fatalf("expected the order to pass, got %+v", hasRequired)
+	}
+	if got := hasRequired[0].Data; got.Email != "" || got.Username != "jane" || got.Accounts[0].Email != "" {
+		t.Fatalf("unsure identifiers must not be acted on, got %+v", got)
+	}
+
+	noEvidence := confidentOrder(0.8)
+	noEvidence.Data.Evidence = nil
+	if ok, _ := checkRequiredInfo(noEvidence); ok {
+		t.Fatal("values without evidence must not pass the threshold")
+	}
+}
+
+func TestSplitLowConfidence(t *testing.T) {
+	t.Setenv("AI_MIN_CONFIDENCE", "0.7")
+
+	missing := agentData{Data: FraudDecision{AgencyName: "BKA"}}
+	_, noRequired := partitionDataByHasRequiredInfo([]agentData{confidentOrder(0.3), missing})
+
+	moreInfo, review := splitLowConfidence(noRequired)
+	if len(moreInfo) != 1 || moreInfo[0].Data.AgencyName != "BKA" || moreInfo[0].Data.Username != "" {
+		t.Fatalf("really missing information must still be asked for, got %+v", moreInfo)
+	}
+	if len(review) != 1 || !strings.Contains(review[0].Reason, "referenceNumber (0.30)") {
+		t.Fatalf("expected the low confidence order to be reviewed, got %+v", review)
+	}
+
+	t.Setenv("AI_LOW_CONFIDENCE_ACTION", "Review")
+	if lowConfidenceAction() != lowConfidenceReview {
+		t.Fatalf("lowConfidenceAction() = %q, want review", lowConfidenceAction())
+	}
+}
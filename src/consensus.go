@@ -0,0 +1,285 @@
+package tco_vo_agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// consensusProvider marks decisions merged from the results of several agents.
+const consensusProvider = "consensus"
+
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"02.01.2006 15:04",
+	"02.01.2006",
+	"2.1.2006",
+	"02/01/2006",
+	"January 2, 2006",
+	"2 January 2006",
+}
+
+// targetCluster collects the decisions of all agents about the same target.
+type targetCluster struct {
+	keys    map[string]bool
+	members []agentData
+}
+
+// buildConsensus merges the results of all agents into one decision per target
+// (account of a ticket). Fields are voted on after normalisation. The run fails
+// only when a majority of agents failed; targets named by a minority of agents
+// or with conflicting fields go to the review bucket instead of being acted on.
+func buildConsensus(data []agentData, failures []agentError) (decisions []agentData, review []agentData, err error) {
+	agents := map[agentConfig]bool{}
+	succeeded := map[agentConfig]bool{}
+	for _, item := range data {
+		agents[item.Agent] = true
+		succeeded[item.Agent] = true
+	}
+	for _, failure := range failures {
+		if !succeeded[failure.agent] {
+			agents[failure.agent] = true
+		}
+	}
+	if len(succeeded)*2 <= len(agents) {
+		return nil, nil, fmt.Errorf("only %d of %d agents succeeded: %v", len(succeeded), len(agents), failures)
+	}
+
+	for _, cluster := range clusterTargets(splitByAccount(data)) {
+		decision, reason := mergeCluster(cluster, len(succeeded))
+		if reason != "" {
+			decision.Reason = reason
+			review = append(review, decision)
+			continue
+		}
+		decisions = append(decisions, decision)
+	}
+	return decisions, review, nil
+}
+
+// clusterTargets groups decisions that share a ticket and any account identifier.
+// Decisions without identifiers are grouped per ticket.
+func clusterTargets(data []agentData) []*targetCluster {
+	var clusters []*targetCluster
+	for _, item := range data {
+		keys := targetKeys(item.Data)
+
+		var match *targetCluster
+		for _, cluster := range clusters {
+			for _, key := range keys {
+				if cluster.keys[key] {
+					match = cluster
+					break
+				}
+			}
+			if match != nil {
+				break
+			}
+		}
+		if match == nil {
+			match = &targetCluster{keys: map[string]bool{}}
+			clusters = append(clusters, match)
+		}
+		for _, key := range keys {
+			match.keys[key] = true
+		}
+		match.members = append(match.members, item)
+	}
+	return clusters
+}
+
+func targetKeys(decision FraudDecision) []string {
+	prefix := decision.TicketID + "|"
+	var keys []string
+	if v := normalizeUsername(decision.Username); v != "" {
+		keys = append(keys, prefix+"username:"+v)
+	}
+	if v := normalizeEmail(decision.Email); v != "" {
+		keys = append(keys, prefix+"email:"+v)
+	}
+	if v := strings.TrimSpace(decision.UserID); v != "" {
+		keys = append(keys, prefix+"userId:"+v)
+	}
+	if len(keys) == 0 {
+		keys = append(keys, prefix+"unidentified")
+	}
+	return keys
+}
+
+// mergeCluster votes on every field of a cluster. A non-empty reason means the
+// merged decision must be reviewed by a human.
+func mergeCluster(cluster *targetCluster, succeeded int) (agentData, string) {
+	supporters := map[agentConfig]bool{}
+	var names []string
+	for _, member := range cluster.members {
+		if !supporters[member.Agent] {
+			supporters[member.Agent] = true
+			names = append(names, agentName(member.Agent))
+		}
+	}
+
+	merged := cluster.members[0]
+	if len(supporters) > 1 {
+		merged.Agent = agentConfig{Provider: consensusProvider, Model: strings.Join(names, "+")}
+	}
+
+	var conflicts []string
+	vote := func(field string, get func(FraudDecision) string, normalize func(string) string) string {
+		value, conflict := voteField(cluster.members, get, normalize, len(supporters))
+		if conflict != "" {
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s)", field, conflict))
+		}
+		return value
+	}
+
+	decision := &merged.Data
+	decision.AgencyName = vote("agencyName", func(d FraudDecision) string { return d.AgencyName }, normalizeText)
+	decision.ReferenceNumber = vote("referenceNumber", func(d FraudDecision) string { return d.ReferenceNumber }, normalizeReference)
+	decision.Date = vote("date", func(d FraudDecision) string { return d.Date }, normalizeDate)
+	decision.Username = vote("username", func(d FraudDecision) string { return d.Username }, normalizeUsername)
+	decision.Email = vote("email", func(d FraudDecision) string { return d.Email }, normalizeEmail)
+	decision.UserID = vote("userId", func(d FraudDecision) string { return d.UserID }, strings.TrimSpace)
+	profileURL, _ := voteField(cluster.members, func(d FraudDecision) string {
+		if len(d.Accounts) == 0 {
+			return ""
+		}
+		return d.Accounts[0].ProfileURL
+	}, normalizeText, len(supporters))
+	decision.Accounts = []TargetAccount{{Username: decision.Username, Email: decision.Email, UserID: decision.UserID, ProfileURL: profileURL}}
+	decision.ContentItems = mergeContentItems(cluster.members)
+	decision.ImpossibilityReason, decision.ImpossibilityExplanation = "", ""
+	if reason := vote("impossibilityReason", func(d FraudDecision) string { return d.ImpossibilityReason }, normalizeText); reason != "" {
+		// refusing an order needs a majority, not just the only agent that answered the field
+		named := map[agentConfig]bool{}
+		for _, member := range cluster.members {
+			if normalizeText(member.Data.ImpossibilityReason) == normalizeText(reason) {
+				named[member.Agent] = true
+				if decision.ImpossibilityExplanation == "" {
+					decision.ImpossibilityExplanation = member.Data.ImpossibilityExplanation
+				}
+			}
+		}
+		if len(named)*2 <= len(supporters) {
+			conflicts = append(conflicts, fmt.Sprintf("impossibilityReason (%q named by %d of %d agents)", reason, len(named), len(supporters)))
+		}
+		decision.ImpossibilityReason = reason
+	}
+	decision.Evidence = nil
+	for _, member := range cluster.members {
+		decision.Evidence = append(decision.Evidence, member.Data.Evidence...)
+	}
+
+	if len(supporters)*2 <= succeeded {
+		return merged, fmt.Sprintf("only named by %d of %d agents (%s)", len(supporters), succeeded, strings.Join(names, ", "))
+	}
+	if len(conflicts) > 0 {
+		return merged, "agents disagree on " + strings.Join(conflicts, "; ")
+	}
+	return merged, ""
+}
+
+// voteField returns the value most agents agree on. Empty values do not vote.
+// When several values were given and none has a majority of the voters, the
+// conflict describes them.
+func voteField(members []agentData, get func(FraudDecision) string, normalize func(string) string, voters int) (string, string) {
+	counts := map[string]int{}
+	first := map[string]string{}
+	var order []string
+	seen := map[agentConfig]bool{}
+	for _, member := range members {
+		value := strings.TrimSpace(get(member.Data))
+		if value == "" || seen[member.Agent] {
+			continue
+		}
+		seen[member.Agent] = true
+
+		key := normalize(value)
+		if _, ok := first[key]; !ok {
+			first[key] = value
+			order = append(order, key)
+		}
+		counts[key]++
+	}
+
+	switch len(order) {
+	case 0:
+		return "", ""
+	case 1:
+		return first[order[0]], ""
+	}
+
+	sort.SliceStable(order, func(i, j int) bool { return counts[order[i]] > counts[order[j]] })
+	if counts[order[0]]*2 > voters {
+		return first[order[0]], ""
+	}
+	var values []string
+	for _, key := range order {
+		values = append(values, fmt.Sprintf("%q x%d", first[key], counts[key]))
+	}
+	return first[order[0]], strings.Join(values, " vs ")
+}
+
+func mergeContentItems(members []agentData) []ContentItem {
+	var items []ContentItem
+	seen := map[string]bool{}
+	for _, member := range members {
+		for _, item := range member.Data.ContentItems {
+			key := strings.Join([]string{normalizeText(item.URL), strings.TrimSpace(item.MessageID), strings.TrimSpace(item.PictureID)}, "|")
+			if key == "||" {
+				key = normalizeText(item.Kind) + "|" + normalizeText(item.Description)
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func agentName(agent agentConfig) string {
+	if agent.Provider == "" && agent.Model == "" {
+		return "agent"
+	}
+	return agent.Provider + ":" + agent.Model
+}
+
+func normalizeText(value string) string {
+	return strings.ToLower(strings.Join(strings.Fields(value), " "))
+}
+
+func normalizeUsername(value string) string {
+	return strings.TrimPrefix(normalizeText(value), "@")
+}
+
+func normalizeEmail(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// normalizeReference ignores case and separators, so "REF-1" and "ref 1" match.
+func normalizeReference(value string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, value)
+}
+
+// normalizeDate compares dates by calendar day, whatever format the agent used.
+func normalizeDate(value string) string {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Format("2006-01-02")
+		}
+	}
+	return normalizeText(value)
+}
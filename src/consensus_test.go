@@ -0,0 +1,147 @@
+package tco_vo_agent
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var (
+	agentA = agentConfig{Provider: "openai", Model: "a"}
+	agentB = agentConfig{Provider: "claude", Model: "b"}
+	agentC = agentConfig{Provider: "gemini", Model: "c"}
+)
+
+func order(agent agentConfig, reference, date string, accounts ...TargetAccount) agentData {
+	return agentData{Agent: agent, Data: FraudDecision{
+		TicketID:        "1",
+		AgencyName:      "Bundeskriminalamt",
+		ReferenceNumber: reference,
+		Date:            date,
+		Accounts:        accounts,
+	}}
+}
+
+func TestBuildConsensusMergesAgreeingAgents(t *testing.T) {
+	data := []agentData{
+		order(agentA, "REF-1", "2024-12-01T10:00:00Z", TargetAccount{Username: "Jane_Doe"}, TargetAccount{Email: "x@example.com"}),
+		order(agentB, "ref 1", "01.12.2024", TargetAccount{Username: "@jane_doe", Email: "jane@example.com"}, TargetAccount{Email: "X@example.com"}),
+	}
+	data[1].Data.ContentItems = []ContentItem{{URL: "https://finya.de/p/1", Account: "jane@example.com"}}
+
+	decisions, review, err := buildConsensus(data, []agentError{{agent: agentC, err: errors.New("timeout")}})
+	if err != nil {
+		t.Fatalf("buildConsensus returned error: %v", err)
+	}
+	if len(review) != 0 {
+		t.Fatalf("expected no review items, got %+v", review)
+	}
+	if len(decisions) != 2 {
+		t.Fatalf("expected one decision per account, got %d: %+v", len(decisions), decisions)
+	}
+
+	jane := decisions[0].Data
+	if jane.Username != "Jane_Doe" || jane.Email != "jane@example.com" || jane.ReferenceNumber != "REF-1" || jane.Date != "2024-12-01T10:00:00Z" {
+		t.Fatalf("unexpected merged decision: %+v", jane)
+	}
+	if len(jane.ContentItems) != 1 {
+		t.Fatalf("content items must be merged, got %+v", jane.ContentItems)
+	}
+	if decisions[0].Agent.Provider != consensusProvider || decisions[0].Agent.Model != "openai:a+claude:b" {
+		t.Fatalf("unexpected agent on merged decision: %+v", decisions[0].Agent)
+	}
+}
+
+func TestBuildConsensusFlagsDisagreements(t *testing.T) {
+	data := []agentData{
+		order(agentA, "REF-1", "2024-12-01", TargetAccount{Username: "jane"}, TargetAccount{Username: "only_a"}),
+		order(agentB, "REF-2", "2024-12-01", TargetAccount{Username: "jane"}),
+	}
+
+	decisions, review, err := buildConsensus(data, nil)
+	if err != nil {
+		t.Fatalf("buildConsensus returned error: %v", err)
+	}
+	if len(decisions) != 0 || len(review) != 2 {
+		t.Fatalf("expected both targets to need review, got decisions=%+v review=%+v", decisions, review)
+	}
+	if !strings.Contains(review[0].Reason, "referenceNumber") {
+		t.Fatalf("expected a reference conflict, got %q", review[0].Reason)
+	}
+	if !strings.Contains(review[1].Reason, "only named by 1 of 2 agents") {
+		t.Fatalf("expected a minority target, got %q", review[1].Reason)
+	}
+
+	// a third agent settles the reference
+	data = append(data, order(agentC, "REF-1", "2024-12-01", TargetAccount{Username: "jane"}))
+	decisions, _, _ = buildConsensus(data, nil)
+	if len(decisions) != 1 || decisions[0].Data.ReferenceNumber != "REF-1" {
+		t.Fatalf("expected the majority reference to win, got %+v", decisions)
+	}
+}
+
+func TestBuildConsensusRequiresQuorum(t *testing.T) {
+	failures := []agentError{{agent: agentB, err: errors.New("down")}, {agent: agentC, err: errors.New("down")}}
+	if _, _, err := buildConsensus([]agentData{order(agentA, "REF-1", "", TargetAccount{Username: "jane"})}, failures); err == nil {
+		t.Fatal("expected an error when most agents failed")
+	}
+}
+
+func TestProcessTicketsAsyncReviewsDisagreements(t *testing.T) {
+	t.Setenv("LEDGER_DIR", t.TempDir())
+
+	origGetAttachments := getAttachmentsFn
+	origExtractData := extractDataFn
+	origBanUsers := banUsersFn
+	origReplyToTickets := replyToTicketsFn
+	origTag := tagTicketFn
+	origNotifySlack := notifySlackFn
+	t.Cleanup(func() {
+		getAttachmentsFn = origGetAttachments
+		extractDataFn = origExtractData
+		banUsersFn = origBanUsers
+		replyToTicketsFn = origReplyToTickets
+		tagTicketFn = origTag
+		notifySlackFn = origNotifySlack
+	})
+
+	getAttachmentsFn = func(ticketId string) ([]string, error) { return nil, nil }
+	extractDataFn = func(paths []string, agents []agentConfig) ([]agentData, []agentError) {
+		return []agentData{
+			order(agentA, "REF-1", "", TargetAccount{Username: "jane"}),
+			order(agentB, "REF-2", "", TargetAccount{Username: "jane"}),
+		}, nil
+	}
+	banUsersFn = func(data []agentData) (banResults, error) {
+		if len(data) != 0 {
+			t.Fatalf("disputed targets must not be banned, got %+v", data)
+		}
+		return banResults{}, nil
+	}
+	replyToTicketsFn = func(tickets []agentData, messageTemplate ReplyToTicketTemplate) error {
+		if len(tickets) != 0 {
+			t.Fatalf("no reply expected for disputed targets, got %s %+v", messageTemplate, tickets)
+		}
+		return nil
+	}
+	var tags []string
+	tagTicketFn = func(ticketId string, t []string) error {
+		tags = append(tags, t...)
+		return nil
+	}
+	var notified processResult
+	notifySlackFn = func(result processResult) error {
+		notified = result
+		return nil
+	}
+
+	if err := processTicketsAsync(ZendeskTicket{ID: "1"}); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	if !hasTag(tags, decisionTagReview) {
+		t.Fatalf("expected the review tag, got %v", tags)
+	}
+	if len(notified.Review) != 1 || !strings.Contains(buildSlackText(notified), "Needs review") {
+		t.Fatalf("expected the review bucket in the notification, got %+v", notified)
+	}
+}
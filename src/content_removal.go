@@ -0,0 +1,150 @@
+package tco_vo_agent
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Kinds of content the Finya API can remove on its own, without banning the account.
+const (
+	contentKindMessage     = "message"
+	contentKindProfileText = "profile_text"
+	contentKindPicture     = "picture"
+)
+
+const (
+	decisionTagContentRemoved  = "tco-vo-decision-content-removed"
+	decisionTagContentDisabled = "tco-vo-decision-content-disabled"
+)
+
+// contentMeasure is how an order is carried out for one account.
+type contentMeasure string
+
+const (
+	measureBanAccount     contentMeasure = "ban_account"
+	measureRemoveContent  contentMeasure = "remove_content"
+	measureDisableContent contentMeasure = "disable_content"
+)
+
+var contentMeasures = []contentMeasure{measureBanAccount, measureRemoveContent, measureDisableContent}
+
+// describe is the verb used for the measure in notes to moderators.
+func (m contentMeasure) describe() string {
+	switch m {
+	case measureRemoveContent:
+		return "Remove the content of"
+	case measureDisableContent:
+		return "Disable access in all Member States to the content of"
+	default:
+		return "Ban"
+	}
+}
+
+// completionTemplates is the reply sent to the authority once a measure was carried out.
+var completionTemplates = map[contentMeasure]ReplyToTicketTemplate{
+	measureBanAccount:     ReplyToTicketTemplateUserBanned,
+	measureRemoveContent:  ReplyToTicketTemplateContentRemoved,
+	measureDisableContent: ReplyToTicketTemplateContentDisabled,
+}
+
+// templateMeasure returns the measure a completion reply reports on.
+func templateMeasure(template ReplyToTicketTemplate) (contentMeasure, bool) {
+	for measure, candidate := range completionTemplates {
+		if candidate == template {
+			return measure, true
+		}
+	}
+	return "", false
+}
+
+// contentMeasureSetting reads CONTENT_MEASURE: "remove" (default) removes the
+// named content, "disable" disables access to it in all Member States and
+// "ban" bans the account as for orders without content.
+func contentMeasureSetting() contentMeasure {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("CONTENT_MEASURE"))) {
+	case "disable":
+		return measureDisableContent
+	case "ban":
+		return measureBanAccount
+	default:
+		return measureRemoveContent
+	}
+}
+
+// measureFor picks the measure for one account. Content can only be handled on
+// its own when every item of the order can be addressed by the Finya API;
+// otherwise the account is banned. Orders naming only content are never
+// turned into a ban, there is no account to ban.
+func measureFor(decision FraudDecision) contentMeasure {
+	if !hasAddressableContent(decision) {
+		return measureBanAccount
+	}
+	measure := contentMeasureSetting()
+	if measure == measureBanAccount && !hasAccountIdentifier(decision) {
+		return measureRemoveContent
+	}
+	return measure
+}
+
+// hasAccountIdentifier reports whether the decision names an account.
+func hasAccountIdentifier(decision FraudDecision) bool {
+	return decision.Username != "" || decision.Email != "" || decision.UserID != ""
+}
+
+// hasAddressableContent reports whether the decision names content and every
+// item can be addressed by the Finya API, so it can be acted on without an account.
+func hasAddressableContent(decision FraudDecision) bool {
+	if len(decision.ContentItems) == 0 {
+		return false
+	}
+	for _, item := range decision.ContentItems {
+		if contentID(decision, item) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// partitionByMeasure splits the decisions that have all required information
+// by how they are carried out.
+func partitionByMeasure(data []agentData) (ban, remove, disable []agentData) {
+	for _, entry := range data {
+		switch measureFor(entry.Data) {
+		case measureRemoveContent:
+			remove = append(remove, entry)
+		case measureDisableContent:
+			disable = append(disable, entry)
+		default:
+			ban = append(ban, entry)
+		}
+	}
+	return ban, remove, disable
+}
+
+// contentID identifies a content item towards the Finya API, or is empty when
+// the item cannot be addressed there. Profile texts are named by their account.
+func contentID(decision FraudDecision, item ContentItem) string {
+	switch {
+	case strings.TrimSpace(item.MessageID) != "":
+		return contentKindMessage + ":" + strings.TrimSpace(item.MessageID)
+	case strings.TrimSpace(item.PictureID) != "":
+		return contentKindPicture + ":" + strings.TrimSpace(item.PictureID)
+	case item.Kind == contentKindProfileText:
+		account := fallbackValue(decision.UserID, fallbackValue(decision.Username, decision.Email))
+		if account == "" {
+			return ""
+		}
+		return fmt.Sprintf("%s:%s", contentKindProfileText, account)
+	default:
+		return ""
+	}
+}
+
+// fallbackMeasure treats decisions recorded without a measure as bans.
+func fallbackMeasure(measure contentMeasure) contentMeasure {
+	if measure == "" {
+		return measureBanAccount
+	}
+	return measure
+}
@@ -0,0 +1,110 @@
+package tco_vo_agent
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPartitionByMeasure(t *testing.T) {
+	account := FraudDecision{Username: "jane"}
+	message := account
+	message.ContentItems = []ContentItem{{Kind: contentKindMessage, MessageID: "m-1"}, {Kind: contentKindProfileText}}
+	urlOnly := account
+	urlOnly.ContentItems = []ContentItem{{MessageID: "m-2"}, {URL: "https://finya.de/p/1"}}
+	data := []agentData{{Data: account}, {Data: message}, {Data: urlOnly}}
+
+	ban, remove, disable := partitionByMeasure(data)
+	if len(ban) != 2 || len(remove) != 1 || len(disable) != 0 {
+		t.Fatalf("expected content removal only where every item is addressable, got ban=%d remove=%d disable=%d", len(ban), len(remove), len(disable))
+	}
+	if id := contentID(remove[0].Data, remove[0].Data.ContentItems[1]); id != "profile_text:jane" {
+		t.Fatalf("expected the profile text to be named by its account, got %q", id)
+	}
+
+	t.Setenv("CONTENT_MEASURE", "disable")
+	if _, _, disable := partitionByMeasure(data); len(disable) != 1 {
+		t.Fatalf("expected CONTENT_MEASURE=disable to disable access, got %+v", disable)
+	}
+	t.Setenv("CONTENT_MEASURE", "ban")
+	if ban, _, _ := partitionByMeasure(data); len(ban) != 3 {
+		t.Fatalf("expected CONTENT_MEASURE=ban to ban every account, got %+v", ban)
+	}
+	contentOnly := FraudDecision{ContentItems: []ContentItem{{MessageID: "m-3"}}}
+	if measure := measureFor(contentOnly); measure != measureRemoveContent {
+		t.Fatalf("expected content without an account to be removed, got %s", measure)
+	}
+}
+
+func TestSplitContentResults(t *testing.T) {
+	decision := FraudDecision{TicketID: "1", Username: "jane", ContentItems: []ContentItem{{MessageID: "m-1"}, {PictureID: "p-1"}}}
+	done, notFound := splitContentResults([]agentData{{Data: decision}},
+		[]contentResult{{ContentID: "message:m-1"}},
+		[]contentResult{{ContentID: "picture:p-1"}})
+	if len(done) != 1 || len(done[0].Data.ContentItems) != 1 || done[0].Data.ContentItems[0].MessageID != "m-1" {
+		t.Fatalf("expected the removed message, got %+v", done)
+	}
+	if len(notFound) != 1 || len(notFound[0].Data.ContentItems) != 1 || notFound[0].Data.ContentItems[0].PictureID != "p-1" {
+		t.Fatalf("expected the missing picture, got %+v", notFound)
+	}
+}
+
+func TestProcessTicketsAsyncRemovesContent(t *testing.T) {
+	stubs := stubApprovalPipeline(t)
+	t.Setenv("APPROVAL_MODE", "false")
+	extractDataFn = func(paths []string, agents []agentConfig) ([]agentData, []agentError) {
+		return []agentData{{Data: FraudDecision{
+			Username:        "jane",
+			AgencyName:      "Bundeskriminalamt",
+			ReferenceNumber: "REF-1",
+			ContentItems:    []ContentItem{{Kind: contentKindMessage, MessageID: "m-1"}},
+		}}}, nil
+	}
+	origRemove := removeContentFn
+	t.Cleanup(func() { removeContentFn = origRemove })
+	var removeCalls []agentData
+	removeContentFn = func(data []agentData) ([]agentData, []agentData, error) {
+		removeCalls = append(removeCalls, data...)
+		return data, nil, nil
+	}
+
+	if err := processTicketsAsync(ZendeskTicket{ID: "7", CreatedAt: time.Now().Format(time.RFC3339)}); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	if len(stubs.banned) != 0 || len(removeCalls) != 1 {
+		t.Fatalf("expected the message to be removed instead of a ban, banned=%+v removed=%+v", stubs.banned, removeCalls)
+	}
+	if len(stubs.replies) != 1 || stubs.replies[0] != ReplyToTicketTemplateContentRemoved || !hasTag(stubs.tags, decisionTagContentRemoved) {
+		t.Fatalf("expected the content removed reply and tag, replies=%v tags=%v", stubs.replies, stubs.tags)
+	}
+	if last := stubs.slack[len(stubs.slack)-1]; len(last.Removed) != 1 || !strings.Contains(buildSlackText(last), "*Content removed*") {
+		t.Fatalf("expected the removal in Slack, got %+v", last)
+	}
+
+	entry, err := (&fileLedger{dir: stateDir("LEDGER_DIR", "ledger")}).Get("7")
+	if err != nil || entry == nil || entry.Removal == nil || entry.Removal.Decisions[0].Measure != measureRemoveContent {
+		t.Fatalf("expected the removal in the ledger, got %+v (%v)", entry, err)
+	}
+	if entry.Deadline == nil || entry.Deadline.Outcome != deadlineOutcomeActed {
+		t.Fatalf("expected the deadline to stop, got %+v", entry.Deadline)
+	}
+}
+
+func TestContentActionReplyAndForm(t *testing.T) {
+	group := []agentData{{Data: FraudDecision{TicketID: "1", Username: "jane", ReferenceNumber: "REF-1", ContentItems: []ContentItem{{PictureID: "p-1"}}}}}
+	message, err := buildTicketMessage(ReplyToTicketTemplateContentDisabled, group)
+	if err != nil {
+		t.Fatalf("buildTicketMessage returned error: %v", err)
+	}
+	if !strings.Contains(message, "disabled in all Member States (username: jane, content: picture ID p-1)") {
+		t.Fatalf("unexpected message:\n%s", message)
+	}
+
+	text := ""
+	for _, line := range annexIILines(group, time.Time{}, time.Time{}, []contentMeasure{measureRemoveContent}) {
+		text += line.Text + "\n"
+	}
+	if !strings.Contains(text, "[X] The terrorist content has been removed") || !strings.Contains(text, "[ ] Access to the terrorist content has been disabled") {
+		t.Fatalf("expected only the removal to be ticked:\n%s", text)
+	}
+}
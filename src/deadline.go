@@ -0,0 +1,542 @@
+package tco_vo_agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// removalDeadline is the time Article 3(3) gives us to act on an order.
+const removalDeadline = time.Hour
+
+const (
+	deadlineOutcomeActed    = "acted"
+	deadlineOutcomeRejected = "rejected"
+	deadlineOutcomeNoAction = "no_action"
+
+	defaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+	// deadlinePauseClarification is the only pause Article 3(8) allows: the
+	// order has manifest errors or lacks the information needed to act on it.
+	deadlinePauseClarification = "clarification requested"
+)
+
+var (
+	defaultEscalationMinutes = []int{30, 50}
+	defaultPageMinutes       = []int{50}
+
+	notifyEscalationFn   = sendSlackEscalation
+	newPagerFn           = newPagerFromEnv
+	listTicketCommentsFn = ListTicketComments
+)
+
+// orderDeadline tracks the removal deadline of an order. The clock starts when
+// the ticket was created, pauses while we wait for a clarification from the
+// authority (Article 3(8)) and stops once we acted.
+type orderDeadline struct {
+	ReceivedAt time.Time       `json:"receivedAt"`
+	Pauses     []deadlinePause `json:"pauses,omitempty"`
+	StoppedAt  time.Time       `json:"stoppedAt,omitempty"`
+	Outcome    string          `json:"outcome,omitempty"`
+	// Escalated lists the thresholds (minutes) that were already escalated.
+	Escalated []int `json:"escalated,omitempty"`
+}
+
+type deadlinePause struct {
+	PausedAt  time.Time `json:"pausedAt"`
+	ResumedAt time.Time `json:"resumedAt,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// deadlineEscalation is sent to Slack and the pager when a threshold is crossed.
+type deadlineEscalation struct {
+	TicketID  string
+	State     ledgerState
+	Elapsed   time.Duration
+	Threshold time.Duration
+}
+
+// pager pages the on-call moderator about an order close to its deadline.
+type pager interface {
+	Page(escalation deadlineEscalation) error
+}
+
+func (d *orderDeadline) paused() bool {
+	return len(d.Pauses) > 0 && d.Pauses[len(d.Pauses)-1].ResumedAt.IsZero()
+}
+
+func (d *orderDeadline) stopped() bool {
+	return !d.StoppedAt.IsZero()
+}
+
+// elapsed returns the deadline time used so far, excluding pauses.
+func (d *orderDeadline) elapsed(now time.Time) time.Duration {
+	end := now
+	if d.stopped() {
+		end = d.StoppedAt
+	}
+	elapsed := end.Sub(d.ReceivedAt)
+	for _, pause := range d.Pauses {
+		resumed := pause.ResumedAt
+		if resumed.IsZero() || resumed.After(end) {
+			resumed = end
+		}
+		if resumed.After(pause.PausedAt) {
+			elapsed -= resumed.Sub(pause.PausedAt)
+		}
+	}
+	if elapsed < 0 {
+		return 0
+	}
+	return elapsed
+}
+
+// ticketReceivedAt returns when the order arrived in Zendesk, or the zero time if unknown.
+func ticketReceivedAt(ticket ZendeskTicket) time.Time {
+	receivedAt, err := time.Parse(time.RFC3339, strings.TrimSpace(ticket.CreatedAt))
+	if err != nil {
+		return time.Time{}
+	}
+	return receivedAt.UTC()
+}
+
+// startDeadline starts the clock on the first run of a ticket and resumes it
+// when the ticket is processed again after a clarification request.
+func startDeadline(ledger ticketLedger, ticket ZendeskTicket) error {
+	return updateDeadline(ledger, ticket.ID, func(deadline *orderDeadline, now time.Time) {
+		if deadline.ReceivedAt.IsZero() {
+			deadline.ReceivedAt = ticketReceivedAt(ticket)
+			if deadline.ReceivedAt.IsZero() {
+				deadline.ReceivedAt = now
+			}
+		}
+		if deadline.paused() {
+			deadline.Pauses[len(deadline.Pauses)-1].ResumedAt = now
+		}
+		if deadline.Outcome == deadlineOutcomeNoAction {
+			// a new run may find an order after all
+			deadline.StoppedAt = time.Time{}
+			deadline.Outcome = ""
+		}
+	})
+}
+
+// resumeDeadlineOnReply resumes a clock paused for clarification once the
+// authority answered with a public comment. The answer arrives as an update of
+// a ticket that already carries a decision tag, so it is not processed again
+// until a moderator asks for it.
+func resumeDeadlineOnReply(ledger ticketLedger, ticket ZendeskTicket) error {
+	entry, err := ledger.Get(ticket.ID)
+	if err != nil || entry == nil || entry.Deadline == nil {
+		return err
+	}
+	if !entry.Deadline.paused() || entry.Deadline.stopped() {
+		return nil
+	}
+	pause := entry.Deadline.Pauses[len(entry.Deadline.Pauses)-1]
+	if pause.Reason != deadlinePauseClarification {
+		return nil
+	}
+
+	comments, err := listTicketCommentsFn(ticket.ID)
+	if err != nil {
+		return err
+	}
+	answeredAt, ok := authorityReplyAfter(ticket, comments, pause.PausedAt)
+	if !ok {
+		return nil
+	}
+
+	resumed := false
+	err = updateDeadline(ledger, ticket.ID, func(deadline *orderDeadline, now time.Time) {
+		resumed = false
+		if !deadline.paused() || deadline.stopped() {
+			return
+		}
+		if answeredAt.After(now) {
+			answeredAt = now
+		}
+		deadline.Pauses[len(deadline.Pauses)-1].ResumedAt = answeredAt
+		resumed = true
+	})
+	if err != nil || !resumed {
+		return err
+	}
+
+	log.Printf("Authority answered the clarification request of ticket %s, deadline resumed at %s", ticket.ID, answeredAt.Format(time.RFC3339))
+	if !hasTag(ticket.Tags, reprocessTag) {
+		note := fmt.Sprintf("%s The authority answered the request for clarification; the removal deadline is running again since %s. Add the %s tag to process the order with the new information.",
+			agentNotePrefix, answeredAt.Format("2006-01-02 15:04 UTC"), reprocessTag)
+		if err := addInternalNoteFn(ticket.ID, note); err != nil {
+			log.Printf("Error adding internal note to ticket %s: %v", ticket.ID, err)
+		}
+	}
+	return nil
+}
+
+// authorityReplyAfter returns when the requester first commented publicly
+// after the given time. Our own replies and notes do not count.
+func authorityReplyAfter(ticket ZendeskTicket, comments []ZendeskComment, after time.Time) (time.Time, bool) {
+	for _, comment := range comments {
+		body := strings.TrimSpace(comment.Body)
+		if !comment.Public || strings.HasPrefix(body, agentReplyPrefix) || strings.HasPrefix(body, agentNotePrefix) {
+			continue
+		}
+		if ticket.RequesterID != "" && strconv.FormatInt(comment.AuthorID, 10) != ticket.RequesterID {
+			continue
+		}
+		createdAt, err := time.Parse(time.RFC3339, strings.TrimSpace(comment.CreatedAt))
+		if err != nil || !createdAt.After(after) {
+			continue
+		}
+		return createdAt.UTC(), true
+	}
+	return time.Time{}, false
+}
+
+// pauseDeadline stops the clock while the authority is asked for clarification.
+func pauseDeadline(ledger ticketLedger, ticketID string, reason string) error {
+	return updateDeadline(ledger, ticketID, func(deadline *orderDeadline, now time.Time) {
+		if deadline.paused() || deadline.stopped() {
+			return
+		}
+		deadline.Pauses = append(deadline.Pauses, deadlinePause{PausedAt: now, Reason: reason})
+	})
+}
+
+// stopDeadline records that the order was dealt with.
+func stopDeadline(ledger ticketLedger, ticketID string, outcome string) error {
+	return updateDeadline(ledger, ticketID, func(deadline *orderDeadline, now time.Time) {
+		if deadline.stopped() {
+			return
+		}
+		if deadline.paused() {
+			deadline.Pauses[len(deadline.Pauses)-1].ResumedAt = now
+		}
+		deadline.StoppedAt = now
+		deadline.Outcome = outcome
+	})
+}
+
+func updateDeadline(ledger ticketLedger, ticketID string, fn func(deadline *orderDeadline, now time.Time)) error {
+	_, err := ledger.Update(ticketID, func(entry *ledgerEntry) (*ledgerEntry, error) {
+		now := nowFn().UTC()
+		if entry == nil {
+			entry = &ledgerEntry{TicketID: ticketID, CreatedAt: now, UpdatedAt: now}
+		}
+		if entry.Deadline == nil {
+			entry.Deadline = &orderDeadline{}
+		}
+		fn(entry.Deadline, now)
+		return entry, nil
+	})
+	return err
+}
+
+// recordDeadlineOutcome stops or pauses the clock after the decisions of a run
+// were carried out. Failures are logged; they must not fail the run.
+func recordDeadlineOutcome(result *processResult) {
+	if result.TicketID == "" {
+		return
+	}
+	ledger, err := openLedgerFn()
+	if err != nil {
+		log.Printf("Error opening processing ledger: %v", err)
+		return
+	}
+
+	switch {
+	case len(result.Banned)+len(result.Removed)+len(result.Disabled) > 0:
+		err = stopDeadline(ledger, result.TicketID, deadlineOutcomeActed)
+	case len(result.Failed) > 0:
+		// nothing was carried out yet; keep the clock running so the order is escalated
+		return
+	case len(result.MoreInfo) > 0:
+		err = pauseDeadline(ledger, result.TicketID, deadlinePauseClarification)
+	case len(result.Impossible) > 0:
+		// the clock restarts once the reason ceased to exist (Article 3(7))
+		err = pauseDeadline(ledger, result.TicketID, "impossible to comply")
+	case len(result.Review) == 0:
+		err = stopDeadline(ledger, result.TicketID, deadlineOutcomeNoAction)
+	}
+	if err != nil {
+		log.Printf("Error recording deadline of ticket %s: %v", result.TicketID, err)
+	}
+}
+
+// orderElapsed returns the deadline time a ticket used so far.
+func orderElapsed(ticketID string) (time.Duration, bool) {
+	ledger, err := openLedgerFn()
+	if err != nil {
+		return 0, false
+	}
+	entry, err := ledger.Get(ticketID)
+	if err != nil || entry == nil || entry.Deadline == nil || entry.Deadline.ReceivedAt.IsZero() {
+		return 0, false
+	}
+	return entry.Deadline.elapsed(nowFn().UTC()), true
+}
+
+// deadlineElapsed is the deadline time used for the ticket of a result, or -1 if unknown.
+func (r processResult) deadlineElapsed() time.Duration {
+	if r.Elapsed > 0 {
+		return r.Elapsed
+	}
+	if r.ReceivedAt.IsZero() {
+		return -1
+	}
+	return nowFn().Sub(r.ReceivedAt)
+}
+
+// formatDeadline describes how much of the removal deadline is left.
+func formatDeadline(elapsed time.Duration, now time.Time) string {
+	if elapsed < 0 {
+		return "unknown (receipt time missing)"
+	}
+	left := removalDeadline - elapsed
+	due := now.Add(left).UTC()
+	if left < 0 {
+		return fmt.Sprintf(":rotating_light: overdue by %s (due %s UTC)", formatMinutes(-left), due.Format("15:04"))
+	}
+	return fmt.Sprintf("%s left (due %s UTC)", formatMinutes(left), due.Format("15:04"))
+}
+
+func formatMinutes(d time.Duration) string {
+	minutes := int(d.Round(time.Minute) / time.Minute)
+	if minutes < 60 {
+		return fmt.Sprintf("%d min", minutes)
+	}
+	return fmt.Sprintf("%dh %02dmin", minutes/60, minutes%60)
+}
+
+// parseMinutes reads a comma-separated list of minutes such as "30,50".
+func parseMinutes(envKey string, fallback []int) []int {
+	raw := strings.TrimSpace(os.Getenv(envKey))
+	if raw == "" {
+		return fallback
+	}
+	var minutes []int
+	for _, part := range strings.Split(raw, ",") {
+		value, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || value <= 0 {
+			log.Printf("Invalid %s %q, using %v", envKey, raw, fallback)
+			return fallback
+		}
+		minutes = append(minutes, value)
+	}
+	sort.Ints(minutes)
+	return minutes
+}
+
+// checkDeadlines escalates every running order that crossed a threshold of
+// DEADLINE_ESCALATION_MINUTES through Slack, and pages for the thresholds in
+// DEADLINE_PAGE_MINUTES. It returns the number of escalations sent.
+func checkDeadlines() (int, error) {
+	ledger, err := openLedgerFn()
+	if err != nil {
+		return 0, fmt.Errorf("opening processing ledger: %w", err)
+	}
+	entries, err := ledger.List()
+	if err != nil {
+		return 0, fmt.Errorf("listing processing ledger: %w", err)
+	}
+
+	thresholds := parseMinutes("DEADLINE_ESCALATION_MINUTES", defaultEscalationMinutes)
+	pageAt := map[int]bool{}
+	for _, minutes := range parseMinutes("DEADLINE_PAGE_MINUTES", defaultPageMinutes) {
+		pageAt[minutes] = true
+	}
+	pager := newPagerFn()
+
+	escalated := 0
+	for _, entry := range entries {
+		deadline := entry.Deadline
+		if deadline == nil || deadline.stopped() || deadline.paused() || deadline.ReceivedAt.IsZero() {
+			continue
+		}
+
+		// claim the crossed thresholds first so concurrent checks do not escalate twice
+		var due []int
+		now := nowFn().UTC()
+		_, err := ledger.Update(entry.TicketID, func(current *ledgerEntry) (*ledgerEntry, error) {
+			due = nil
+			if current == nil || current.Deadline == nil {
+				return nil, errLedgerSkip
+			}
+			elapsed := current.Deadline.elapsed(now)
+			for _, minutes := range thresholds {
+				threshold := time.Duration(minutes) * time.Minute
+				if elapsed >= threshold && !containsInt(current.Deadline.Escalated, minutes) {
+					due = append(due, minutes)
+					current.Deadline.Escalated = append(current.Deadline.Escalated, minutes)
+				}
+			}
+			if len(due) == 0 {
+				return nil, errLedgerSkip
+			}
+			entry = current
+			return current, nil
+		})
+		if err != nil {
+			if !errors.Is(err, errLedgerSkip) {
+				log.Printf("Error checking deadline of ticket %s: %v", entry.TicketID, err)
+			}
+			continue
+		}
+
+		// one message for the highest threshold crossed since the last check
+		minutes := due[len(due)-1]
+		escalation := deadlineEscalation{
+			TicketID:  entry.TicketID,
+			State:     entry.State,
+			Elapsed:   entry.Deadline.elapsed(now),
+			Threshold: time.Duration(minutes) * time.Minute,
+		}
+		if err := notifyEscalationFn(escalation); err != nil {
+			log.Printf("Error sending deadline escalation for ticket %s: %v", entry.TicketID, err)
+		}
+		shouldPage := false
+		for _, m := range due {
+			shouldPage = shouldPage || pageAt[m]
+		}
+		if shouldPage && pager != nil {
+			if err := pager.Page(escalation); err != nil {
+				log.Printf("Error paging for ticket %s: %v", entry.TicketID, err)
+			}
+		}
+		escalated++
+	}
+	return escalated, nil
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (e deadlineEscalation) summary() string {
+	return fmt.Sprintf("TCO order in ticket %s has used %s of the %s removal deadline (state %s)",
+		e.TicketID, formatMinutes(e.Elapsed), formatMinutes(removalDeadline), e.State)
+}
+
+// CheckDeadlines is the scheduler endpoint that escalates orders close to their deadline.
+func CheckDeadlines(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := validateBearerToken(r); err != nil {
+		log.Printf("Error validating bearer token: %v", err)
+		http.Error(w, "Invalid bearer token", http.StatusUnauthorized)
+		return
+	}
+
+	escalated, err := checkDeadlines()
+	if err != nil {
+		log.Printf("Error checking deadlines: %v", err)
+		http.Error(w, "Error checking deadlines", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]int{"escalated": escalated})
+}
+
+// sendSlackEscalation posts the escalation to SLACK_WEBHOOK_URL, if set.
+func sendSlackEscalation(escalation deadlineEscalation) error {
+	webhookURL := strings.TrimSpace(os.Getenv("SLACK_WEBHOOK_URL"))
+	if webhookURL == "" {
+		return nil
+	}
+	return postSlackMessage(webhookURL, map[string]interface{}{
+		"text": fmt.Sprintf(":alarm_clock: %s. %s left.", escalation.summary(), formatMinutes(removalDeadline-escalation.Elapsed)),
+	})
+}
+
+// newPagerFromEnv returns a PagerDuty pager when PAGERDUTY_ROUTING_KEY is set,
+// a generic webhook pager when PAGER_WEBHOOK_URL is set, and nil otherwise.
+func newPagerFromEnv() pager {
+	if key := strings.TrimSpace(os.Getenv("PAGERDUTY_ROUTING_KEY")); key != "" {
+		url := strings.TrimSpace(os.Getenv("PAGERDUTY_URL"))
+		if url == "" {
+			url = defaultPagerDutyURL
+		}
+		return &pagerDutyPager{url: url, routingKey: key}
+	}
+	if url := strings.TrimSpace(os.Getenv("PAGER_WEBHOOK_URL")); url != "" {
+		return &webhookPager{url: url}
+	}
+	return nil
+}
+
+// pagerDutyPager triggers a PagerDuty Events API v2 incident per ticket.
+type pagerDutyPager struct {
+	url        string
+	routingKey string
+}
+
+func (p *pagerDutyPager) Page(escalation deadlineEscalation) error {
+	return postPagerJSON(p.url, map[string]interface{}{
+		"routing_key":  p.routingKey,
+		"event_action": "trigger",
+		// one incident per ticket, later thresholds update it
+		"dedup_key": "tco-vo-deadline-" + escalation.TicketID,
+		"payload": map[string]interface{}{
+			"summary":  escalation.summary(),
+			"source":   "tco-vo-agent",
+			"severity": "critical",
+			"custom_details": map[string]interface{}{
+				"ticketId":       escalation.TicketID,
+				"state":          escalation.State,
+				"elapsedMinutes": int(escalation.Elapsed / time.Minute),
+			},
+		},
+	})
+}
+
+// webhookPager posts the escalation as JSON to any alerting webhook.
+type webhookPager struct {
+	url string
+}
+
+func (p *webhookPager) Page(escalation deadlineEscalation) error {
+	return postPagerJSON(p.url, map[string]interface{}{
+		"ticketId":         escalation.TicketID,
+		"state":            escalation.State,
+		"elapsedMinutes":   int(escalation.Elapsed / time.Minute),
+		"thresholdMinutes": int(escalation.Threshold / time.Minute),
+		"summary":          escalation.summary(),
+	})
+}
+
+func postPagerJSON(url string, payload map[string]interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("pager status %d: %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package tco_vo_agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var deadlineStart = time.Date(2025, 1, 8, 10, 0, 0, 0, time.UTC)
+
+func TestOrderDeadlineElapsedExcludesPauses(t *testing.T) {
+	deadline := orderDeadline{
+		ReceivedAt: deadlineStart,
+		Pauses: []deadlinePause{
+			{PausedAt: deadlineStart.Add(10 * time.Minute), ResumedAt: deadlineStart.Add(2 * time.Hour)},
+			{PausedAt: deadlineStart.Add(2*time.Hour + 5*time.Minute)},
+		},
+	}
+	if got := deadline.elapsed(deadlineStart.Add(3 * time.Hour)); got != 15*time.Minute {
+		t.Fatalf("expected 15m while paused, got %s", got)
+	}
+	deadline.Pauses[1].ResumedAt = deadlineStart.Add(3 * time.Hour)
+	deadline.StoppedAt = deadlineStart.Add(3*time.Hour + 20*time.Minute)
+	if got := deadline.elapsed(deadlineStart.Add(5 * time.Hour)); got != 35*time.Minute {
+		t.Fatalf("expected 35m after stopping, got %s", got)
+	}
+}
+
+func TestDeadlinePausesForClarification(t *testing.T) {
+	t.Setenv("LEDGER_DIR", t.TempDir())
+
+	origNow := nowFn
+	origGetAttachments := getAttachmentsFn
+	origExtractData := extractDataFn
+	origBanUsers := banUsersFn
+	origReply := replyToTicketFn
+	origTag := tagTicketFn
+	origUntag := untagTicketFn
+	origNotifySlack := notifySlackFn
+	origListComments := listTicketCommentsFn
+	t.Cleanup(func() {
+		nowFn = origNow
+		getAttachmentsFn = origGetAttachments
+		extractDataFn = origExtractData
+		banUsersFn = origBanUsers
+		replyToTicketFn = origReply
+		tagTicketFn = origTag
+		untagTicketFn = origUntag
+		notifySlackFn = origNotifySlack
+		listTicketCommentsFn = origListComments
+	})
+	stubPreservation(t)
+	stubLookup(t)
+	listTicketCommentsFn = func(ticketId string) ([]ZendeskComment, error) { return nil, nil }
+
+	now := deadlineStart
+	nowFn = func() time.Time { return now }
+	getAttachmentsFn = func(ticketId string) ([]string, error) { return nil, nil }
+	agency := ""
+	extractDataFn = func(paths []string, agents []agentConfig) ([]agentData, []agentError) {
+		return []agentData{{Data: FraudDecision{Username: "jane", AgencyName: agency, ReferenceNumber: "REF-1"}}}, nil
+	}
+	banUsersFn = func(data []agentData) (banResults, error) { return banResults{Banned: data}, nil }
+	var replies []string
+	replyToTicketFn = func(ticketId string, message string) error {
+		replies = append(replies, message)
+		return nil
+	}
+	tagTicketFn = func(ticketId string, tags []string) error { return nil }
+	untagTicketFn = func(ticketId string, tags []string) error { return nil }
+	var notified processResult
+	notifySlackFn = func(result processResult) error {
+		notified = result
+		return nil
+	}
+
+	ticket := ZendeskTicket{ID: "42", CreatedAt: deadlineStart.Format(time.RFC3339)}
+
+	// the order lacks the authority: clarification is requested after 10 minutes
+	now = deadlineStart.Add(10 * time.Minute)
+	if err := processTicketsAsync(ticket); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	if elapsed, _ := orderElapsed("42"); elapsed != 10*time.Minute {
+		t.Fatalf("expected the clock to pause at 10m, got %s", elapsed)
+	}
+
+	// the authority answers two hours later and the ticket is processed again
+	agency = "Bundeskriminalamt"
+	now = deadlineStart.Add(2 * time.Hour)
+	ticket.Tags = []string{reprocessTag}
+	if err := processTicketsAsync(ticket); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+
+	if len(replies) != 2 || !strings.Contains(replies[1], "10 min after we received the order") {
+		t.Fatalf("expected the completion reply to report the elapsed time, got %q", replies)
+	}
+	if notified.Elapsed != 10*time.Minute || !strings.Contains(buildSlackText(notified), "*Elapsed*: 10 min of 1h 00min") {
+		t.Fatalf("expected the elapsed time in Slack, got %s", buildSlackText(notified))
+	}
+}
+
+func TestDeadlineResumesOnAuthorityReply(t *testing.T) {
+	t.Setenv("LEDGER_DIR", t.TempDir())
+	origNow, origListComments, origNote := nowFn, listTicketCommentsFn, addInternalNoteFn
+	t.Cleanup(func() { nowFn, listTicketCommentsFn, addInternalNoteFn = origNow, origListComments, origNote })
+	var notes []string
+	addInternalNoteFn = func(ticketId string, message string) error {
+		notes = append(notes, message)
+		return nil
+	}
+	comments := []ZendeskComment{
+		{AuthorID: 77, Public: true, Body: "Please remove jane", CreatedAt: deadlineStart.Format(time.RFC3339)},
+		{AuthorID: 1, Public: true, Body: agentReplyPrefix + " - information required", CreatedAt: deadlineStart.Add(10 * time.Minute).Format(time.RFC3339)},
+		{AuthorID: 2, Public: false, Body: "internal remark", CreatedAt: deadlineStart.Add(30 * time.Minute).Format(time.RFC3339)},
+		{AuthorID: 77, Public: true, Body: "The issuing authority is the BKA", CreatedAt: deadlineStart.Add(time.Hour).Format(time.RFC3339)},
+	}
+	listTicketCommentsFn = func(ticketId string) ([]ZendeskComment, error) { return comments, nil }
+
+	ledger := &fileLedger{dir: stateDir("LEDGER_DIR", "ledger")}
+	ticket := ZendeskTicket{ID: "42", CreatedAt: deadlineStart.Format(time.RFC3339), RequesterID: "77", Tags: []string{decisionTagMoreInfo}}
+	nowFn = func() time.Time { return deadlineStart }
+	startDeadline(ledger, ticket)
+	nowFn = func() time.Time { return deadlineStart.Add(10 * time.Minute) }
+	recordDeadlineOutcome(&processResult{TicketID: "42", MoreInfo: []agentData{{Data: FraudDecision{Username: "jane"}}}})
+
+	// the answer arrives as an update of the tagged ticket
+	nowFn = func() time.Time { return deadlineStart.Add(65 * time.Minute) }
+	if err := processTicketsAsync(ticket); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	nowFn = func() time.Time { return deadlineStart.Add(90 * time.Minute) }
+	if elapsed, _ := orderElapsed("42"); elapsed != 40*time.Minute {
+		t.Fatalf("expected the clock to resume at the reply, got %s elapsed", elapsed)
+	}
+	if len(notes) != 1 || !strings.Contains(notes[0], reprocessTag) {
+		t.Fatalf("expected a note asking to process the order again, got %q", notes)
+	}
+
+	// a not-found account is no reason to pause
+	recordDeadlineOutcome(&processResult{TicketID: "43", NotFound: []agentData{{Data: FraudDecision{Username: "joe"}}}})
+	entry, _ := ledger.Get("43")
+	if entry.Deadline.paused() || entry.Deadline.Outcome != deadlineOutcomeNoAction {
+		t.Fatalf("expected not-found to stop the clock without a pause, got %+v", entry.Deadline)
+	}
+}
+
+func TestRecordDeadlineOutcomeKeepsClockOnFailedBans(t *testing.T) {
+	t.Setenv("LEDGER_DIR", t.TempDir())
+	ledger := &fileLedger{dir: stateDir("LEDGER_DIR", "ledger")}
+	if err := startDeadline(ledger, ZendeskTicket{ID: "42", CreatedAt: deadlineStart.Format(time.RFC3339)}); err != nil {
+		t.Fatal(err)
+	}
+
+	recordDeadlineOutcome(&processResult{TicketID: "42", Failed: []agentData{{Data: FraudDecision{Username: "jane"}, Reason: "connection refused"}}})
+	entry, _ := ledger.Get("42")
+	if entry.Deadline.stopped() || entry.Deadline.paused() {
+		t.Fatalf("expected the clock to keep running after failed bans, got %+v", entry.Deadline)
+	}
+}
+
+func TestCheckDeadlinesEscalatesOnce(t *testing.T) {
+	t.Setenv("LEDGER_DIR", t.TempDir())
+	t.Setenv("DEADLINE_ESCALATION_MINUTES", "30,50")
+	t.Setenv("DEADLINE_PAGE_MINUTES", "50")
+
+	origNow := nowFn
+	origNotify := notifyEscalationFn
+	origPager := newPagerFn
+	t.Cleanup(func() {
+		nowFn = origNow
+		notifyEscalationFn = origNotify
+		newPagerFn = origPager
+	})
+
+	var pages []deadlineEscalation
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event struct {
+			RoutingKey string `json:"routing_key"`
+			DedupKey   string `json:"dedup_key"`
+		}
+		json.NewDecoder(r.Body).Decode(&event)
+		if event.RoutingKey != "routing" || event.DedupKey != "tco-vo-deadline-1" {
+			t.Errorf("unexpected PagerDuty event %+v", event)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	t.Setenv("PAGERDUTY_ROUTING_KEY", "routing")
+	t.Setenv("PAGERDUTY_URL", server.URL)
+	newPagerFn = func() pager {
+		return recordingPager{next: newPagerFromEnv(), pages: &pages}
+	}
+
+	var escalations []deadlineEscalation
+	notifyEscalationFn = func(escalation deadlineEscalation) error {
+		escalations = append(escalations, escalation)
+		return nil
+	}
+
+	ledger, _ := openLedger()
+	startDeadline(ledger, ZendeskTicket{ID: "1", CreatedAt: deadlineStart.Format(time.RFC3339)})
+	startDeadline(ledger, ZendeskTicket{ID: "2", CreatedAt: deadlineStart.Format(time.RFC3339)})
+	nowFn = func() time.Time { return deadlineStart.Add(5 * time.Minute) }
+	pauseDeadline(ledger, "2", deadlinePauseClarification)
+
+	nowFn = func() time.Time { return deadlineStart.Add(35 * time.Minute) }
+	if n, err := checkDeadlines(); err != nil || n != 1 {
+		t.Fatalf("expected one escalation at 35m, got %d (%v)", n, err)
+	}
+	if len(pages) != 0 {
+		t.Fatalf("no page expected before 50m, got %+v", pages)
+	}
+
+	nowFn = func() time.Time { return deadlineStart.Add(52 * time.Minute) }
+	checkDeadlines()
+	if n, _ := checkDeadlines(); n != 0 {
+		t.Fatalf("thresholds must only be escalated once, got %d", n)
+	}
+	if len(escalations) != 2 || escalations[1].TicketID != "1" || escalations[1].Threshold != 50*time.Minute {
+		t.Fatalf("unexpected escalations %+v", escalations)
+	}
+	if len(pages) != 1 {
+		t.Fatalf("expected one page at 50m, got %+v", pages)
+	}
+}
+
+type recordingPager struct {
+	next  pager
+	pages *[]deadlineEscalation
+}
+
+func (p recordingPager) Page(escalation deadlineEscalation) error {
+	*p.pages = append(*p.pages, escalation)
+	return p.next.Page(escalation)
+}
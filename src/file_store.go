@@ -0,0 +1,63 @@
+package tco_vo_agent
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stateDir returns the directory configured in envKey, falling back to a
+// per-service directory below the system temp dir.
+func stateDir(envKey, name string) string {
+	if dir := strings.TrimSpace(os.Getenv(envKey)); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "tco-vo-agent", name)
+}
+
+// writeJSONFile writes v to path atomically by renaming a temp file into place.
+func writeJSONFile(path string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readJSONFile decodes the JSON document at path into v.
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// safeFileName maps an identifier (e.g. a ticket ID) to a file name component.
+func safeFileName(id string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, id)
+}
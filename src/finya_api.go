@@ -3,18 +3,53 @@ package tco_vo_agent
 import (
 	"bytes"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 )
 
+const defaultFinyaTimeout = 30 * time.Second
+
+// finyaEnvironments are the base URLs selected with FINYA_ENV.
+var finyaEnvironments = map[string]string{
+	"production": "https://www.finya.de",
+	"staging":    "https://staging.finya.de",
+	"local":      "https://local.finya.de",
+}
+
+// finyaTransport is shared by Finya clients that verify against the system roots.
+var finyaTransport http.RoundTripper = http.DefaultTransport.(*http.Transport).Clone()
+
+// banRequestItem is one account sent to the ban endpoint. The API echoes
+// CorrelationID in its result, so results map back to the exact decision even
+// when identifiers repeat or the API answers with its own user IDs.
+type banRequestItem struct {
+	CorrelationID   string `json:"correlationId"`
+	TicketID        string `json:"ticketId"`
+	Username        string `json:"username,omitempty"`
+	Email           string `json:"email,omitempty"`
+	UserID          string `json:"userId,omitempty"`
+	FinyaUserID     string `json:"finyaUserId,omitempty"` // resolved by the lookup, if it ran
+	AgencyName      string `json:"agencyName,omitempty"`
+	ReferenceNumber string `json:"referenceNumber,omitempty"`
+	Date            string `json:"date,omitempty"`
+}
+
+// result is the outcome for one banRequestItem. UserId is the Finya user ID
+// the identifiers resolved to; Error is set for items in the error bucket.
 type result struct {
-	UserId string `json:"userId"`
-	Decision string `json:"decision"`
+	CorrelationID string `json:"correlationId"`
+	UserId        string `json:"userId"`
+	Decision      string `json:"decision"`
+	Error         string `json:"error,omitempty"`
 }
 
 type response struct {
@@ -22,89 +57,633 @@ type response struct {
 	Data    struct {
 		Banned   []result `json:"banned"`
 		NotFound []result `json:"not_found"`
+		Error    []result `json:"error"`
 	} `json:"data"`
 }
 
-func BanUsers(data []agentData) (bannedUsers []agentData, notFoundUsers []agentData, err error) {
+// banResults sorts the accounts of a ban request by outcome.
+type banResults struct {
+	Banned   []agentData
+	NotFound []agentData
+	// Failed holds the accounts the API could not ban, with the error as Reason.
+	Failed []agentData
+}
 
-	// http request to finya.de API to ban fraud users
+// FinyaClient talks to the TCO endpoints of the Finya API.
+type FinyaClient struct {
+	// BaseURL is the scheme and host of the environment, e.g. https://www.finya.de.
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+// finyaAPIError is returned when Finya answers with a non-2xx status.
+type finyaAPIError struct {
+	Path       string
+	StatusCode int
+	Body       string
+}
+
+func (e *finyaAPIError) Error() string {
+	return fmt.Sprintf("finya POST %s: status %d: %s", e.Path, e.StatusCode, e.Body)
+}
+
+// NewFinyaClientFromEnv builds a client from FINYA_API_KEY and the environment:
+// FINYA_API_URL is the base URL, or FINYA_ENV selects production, staging or
+// local. TLS certificates are always verified, against the system roots plus
+// the PEM certificates in FINYA_CA_BUNDLE; only
+// FINYA_TLS_INSECURE_SKIP_VERIFY=true turns verification off. FINYA_TIMEOUT
+// sets the request timeout.
+func NewFinyaClientFromEnv() (*FinyaClient, error) {
 	apiKey := os.Getenv("FINYA_API_KEY")
 	if apiKey == "" {
-		return nil, nil, errors.New("FINYA_API_KEY is not set")
+		return nil, errors.New("FINYA_API_KEY is not set")
 	}
 
-	realm := "local"
-
-	url := fmt.Sprintf("https://%s.finya.de/api/tco/ban", realm)
-	headers := map[string]string{
-		"Authorization": fmt.Sprintf("Bearer %s", apiKey),
-		"Content-Type":  "application/json",
+	baseURL := strings.TrimSpace(os.Getenv("FINYA_API_URL"))
+	if baseURL == "" {
+		env := strings.ToLower(strings.TrimSpace(os.Getenv("FINYA_ENV")))
+		if env == "" {
+			return nil, errors.New("FINYA_API_URL or FINYA_ENV is not set")
+		}
+		var ok bool
+		if baseURL, ok = finyaEnvironments[env]; !ok {
+			return nil, fmt.Errorf("unknown FINYA_ENV %q, expected production, staging or local", env)
+		}
 	}
-	body := map[string]interface{}{
-		"users": data,
+
+	timeout := defaultFinyaTimeout
+	if raw := strings.TrimSpace(os.Getenv("FINYA_TIMEOUT")); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FINYA_TIMEOUT %q: %w", raw, err)
+		}
+		timeout = d
 	}
-	jsonBody, err := json.Marshal(body)
-	log.Printf("jsonBody: %s", string(jsonBody))
+
+	transport, err := finyaTransportFromEnv()
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	client := &http.Client{}
-	// dont verify the certificate
-	if realm == "local" {
-		client.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	return &FinyaClient{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		APIKey:     apiKey,
+		HTTPClient: &http.Client{Transport: transport, Timeout: timeout},
+	}, nil
+}
+
+// finyaTransportFromEnv returns the shared transport unless the TLS settings
+// need a transport of their own.
+func finyaTransportFromEnv() (http.RoundTripper, error) {
+	insecure := strings.EqualFold(strings.TrimSpace(os.Getenv("FINYA_TLS_INSECURE_SKIP_VERIFY")), "true")
+	caBundle := strings.TrimSpace(os.Getenv("FINYA_CA_BUNDLE"))
+	if !insecure && caBundle == "" {
+		return finyaTransport, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caBundle != "" {
+		pem, err := os.ReadFile(caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("reading FINYA_CA_BUNDLE: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
 		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("FINYA_CA_BUNDLE %s contains no PEM certificates", caBundle)
+		}
+		tlsConfig.RootCAs = pool
 	}
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
+	if insecure {
+		log.Printf("WARNING: TLS verification of the Finya API is disabled by FINYA_TLS_INSECURE_SKIP_VERIFY")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// BanUsers bans the accounts of the decisions with a client configured from the environment.
+func BanUsers(data []agentData) (banResults, error) {
+	client, err := NewFinyaClientFromEnv()
+	if err != nil {
+		return banResults{}, err
+	}
+	return client.BanUsers(data)
+}
+
+// LookupUsers resolves the identifiers of every decision to candidate accounts
+// with a client configured from the environment.
+func LookupUsers(data []agentData) ([][]UserData, error) {
+	client, err := NewFinyaClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return client.LookupUsers(data)
+}
+
+// RemoveContent removes the content items of the decisions without banning the
+// accounts. Decisions are returned with the items that were removed and the
+// items that could not be found, respectively.
+func RemoveContent(data []agentData) (removed []agentData, notFound []agentData, err error) {
+	client, err := NewFinyaClientFromEnv()
 	if err != nil {
 		return nil, nil, err
 	}
-	for k, v := range headers {
-		req.Header.Set(k, v)
+	return client.RemoveContent(data)
+}
+
+// DisableContent disables access to the content items of the decisions in all
+// Member States, keeping the content itself.
+func DisableContent(data []agentData) (disabled []agentData, notFound []agentData, err error) {
+	client, err := NewFinyaClientFromEnv()
+	if err != nil {
+		return nil, nil, err
 	}
+	return client.DisableContent(data)
+}
 
-	resp, err := client.Do(req)
+// PreserveContent asks Finya to keep the content and related data of the
+// decisions of one ticket until retainUntil (Article 6).
+func PreserveContent(data []agentData, retainUntil time.Time) (preservationReceipt, error) {
+	client, err := NewFinyaClientFromEnv()
+	if err != nil {
+		return preservationReceipt{}, err
+	}
+	return client.PreserveContent(data, retainUntil)
+}
+
+// ExtendPreservation keeps a preservation until retainUntil, for a legal hold.
+func ExtendPreservation(ticketID string, preservationID string, retainUntil time.Time) (preservationReceipt, error) {
+	client, err := NewFinyaClientFromEnv()
+	if err != nil {
+		return preservationReceipt{}, err
+	}
+	return client.ExtendPreservation(ticketID, preservationID, retainUntil)
+}
+
+// ReinstateUsers undoes the measures recorded for an order with a client
+// configured from the environment.
+func ReinstateUsers(decisions []removedDecision, reason reinstatementReason) (reinstated []agentData, failed []agentData, err error) {
+	client, err := NewFinyaClientFromEnv()
 	if err != nil {
 		return nil, nil, err
 	}
+	return client.ReinstateUsers(decisions, reason)
+}
+
+func (c *FinyaClient) BanUsers(data []agentData) (banResults, error) {
+
+	// http request to finya.de API to ban fraud users
+	items := make([]banRequestItem, len(data))
+	for i, entry := range data {
+		items[i] = banRequestItem{
+			CorrelationID:   banCorrelationID(entry.Data.TicketID, i),
+			TicketID:        entry.Data.TicketID,
+			Username:        entry.Data.Username,
+			Email:           entry.Data.Email,
+			UserID:          entry.Data.UserID,
+			FinyaUserID:     entry.Data.FinyaUserID,
+			AgencyName:      entry.Data.AgencyName,
+			ReferenceNumber: entry.Data.ReferenceNumber,
+			Date:            entry.Data.Date,
+		}
+	}
+	body := map[string]interface{}{
+		"users": items,
+	}
+	bodyBytes, err := c.post(data, "/api/tco/ban", body, auditBanRequest, auditBanResponse)
+	if err != nil {
+		return banResults{}, err
+	}
+
+	var response response
+
+	if err := json.Unmarshal(bodyBytes, &response); err != nil {
+		return banResults{}, err
+	}
+	if !response.Success {
+		return banResults{}, errors.New("failed to process fraud users")
+	}
+	return matchBanResults(data, items, response), nil
+}
+
+// lookupResponse lists the candidate accounts per correlation ID of the request.
+type lookupResponse struct {
+	Success bool `json:"success"`
+	Data    struct {
+		Results []struct {
+			CorrelationID string     `json:"correlationId"`
+			Candidates    []UserData `json:"candidates"`
+		} `json:"results"`
+	} `json:"data"`
+}
+
+// LookupUsers resolves the username, email and user ID of every decision to
+// the Finya accounts they match, without changing anything. The candidates are
+// returned in the order of data.
+func (c *FinyaClient) LookupUsers(data []agentData) ([][]UserData, error) {
+	items := make([]banRequestItem, len(data))
+	byID := map[string]int{}
+	for i, entry := range data {
+		items[i] = banRequestItem{
+			CorrelationID: banCorrelationID(entry.Data.TicketID, i),
+			TicketID:      entry.Data.TicketID,
+			Username:      entry.Data.Username,
+			Email:         entry.Data.Email,
+			UserID:        entry.Data.UserID,
+		}
+		byID[items[i].CorrelationID] = i
+	}
+	bodyBytes, err := c.post(data, "/api/tco/lookup", map[string]interface{}{"users": items}, auditLookupRequest, auditLookupResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	var response lookupResponse
+	if err := json.Unmarshal(bodyBytes, &response); err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		return nil, errors.New("failed to look up users")
+	}
+	candidates := make([][]UserData, len(data))
+	for _, r := range response.Data.Results {
+		i, ok := byID[r.CorrelationID]
+		if !ok {
+			log.Printf("Ignoring Finya lookup result with unknown correlation ID %q", r.CorrelationID)
+			continue
+		}
+		candidates[i] = append(candidates[i], r.Candidates...)
+	}
+	return candidates, nil
+}
+
+// banCorrelationID is unique within a request, and readable in the audit trail.
+func banCorrelationID(ticketID string, index int) string {
+	return fmt.Sprintf("%s-%d", fallbackValue(ticketID, "ticket"), index)
+}
+
+// matchBanResults maps the results back to the decisions by correlation ID.
+// Accounts the API did not answer for are treated as failed.
+func matchBanResults(data []agentData, items []banRequestItem, response response) banResults {
+	byID := map[string]int{}
+	for i, item := range items {
+		byID[item.CorrelationID] = i
+	}
+	answered := make([]bool, len(data))
+	lookup := func(r result) (agentData, bool) {
+		i, ok := byID[r.CorrelationID]
+		if !ok || answered[i] {
+			log.Printf("Ignoring Finya result with unknown or repeated correlation ID %q", r.CorrelationID)
+			return agentData{}, false
+		}
+		answered[i] = true
+		entry := data[i]
+		if r.UserId != "" {
+			entry.Data.FinyaUserID = r.UserId
+		}
+		return entry, true
+	}
+
+	results := banResults{Banned: []agentData{}, NotFound: []agentData{}}
+	for _, r := range response.Data.Banned {
+		if entry, ok := lookup(r); ok {
+			results.Banned = append(results.Banned, entry)
+		}
+	}
+	for _, r := range response.Data.NotFound {
+		if entry, ok := lookup(r); ok {
+			results.NotFound = append(results.NotFound, entry)
+		}
+	}
+	for _, r := range response.Data.Error {
+		if entry, ok := lookup(r); ok {
+			entry.Reason = fallbackValue(r.Error, "the Finya API reported an error")
+			results.Failed = append(results.Failed, entry)
+		}
+	}
+	for i, entry := range data {
+		if !answered[i] {
+			entry.Reason = "the Finya API returned no result"
+			results.Failed = append(results.Failed, entry)
+		}
+	}
+	return results
+}
+
+// post sends a request to the Finya TCO API and returns the response body.
+// Request and response are written to the audit trail of every ticket in data.
+func (c *FinyaClient) post(data []agentData, path string, body interface{}, requestEvent, responseEvent string) ([]byte, error) {
+	url := c.BaseURL + path
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	recordAuditPerTicket(data, requestEvent, func(group []agentData) interface{} {
+		return map[string]interface{}{"url": url, "body": json.RawMessage(jsonBody)}
+	})
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.APIKey))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		recordAuditPerTicket(data, responseEvent, func(group []agentData) interface{} {
+			return map[string]interface{}{"error": err.Error()}
+		})
+		return nil, err
+	}
 	defer resp.Body.Close()
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+	recordAuditPerTicket(data, responseEvent, func(group []agentData) interface{} {
+		return map[string]interface{}{"status": resp.StatusCode, "body": auditBody(bodyBytes)}
+	})
+	if resp.StatusCode >= 300 {
+		return nil, &finyaAPIError{Path: path, StatusCode: resp.StatusCode, Body: string(bodyBytes)}
+	}
+	return bodyBytes, nil
+}
 
-	var response response
+type contentResult struct {
+	ContentID string `json:"contentId"`
+}
+
+type contentResponse struct {
+	Success bool `json:"success"`
+	Data    struct {
+		Removed  []contentResult `json:"removed"`
+		Disabled []contentResult `json:"disabled"`
+		NotFound []contentResult `json:"not_found"`
+	} `json:"data"`
+}
+
+// contentRequestItem is one content item sent to the Finya API, together with
+// the account it belongs to.
+type contentRequestItem struct {
+	ContentID       string `json:"contentId"`
+	Kind            string `json:"kind"`
+	MessageID       string `json:"messageId,omitempty"`
+	PictureID       string `json:"pictureId,omitempty"`
+	Username        string `json:"username,omitempty"`
+	Email           string `json:"email,omitempty"`
+	UserID          string `json:"userId,omitempty"`
+	TicketID        string `json:"ticketId"`
+	ReferenceNumber string `json:"referenceNumber,omitempty"`
+}
+
+// RemoveContent removes the content items of the decisions.
+func (c *FinyaClient) RemoveContent(data []agentData) (removed []agentData, notFound []agentData, err error) {
+	return c.moderateContent(data, measureRemoveContent)
+}
+
+// DisableContent disables access to the content items of the decisions in all Member States.
+func (c *FinyaClient) DisableContent(data []agentData) (disabled []agentData, notFound []agentData, err error) {
+	return c.moderateContent(data, measureDisableContent)
+}
 
-	log.Printf("bodyBytes: %s", string(bodyBytes))
+func (c *FinyaClient) moderateContent(data []agentData, measure contentMeasure) ([]agentData, []agentData, error) {
+	var items []contentRequestItem
+	for _, entry := range data {
+		decision := entry.Data
+		for _, item := range decision.ContentItems {
+			id := contentID(decision, item)
+			if id == "" {
+				continue
+			}
+			items = append(items, contentRequestItem{
+				ContentID:       id,
+				Kind:            strings.SplitN(id, ":", 2)[0],
+				MessageID:       item.MessageID,
+				PictureID:       item.PictureID,
+				Username:        decision.Username,
+				Email:           decision.Email,
+				UserID:          decision.UserID,
+				TicketID:        decision.TicketID,
+				ReferenceNumber: decision.ReferenceNumber,
+			})
+		}
+	}
+
+	path := "/api/tco/content/remove"
+	body := map[string]interface{}{"items": items}
+	if measure == measureDisableContent {
+		path = "/api/tco/content/disable"
+		body["scope"] = "all_member_states"
+	}
+	bodyBytes, err := c.post(data, path, body, auditContentRequest, auditContentResponse)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var response contentResponse
 	if err := json.Unmarshal(bodyBytes, &response); err != nil {
 		return nil, nil, err
 	}
 	if !response.Success {
-		return nil, nil, errors.New("failed to process fraud users")
+		return nil, nil, errors.New("failed to process content")
 	}
+	done := response.Data.Removed
+	if measure == measureDisableContent {
+		done = response.Data.Disabled
+	}
+	doneData, notFoundData := splitContentResults(data, done, response.Data.NotFound)
+	return doneData, notFoundData, nil
+}
 
-	bannedUsers = []agentData{}
-	notFoundUsers = []agentData{}
+// splitContentResults maps the results back to the decisions. A decision whose
+// items were only partly found shows up in both lists with the respective items.
+func splitContentResults(data []agentData, done []contentResult, notFound []contentResult) ([]agentData, []agentData) {
+	doneIDs := map[string]bool{}
+	for _, result := range done {
+		doneIDs[result.ContentID] = true
+	}
+	notFoundIDs := map[string]bool{}
+	for _, result := range notFound {
+		notFoundIDs[result.ContentID] = true
+	}
 
-	// Helper function to find a user by ID (username or email)
-	findUserByID := func(userId string) *agentData {
-		for i := range data {
-			if data[i].Data.Username == userId || data[i].Data.Email == userId {
-				return &data[i]
+	doneData := []agentData{}
+	notFoundData := []agentData{}
+	for _, entry := range data {
+		var doneItems, notFoundItems []ContentItem
+		for _, item := range entry.Data.ContentItems {
+			id := contentID(entry.Data, item)
+			switch {
+			case doneIDs[id]:
+				doneItems = append(doneItems, item)
+			case notFoundIDs[id]:
+				notFoundItems = append(notFoundItems, item)
 			}
 		}
-		return nil
+		if len(doneItems) > 0 {
+			doneEntry := entry
+			doneEntry.Data.ContentItems = doneItems
+			doneData = append(doneData, doneEntry)
+		}
+		if len(notFoundItems) > 0 {
+			notFoundEntry := entry
+			notFoundEntry.Data.ContentItems = notFoundItems
+			notFoundData = append(notFoundData, notFoundEntry)
+		}
 	}
+	return doneData, notFoundData
+}
+
+type preservationResponse struct {
+	Success bool                `json:"success"`
+	Data    preservationReceipt `json:"data"`
+}
 
-	for _, bannedUser := range response.Data.Banned {
-		if user := findUserByID(bannedUser.UserId); user != nil {
-			bannedUsers = append(bannedUsers, *user)
+type preservationRequestItem struct {
+	Username    string   `json:"username,omitempty"`
+	Email       string   `json:"email,omitempty"`
+	UserID      string   `json:"userId,omitempty"`
+	FinyaUserID string   `json:"finyaUserId,omitempty"`
+	ContentIDs  []string `json:"contentIds,omitempty"`
+}
+
+// PreserveContent preserves the accounts and content items of the decisions of
+// one ticket. Accounts without content items are preserved as a whole.
+func (c *FinyaClient) PreserveContent(data []agentData, retainUntil time.Time) (preservationReceipt, error) {
+	items := make([]preservationRequestItem, 0, len(data))
+	for _, entry := range data {
+		decision := entry.Data
+		item := preservationRequestItem{
+			Username:    decision.Username,
+			Email:       decision.Email,
+			UserID:      decision.UserID,
+			FinyaUserID: decision.FinyaUserID,
+		}
+		for _, content := range decision.ContentItems {
+			if id := contentID(decision, content); id != "" {
+				item.ContentIDs = append(item.ContentIDs, id)
+			}
 		}
+		items = append(items, item)
+	}
+	order := data[0].Data
+	body := map[string]interface{}{
+		"ticketId":        order.TicketID,
+		"referenceNumber": order.ReferenceNumber,
+		"retainUntil":     retainUntil.UTC().Format(time.RFC3339),
+		"items":           items,
+	}
+	return c.preserve(data, "/api/tco/preservation", body, retainUntil)
+}
+
+// ExtendPreservation moves the expiry of an existing preservation to retainUntil.
+func (c *FinyaClient) ExtendPreservation(ticketID string, preservationID string, retainUntil time.Time) (preservationReceipt, error) {
+	data := []agentData{{Data: FraudDecision{TicketID: ticketID}}}
+	body := map[string]interface{}{
+		"retainUntil": retainUntil.UTC().Format(time.RFC3339),
+		"reason":      "legal_hold",
+	}
+	return c.preserve(data, "/api/tco/preservation/"+url.PathEscape(preservationID)+"/extend", body, retainUntil)
+}
+
+func (c *FinyaClient) preserve(data []agentData, path string, body interface{}, retainUntil time.Time) (preservationReceipt, error) {
+	bodyBytes, err := c.post(data, path, body, auditPreservationRequest, auditPreservationResponse)
+	if err != nil {
+		return preservationReceipt{}, err
+	}
+
+	var response preservationResponse
+	if err := json.Unmarshal(bodyBytes, &response); err != nil {
+		return preservationReceipt{}, err
+	}
+	if !response.Success || response.Data.PreservationID == "" {
+		return preservationReceipt{}, errors.New("failed to preserve content")
 	}
-	for _, notFoundUser := range response.Data.NotFound {
-		if user := findUserByID(notFoundUser.UserId); user != nil {
-			notFoundUsers = append(notFoundUsers, *user)
+	if response.Data.RetainUntil.IsZero() {
+		response.Data.RetainUntil = retainUntil.UTC()
+	}
+	return response.Data, nil
+}
+
+// reinstateRequestItem is one measure to undo: banned accounts are unbanned,
+// removed or disabled content is restored.
+type reinstateRequestItem struct {
+	banRequestItem
+	Measure    contentMeasure `json:"measure"`
+	ContentIDs []string       `json:"contentIds,omitempty"`
+}
+
+type reinstateResponse struct {
+	Success bool `json:"success"`
+	Data    struct {
+		Reinstated []result `json:"reinstated"`
+		NotFound   []result `json:"not_found"`
+		Error      []result `json:"error"`
+	} `json:"data"`
+}
+
+// ReinstateUsers undoes the recorded measures of an order. Accounts the API
+// could not find or reinstate are returned as failed, with the error as Reason.
+func (c *FinyaClient) ReinstateUsers(decisions []removedDecision, reason reinstatementReason) ([]agentData, []agentData, error) {
+	data := make([]agentData, len(decisions))
+	items := make([]reinstateRequestItem, len(decisions))
+	for i, decision := range decisions {
+		data[i] = agentData{Data: decision.FraudDecision}
+		items[i] = reinstateRequestItem{
+			banRequestItem: banRequestItem{
+				CorrelationID:   banCorrelationID(decision.TicketID, i),
+				TicketID:        decision.TicketID,
+				Username:        decision.Username,
+				Email:           decision.Email,
+				UserID:          decision.UserID,
+				FinyaUserID:     decision.FinyaUserID,
+				AgencyName:      decision.AgencyName,
+				ReferenceNumber: decision.ReferenceNumber,
+				Date:            decision.Date,
+			},
+			Measure: fallbackMeasure(decision.Measure),
+		}
+		for _, item := range decision.ContentItems {
+			if id := contentID(decision.FraudDecision, item); id != "" {
+				items[i].ContentIDs = append(items[i].ContentIDs, id)
+			}
 		}
 	}
-	return bannedUsers, notFoundUsers, nil
+	body := map[string]interface{}{
+		"reason": reason,
+		"users":  items,
+	}
+	bodyBytes, err := c.post(data, "/api/tco/reinstate", body, auditReinstateRequest, auditReinstateResponse)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var reinstateResp reinstateResponse
+	if err := json.Unmarshal(bodyBytes, &reinstateResp); err != nil {
+		return nil, nil, err
+	}
+	if !reinstateResp.Success {
+		return nil, nil, errors.New("failed to reinstate users")
+	}
+
+	// the buckets match those of a ban, so results map back the same way
+	banItems := make([]banRequestItem, len(items))
+	for i, item := range items {
+		banItems[i] = item.banRequestItem
+	}
+	var matched response
+	matched.Data.Banned = reinstateResp.Data.Reinstated
+	matched.Data.NotFound = reinstateResp.Data.NotFound
+	matched.Data.Error = reinstateResp.Data.Error
+	results := matchBanResults(data, banItems, matched)
+	for _, entry := range results.NotFound {
+		entry.Reason = "the account or content no longer exists"
+		results.Failed = append(results.Failed, entry)
+	}
+	return results.Banned, results.Failed, nil
 }
@@ -0,0 +1,165 @@
+package tco_vo_agent
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newFakeFinya serves the ban endpoint over TLS with a self-signed certificate
+// and bans every user it is sent.
+func newFakeFinya(t *testing.T, requests *[]map[string]interface{}) *httptest.Server {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tco/ban" || r.Header.Get("Authorization") != "Bearer finya-key" {
+			http.Error(w, "unexpected request", http.StatusForbidden)
+			return
+		}
+		var body struct {
+			Users []banRequestItem `json:"users"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		*requests = append(*requests, map[string]interface{}{"users": len(body.Users)})
+		var banned []map[string]string
+		for i, user := range body.Users {
+			banned = append(banned, map[string]string{"correlationId": user.CorrelationID, "userId": fmt.Sprint(1000 + i), "decision": "banned"})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "data": map[string]interface{}{"banned": banned}})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestBanUsersVerifiesTLS(t *testing.T) {
+	t.Setenv("FINYA_API_KEY", "finya-key")
+	var requests []map[string]interface{}
+	server := newFakeFinya(t, &requests)
+	t.Setenv("FINYA_API_URL", server.URL)
+	data := []agentData{{Data: FraudDecision{TicketID: "1", Username: "jane"}}}
+
+	if _, err := BanUsers(data); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Fatalf("expected the self-signed certificate to be rejected, got %v", err)
+	}
+	if len(requests) != 0 {
+		t.Fatalf("no request may reach an unverified server, got %+v", requests)
+	}
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(bundle, certificate, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FINYA_CA_BUNDLE", bundle)
+	results, err := BanUsers(data)
+	if err != nil {
+		t.Fatalf("BanUsers with the CA bundle returned error: %v", err)
+	}
+	if len(results.Banned) != 1 || results.Banned[0].Data.Username != "jane" || results.Banned[0].Data.FinyaUserID != "1000" {
+		t.Fatalf("expected jane to be banned, got %+v", results)
+	}
+
+	t.Setenv("FINYA_CA_BUNDLE", "")
+	t.Setenv("FINYA_TLS_INSECURE_SKIP_VERIFY", "true")
+	if _, err := BanUsers(data); err != nil {
+		t.Fatalf("BanUsers with verification disabled returned error: %v", err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected two requests, got %+v", requests)
+	}
+}
+
+func TestNewFinyaClientFromEnv(t *testing.T) {
+	t.Setenv("FINYA_API_KEY", "finya-key")
+	t.Setenv("FINYA_API_URL", "")
+
+	if _, err := NewFinyaClientFromEnv(); err == nil {
+		t.Fatal("expected an error without FINYA_API_URL or FINYA_ENV")
+	}
+	t.Setenv("FINYA_ENV", "qa")
+	if _, err := NewFinyaClientFromEnv(); err == nil {
+		t.Fatal("expected an error for an unknown environment")
+	}
+
+	t.Setenv("FINYA_ENV", "Staging")
+	t.Setenv("FINYA_TIMEOUT", "5s")
+	client, err := NewFinyaClientFromEnv()
+	if err != nil {
+		t.Fatalf("NewFinyaClientFromEnv returned error: %v", err)
+	}
+	if client.BaseURL != "https://staging.finya.de" || client.HTTPClient.Timeout.String() != "5s" || client.HTTPClient.Transport != finyaTransport {
+		t.Fatalf("unexpected client: %+v", client)
+	}
+
+	t.Setenv("FINYA_API_URL", "https://finya.example/")
+	if client, _ := NewFinyaClientFromEnv(); client.BaseURL != "https://finya.example" {
+		t.Fatalf("FINYA_API_URL must take precedence, got %q", client.BaseURL)
+	}
+
+	t.Setenv("FINYA_CA_BUNDLE", filepath.Join(t.TempDir(), "missing.pem"))
+	if _, err := NewFinyaClientFromEnv(); err == nil {
+		t.Fatal("expected an error for a missing CA bundle")
+	}
+}
+
+func TestMatchBanResultsByCorrelationID(t *testing.T) {
+	// the same account twice, differently cased, and one answered by numeric ID only
+	data := []agentData{
+		{Data: FraudDecision{TicketID: "1", Username: "Jane"}},
+		{Data: FraudDecision{TicketID: "1", Username: "jane"}},
+		{Data: FraudDecision{TicketID: "2", Email: "joe@example.com"}},
+		{Data: FraudDecision{TicketID: "2", Email: "gone@example.com"}},
+		{Data: FraudDecision{TicketID: "3", UserID: "77"}},
+	}
+	items := make([]banRequestItem, len(data))
+	for i, entry := range data {
+		items[i] = banRequestItem{CorrelationID: banCorrelationID(entry.Data.TicketID, i)}
+	}
+	var resp response
+	resp.Data.Banned = []result{{CorrelationID: "1-0", UserId: "501"}, {CorrelationID: "1-1", UserId: "501"}, {CorrelationID: "unknown"}}
+	resp.Data.NotFound = []result{{CorrelationID: "2-3"}}
+	resp.Data.Error = []result{{CorrelationID: "2-2", UserId: "502", Error: "account is locked"}}
+
+	results := matchBanResults(data, items, resp)
+	if len(results.Banned) != 2 || results.Banned[0].Data.Username != "Jane" || results.Banned[1].Data.FinyaUserID != "501" {
+		t.Fatalf("expected both entries of jane to be banned, got %+v", results.Banned)
+	}
+	if len(results.NotFound) != 1 || results.NotFound[0].Data.Email != "gone@example.com" {
+		t.Fatalf("unexpected not found: %+v", results.NotFound)
+	}
+	if len(results.Failed) != 2 || results.Failed[0].Reason != "account is locked" || results.Failed[1].Data.UserID != "77" {
+		t.Fatalf("expected the error bucket and the unanswered account to fail, got %+v", results.Failed)
+	}
+}
+
+func TestExecuteDecisionsReportsFailedBans(t *testing.T) {
+	stubs := stubApprovalPipeline(t)
+	banUsersFn = func(data []agentData) (banResults, error) {
+		failed := data[0]
+		failed.Reason = "account is locked"
+		return banResults{Failed: []agentData{failed}}, nil
+	}
+
+	result := processResult{TicketID: "5"}
+	executeDecisions(&result, []agentData{{Data: FraudDecision{TicketID: "5", Username: "jane"}}}, nil, nil)
+	if result.Error == nil || len(result.Failed) != 1 {
+		t.Fatalf("expected the failed ban to be reported, got %+v", result)
+	}
+	if !hasTag(stubs.tags, decisionTagError) || len(stubs.notes) != 1 || !strings.Contains(stubs.notes[0], "account is locked") {
+		t.Fatalf("expected the error tag and a note, tags=%v notes=%q", stubs.tags, stubs.notes)
+	}
+	if len(stubs.replies) != 0 {
+		t.Fatalf("the authority must not be answered for a failed ban, got %v", stubs.replies)
+	}
+	if !strings.Contains(buildSlackText(result), "*Ban failed*: username: jane: account is locked") {
+		t.Fatalf("expected the failure in Slack:\n%s", buildSlackText(result))
+	}
+}
@@ -0,0 +1,123 @@
+package tco_vo_agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	gcpTokenMu      sync.Mutex
+	gcpCachedToken  string
+	gcpTokenExpires time.Time
+)
+
+// gcpAccessToken returns an OAuth access token for Google Cloud REST APIs.
+// GCP_ACCESS_TOKEN takes precedence (useful locally); otherwise the token of the
+// runtime service account is fetched from the metadata server and cached.
+func gcpAccessToken() (string, error) {
+	if token := strings.TrimSpace(os.Getenv("GCP_ACCESS_TOKEN")); token != "" {
+		return token, nil
+	}
+
+	gcpTokenMu.Lock()
+	defer gcpTokenMu.Unlock()
+
+	if gcpCachedToken != "" && time.Now().Before(gcpTokenExpires) {
+		return gcpCachedToken, nil
+	}
+
+	host := strings.TrimSpace(os.Getenv("GCE_METADATA_HOST"))
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+	url := fmt.Sprintf("http://%s/computeMetadata/v1/instance/service-accounts/default/token", host)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch access token from metadata server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("metadata server returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("failed to parse metadata token response: %w", err)
+	}
+
+	gcpCachedToken = token.AccessToken
+	// refresh a minute early so long requests do not run with an expired token
+	gcpTokenExpires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return gcpCachedToken, nil
+}
+
+// gcpStatusError is returned by gcpRequest when a Google Cloud API answers with
+// a status from 300 up.
+type gcpStatusError struct {
+	service    string
+	StatusCode int
+	body       string
+}
+
+func (e *gcpStatusError) Error() string {
+	return fmt.Sprintf("%s returned status %d: %s", e.service, e.StatusCode, e.body)
+}
+
+// gcpRequest sends a request to a Google Cloud REST API and returns the
+// response body and headers. A non-nil body is sent as JSON. The access token
+// is only attached when authorize is set, so emulators can be called without
+// one; service names the API in errors.
+func gcpRequest(client *http.Client, service, method, rawURL string, body []byte, authorize bool) ([]byte, http.Header, error) {
+	req, err := http.NewRequest(method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if authorize {
+		token, err := gcpAccessToken()
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, nil, &gcpStatusError{service: service, StatusCode: resp.StatusCode, body: string(respBody)}
+	}
+	return respBody, resp.Header, nil
+}
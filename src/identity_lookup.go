@@ -0,0 +1,118 @@
+package tco_vo_agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+var lookupUsersFn = LookupUsers
+
+// lookupEnabled reads FINYA_LOOKUP. The identifiers of every order are resolved
+// to Finya accounts before anything is banned unless it is set to false.
+func lookupEnabled() bool {
+	return !strings.EqualFold(strings.TrimSpace(os.Getenv("FINYA_LOOKUP")), "false")
+}
+
+// resolveIdentities looks up the accounts the decisions name. Decisions that
+// match exactly one account carry its Finya user ID from here on; those that
+// match several accounts, or an account other than the user ID in the order,
+// are returned separately for review. Decisions without a match stay, so the
+// authority is told the account was not found.
+func resolveIdentities(data []agentData) ([]agentData, []agentData, error) {
+	// orders naming only content have no account to look up
+	var lookup, contentOnly []agentData
+	for _, entry := range data {
+		if hasAccountIdentifier(entry.Data) {
+			lookup = append(lookup, entry)
+		} else {
+			contentOnly = append(contentOnly, entry)
+		}
+	}
+	if len(lookup) == 0 {
+		return contentOnly, nil, nil
+	}
+	candidates, err := lookupUsersFn(lookup)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var resolved, ambiguous []agentData
+	for i, entry := range lookup {
+		var matches []UserData
+		seen := map[string]bool{}
+		for _, candidate := range candidates[i] {
+			if candidate.UserID == "" || seen[candidate.UserID] {
+				continue
+			}
+			seen[candidate.UserID] = true
+			matches = append(matches, candidate)
+		}
+
+		switch {
+		case len(matches) > 1:
+			entry.Reason = fmt.Sprintf("the identifiers match %d Finya accounts", len(matches))
+			entry.Candidates = matches
+			ambiguous = append(ambiguous, entry)
+		case len(matches) == 1 && entry.Data.UserID != "" && !strings.EqualFold(strings.TrimSpace(entry.Data.UserID), matches[0].UserID):
+			entry.Reason = fmt.Sprintf("the identifiers resolve to Finya user %s, but the order names user ID %s", matches[0].UserID, entry.Data.UserID)
+			entry.Candidates = matches
+			ambiguous = append(ambiguous, entry)
+		case len(matches) == 1:
+			entry.Data.FinyaUserID = matches[0].UserID
+			resolved = append(resolved, entry)
+		default:
+			resolved = append(resolved, entry)
+		}
+	}
+	return append(resolved, contentOnly...), ambiguous, nil
+}
+
+// noteCandidates writes the candidate accounts of ambiguous decisions to their
+// tickets so the moderator can pick the right one.
+func noteCandidates(review []agentData) {
+	for _, group := range groupByTicket(review) {
+		var lines []string
+		for _, data := range group {
+			if len(data.Candidates) == 0 {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%s (%s):", formatIdentifiers(data.Data), data.Reason))
+			for _, candidate := range data.Candidates {
+				lines = append(lines, "- "+formatCandidate(candidate))
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		ticketID := group[0].Data.TicketID
+		note := fmt.Sprintf("%s Not banned automatically, please choose the account to act on:\n%s", agentNotePrefix, strings.Join(lines, "\n"))
+		if err := addInternalNoteFn(ticketID, note); err != nil {
+			log.Printf("Error adding internal note to ticket %s: %v", ticketID, err)
+		}
+	}
+}
+
+// formatCandidate summarizes the profile metadata of a candidate account.
+func formatCandidate(candidate UserData) string {
+	parts := []string{"user ID " + candidate.UserID}
+	if candidate.MaksedUserName != "" {
+		parts = append(parts, "username "+candidate.MaksedUserName)
+	}
+	optIn := "no"
+	if candidate.HasOptIn {
+		optIn = "yes"
+	}
+	parts = append(parts, "opt-in "+optIn)
+	if candidate.TwoFactorData != nil {
+		parts = append(parts, "2FA set up")
+	}
+	if candidate.UserMetadata != nil {
+		if metadata, err := json.Marshal(candidate.UserMetadata); err == nil {
+			parts = append(parts, "metadata "+string(metadata))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
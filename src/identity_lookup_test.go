@@ -0,0 +1,125 @@
+package tco_vo_agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// stubLookup finds no Finya account for any identifier, so pipeline tests act
+// on the identifiers of the order as they are.
+func stubLookup(t *testing.T) {
+	t.Helper()
+	origLookup := lookupUsersFn
+	t.Cleanup(func() { lookupUsersFn = origLookup })
+	lookupUsersFn = func(data []agentData) ([][]UserData, error) {
+		return make([][]UserData, len(data)), nil
+	}
+}
+
+func TestLookupUsersMapsCandidatesByCorrelationID(t *testing.T) {
+	t.Setenv("FINYA_API_KEY", "finya-key")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tco/lookup" {
+			http.NotFound(w, r)
+			return
+		}
+		var body struct {
+			Users []banRequestItem `json:"users"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		// answered in reverse order, to show results are matched by ID
+		fmt.Fprintf(w, `{"success":true,"data":{"results":[{"correlationId":%q,"candidates":[{"userId":"2","maksedUserName":"j***"}]},{"correlationId":%q,"candidates":[]}]}}`,
+			body.Users[1].CorrelationID, body.Users[0].CorrelationID)
+	}))
+	defer server.Close()
+	t.Setenv("FINYA_API_URL", server.URL)
+
+	candidates, err := LookupUsers([]agentData{{Data: FraudDecision{TicketID: "1", Username: "gone"}}, {Data: FraudDecision{TicketID: "1", Username: "jane"}}})
+	if err != nil {
+		t.Fatalf("LookupUsers returned error: %v", err)
+	}
+	if len(candidates) != 2 || len(candidates[0]) != 0 || len(candidates[1]) != 1 || candidates[1][0].MaksedUserName != "j***" {
+		t.Fatalf("unexpected candidates: %+v", candidates)
+	}
+}
+
+func TestResolveIdentities(t *testing.T) {
+	origLookup := lookupUsersFn
+	t.Cleanup(func() { lookupUsersFn = origLookup })
+	lookupUsersFn = func(data []agentData) ([][]UserData, error) {
+		return [][]UserData{
+			{{UserID: "1"}, {UserID: "1"}},
+			{{UserID: "2"}, {UserID: "3"}},
+			{{UserID: "4"}},
+			nil,
+		}, nil
+	}
+
+	data := []agentData{
+		{Data: FraudDecision{Username: "jane"}},
+		{Data: FraudDecision{Username: "joe", Email: "other@example.com"}},
+		{Data: FraudDecision{Username: "max", UserID: "5"}},
+		{Data: FraudDecision{ContentItems: []ContentItem{{MessageID: "m-1"}}}},
+		{Data: FraudDecision{Username: "gone"}},
+	}
+	resolved, ambiguous, err := resolveIdentities(data)
+	if err != nil {
+		t.Fatalf("resolveIdentities returned error: %v", err)
+	}
+	if len(resolved) != 3 || resolved[0].Data.FinyaUserID != "1" || resolved[1].Data.Username != "gone" || resolved[1].Data.FinyaUserID != "" {
+		t.Fatalf("unexpected resolved decisions: %+v", resolved)
+	}
+	// the content-only order is passed through without a lookup
+	if len(resolved[2].Data.ContentItems) != 1 || resolved[2].Data.FinyaUserID != "" {
+		t.Fatalf("expected the content-only order to be resolved last, got %+v", resolved[2])
+	}
+	if len(ambiguous) != 2 || len(ambiguous[0].Candidates) != 2 || !strings.Contains(ambiguous[1].Reason, "names user ID 5") {
+		t.Fatalf("unexpected ambiguous decisions: %+v", ambiguous)
+	}
+}
+
+func TestLookupEnabledByDefault(t *testing.T) {
+	t.Setenv("FINYA_LOOKUP", "")
+	if !lookupEnabled() {
+		t.Fatal("expected the lookup to run unless it is switched off")
+	}
+	t.Setenv("FINYA_LOOKUP", "false")
+	if lookupEnabled() {
+		t.Fatal("expected FINYA_LOOKUP=false to switch the lookup off")
+	}
+}
+
+func TestProcessTicketsAsyncReviewsAmbiguousAccounts(t *testing.T) {
+	stubs := stubApprovalPipeline(t)
+	t.Setenv("APPROVAL_MODE", "false")
+	origLookup := lookupUsersFn
+	t.Cleanup(func() { lookupUsersFn = origLookup })
+	lookupUsersFn = func(data []agentData) ([][]UserData, error) {
+		return [][]UserData{{
+			{UserID: "11", MaksedUserName: "ja**", HasOptIn: true, TwoFactorData: map[string]interface{}{"method": "app"}},
+			{UserID: "12", MaksedUserName: "ja**", UserMetadata: map[string]interface{}{"city": "Berlin"}},
+		}}, nil
+	}
+
+	if err := processTicketsAsync(ZendeskTicket{ID: "7"}); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	if len(stubs.banned) != 0 || len(stubs.replies) != 0 {
+		t.Fatalf("an ambiguous account must not be banned, banned=%+v replies=%v", stubs.banned, stubs.replies)
+	}
+	if !hasTag(stubs.tags, decisionTagReview) {
+		t.Fatalf("expected the review tag, got %v", stubs.tags)
+	}
+	if len(stubs.notes) != 1 {
+		t.Fatalf("expected the candidates as a note, got %q", stubs.notes)
+	}
+	for _, want := range []string{"match 2 Finya accounts", "user ID 11, username ja**, opt-in yes, 2FA set up", `user ID 12, username ja**, opt-in no, metadata {"city":"Berlin"}`} {
+		if !strings.Contains(stubs.notes[0], want) {
+			t.Fatalf("note lacks %q:\n%s", want, stubs.notes[0])
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package tco_vo_agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJobMaxAttempts     = 3
+	defaultJobVisibilityAfter = 15 * time.Minute
+	jobRetryBackoff           = 30 * time.Second
+	jobLease                  = 3 * time.Minute
+)
+
+var (
+	openJobQueueFn = openJobQueue
+	drainJobsFn    = drainJobs
+	drainMu        sync.Mutex
+
+	// jobLeaseRenewal is how often the lease of a running job is extended.
+	jobLeaseRenewal = time.Minute
+)
+
+// ticketJob is a unit of work accepted by the webhook and processed by the worker.
+type ticketJob struct {
+	ID         string        `json:"id"`
+	Ticket     ZendeskTicket `json:"ticket"`
+	Attempts   int           `json:"attempts"`
+	LastError  string        `json:"lastError,omitempty"`
+	EnqueuedAt time.Time     `json:"enqueuedAt"`
+	NotBefore  time.Time     `json:"notBefore,omitempty"`
+	// SlackAction is set for a moderator's decision from Slack; Ticket then only carries the ticket ID.
+	SlackAction *slackActionJob `json:"slackAction,omitempty"`
+
+	// ackID is the backend specific handle used to acknowledge the delivery.
+	ackID string
+}
+
+// jobQueue is a durable queue with explicit acknowledgement. A received job
+// stays invisible to other workers until it is acked, nacked or times out.
+type jobQueue interface {
+	Enqueue(job ticketJob) error
+	// Receive returns the next job that is ready for processing, or nil when the queue is empty.
+	Receive() (*ticketJob, error)
+	Ack(job ticketJob) error
+	// Extend keeps a received job invisible to other workers while it is still being processed.
+	Extend(job ticketJob) error
+	// Nack releases the job for another attempt, or parks it as failed once
+	// the attempt limit is reached. It reports whether the job was given up.
+	Nack(job ticketJob, cause error) (bool, error)
+}
+
+func newTicketJob(ticket ZendeskTicket) ticketJob {
+	now := nowFn().UTC()
+	return ticketJob{
+		ID:         fmt.Sprintf("%020d-%s", now.UnixNano(), safeFileName(ticket.ID)),
+		Ticket:     ticket,
+		EnqueuedAt: now,
+	}
+}
+
+// jobQueueBackend reads JOB_QUEUE_BACKEND.
+func jobQueueBackend() string {
+	return strings.ToLower(strings.TrimSpace(os.Getenv("JOB_QUEUE_BACKEND")))
+}
+
+// openJobQueue returns the queue selected by JOB_QUEUE_BACKEND ("file" or "pubsub").
+func openJobQueue() (jobQueue, error) {
+	if err := validateJobQueueConfig(); err != nil {
+		return nil, err
+	}
+	backend := jobQueueBackend()
+	switch backend {
+	case "", "file":
+		return &fileJobQueue{
+			dir:         stateDir("JOB_QUEUE_DIR", "jobs"),
+			maxAttempts: jobMaxAttempts(),
+			visibility:  defaultJobVisibilityAfter,
+		}, nil
+	case "pubsub":
+		return newPubSubJobQueueFromEnv()
+	default:
+		return nil, fmt.Errorf("unsupported JOB_QUEUE_BACKEND %s", backend)
+	}
+}
+
+// validateJobQueueConfig refuses a production deployment whose queue would
+// default to the temporary directory of a single instance, or whose Pub/Sub
+// jobs would never be delivered: accepted webhooks would be lost.
+func validateJobQueueConfig() error {
+	if !isProductionMode() {
+		return nil
+	}
+	switch jobQueueBackend() {
+	case "", "file":
+		if strings.TrimSpace(os.Getenv("JOB_QUEUE_DIR")) == "" {
+			return errors.New("JOB_QUEUE_BACKEND=pubsub or a JOB_QUEUE_DIR on shared storage is required in production")
+		}
+	case "pubsub":
+		if strings.TrimSpace(os.Getenv("PUBSUB_PUSH_TOKEN")) == "" && strings.TrimSpace(os.Getenv("PUBSUB_SUBSCRIPTION")) == "" {
+			return errors.New("PUBSUB_PUSH_TOKEN or PUBSUB_SUBSCRIPTION is required in production, or no job is ever processed")
+		}
+	}
+	return nil
+}
+
+func jobMaxAttempts() int {
+	raw := strings.TrimSpace(os.Getenv("JOB_MAX_ATTEMPTS"))
+	if raw == "" {
+		return defaultJobMaxAttempts
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 {
+		log.Printf("Invalid JOB_MAX_ATTEMPTS %q, using %d", raw, defaultJobMaxAttempts)
+		return defaultJobMaxAttempts
+	}
+	return n
+}
+
+// enqueueTicket persists a job for the ticket so it survives the HTTP response.
+func enqueueTicket(ticket ZendeskTicket) error {
+	return enqueueJob(newTicketJob(ticket))
+}
+
+// enqueueJob persists the job so it survives the HTTP response.
+func enqueueJob(job ticketJob) error {
+	queue, err := openJobQueueFn()
+	if err != nil {
+		return err
+	}
+	if err := queue.Enqueue(job); err != nil {
+		return err
+	}
+	log.Printf("Enqueued job %s for ticket %s", job.ID, job.Ticket.ID)
+	return nil
+}
+
+// processJob runs the work a job stands for.
+func processJob(job ticketJob) error {
+	if job.SlackAction != nil {
+		return handleSlackActionFn(job.ID, job.SlackAction.Interaction, job.Ticket.ID, job.SlackAction.Decision)
+	}
+	return asyncTicketProcessor(job.ID, job.Ticket)
+}
+
+// processQueuedJobs runs freshly enqueued jobs before the handler answers,
+// because an instance gets no CPU once the response is sent. Pub/Sub pushes
+// its jobs to /jobs/push instead, so nothing is drained here for it.
+func processQueuedJobs() {
+	if jobQueueBackend() == "pubsub" {
+		return
+	}
+	drainJobsFn()
+}
+
+// drainJobs processes queued jobs until the queue is empty and returns the
+// number of jobs handled. Only one drain runs per instance at a time.
+func drainJobs() int {
+	if !drainMu.TryLock() {
+		return 0
+	}
+	defer drainMu.Unlock()
+
+	queue, err := openJobQueueFn()
+	if err != nil {
+		log.Printf("Error opening job queue: %v", err)
+		return 0
+	}
+
+	handled := 0
+	for {
+		job, err := queue.Receive()
+		if err != nil {
+			log.Printf("Error receiving job: %v", err)
+			return handled
+		}
+		if job == nil {
+			return handled
+		}
+		handled++
+
+		release := leaseJob(queue, *job)
+		procErr := processJob(*job)
+		release()
+		if procErr == nil {
+			if err := queue.Ack(*job); err != nil {
+				log.Printf("Error acknowledging job %s: %v", job.ID, err)
+			}
+			continue
+		}
+
+		gaveUp, err := queue.Nack(*job, procErr)
+		if err != nil {
+			log.Printf("Error releasing job %s: %v", job.ID, err)
+			continue
+		}
+		if gaveUp {
+			reportFailedJob(*job, job.Attempts+1, procErr)
+		} else {
+			log.Printf("Job %s for ticket %s failed, will retry: %v", job.ID, job.Ticket.ID, procErr)
+		}
+	}
+}
+
+// leaseJob extends the lease of the job right away and then periodically
+// until the returned function is called, so a pipeline run that outlasts the
+// queue's visibility timeout is not handed to a second worker.
+func leaseJob(queue jobQueue, job ticketJob) func() {
+	extend := func() {
+		if err := queue.Extend(job); err != nil {
+			log.Printf("Error extending lease of job %s: %v", job.ID, err)
+		}
+	}
+	extend()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(jobLeaseRenewal)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				extend()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// reportFailedJob tells Slack that a job was given up on after the given
+// number of attempts.
+func reportFailedJob(job ticketJob, attempts int, cause error) {
+	log.Printf("Job %s for ticket %s failed permanently after %d attempts: %v", job.ID, job.Ticket.ID, attempts, cause)
+	err := notifySlackFn(processResult{
+		TicketID: job.Ticket.ID,
+		Subject:  job.Ticket.Subject,
+		Error:    fmt.Errorf("giving up after %d attempts: %w", attempts, cause),
+	})
+	if err != nil {
+		log.Printf("Error sending Slack notification: %v", err)
+	}
+}
+
+// DrainJobs drains the job queue synchronously. It is meant to be called by a
+// scheduler so jobs left behind by a killed instance are picked up again.
+func DrainJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := validateBearerToken(r); err != nil {
+		log.Printf("Error validating bearer token: %v", err)
+		http.Error(w, "Invalid bearer token", http.StatusUnauthorized)
+		return
+	}
+
+	handled := drainJobsFn()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]int{"handled": handled})
+}
+
+// fileJobQueue keeps one JSON file per job in pending/, inflight/ and failed/
+// subdirectories. Claims are made with an atomic rename.
+type fileJobQueue struct {
+	dir         string
+	maxAttempts int
+	visibility  time.Duration
+}
+
+func (q *fileJobQueue) path(state, id string) string {
+	return filepath.Join(q.dir, state, id+".json")
+}
+
+func (q *fileJobQueue) Enqueue(job ticketJob) error {
+	return writeJSONFile(q.path("pending", job.ID), job)
+}
+
+func (q *fileJobQueue) Receive() (*ticketJob, error) {
+	if err := q.requeueStale(); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(q.dir, "pending"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	if err := os.MkdirAll(filepath.Join(q.dir, "inflight"), 0o755); err != nil {
+		return nil, err
+	}
+
+	now := nowFn()
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		id := strings.TrimSuffix(name, ".json")
+
+		var job ticketJob
+		if err := readJSONFile(q.path("pending", id), &job); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read job %s: %w", id, err)
+		}
+		if !job.NotBefore.IsZero() && now.Before(job.NotBefore) {
+			continue
+		}
+
+		// the rename is the claim; losing the race just means another worker has it
+		if err := os.Rename(q.path("pending", id), q.path("inflight", id)); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		os.Chtimes(q.path("inflight", id), now, now)
+		job.ackID = id
+		return &job, nil
+	}
+
+	return nil, nil
+}
+
+// requeueStale moves jobs whose worker died mid-processing back to pending.
+func (q *fileJobQueue) requeueStale() error {
+	entries, err := os.ReadDir(filepath.Join(q.dir, "inflight"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		if nowFn().Sub(info.ModTime()) < q.visibility {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), ".json")
+		log.Printf("Requeueing stale job %s", id)
+		if err := os.Rename(q.path("inflight", id), q.path("pending", id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *fileJobQueue) Ack(job ticketJob) error {
+	err := os.Remove(q.path("inflight", job.ackID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (q *fileJobQueue) Extend(job ticketJob) error {
+	now := nowFn()
+	return os.Chtimes(q.path("inflight", job.ackID), now, now)
+}
+
+func (q *fileJobQueue) Nack(job ticketJob, cause error) (bool, error) {
+	job.Attempts++
+	if cause != nil {
+		job.LastError = cause.Error()
+	}
+
+	state := "pending"
+	gaveUp := job.Attempts >= q.maxAttempts
+	if gaveUp {
+		state = "failed"
+	} else {
+		job.NotBefore = nowFn().UTC().Add(time.Duration(job.Attempts) * jobRetryBackoff)
+	}
+
+	if err := writeJSONFile(q.path(state, job.ackID), job); err != nil {
+		return false, err
+	}
+	if err := os.Remove(q.path("inflight", job.ackID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return gaveUp, err
+	}
+	return gaveUp, nil
+}
@@ -0,0 +1,303 @@
+package tco_vo_agent
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// pubSubMaxAckDeadline is the longest ack deadline Pub/Sub accepts.
+const pubSubMaxAckDeadline = 600 * time.Second
+
+// pubSubJobQueue publishes jobs to a Pub/Sub topic and pulls them from a
+// subscription using the REST API. Failed attempts are counted in the
+// processing ledger, because Pub/Sub only reports delivery attempts on
+// subscriptions with a dead-letter policy. Nack extends the ack deadline by an
+// exponential backoff so the message is redelivered later; once the worker
+// gives up the message is acked so it leaves the subscription, and the failure
+// is reported. Messages that already used up their attempts are acked and
+// reported the same way without being processed again.
+type pubSubJobQueue struct {
+	baseURL      string
+	project      string
+	topic        string
+	subscription string
+	maxAttempts  int
+	emulator     bool
+	client       *http.Client
+}
+
+func newPubSubJobQueueFromEnv() (*pubSubJobQueue, error) {
+	project := strings.TrimSpace(os.Getenv("PUBSUB_PROJECT"))
+	if project == "" {
+		project = strings.TrimSpace(os.Getenv("GOOGLE_CLOUD_PROJECT"))
+	}
+	if project == "" {
+		return nil, errors.New("PUBSUB_PROJECT is not set")
+	}
+	topic := strings.TrimSpace(os.Getenv("PUBSUB_TOPIC"))
+	if topic == "" {
+		return nil, errors.New("PUBSUB_TOPIC is not set")
+	}
+	// without a pull subscription the jobs are only delivered to /jobs/push
+	subscription := strings.TrimSpace(os.Getenv("PUBSUB_SUBSCRIPTION"))
+
+	q := &pubSubJobQueue{
+		baseURL:      "https://pubsub.googleapis.com",
+		project:      project,
+		topic:        topic,
+		subscription: subscription,
+		maxAttempts:  jobMaxAttempts(),
+		client:       &http.Client{Timeout: 30 * time.Second},
+	}
+	if host := strings.TrimSpace(os.Getenv("PUBSUB_EMULATOR_HOST")); host != "" {
+		q.baseURL = "http://" + host
+		q.emulator = true
+	}
+	return q, nil
+}
+
+func (q *pubSubJobQueue) call(method, path string, payload interface{}, out interface{}) error {
+	jsonBody, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	body, _, err := gcpRequest(q.client, "pubsub "+path, method, q.baseURL+path, jsonBody, !q.emulator)
+	if err != nil {
+		return err
+	}
+	if out != nil {
+		return json.Unmarshal(body, out)
+	}
+	return nil
+}
+
+func (q *pubSubJobQueue) Enqueue(job ticketJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	payload := map[string]interface{}{
+		"messages": []map[string]interface{}{{
+			"data":       base64.StdEncoding.EncodeToString(data),
+			"attributes": map[string]string{"ticketId": job.Ticket.ID, "jobId": job.ID},
+		}},
+	}
+	return q.call("POST", fmt.Sprintf("/v1/projects/%s/topics/%s:publish", q.project, q.topic), payload, nil)
+}
+
+func (q *pubSubJobQueue) Receive() (*ticketJob, error) {
+	if q.subscription == "" {
+		return nil, nil
+	}
+	for {
+		var response struct {
+			ReceivedMessages []struct {
+				AckID   string `json:"ackId"`
+				Message struct {
+					Data      string `json:"data"`
+					MessageID string `json:"messageId"`
+				} `json:"message"`
+				DeliveryAttempt int `json:"deliveryAttempt"`
+			} `json:"receivedMessages"`
+		}
+		payload := map[string]interface{}{"maxMessages": 1}
+		if err := q.call("POST", fmt.Sprintf("/v1/projects/%s/subscriptions/%s:pull", q.project, q.subscription), payload, &response); err != nil {
+			return nil, err
+		}
+		if len(response.ReceivedMessages) == 0 {
+			return nil, nil
+		}
+
+		received := response.ReceivedMessages[0]
+		var job ticketJob
+		data, err := base64.StdEncoding.DecodeString(received.Message.Data)
+		if err == nil {
+			err = json.Unmarshal(data, &job)
+		}
+		if err != nil {
+			// a message we cannot parse will never succeed, drop it instead of looping on it
+			log.Printf("Dropping malformed job message %s: %v", received.Message.MessageID, err)
+			if err := q.Ack(ticketJob{ackID: received.AckID}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if received.DeliveryAttempt > 0 {
+			job.Attempts = received.DeliveryAttempt - 1
+		}
+		if attempts := recordedAttempts(job); attempts > job.Attempts {
+			job.Attempts = attempts
+		}
+		job.ackID = received.AckID
+		if job.Attempts >= q.maxAttempts {
+			// given up on before, but the acknowledgement did not reach Pub/Sub
+			if err := q.Ack(job); err != nil {
+				return nil, err
+			}
+			reportFailedJob(job, job.Attempts, errors.New("redelivered after the last attempt"))
+			continue
+		}
+		return &job, nil
+	}
+}
+
+// recordedAttempts returns the failed attempts of the job kept in the ledger.
+func recordedAttempts(job ticketJob) int {
+	ledger, err := openLedgerFn()
+	if err != nil {
+		log.Printf("Error opening processing ledger: %v", err)
+		return 0
+	}
+	entry, err := ledger.Get(job.Ticket.ID)
+	if err != nil {
+		log.Printf("Error reading attempts of job %s: %v", job.ID, err)
+		return 0
+	}
+	if entry == nil {
+		return 0
+	}
+	return entry.JobAttempts[job.ID]
+}
+
+// recordAttempt counts a failed attempt of the job in the ledger and returns
+// the number of failed attempts so far.
+func recordAttempt(job ticketJob) int {
+	attempts := job.Attempts + 1
+	ledger, err := openLedgerFn()
+	if err != nil {
+		log.Printf("Error opening processing ledger: %v", err)
+		return attempts
+	}
+	_, err = ledger.Update(job.Ticket.ID, func(entry *ledgerEntry) (*ledgerEntry, error) {
+		now := nowFn().UTC()
+		if entry == nil {
+			entry = &ledgerEntry{TicketID: job.Ticket.ID, CreatedAt: now, UpdatedAt: now}
+		}
+		if entry.JobAttempts == nil {
+			entry.JobAttempts = map[string]int{}
+		}
+		if entry.JobAttempts[job.ID] < attempts {
+			entry.JobAttempts[job.ID] = attempts
+		} else {
+			entry.JobAttempts[job.ID]++
+		}
+		attempts = entry.JobAttempts[job.ID]
+		return entry, nil
+	})
+	if err != nil {
+		log.Printf("Error recording attempt of job %s: %v", job.ID, err)
+	}
+	return attempts
+}
+
+// retryBackoff doubles the delay with every failed attempt, up to the longest
+// ack deadline.
+func retryBackoff(attempts int) time.Duration {
+	backoff := jobRetryBackoff
+	for i := 1; i < attempts && backoff < pubSubMaxAckDeadline; i++ {
+		backoff *= 2
+	}
+	if backoff > pubSubMaxAckDeadline {
+		backoff = pubSubMaxAckDeadline
+	}
+	return backoff
+}
+
+func (q *pubSubJobQueue) modifyAckDeadline(job ticketJob, deadline time.Duration) error {
+	payload := map[string]interface{}{
+		"ackIds":             []string{job.ackID},
+		"ackDeadlineSeconds": int(deadline / time.Second),
+	}
+	return q.call("POST", fmt.Sprintf("/v1/projects/%s/subscriptions/%s:modifyAckDeadline", q.project, q.subscription), payload, nil)
+}
+
+func (q *pubSubJobQueue) Ack(job ticketJob) error {
+	payload := map[string]interface{}{"ackIds": []string{job.ackID}}
+	return q.call("POST", fmt.Sprintf("/v1/projects/%s/subscriptions/%s:acknowledge", q.project, q.subscription), payload, nil)
+}
+
+func (q *pubSubJobQueue) Extend(job ticketJob) error {
+	return q.modifyAckDeadline(job, jobLease)
+}
+
+func (q *pubSubJobQueue) Nack(job ticketJob, cause error) (bool, error) {
+	attempts := recordAttempt(job)
+	if attempts >= q.maxAttempts {
+		return true, q.Ack(job)
+	}
+	return false, q.modifyAckDeadline(job, retryBackoff(attempts))
+}
+
+// PushJob receives a job from a Pub/Sub push subscription and processes it
+// before answering, so the instance keeps its CPU for the whole run. A 2xx
+// answer acks the message; an error status makes Pub/Sub deliver it again
+// with the subscription's retry policy.
+func PushJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.TrimSpace(os.Getenv("PUBSUB_PUSH_TOKEN"))
+	if token == "" || subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(token)) != 1 {
+		log.Printf("Rejecting Pub/Sub push without a valid token")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var envelope struct {
+		Message struct {
+			Data      string `json:"data"`
+			MessageID string `json:"messageId"`
+		} `json:"message"`
+		DeliveryAttempt int `json:"deliveryAttempt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+		http.Error(w, "Invalid push payload", http.StatusBadRequest)
+		return
+	}
+	var job ticketJob
+	data, err := base64.StdEncoding.DecodeString(envelope.Message.Data)
+	if err == nil {
+		err = json.Unmarshal(data, &job)
+	}
+	if err != nil {
+		// a message we cannot parse will never succeed, ack it instead of looping on it
+		log.Printf("Dropping malformed job message %s: %v", envelope.Message.MessageID, err)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if envelope.DeliveryAttempt > 0 {
+		job.Attempts = envelope.DeliveryAttempt - 1
+	}
+	if attempts := recordedAttempts(job); attempts > job.Attempts {
+		job.Attempts = attempts
+	}
+	maxAttempts := jobMaxAttempts()
+	if job.Attempts >= maxAttempts {
+		reportFailedJob(job, job.Attempts, errors.New("redelivered after the last attempt"))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	procErr := processJob(job)
+	if procErr == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if attempts := recordAttempt(job); attempts >= maxAttempts {
+		reportFailedJob(job, attempts, procErr)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	log.Printf("Job %s for ticket %s failed, will retry: %v", job.ID, job.Ticket.ID, procErr)
+	http.Error(w, "Job failed, retry later", http.StatusInternalServerError)
+}
@@ -0,0 +1,402 @@
+package tco_vo_agent
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFileJobQueueLifecycle(t *testing.T) {
+	queue := &fileJobQueue{dir: t.TempDir(), maxAttempts: 2, visibility: time.Minute}
+
+	if err := queue.Enqueue(newTicketJob(ZendeskTicket{ID: "42", Subject: "order"})); err != nil {
+		t.Fatalf("Enqueue returned error: %v", err)
+	}
+
+	job, err := queue.Receive()
+	if err != nil || job == nil {
+		t.Fatalf("Receive() = %+v, %v, want job", job, err)
+	}
+	if job.Ticket.ID != "42" {
+		t.Fatalf("received ticket %q, want 42", job.Ticket.ID)
+	}
+
+	if again, _ := queue.Receive(); again != nil {
+		t.Fatalf("in-flight job must not be delivered twice, got %+v", again)
+	}
+
+	gaveUp, err := queue.Nack(*job, errors.New("boom"))
+	if err != nil || gaveUp {
+		t.Fatalf("first Nack = %v, %v, want retry", gaveUp, err)
+	}
+
+	if early, _ := queue.Receive(); early != nil {
+		t.Fatalf("job must wait for its backoff, got %+v", early)
+	}
+
+	origNow := nowFn
+	t.Cleanup(func() { nowFn = origNow })
+	nowFn = func() time.Time { return time.Now().Add(time.Hour) }
+
+	job, err = queue.Receive()
+	if err != nil || job == nil {
+		t.Fatalf("Receive() after backoff = %+v, %v, want job", job, err)
+	}
+	if job.Attempts != 1 || job.LastError != "boom" {
+		t.Fatalf("unexpected retry metadata: %+v", job)
+	}
+
+	gaveUp, err = queue.Nack(*job, errors.New("boom again"))
+	if err != nil || !gaveUp {
+		t.Fatalf("second Nack = %v, %v, want give up", gaveUp, err)
+	}
+
+	failed, _ := os.ReadDir(filepath.Join(queue.dir, "failed"))
+	if len(failed) != 1 {
+		t.Fatalf("expected job to be parked in failed/, got %d entries", len(failed))
+	}
+	if next, _ := queue.Receive(); next != nil {
+		t.Fatalf("failed job must not be delivered again, got %+v", next)
+	}
+}
+
+func TestFileJobQueueRequeuesStaleJobs(t *testing.T) {
+	queue := &fileJobQueue{dir: t.TempDir(), maxAttempts: 3, visibility: time.Minute}
+	if err := queue.Enqueue(newTicketJob(ZendeskTicket{ID: "7"})); err != nil {
+		t.Fatalf("Enqueue returned error: %v", err)
+	}
+	if job, _ := queue.Receive(); job == nil {
+		t.Fatal("expected job")
+	}
+
+	origNow := nowFn
+	t.Cleanup(func() { nowFn = origNow })
+	nowFn = func() time.Time { return time.Now().Add(2 * time.Minute) }
+
+	job, err := queue.Receive()
+	if err != nil || job == nil || job.Ticket.ID != "7" {
+		t.Fatalf("expected stale job to be redelivered, got %+v, %v", job, err)
+	}
+}
+
+func TestDrainJobsAcksAndRetries(t *testing.T) {
+	queue := &fileJobQueue{dir: t.TempDir(), maxAttempts: 1, visibility: time.Minute}
+
+	origOpen := openJobQueueFn
+	origAsync := asyncTicketProcessor
+	origNotify := notifySlackFn
+	t.Cleanup(func() {
+		openJobQueueFn = origOpen
+		asyncTicketProcessor = origAsync
+		notifySlackFn = origNotify
+	})
+
+	openJobQueueFn = func() (jobQueue, error) { return queue, nil }
+	queue.Enqueue(newTicketJob(ZendeskTicket{ID: "ok"}))
+	queue.Enqueue(newTicketJob(ZendeskTicket{ID: "bad"}))
+
+	var processed []string
+	asyncTicketProcessor = func(jobID string, ticket ZendeskTicket) error {
+		processed = append(processed, ticket.ID)
+		if ticket.ID == "bad" {
+			return errors.New("extraction failed")
+		}
+		return nil
+	}
+	var notified []processResult
+	notifySlackFn = func(result processResult) error {
+		notified = append(notified, result)
+		return nil
+	}
+
+	if handled := drainJobs(); handled != 2 {
+		t.Fatalf("drainJobs handled %d jobs, want 2", handled)
+	}
+	if strings.Join(processed, ",") != "ok,bad" {
+		t.Fatalf("unexpected processing order: %v", processed)
+	}
+	if len(notified) != 1 || notified[0].TicketID != "bad" || notified[0].Error == nil {
+		t.Fatalf("expected a failure notification for the bad job, got %+v", notified)
+	}
+
+	for _, state := range []string{"pending", "inflight"} {
+		entries, _ := os.ReadDir(filepath.Join(queue.dir, state))
+		if len(entries) != 0 {
+			t.Fatalf("expected %s/ to be empty, got %d entries", state, len(entries))
+		}
+	}
+}
+
+func TestPubSubJobQueue(t *testing.T) {
+	var published []byte
+	acked := false
+	var deadlines []int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/v1/projects/p/topics/t:publish":
+			var payload struct {
+				Messages []struct {
+					Data string `json:"data"`
+				} `json:"messages"`
+			}
+			json.Unmarshal(body, &payload)
+			published, _ = base64.StdEncoding.DecodeString(payload.Messages[0].Data)
+			fmt.Fprint(w, `{"messageIds":["1"]}`)
+		case "/v1/projects/p/subscriptions/s:pull":
+			if published == nil {
+				fmt.Fprint(w, `{}`)
+				return
+			}
+			// no dead-letter policy: deliveryAttempt is not reported
+			fmt.Fprintf(w, `{"receivedMessages":[{"ackId":"ack-1","message":{"messageId":"1","data":%q}}]}`,
+				base64.StdEncoding.EncodeToString(published))
+		case "/v1/projects/p/subscriptions/s:acknowledge":
+			acked = strings.Contains(string(body), "ack-1")
+			published = nil
+			fmt.Fprint(w, `{}`)
+		case "/v1/projects/p/subscriptions/s:modifyAckDeadline":
+			var payload struct {
+				AckDeadlineSeconds int `json:"ackDeadlineSeconds"`
+			}
+			json.Unmarshal(body, &payload)
+			deadlines = append(deadlines, payload.AckDeadlineSeconds)
+			fmt.Fprint(w, `{}`)
+		default:
+			t.Fatalf("unexpected path %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("PUBSUB_PROJECT", "p")
+	t.Setenv("PUBSUB_TOPIC", "t")
+	t.Setenv("PUBSUB_SUBSCRIPTION", "s")
+	t.Setenv("PUBSUB_EMULATOR_HOST", strings.TrimPrefix(server.URL, "http://"))
+	t.Setenv("JOB_MAX_ATTEMPTS", "2")
+	t.Setenv("LEDGER_DIR", t.TempDir())
+
+	origNotify := notifySlackFn
+	t.Cleanup(func() { notifySlackFn = origNotify })
+	var notified []processResult
+	notifySlackFn = func(result processResult) error {
+		notified = append(notified, result)
+		return nil
+	}
+
+	queue, err := newPubSubJobQueueFromEnv()
+	if err != nil {
+		t.Fatalf("newPubSubJobQueueFromEnv returned error: %v", err)
+	}
+
+	if err := queue.Enqueue(newTicketJob(ZendeskTicket{ID: "99"})); err != nil {
+		t.Fatalf("Enqueue returned error: %v", err)
+	}
+	message := published
+	job, err := queue.Receive()
+	if err != nil || job == nil || job.Ticket.ID != "99" || job.Attempts != 0 {
+		t.Fatalf("Receive() = %+v, %v", job, err)
+	}
+	if err := queue.Extend(*job); err != nil {
+		t.Fatalf("Extend returned error: %v", err)
+	}
+	if gaveUp, err := queue.Nack(*job, errors.New("retry")); err != nil || gaveUp {
+		t.Fatalf("first Nack = %v, %v", gaveUp, err)
+	}
+
+	// the attempt is counted in the ledger, not by Pub/Sub
+	job, err = queue.Receive()
+	if err != nil || job == nil || job.Attempts != 1 {
+		t.Fatalf("Receive() = %+v, %v, want the second attempt", job, err)
+	}
+	if gaveUp, err := queue.Nack(*job, errors.New("retry")); err != nil || !gaveUp {
+		t.Fatalf("second Nack = %v, %v, want give-up", gaveUp, err)
+	}
+	if !acked {
+		t.Fatal("a message given up on must be acked so it leaves the subscription")
+	}
+	if len(deadlines) != 2 || deadlines[0] != int(jobLease/time.Second) || deadlines[1] != 30 {
+		t.Fatalf("expected the lease and one backoff deadline, got %v", deadlines)
+	}
+
+	// a redelivery after a lost acknowledgement is acked and reported, not processed again
+	published, acked = message, false
+	if job, err := queue.Receive(); err != nil || job != nil {
+		t.Fatalf("Receive() = %+v, %v, want the exhausted job dropped", job, err)
+	}
+	if !acked || len(notified) != 1 || notified[0].TicketID != "99" || notified[0].Error == nil {
+		t.Fatalf("expected the exhausted job to be acked and reported, acked=%v notified=%+v", acked, notified)
+	}
+
+	// a malformed message is dropped and the next pull is served
+	published = []byte("not json")
+	if job, err := queue.Receive(); err != nil || job != nil || !acked {
+		t.Fatalf("Receive() = %+v, %v (acked=%v), want the malformed message dropped", job, err, acked)
+	}
+}
+
+// leaseCountingQueue counts the lease extensions of a wrapped queue.
+type leaseCountingQueue struct {
+	jobQueue
+	extended int32
+}
+
+func (q *leaseCountingQueue) Extend(job ticketJob) error {
+	atomic.AddInt32(&q.extended, 1)
+	return q.jobQueue.Extend(job)
+}
+
+func TestDrainJobsExtendsLeaseWhileProcessing(t *testing.T) {
+	queue := &leaseCountingQueue{jobQueue: &fileJobQueue{dir: t.TempDir(), maxAttempts: 3, visibility: time.Minute}}
+
+	origOpen := openJobQueueFn
+	origAsync := asyncTicketProcessor
+	origRenewal := jobLeaseRenewal
+	t.Cleanup(func() {
+		openJobQueueFn = origOpen
+		asyncTicketProcessor = origAsync
+		jobLeaseRenewal = origRenewal
+	})
+	openJobQueueFn = func() (jobQueue, error) { return queue, nil }
+	jobLeaseRenewal = 5 * time.Millisecond
+	asyncTicketProcessor = func(jobID string, ticket ZendeskTicket) error {
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	}
+
+	queue.Enqueue(newTicketJob(ZendeskTicket{ID: "slow"}))
+	if handled := drainJobs(); handled != 1 {
+		t.Fatalf("drainJobs handled %d jobs, want 1", handled)
+	}
+	if extended := atomic.LoadInt32(&queue.extended); extended < 2 {
+		t.Fatalf("expected the lease to be renewed while the job ran, got %d extensions", extended)
+	}
+}
+
+// syncDrain makes the drain run by ProcessTickets observable: the returned
+// function blocks until that drain has finished.
+func syncDrain(t *testing.T) func() {
+	t.Helper()
+
+	orig := drainJobsFn
+	t.Cleanup(func() { drainJobsFn = orig })
+
+	done := make(chan struct{})
+	drainJobsFn = func() int {
+		defer close(done)
+		return drainJobs()
+	}
+	return func() {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for job drain")
+		}
+	}
+}
+
+func TestPushJob(t *testing.T) {
+	t.Setenv("PUBSUB_PUSH_TOKEN", "push-secret")
+	t.Setenv("JOB_MAX_ATTEMPTS", "2")
+	t.Setenv("LEDGER_DIR", t.TempDir())
+
+	origAsync := asyncTicketProcessor
+	origNotify := notifySlackFn
+	t.Cleanup(func() {
+		asyncTicketProcessor = origAsync
+		notifySlackFn = origNotify
+	})
+	var processed []string
+	asyncTicketProcessor = func(jobID string, ticket ZendeskTicket) error {
+		processed = append(processed, ticket.ID)
+		if ticket.ID == "bad" {
+			return errors.New("extraction failed")
+		}
+		return nil
+	}
+	var notified []processResult
+	notifySlackFn = func(result processResult) error {
+		notified = append(notified, result)
+		return nil
+	}
+
+	push := func(token string, job ticketJob) int {
+		data, _ := json.Marshal(job)
+		body := fmt.Sprintf(`{"message":{"messageId":"1","data":%q},"subscription":"projects/p/subscriptions/s"}`, base64.StdEncoding.EncodeToString(data))
+		rec := httptest.NewRecorder()
+		ProcessTickets(rec, httptest.NewRequest(http.MethodPost, "/jobs/push?token="+token, strings.NewReader(body)))
+		return rec.Code
+	}
+
+	if code := push("wrong", newTicketJob(ZendeskTicket{ID: "ok"})); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a wrong token, got %d", code)
+	}
+	if code := push("push-secret", newTicketJob(ZendeskTicket{ID: "ok"})); code != http.StatusNoContent {
+		t.Fatalf("expected the job to be acked, got %d", code)
+	}
+
+	bad := newTicketJob(ZendeskTicket{ID: "bad"})
+	if code := push("push-secret", bad); code != http.StatusInternalServerError {
+		t.Fatalf("expected a failed job to be redelivered, got %d", code)
+	}
+	if code := push("push-secret", bad); code != http.StatusNoContent {
+		t.Fatalf("expected the job to be acked once it ran out of attempts, got %d", code)
+	}
+	if strings.Join(processed, ",") != "ok,bad,bad" {
+		t.Fatalf("unexpected processing: %v", processed)
+	}
+	if len(notified) != 1 || notified[0].TicketID != "bad" || notified[0].Error == nil {
+		t.Fatalf("expected a failure notification for the bad job, got %+v", notified)
+	}
+
+	// a delivery after the last attempt is reported again but not processed
+	if code := push("push-secret", bad); code != http.StatusNoContent || len(processed) != 3 {
+		t.Fatalf("expected the exhausted job to be acked unprocessed, got %d after %v", code, processed)
+	}
+}
+
+func TestValidateJobQueueConfig(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+	t.Setenv("JOB_QUEUE_BACKEND", "")
+	t.Setenv("JOB_QUEUE_DIR", "")
+
+	if err := validateJobQueueConfig(); err == nil {
+		t.Fatal("expected production mode without a shared queue to be rejected")
+	}
+	if _, err := openJobQueue(); err == nil {
+		t.Fatal("expected openJobQueue to refuse the instance-local queue in production")
+	}
+
+	t.Setenv("JOB_QUEUE_DIR", "/mnt/shared/jobs")
+	if err := validateJobQueueConfig(); err != nil {
+		t.Fatalf("expected an explicit JOB_QUEUE_DIR to be accepted, got %v", err)
+	}
+
+	t.Setenv("JOB_QUEUE_DIR", "")
+	t.Setenv("JOB_QUEUE_BACKEND", "pubsub")
+	t.Setenv("PUBSUB_PUSH_TOKEN", "")
+	t.Setenv("PUBSUB_SUBSCRIPTION", "")
+	if err := validateJobQueueConfig(); err == nil {
+		t.Fatal("expected Pub/Sub without push or pull delivery to be rejected")
+	}
+	t.Setenv("PUBSUB_PUSH_TOKEN", "push-secret")
+	if err := validateJobQueueConfig(); err != nil {
+		t.Fatalf("expected push delivery to be accepted, got %v", err)
+	}
+
+	t.Setenv("APP_ENV", "development")
+	t.Setenv("JOB_QUEUE_BACKEND", "")
+	if err := validateJobQueueConfig(); err != nil {
+		t.Fatalf("development mode must not require a shared queue, got %v", err)
+	}
+}
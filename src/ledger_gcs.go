@@ -0,0 +1,173 @@
+package tco_vo_agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gcsLedgerMaxConflicts bounds how often an update is retried when other
+// instances keep writing the same entry.
+const gcsLedgerMaxConflicts = 10
+
+var (
+	errGCSObjectNotFound = errors.New("cloud storage object not found")
+	errGCSConflict       = errors.New("cloud storage object was changed concurrently")
+)
+
+// gcsLedger keeps one JSON object per ticket in Cloud Storage, so every
+// instance sees the same entries. Updates are optimistic: an entry is written
+// with ifGenerationMatch set to the generation it was read at, and fn is
+// applied again to the fresh entry when another instance wrote first.
+type gcsLedger struct {
+	baseURL string
+	bucket  string
+	prefix  string
+	client  *http.Client
+}
+
+func newGCSLedgerFromEnv() (*gcsLedger, error) {
+	bucket := strings.TrimSpace(os.Getenv("LEDGER_BUCKET"))
+	if bucket == "" {
+		return nil, errors.New("LEDGER_BUCKET is not set")
+	}
+	baseURL := strings.TrimRight(strings.TrimSpace(os.Getenv("LEDGER_GCS_BASE_URL")), "/")
+	if baseURL == "" {
+		baseURL = "https://storage.googleapis.com"
+	}
+	prefix := strings.Trim(strings.TrimSpace(os.Getenv("LEDGER_PREFIX")), "/")
+	if prefix == "" {
+		prefix = "ledger"
+	}
+	return &gcsLedger{
+		baseURL: baseURL,
+		bucket:  bucket,
+		prefix:  prefix + "/",
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (l *gcsLedger) objectName(ticketID string) string {
+	return l.prefix + safeFileName(ticketID) + ".json"
+}
+
+func (l *gcsLedger) do(method, rawURL string, body []byte) ([]byte, http.Header, error) {
+	respBody, header, err := gcpRequest(l.client, "cloud storage", method, rawURL, body, true)
+	var status *gcpStatusError
+	if errors.As(err, &status) {
+		switch status.StatusCode {
+		case http.StatusNotFound:
+			return nil, nil, errGCSObjectNotFound
+		case http.StatusPreconditionFailed:
+			return nil, nil, errGCSConflict
+		}
+	}
+	return respBody, header, err
+}
+
+// read returns the entry of an object and the generation it was read at; a
+// missing object has generation 0.
+func (l *gcsLedger) read(name string) (*ledgerEntry, int64, error) {
+	body, header, err := l.do("GET", fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", l.baseURL, url.PathEscape(l.bucket), url.PathEscape(name)), nil)
+	if errors.Is(err, errGCSObjectNotFound) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	generation, err := strconv.ParseInt(header.Get("X-Goog-Generation"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ledger object %s has no generation: %w", name, err)
+	}
+	var entry ledgerEntry
+	if err := json.Unmarshal(body, &entry); err != nil {
+		return nil, 0, fmt.Errorf("corrupt ledger object %s: %w", name, err)
+	}
+	return &entry, generation, nil
+}
+
+func (l *gcsLedger) Get(ticketID string) (*ledgerEntry, error) {
+	entry, _, err := l.read(l.objectName(ticketID))
+	return entry, err
+}
+
+func (l *gcsLedger) Update(ticketID string, fn func(entry *ledgerEntry) (*ledgerEntry, error)) (*ledgerEntry, error) {
+	name := l.objectName(ticketID)
+	for attempt := 0; attempt < gcsLedgerMaxConflicts; attempt++ {
+		current, generation, err := l.read(name)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := fn(current)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(updated)
+		if err != nil {
+			return nil, err
+		}
+		query := url.Values{
+			"uploadType":        {"media"},
+			"name":              {name},
+			"ifGenerationMatch": {strconv.FormatInt(generation, 10)},
+		}
+		_, _, err = l.do("POST", fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", l.baseURL, url.PathEscape(l.bucket), query.Encode()), data)
+		if errors.Is(err, errGCSConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return updated, nil
+	}
+	return nil, fmt.Errorf("updating ledger entry of ticket %s: %w", ticketID, errGCSConflict)
+}
+
+func (l *gcsLedger) List() ([]*ledgerEntry, error) {
+	var entries []*ledgerEntry
+	pageToken := ""
+	for {
+		query := url.Values{"prefix": {l.prefix}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		body, _, err := l.do("GET", fmt.Sprintf("%s/storage/v1/b/%s/o?%s", l.baseURL, url.PathEscape(l.bucket), query.Encode()), nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Items {
+			if !strings.HasSuffix(item.Name, ".json") {
+				continue
+			}
+			entry, _, err := l.read(item.Name)
+			if err != nil {
+				return nil, err
+			}
+			if entry != nil {
+				entries = append(entries, entry)
+			}
+		}
+
+		if page.NextPageToken == "" {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+	return entries, nil
+}
@@ -3,7 +3,9 @@ package tco_vo_agent
 import (
 	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 
@@ -22,6 +24,23 @@ func Ping(w http.ResponseWriter, r *http.Request) {
 }
 
 func init() {
+	// Refuse to serve a production deployment that would accept anyone's webhooks.
+	if err := validateAuthConfig(); err != nil {
+		log.Fatalf("Invalid authentication configuration: %v", err)
+	}
+	// Refuse to keep the processing ledger on storage of a single instance.
+	if err := validateLedgerConfig(); err != nil {
+		log.Fatalf("Invalid processing ledger configuration: %v", err)
+	}
+	// Refuse to keep accepted webhooks on storage of a single instance.
+	if err := validateJobQueueConfig(); err != nil {
+		log.Fatalf("Invalid job queue configuration: %v", err)
+	}
+	// Refuse to keep the audit trail on storage of a single instance.
+	if err := validateAuditConfig(); err != nil {
+		log.Fatalf("Invalid audit configuration: %v", err)
+	}
+
 	// Register the Cloud Function handler with the Functions Framework.
 	// The Cloud Functions runtime or any importing main package is
 	// responsible for starting the HTTP server.
@@ -33,7 +52,7 @@ func init() {
 func validateBearerToken(r *http.Request) error {
 	token := os.Getenv("BEARER_TOKEN")
 	if token == "" {
-		token = "oon4at1odepaiTahS4eng3biejah3aidaeng7yahse"
+		return errors.New("BEARER_TOKEN is not set")
 	}
 
 	expected := fmt.Sprintf("Bearer %s", token)
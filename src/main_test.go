@@ -0,0 +1,23 @@
+package tco_vo_agent
+
+import (
+	"log"
+	"os"
+	"testing"
+)
+
+// TestMain points all file backed state at a throwaway directory so test runs
+// never see jobs or records left behind by earlier runs.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "tco-vo-agent-test-*")
+	if err != nil {
+		log.Fatalf("failed to create state dir: %v", err)
+	}
+	os.Setenv("JOB_QUEUE_DIR", dir+"/jobs")
+	os.Setenv("LEDGER_DIR", dir+"/ledger")
+	os.Setenv("AUDIT_DIR", dir+"/audit")
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
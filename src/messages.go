@@ -43,34 +43,141 @@ const userBannedMessage = `Subject: TCO removal order – action completed (Ref:
 
 Hello %s,
 
-We executed the removal order under Article 3 of Regulation (EU) 2021/784. Access to the reported account/content (%s) has been disabled across our service as of %s UTC.
+We executed the removal order under Article 3 of Regulation (EU) 2021/784. Access to the reported account/content (%s) has been disabled across our service as of %s UTC%s.
 
-We have preserved the removed content and related data for six months in line with Article 6 and can extend retention on request for ongoing proceedings. If you need confirmation in the Annex II format, please let us know.
+%s %s
+
+Thank you.
+`
+
+const contentActionMessage = `Subject: TCO removal order – action completed (Ref: %s)
+
+Hello %s,
+
+We executed the removal order under Article 3 of Regulation (EU) 2021/784. %s (%s) as of %s UTC%s. The accounts concerned were not banned.
+
+%s %s
+
+Thank you.
+`
+
+const impossibleToComplyMessage = `Subject: TCO removal order – impossible to comply (Ref: %s)
+
+Hello %s,
+
+We cannot carry out your removal order under Regulation (EU) 2021/784 dated %s concerning %s. Reason: %s.
+
+In line with Article 3(7) and (8), please find attached the information on the impossibility to execute the order in the format of Annex III. We will carry out the order without undue delay once the reason has ceased to exist or we receive a corrected order.
+`
+
+const preservedMessage = `Subject: TCO removal order – content preserved (Ref: %s)
+
+Hello %s,
+
+Further to our completion notice, the content and related data removed under your order (%s) are now preserved in line with Article 6 of Regulation (EU) 2021/784 until %s UTC. We can extend retention on request for ongoing proceedings.
+
+Thank you.
+`
+
+const reinstatedMessage = `Subject: TCO removal order – content reinstated (Ref: %s)
+
+Hello %s,
+
+Following %s, we reinstated the account/content (%s) concerned by your removal order under Regulation (EU) 2021/784 as of %s UTC.
+
+The content and related data preserved under Article 6 remain available until the end of the preservation period.
 
 Thank you.
 `
 
 func buildMessage(template ReplyToTicketTemplate, data agentData) (string, error) {
+	return buildTicketMessage(template, []agentData{data})
+}
+
+// buildTicketMessage builds one reply covering every account of a ticket that
+// received the same decision. Order details are taken from the first entry.
+func buildTicketMessage(template ReplyToTicketTemplate, group []agentData) (string, error) {
+	if len(group) == 0 {
+		return "", errors.New("no decisions to reply to")
+	}
+	data := group[0]
 	agency := fallbackValue(data.Data.AgencyName, "competent authority")
 	reference := fallbackValue(data.Data.ReferenceNumber, "N/A")
 
 	switch template {
 	case ReplyToTicketTemplateMoreInfoRequired:
 		orderDate := fallbackValue(data.Data.Date, "not provided")
-		missing := fallbackValue(strings.TrimSpace(data.Reason), "Additional identifiers required under Article 3(4) to locate the content.")
+		missing := fallbackValue(formatReasons(group), "Additional identifiers required under Article 3(4) to locate the content.")
 		return fmt.Sprintf(moreInfoRequiredMessage, reference, agency, orderDate, missing), nil
 	case ReplyToTicketTemplateUserNotFound:
-		identifiers := formatIdentifiers(data.Data)
+		identifiers := formatGroupIdentifiers(group)
 		return fmt.Sprintf(userNotFoundMessage, reference, agency, identifiers), nil
 	case ReplyToTicketTemplateUserBanned:
-		identifiers := formatIdentifiers(data.Data)
+		identifiers := formatGroupIdentifiers(group)
+		actionTime := nowFn().UTC().Format(time.RFC3339)
+		elapsed := ""
+		if d, ok := orderElapsed(data.Data.TicketID); ok {
+			elapsed = fmt.Sprintf(", %s after we received the order", formatMinutes(d))
+		}
+		return fmt.Sprintf(userBannedMessage, reference, agency, identifiers, actionTime, elapsed, preservationStatement(data.Data.TicketID), annexIINote()), nil
+	case ReplyToTicketTemplateContentRemoved, ReplyToTicketTemplateContentDisabled:
+		action := "The reported content has been removed from our service"
+		if template == ReplyToTicketTemplateContentDisabled {
+			action = "Access to the reported content has been disabled in all Member States"
+		}
+		identifiers := formatGroupIdentifiers(group)
 		actionTime := nowFn().UTC().Format(time.RFC3339)
-		return fmt.Sprintf(userBannedMessage, reference, agency, identifiers, actionTime), nil
+		elapsed := ""
+		if d, ok := orderElapsed(data.Data.TicketID); ok {
+			elapsed = fmt.Sprintf(", %s after we received the order", formatMinutes(d))
+		}
+		return fmt.Sprintf(contentActionMessage, reference, agency, action, identifiers, actionTime, elapsed, preservationStatement(data.Data.TicketID), annexIINote()), nil
+	case ReplyToTicketTemplatePreserved:
+		identifiers := formatGroupIdentifiers(group)
+		return fmt.Sprintf(preservedMessage, reference, agency, identifiers, preservedUntil(data.Data.TicketID)), nil
+	case ReplyToTicketTemplateReinstated:
+		identifiers := formatGroupIdentifiers(group)
+		actionTime := nowFn().UTC().Format(time.RFC3339)
+		reason := fallbackValue(strings.TrimSpace(data.Reason), "a review of the removal order")
+		return fmt.Sprintf(reinstatedMessage, reference, agency, reason, identifiers, actionTime), nil
+	case ReplyToTicketTemplateImpossible:
+		orderDate := fallbackValue(data.Data.Date, "not provided")
+		identifiers := formatGroupIdentifiers(group)
+		return fmt.Sprintf(impossibleToComplyMessage, reference, agency, orderDate, identifiers, formatImpossibility(group)), nil
 	default:
 		return "", errors.New("invalid message template")
 	}
 }
 
+// formatReasons lists the distinct reasons of a group, naming the account when
+// the group covers more than one.
+func formatReasons(group []agentData) string {
+	var reasons []string
+	seen := map[string]bool{}
+	for _, data := range group {
+		reason := strings.TrimSpace(data.Reason)
+		if reason == "" {
+			continue
+		}
+		if len(group) > 1 {
+			reason = fmt.Sprintf("%s (%s)", reason, formatIdentifiers(data.Data))
+		}
+		if !seen[reason] {
+			seen[reason] = true
+			reasons = append(reasons, reason)
+		}
+	}
+	return strings.Join(reasons, "; ")
+}
+
+func formatGroupIdentifiers(group []agentData) string {
+	var parts []string
+	for _, data := range group {
+		parts = append(parts, formatIdentifiers(data.Data))
+	}
+	return strings.Join(parts, "; ")
+}
+
 func fallbackValue(value, fallback string) string {
 	if strings.TrimSpace(value) == "" {
 		return fallback
@@ -86,8 +193,34 @@ func formatIdentifiers(decision FraudDecision) string {
 	if decision.Email != "" {
 		parts = append(parts, fmt.Sprintf("email: %s", decision.Email))
 	}
+	if decision.UserID != "" {
+		parts = append(parts, fmt.Sprintf("user ID: %s", decision.UserID))
+	}
+	identifiers := strings.Join(parts, " / ")
 	if len(parts) == 0 {
-		return "no user identifier provided"
+		identifiers = "no user identifier provided"
+	}
+	if content := formatContentItems(decision.ContentItems); content != "" {
+		identifiers = fmt.Sprintf("%s, content: %s", identifiers, content)
+	}
+	return identifiers
+}
+
+func formatContentItems(items []ContentItem) string {
+	var parts []string
+	for _, item := range items {
+		switch {
+		case item.URL != "":
+			parts = append(parts, item.URL)
+		case item.MessageID != "":
+			parts = append(parts, fmt.Sprintf("message ID %s", item.MessageID))
+		case item.PictureID != "":
+			parts = append(parts, fmt.Sprintf("picture ID %s", item.PictureID))
+		case item.Kind == contentKindProfileText:
+			parts = append(parts, fallbackValue(item.Description, "profile text"))
+		case item.Description != "":
+			parts = append(parts, item.Description)
+		}
 	}
-	return strings.Join(parts, " / ")
+	return strings.Join(parts, ", ")
 }
@@ -0,0 +1,232 @@
+package tco_vo_agent
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/hex"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const pdftotextTimeout = 30 * time.Second
+
+var (
+	streamPattern    = regexp.MustCompile(`stream\r?\n`)
+	pdfTextOperators = map[string]bool{"Tj": true, "TJ": true, "'": true, "\"": true}
+)
+
+// extractPDFText returns the text of a PDF without sending it anywhere. It
+// prefers pdftotext (poppler) when installed and falls back to a built-in
+// extractor that handles uncompressed and Flate encoded content streams.
+func extractPDFText(data []byte) string {
+	if path, err := exec.LookPath("pdftotext"); err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), pdftotextTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, path, "-layout", "-", "-")
+		cmd.Stdin = bytes.NewReader(data)
+		if out, err := cmd.Output(); err == nil && strings.TrimSpace(string(out)) != "" {
+			return string(out)
+		}
+	}
+	return builtinPDFText(data)
+}
+
+// builtinPDFText decodes the content streams of a PDF and collects the strings
+// shown by the text operators. Strings are read as one byte per glyph, which
+// covers the standard fonts; CID fonts need pdftotext.
+func builtinPDFText(data []byte) string {
+	var text strings.Builder
+	offset := 0
+	for {
+		loc := streamPattern.FindIndex(data[offset:])
+		if loc == nil {
+			break
+		}
+		start := offset + loc[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		dict := data[offset : offset+loc[0]]
+		if i := bytes.LastIndex(dict, []byte("<<")); i >= 0 {
+			dict = dict[i:]
+		}
+		content := data[start : start+end]
+		offset = start + end + len("endstream")
+
+		if bytes.Contains(dict, []byte("/Image")) || bytes.Contains(dict, []byte("/DCTDecode")) {
+			continue
+		}
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			reader, err := zlib.NewReader(bytes.NewReader(content))
+			if err != nil {
+				continue
+			}
+			// truncated streams still yield their readable prefix
+			content, _ = io.ReadAll(reader)
+			reader.Close()
+		}
+		text.WriteString(contentStreamText(content))
+	}
+	return text.String()
+}
+
+// contentStreamText interprets the text showing operators of a content stream.
+func contentStreamText(content []byte) string {
+	var out strings.Builder
+	var operands []string
+	inText := false
+
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, next := readLiteralString(content, i)
+			operands = append(operands, s)
+			i = next
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return out.String()
+			}
+			operands = append(operands, decodeHexString(content[i+1:i+end]))
+			i += end + 1
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '[' || c == ']':
+			i++
+		case isPDFWhitespace(c):
+			i++
+		case c == '/':
+			// names are operands of operators we do not interpret
+			i = skipRegular(content, i+1)
+		default:
+			start := i
+			i = skipRegular(content, i)
+			if start == i {
+				// stray delimiter
+				i++
+				continue
+			}
+			token := string(content[start:i])
+			if f, err := strconv.ParseFloat(token, 64); err == nil {
+				// a large negative kerning in TJ arrays usually separates words
+				if f < -200 && len(operands) > 0 {
+					operands[len(operands)-1] += " "
+				}
+				continue
+			}
+
+			switch {
+			case token == "BT":
+				inText = true
+			case token == "ET":
+				inText = false
+				out.WriteString("\n")
+			case inText && pdfTextOperators[token]:
+				if token == "'" || token == "\"" {
+					out.WriteString("\n")
+				}
+				out.WriteString(strings.Join(operands, ""))
+			case inText && (token == "T*" || token == "Td" || token == "TD"):
+				out.WriteString("\n")
+			}
+			operands = operands[:0]
+		}
+	}
+	return out.String()
+}
+
+func readLiteralString(content []byte, i int) (string, int) {
+	var s strings.Builder
+	depth := 0
+	for i < len(content) {
+		c := content[i]
+		switch {
+		case c == '\\' && i+1 < len(content):
+			i++
+			switch e := content[i]; e {
+			case 'n':
+				s.WriteByte('\n')
+			case 'r':
+				s.WriteByte('\r')
+			case 't':
+				s.WriteByte('\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					j := i
+					for j < len(content) && j < i+3 && content[j] >= '0' && content[j] <= '7' {
+						j++
+					}
+					n, _ := strconv.ParseUint(string(content[i:j]), 8, 8)
+					s.WriteByte(byte(n))
+					i = j - 1
+				} else {
+					s.WriteByte(e)
+				}
+			}
+		case c == '(':
+			if depth > 0 {
+				s.WriteByte(c)
+			}
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return latin1ToUTF8(s.String()), i + 1
+			}
+			s.WriteByte(c)
+		default:
+			s.WriteByte(c)
+		}
+		i++
+	}
+	return latin1ToUTF8(s.String()), i
+}
+
+func decodeHexString(raw []byte) string {
+	clean := strings.Map(func(r rune) rune {
+		if isPDFWhitespace(byte(r)) {
+			return -1
+		}
+		return r
+	}, string(raw))
+	if len(clean)%2 == 1 {
+		clean += "0"
+	}
+	decoded, err := hex.DecodeString(clean)
+	if err != nil {
+		return ""
+	}
+	return latin1ToUTF8(string(decoded))
+}
+
+// latin1ToUTF8 maps the single byte encodings used by standard fonts to UTF-8.
+func latin1ToUTF8(s string) string {
+	runes := make([]rune, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		runes = append(runes, rune(s[i]))
+	}
+	return string(runes)
+}
+
+func skipRegular(content []byte, i int) int {
+	for i < len(content) && !isPDFWhitespace(content[i]) && !strings.ContainsRune("()<>[]/%", rune(content[i])) {
+		i++
+	}
+	return i
+}
+
+func isPDFWhitespace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
@@ -0,0 +1,54 @@
+package tco_vo_agent
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// buildTestPDF writes a one page PDF showing the given content stream.
+func buildTestPDF(t *testing.T, stream string, compress bool) []byte {
+	t.Helper()
+
+	data := []byte(stream)
+	filter := ""
+	if compress {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+		data = buf.Bytes()
+		filter = " /Filter /FlateDecode"
+	}
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	pdf.WriteString("1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj\n")
+	pdf.WriteString("2 0 obj << /Type /Pages /Kids [3 0 R] /Count 1 >> endobj\n")
+	pdf.WriteString("3 0 obj << /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >> endobj\n")
+	fmt.Fprintf(&pdf, "4 0 obj << /Length %d%s >>\nstream\n", len(data), filter)
+	pdf.Write(data)
+	pdf.WriteString("\nendstream\nendobj\n")
+	pdf.WriteString("5 0 obj << /Type /Font /Subtype /Type1 /BaseFont /Helvetica >> endobj\n")
+	pdf.WriteString("trailer << /Root 1 0 R >>\n%%EOF\n")
+	return pdf.Bytes()
+}
+
+func TestBuiltinPDFText(t *testing.T) {
+	stream := "BT /F1 12 Tf 72 720 Td (Removal order \\(Annex I\\)) Tj 0 -14 Td [(Reference:) -250 (REF-1)] TJ T* <557365726E616D653A206A616E65> Tj ET"
+
+	for _, compress := range []bool{false, true} {
+		text := builtinPDFText(buildTestPDF(t, stream, compress))
+		for _, expected := range []string{"Removal order (Annex I)", "Reference: REF-1", "Username: jane"} {
+			if !strings.Contains(text, expected) {
+				t.Fatalf("compress=%v: text %q missing %q", compress, text, expected)
+			}
+		}
+	}
+
+	if text := builtinPDFText([]byte("not a pdf")); text != "" {
+		t.Fatalf("expected no text for garbage input, got %q", text)
+	}
+}
@@ -0,0 +1,166 @@
+package tco_vo_agent
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A4 portrait in points, with the layout of the generated forms.
+const (
+	pdfPageWidth  = 595
+	pdfPageHeight = 842
+	pdfMargin     = 56
+	pdfFontSize   = 10
+	pdfLeading    = 14
+	// pdfLineChars is how many Helvetica characters of pdfFontSize fit on a line.
+	pdfLineChars = 95
+)
+
+// pdfLine is one paragraph of a generated document; long text is wrapped.
+type pdfLine struct {
+	Text string
+	Bold bool
+}
+
+// pdfCheckbox is a form line with a ticked or empty checkbox in front.
+func pdfCheckbox(checked bool, text string) pdfLine {
+	if checked {
+		return pdfLine{Text: "[X] " + text}
+	}
+	return pdfLine{Text: "[ ] " + text}
+}
+
+// renderTextPDF lays out the lines on as many A4 pages as needed, using the
+// standard Helvetica fonts so no font has to be embedded.
+func renderTextPDF(lines []pdfLine) []byte {
+	perPage := (pdfPageHeight - 2*pdfMargin) / pdfLeading
+	var pages [][]pdfLine
+	var page []pdfLine
+	for _, line := range lines {
+		for _, wrapped := range wrapPDFText(line.Text, pdfLineChars) {
+			if len(page) == perPage {
+				pages = append(pages, page)
+				page = nil
+			}
+			page = append(page, pdfLine{Text: wrapped, Bold: line.Bold})
+		}
+	}
+	if len(page) > 0 || len(pages) == 0 {
+		pages = append(pages, page)
+	}
+
+	// objects 1-4 are the catalog, the page tree and the fonts; each page adds
+	// a page object and its content stream
+	var objects []string
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+	)
+	for i, lines := range pages {
+		var content bytes.Buffer
+		y := pdfPageHeight - pdfMargin - pdfFontSize
+		for _, line := range lines {
+			font := "F1"
+			if line.Bold {
+				font = "F2"
+			}
+			if line.Text != "" {
+				fmt.Fprintf(&content, "BT /%s %d Tf %d %d Td (%s) Tj ET\n", font, pdfFontSize, pdfMargin, y, pdfString(line.Text))
+			}
+			y -= pdfLeading
+		}
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+				pdfPageWidth, pdfPageHeight, 6+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return out.Bytes()
+}
+
+// wrapPDFText breaks text at spaces so no line exceeds width characters.
+func wrapPDFText(text string, width int) []string {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return []string{""}
+	}
+	var lines []string
+	current := ""
+	for _, word := range words {
+		for len([]rune(word)) > width {
+			if current != "" {
+				lines = append(lines, current)
+				current = ""
+			}
+			runes := []rune(word)
+			lines = append(lines, string(runes[:width]))
+			word = string(runes[width:])
+		}
+		switch {
+		case current == "":
+			current = word
+		case len([]rune(current))+1+len([]rune(word)) <= width:
+			current += " " + word
+		default:
+			lines = append(lines, current)
+			current = word
+		}
+	}
+	return append(lines, current)
+}
+
+// winAnsiSpecials maps the characters outside Latin-1 that WinAnsiEncoding covers.
+var winAnsiSpecials = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '‘': 0x91, '’': 0x92,
+	'“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+}
+
+// pdfString encodes text as the body of a PDF literal string in WinAnsiEncoding.
+// Characters the encoding lacks are replaced by a question mark.
+func pdfString(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		var c byte
+		switch special, ok := winAnsiSpecials[r]; {
+		case ok:
+			c = special
+		case r < 0x20:
+			c = ' '
+		case r < 0x7f || (r >= 0xa0 && r <= 0xff):
+			c = byte(r)
+		default:
+			c = '?'
+		}
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c >= 0x80:
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
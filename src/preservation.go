@@ -0,0 +1,430 @@
+package tco_vo_agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// legalHoldTag asks to keep the preserved content of an order longer, when
+	// the authority or a court needs it for a review or complaint (Article 6(2)).
+	legalHoldTag = "tco-vo-legal-hold"
+
+	// preservationMonths is the retention period of Article 6(2).
+	preservationMonths = 6
+
+	defaultLegalHoldMonths         = 6
+	defaultPreservationWarningDays = 14
+
+	// preservationReportClaim is how long a check may take to send a report
+	// before another check sends it instead.
+	preservationReportClaim = 10 * time.Minute
+)
+
+var (
+	preserveContentFn          = PreserveContent
+	extendPreservationFn       = ExtendPreservation
+	notifyPreservationExpiryFn = sendSlackPreservationExpiry
+)
+
+var errNoPreservation = errors.New("no preservation was recorded for the ticket")
+
+// preservationRecord is the Finya preservation of the content removed for an
+// order. It lives in the processing ledger, so the expiry check of any instance
+// finds it only when the ledger is shared (LEDGER_BACKEND=gcs, or a LEDGER_DIR
+// on shared storage); production refuses to start otherwise.
+type preservationRecord struct {
+	ID          string      `json:"id"`
+	PreservedAt time.Time   `json:"preservedAt"`
+	RetainUntil time.Time   `json:"retainUntil"`
+	LegalHolds  []legalHold `json:"legalHolds,omitempty"`
+	// ExpiryReportedAt is set once the coming expiry was reported; an extension clears it.
+	ExpiryReportedAt time.Time `json:"expiryReportedAt,omitempty"`
+	// ExpiryReportClaimedAt is set while a check is sending the report.
+	ExpiryReportClaimedAt time.Time `json:"expiryReportClaimedAt,omitempty"`
+}
+
+// pendingPreservation keeps what still has to be preserved after the
+// preservation of an order failed, so the preservation check can retry it.
+type pendingPreservation struct {
+	Accounts    []agentData `json:"accounts"`
+	RetainUntil time.Time   `json:"retainUntil"`
+	FailedAt    time.Time   `json:"failedAt"`
+	LastError   string      `json:"lastError,omitempty"`
+	// ClaimedAt is set while a check is retrying the preservation.
+	ClaimedAt time.Time `json:"claimedAt,omitempty"`
+}
+
+const (
+	preservedStatement           = "We have preserved the removed content and related data for six months in line with Article 6 and can extend retention on request for ongoing proceedings."
+	preservationPendingStatement = "We are preserving the removed content and related data in line with Article 6 and will confirm separately once this is completed."
+)
+
+// preservationStatement is the Article 6 sentence of a completion reply. It
+// only claims a preservation the ledger has recorded.
+func preservationStatement(ticketID string) string {
+	ledger, err := openLedgerFn()
+	if err != nil {
+		return preservationPendingStatement
+	}
+	entry, err := ledger.Get(ticketID)
+	if err != nil || entry == nil || entry.Preservation == nil || entry.PendingPreservation != nil {
+		return preservationPendingStatement
+	}
+	return preservedStatement
+}
+
+// preservedUntil formats the recorded end of the preservation of a ticket.
+func preservedUntil(ticketID string) string {
+	ledger, err := openLedgerFn()
+	if err != nil {
+		return "the end of the preservation period"
+	}
+	entry, err := ledger.Get(ticketID)
+	if err != nil || entry == nil || entry.Preservation == nil {
+		return "the end of the preservation period"
+	}
+	return entry.Preservation.RetainUntil.UTC().Format(time.RFC3339)
+}
+
+// legalHold is one extension of a preservation beyond the retention period.
+type legalHold struct {
+	RequestedAt time.Time `json:"requestedAt"`
+	RetainUntil time.Time `json:"retainUntil"`
+}
+
+// preservationReceipt is what the Finya API answers for a preservation or extension.
+type preservationReceipt struct {
+	PreservationID string    `json:"preservationId"`
+	RetainUntil    time.Time `json:"retainUntil"`
+}
+
+// positiveIntSetting reads a positive number from the environment.
+func positiveIntSetting(envKey string, fallback int) int {
+	raw := strings.TrimSpace(os.Getenv(envKey))
+	if raw == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 {
+		log.Printf("Invalid %s %q, using %d", envKey, raw, fallback)
+		return fallback
+	}
+	return n
+}
+
+// preserveRemovals asks Finya to preserve what was carried out for every
+// ticket, and writes the preservation ID to the ledger, the audit trail and
+// the ticket. A failed preservation fails the run so moderators see it, and is
+// kept in the ledger for retryPendingPreservations; the measures themselves
+// already happened, so the run is not retried.
+func preserveRemovals(result *processResult, actioned map[contentMeasure][]agentData) {
+	var all []agentData
+	for _, measure := range contentMeasures {
+		all = append(all, actioned[measure]...)
+	}
+	if len(all) == 0 {
+		return
+	}
+	ledger, err := openLedgerFn()
+	if err != nil {
+		log.Printf("Error opening processing ledger: %v", err)
+		result.recordError(err, "preserving content")
+		return
+	}
+	for _, group := range groupByTicket(all) {
+		ticketID := group[0].Data.TicketID
+		now := nowFn().UTC()
+		retainUntil := now.AddDate(0, preservationMonths, 0)
+		receipt, err := preserveContentFn(group, retainUntil)
+		if err != nil {
+			log.Printf("Error preserving content of ticket %s: %v", ticketID, err)
+			result.recordError(err, "preserving content")
+			_, err = ledger.Update(ticketID, func(entry *ledgerEntry) (*ledgerEntry, error) {
+				if entry == nil {
+					entry = &ledgerEntry{TicketID: ticketID, CreatedAt: now, UpdatedAt: now}
+				}
+				entry.PendingPreservation = &pendingPreservation{Accounts: group, RetainUntil: retainUntil, FailedAt: now, LastError: err.Error()}
+				return entry, nil
+			})
+			if err != nil {
+				log.Printf("Error recording pending preservation of ticket %s: %v", ticketID, err)
+			}
+			continue
+		}
+		recordPreservation(ledger, ticketID, now, receipt)
+	}
+}
+
+// recordPreservation writes a successful preservation to the ledger, the audit
+// trail and the ticket, and clears a pending retry.
+func recordPreservation(ledger ticketLedger, ticketID string, now time.Time, receipt preservationReceipt) {
+	_, err := ledger.Update(ticketID, func(entry *ledgerEntry) (*ledgerEntry, error) {
+		if entry == nil {
+			entry = &ledgerEntry{TicketID: ticketID, CreatedAt: now, UpdatedAt: now}
+		}
+		entry.Preservation = &preservationRecord{ID: receipt.PreservationID, PreservedAt: now, RetainUntil: receipt.RetainUntil}
+		entry.PendingPreservation = nil
+		return entry, nil
+	})
+	if err != nil {
+		log.Printf("Error recording preservation of ticket %s: %v", ticketID, err)
+	}
+	recordAudit(ticketID, auditPreserved, map[string]interface{}{
+		"preservationId": receipt.PreservationID,
+		"retainUntil":    receipt.RetainUntil,
+	})
+	note := fmt.Sprintf("%s The removed content and related data are preserved under Article 6 with preservation ID %s until %s. Tag the ticket %s if the authority asks to keep them longer.",
+		agentNotePrefix, receipt.PreservationID, formatAnnexTime(receipt.RetainUntil), legalHoldTag)
+	if err := addInternalNoteFn(ticketID, note); err != nil {
+		log.Printf("Error adding internal note to ticket %s: %v", ticketID, err)
+	}
+}
+
+// retryPendingPreservations retries every preservation that failed during a
+// run. Once it succeeds the authority is told, since the completion reply
+// could not confirm it. It returns the number of preservations made.
+func retryPendingPreservations() (int, error) {
+	ledger, err := openLedgerFn()
+	if err != nil {
+		return 0, fmt.Errorf("opening processing ledger: %w", err)
+	}
+	entries, err := ledger.List()
+	if err != nil {
+		return 0, fmt.Errorf("listing processing ledger: %w", err)
+	}
+
+	preserved, failed := 0, 0
+	var lastErr error
+	for _, entry := range entries {
+		if entry.PendingPreservation == nil {
+			continue
+		}
+
+		// claim the retry first so concurrent checks do not preserve twice
+		now := nowFn().UTC()
+		var pending pendingPreservation
+		_, err := ledger.Update(entry.TicketID, func(current *ledgerEntry) (*ledgerEntry, error) {
+			if current == nil || current.PendingPreservation == nil ||
+				now.Sub(current.PendingPreservation.ClaimedAt) < preservationReportClaim {
+				return nil, errLedgerSkip
+			}
+			current.PendingPreservation.ClaimedAt = now
+			pending = *current.PendingPreservation
+			return current, nil
+		})
+		if err != nil {
+			if !errors.Is(err, errLedgerSkip) {
+				log.Printf("Error checking pending preservation of ticket %s: %v", entry.TicketID, err)
+			}
+			continue
+		}
+
+		receipt, preserveErr := preserveContentFn(pending.Accounts, pending.RetainUntil)
+		if preserveErr != nil {
+			log.Printf("Error preserving content of ticket %s: %v", entry.TicketID, preserveErr)
+			failed++
+			lastErr = preserveErr
+			_, err = ledger.Update(entry.TicketID, func(current *ledgerEntry) (*ledgerEntry, error) {
+				if current == nil || current.PendingPreservation == nil {
+					return nil, errLedgerSkip
+				}
+				current.PendingPreservation.ClaimedAt = time.Time{}
+				current.PendingPreservation.LastError = preserveErr.Error()
+				return current, nil
+			})
+			if err != nil && !errors.Is(err, errLedgerSkip) {
+				log.Printf("Error recording pending preservation of ticket %s: %v", entry.TicketID, err)
+			}
+			continue
+		}
+
+		recordPreservation(ledger, entry.TicketID, now, receipt)
+		preserved++
+		if err := replyToTicketsFn(pending.Accounts, ReplyToTicketTemplatePreserved); err != nil {
+			log.Printf("Error confirming preservation of ticket %s: %v", entry.TicketID, err)
+		}
+	}
+	if failed > 0 {
+		return preserved, fmt.Errorf("%d preservations could not be made: %w", failed, lastErr)
+	}
+	return preserved, nil
+}
+
+// handleLegalHoldRequest extends the preservation of a ticket tagged
+// tco-vo-legal-hold by LEGAL_HOLD_MONTHS, then removes the tag.
+func handleLegalHoldRequest(ticket ZendeskTicket) error {
+	ledger, err := openLedgerFn()
+	if err != nil {
+		return fmt.Errorf("opening processing ledger: %w", err)
+	}
+	entry, err := ledger.Get(ticket.ID)
+	if err != nil {
+		return fmt.Errorf("reading processing ledger: %w", err)
+	}
+	if entry == nil || entry.Preservation == nil {
+		log.Printf("Ignoring %s tag on ticket %s: %v", legalHoldTag, ticket.ID, errNoPreservation)
+		if err := addInternalNoteFn(ticket.ID, fmt.Sprintf("%s No legal hold was applied: %v.", agentNotePrefix, errNoPreservation)); err != nil {
+			log.Printf("Error adding internal note to ticket %s: %v", ticket.ID, err)
+		}
+	} else {
+		// extend from the current expiry, or from now if it already passed
+		now := nowFn().UTC()
+		from := entry.Preservation.RetainUntil
+		if from.Before(now) {
+			from = now
+		}
+		receipt, err := extendPreservationFn(ticket.ID, entry.Preservation.ID, from.AddDate(0, positiveIntSetting("LEGAL_HOLD_MONTHS", defaultLegalHoldMonths), 0))
+		if err != nil {
+			return fmt.Errorf("extending preservation: %w", err)
+		}
+		_, err = ledger.Update(ticket.ID, func(current *ledgerEntry) (*ledgerEntry, error) {
+			if current == nil || current.Preservation == nil {
+				return nil, errLedgerSkip
+			}
+			current.Preservation.RetainUntil = receipt.RetainUntil
+			current.Preservation.ExpiryReportedAt = time.Time{}
+			current.Preservation.LegalHolds = append(current.Preservation.LegalHolds, legalHold{RequestedAt: now, RetainUntil: receipt.RetainUntil})
+			return current, nil
+		})
+		if err != nil && !errors.Is(err, errLedgerSkip) {
+			log.Printf("Error recording legal hold of ticket %s: %v", ticket.ID, err)
+		}
+		recordAudit(ticket.ID, auditPreserved, map[string]interface{}{
+			"preservationId": entry.Preservation.ID,
+			"retainUntil":    receipt.RetainUntil,
+			"legalHold":      true,
+		})
+		note := fmt.Sprintf("%s Legal hold applied: preservation %s is now kept until %s.", agentNotePrefix, entry.Preservation.ID, formatAnnexTime(receipt.RetainUntil))
+		if err := addInternalNoteFn(ticket.ID, note); err != nil {
+			log.Printf("Error adding internal note to ticket %s: %v", ticket.ID, err)
+		}
+	}
+
+	// one-shot request, like the Annex II tag
+	if err := untagTicketFn(ticket.ID, []string{legalHoldTag}); err != nil {
+		log.Printf("Error removing %s tag from ticket %s: %v", legalHoldTag, ticket.ID, err)
+	}
+	return nil
+}
+
+// checkPreservations reports every preservation that expires within
+// PRESERVATION_WARNING_DAYS, once per retention period. A preservation counts
+// as reported only once the report was delivered; failed reports are retried by
+// the next check. It returns the number of preservations reported.
+func checkPreservations() (int, error) {
+	ledger, err := openLedgerFn()
+	if err != nil {
+		return 0, fmt.Errorf("opening processing ledger: %w", err)
+	}
+	entries, err := ledger.List()
+	if err != nil {
+		return 0, fmt.Errorf("listing processing ledger: %w", err)
+	}
+
+	warning := time.Duration(positiveIntSetting("PRESERVATION_WARNING_DAYS", defaultPreservationWarningDays)) * 24 * time.Hour
+	reported, failed := 0, 0
+	var lastErr error
+	for _, entry := range entries {
+		if entry.Preservation == nil || !entry.Preservation.ExpiryReportedAt.IsZero() {
+			continue
+		}
+
+		// claim the report first so concurrent checks do not report twice
+		now := nowFn().UTC()
+		_, err := ledger.Update(entry.TicketID, func(current *ledgerEntry) (*ledgerEntry, error) {
+			if current == nil || current.Preservation == nil || !current.Preservation.ExpiryReportedAt.IsZero() ||
+				current.Preservation.RetainUntil.Sub(now) > warning ||
+				now.Sub(current.Preservation.ExpiryReportClaimedAt) < preservationReportClaim {
+				return nil, errLedgerSkip
+			}
+			current.Preservation.ExpiryReportClaimedAt = now
+			entry = current
+			return current, nil
+		})
+		if err != nil {
+			if !errors.Is(err, errLedgerSkip) {
+				log.Printf("Error checking preservation of ticket %s: %v", entry.TicketID, err)
+			}
+			continue
+		}
+
+		notifyErr := notifyPreservationExpiryFn(entry.TicketID, *entry.Preservation)
+		if notifyErr != nil {
+			log.Printf("Error reporting preservation expiry for ticket %s: %v", entry.TicketID, notifyErr)
+			failed++
+			lastErr = notifyErr
+		}
+		_, err = ledger.Update(entry.TicketID, func(current *ledgerEntry) (*ledgerEntry, error) {
+			if current == nil || current.Preservation == nil {
+				return nil, errLedgerSkip
+			}
+			current.Preservation.ExpiryReportClaimedAt = time.Time{}
+			if notifyErr == nil {
+				current.Preservation.ExpiryReportedAt = now
+			}
+			return current, nil
+		})
+		if err != nil && !errors.Is(err, errLedgerSkip) {
+			log.Printf("Error recording preservation report of ticket %s: %v", entry.TicketID, err)
+		}
+		if notifyErr == nil {
+			reported++
+		}
+	}
+	if failed > 0 {
+		return reported, fmt.Errorf("%d preservation reports could not be sent: %w", failed, lastErr)
+	}
+	return reported, nil
+}
+
+// CheckPreservations is the scheduler endpoint that retries failed
+// preservations and reports preservations about to expire.
+func CheckPreservations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := validateBearerToken(r); err != nil {
+		log.Printf("Error validating bearer token: %v", err)
+		http.Error(w, "Invalid bearer token", http.StatusUnauthorized)
+		return
+	}
+
+	preserved, retryErr := retryPendingPreservations()
+	if retryErr != nil {
+		log.Printf("Error retrying preservations: %v", retryErr)
+	}
+	reported, err := checkPreservations()
+	if err != nil {
+		log.Printf("Error checking preservations: %v", err)
+	}
+	if retryErr != nil || err != nil {
+		http.Error(w, "Error checking preservations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]int{"preserved": preserved, "reported": reported})
+}
+
+// sendSlackPreservationExpiry posts the coming expiry to SLACK_WEBHOOK_URL. An
+// expiry must not go unnoticed, so a missing webhook is an error.
+func sendSlackPreservationExpiry(ticketID string, preservation preservationRecord) error {
+	webhookURL := strings.TrimSpace(os.Getenv("SLACK_WEBHOOK_URL"))
+	if webhookURL == "" {
+		return errors.New("SLACK_WEBHOOK_URL is not set")
+	}
+	return postSlackMessage(webhookURL, map[string]interface{}{
+		"text": fmt.Sprintf(":file_cabinet: Preservation %s of the TCO order in ticket %s expires %s. Tag the ticket %s if the authority asked to keep the content longer.",
+			preservation.ID, ticketID, formatAnnexTime(preservation.RetainUntil), legalHoldTag),
+	})
+}
@@ -0,0 +1,239 @@
+package tco_vo_agent
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubPreservation answers every preservation with a fixed ID, so pipeline
+// tests do not need a Finya API.
+func stubPreservation(t *testing.T) {
+	t.Helper()
+	origPreserve := preserveContentFn
+	t.Cleanup(func() { preserveContentFn = origPreserve })
+	preserveContentFn = func(data []agentData, retainUntil time.Time) (preservationReceipt, error) {
+		return preservationReceipt{PreservationID: "pres-" + data[0].Data.TicketID, RetainUntil: retainUntil}, nil
+	}
+}
+
+func TestPreserveContentAndExtend(t *testing.T) {
+	t.Setenv("FINYA_API_KEY", "finya-key")
+	var paths []string
+	var bodies []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		paths = append(paths, r.URL.Path)
+		bodies = append(bodies, body)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "data": map[string]interface{}{"preservationId": "P-1", "retainUntil": body["retainUntil"]}})
+	}))
+	defer server.Close()
+	t.Setenv("FINYA_API_URL", server.URL)
+
+	retainUntil := time.Date(2027, 4, 17, 10, 0, 0, 0, time.UTC)
+	decision := FraudDecision{TicketID: "1", Username: "jane", FinyaUserID: "501", ReferenceNumber: "REF-1", ContentItems: []ContentItem{{MessageID: "m-1"}, {URL: "https://finya.de/p/1"}}}
+	receipt, err := PreserveContent([]agentData{{Data: decision}}, retainUntil)
+	if err != nil {
+		t.Fatalf("PreserveContent returned error: %v", err)
+	}
+	if receipt.PreservationID != "P-1" || !receipt.RetainUntil.Equal(retainUntil) {
+		t.Fatalf("unexpected receipt: %+v", receipt)
+	}
+	items := bodies[0]["items"].([]interface{})
+	item := items[0].(map[string]interface{})
+	if paths[0] != "/api/tco/preservation" || bodies[0]["referenceNumber"] != "REF-1" || item["finyaUserId"] != "501" || len(item["contentIds"].([]interface{})) != 1 {
+		t.Fatalf("unexpected preservation request %s: %+v", paths[0], bodies[0])
+	}
+
+	if _, err := ExtendPreservation("1", "P-1", retainUntil.AddDate(0, 6, 0)); err != nil {
+		t.Fatalf("ExtendPreservation returned error: %v", err)
+	}
+	if paths[1] != "/api/tco/preservation/P-1/extend" || bodies[1]["retainUntil"] != "2027-10-17T10:00:00Z" {
+		t.Fatalf("unexpected extension request %s: %+v", paths[1], bodies[1])
+	}
+}
+
+func TestProcessTicketsAsyncPreservesContent(t *testing.T) {
+	stubs := stubApprovalPipeline(t)
+	t.Setenv("APPROVAL_MODE", "false")
+	t.Setenv("AUDIT_DIR", t.TempDir())
+	now := time.Date(2026, 10, 17, 9, 0, 0, 0, time.UTC)
+	origNow := nowFn
+	t.Cleanup(func() { nowFn = origNow })
+	nowFn = func() time.Time { return now }
+
+	if err := processTicketsAsync(ZendeskTicket{ID: "7"}); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	entry, err := (&fileLedger{dir: stateDir("LEDGER_DIR", "ledger")}).Get("7")
+	if err != nil || entry == nil || entry.Preservation == nil {
+		t.Fatalf("expected the preservation in the ledger, got %+v (%v)", entry, err)
+	}
+	if entry.Preservation.ID != "pres-7" || !entry.Preservation.RetainUntil.Equal(now.AddDate(0, 6, 0)) {
+		t.Fatalf("expected six months of retention, got %+v", entry.Preservation)
+	}
+	if len(stubs.notes) != 1 || !strings.Contains(stubs.notes[0], "preservation ID pres-7 until 2027-04-17") {
+		t.Fatalf("expected the preservation ID on the ticket, got %q", stubs.notes)
+	}
+
+	store, err := openAuditStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := store.List(auditFilter{TicketID: "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, event := range events {
+		found = found || (event.Type == auditPreserved && strings.Contains(string(event.Data), `"preservationId":"pres-7"`))
+	}
+	if !found {
+		t.Fatalf("expected the preservation ID in the audit trail, got %+v", events)
+	}
+
+	// a failed preservation is reported, the ban stands
+	preserveContentFn = func(data []agentData, retainUntil time.Time) (preservationReceipt, error) {
+		return preservationReceipt{}, errNoPreservation
+	}
+	if err := processTicketsAsync(ZendeskTicket{ID: "8"}); err == nil || !strings.Contains(err.Error(), "preserving content") {
+		t.Fatalf("expected the failed preservation to fail the run, got %v", err)
+	}
+	if len(stubs.banned) != 2 {
+		t.Fatalf("expected both accounts to be banned, got %+v", stubs.banned)
+	}
+	entry, err = (&fileLedger{dir: stateDir("LEDGER_DIR", "ledger")}).Get("8")
+	if err != nil || entry == nil || entry.PendingPreservation == nil || len(entry.PendingPreservation.Accounts) != 1 {
+		t.Fatalf("expected the failed preservation to be kept for a retry, got %+v (%v)", entry, err)
+	}
+	if got := preservationStatement("8"); got != preservationPendingStatement {
+		t.Fatalf("the completion reply must not claim a preservation that failed, got %q", got)
+	}
+
+	// the preservation check retries it and tells the authority
+	preserveContentFn = func(data []agentData, retainUntil time.Time) (preservationReceipt, error) {
+		return preservationReceipt{PreservationID: "pres-retry", RetainUntil: retainUntil}, nil
+	}
+	preserved, err := retryPendingPreservations()
+	if err != nil || preserved != 1 {
+		t.Fatalf("retryPendingPreservations() = %d, %v, want 1", preserved, err)
+	}
+	entry, err = (&fileLedger{dir: stateDir("LEDGER_DIR", "ledger")}).Get("8")
+	if err != nil || entry == nil || entry.PendingPreservation != nil || entry.Preservation == nil || entry.Preservation.ID != "pres-retry" {
+		t.Fatalf("expected the retried preservation in the ledger, got %+v (%v)", entry, err)
+	}
+	if !entry.Preservation.RetainUntil.Equal(now.AddDate(0, 6, 0)) {
+		t.Fatalf("the retry must keep the original retention period, got %s", entry.Preservation.RetainUntil)
+	}
+	if last := stubs.replies[len(stubs.replies)-1]; last != ReplyToTicketTemplatePreserved {
+		t.Fatalf("expected the authority to be told about the preservation, got %v", stubs.replies)
+	}
+	if got := preservationStatement("8"); got != preservedStatement {
+		t.Fatalf("expected the preservation to be confirmed, got %q", got)
+	}
+	if preserved, err := retryPendingPreservations(); err != nil || preserved != 0 {
+		t.Fatalf("a preservation must only be retried once it failed, got %d, %v", preserved, err)
+	}
+}
+
+func TestLegalHoldExtendsPreservation(t *testing.T) {
+	stubs := stubApprovalPipeline(t)
+	t.Setenv("LEGAL_HOLD_MONTHS", "3")
+	now := time.Date(2026, 10, 17, 9, 0, 0, 0, time.UTC)
+	origNow, origExtend := nowFn, extendPreservationFn
+	t.Cleanup(func() { nowFn, extendPreservationFn = origNow, origExtend })
+	nowFn = func() time.Time { return now }
+	var extended []string
+	extendPreservationFn = func(ticketID string, preservationID string, retainUntil time.Time) (preservationReceipt, error) {
+		extended = append(extended, preservationID+" "+retainUntil.Format("2006-01-02"))
+		return preservationReceipt{PreservationID: preservationID, RetainUntil: retainUntil}, nil
+	}
+
+	// without a preservation there is nothing to hold
+	if err := processTicketsAsync(ZendeskTicket{ID: "7", Tags: []string{legalHoldTag}}); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	if len(extended) != 0 || len(stubs.notes) != 1 || !strings.Contains(stubs.notes[0], errNoPreservation.Error()) {
+		t.Fatalf("expected only a note, extended=%v notes=%q", extended, stubs.notes)
+	}
+
+	ledger := &fileLedger{dir: stateDir("LEDGER_DIR", "ledger")}
+	ledger.Update("7", func(entry *ledgerEntry) (*ledgerEntry, error) {
+		return &ledgerEntry{TicketID: "7", Preservation: &preservationRecord{ID: "P-1", RetainUntil: now.AddDate(0, 0, 10), ExpiryReportedAt: now}}, nil
+	})
+	if err := processTicketsAsync(ZendeskTicket{ID: "7", Tags: []string{legalHoldTag}}); err != nil {
+		t.Fatalf("processTicketsAsync returned error: %v", err)
+	}
+	if len(extended) != 1 || extended[0] != "P-1 2027-01-27" {
+		t.Fatalf("expected three months from the current expiry, got %v", extended)
+	}
+	entry, _ := ledger.Get("7")
+	if len(entry.Preservation.LegalHolds) != 1 || !entry.Preservation.ExpiryReportedAt.IsZero() || entry.Preservation.RetainUntil.Format("2006-01-02") != "2027-01-27" {
+		t.Fatalf("unexpected preservation after the legal hold: %+v", entry.Preservation)
+	}
+	if len(stubs.banned) != 0 || len(stubs.untags) != 2 || !strings.Contains(stubs.notes[1], "kept until 2027-01-27") {
+		t.Fatalf("expected a note and the tag removed, banned=%+v untags=%v notes=%q", stubs.banned, stubs.untags, stubs.notes)
+	}
+}
+
+func TestCheckPreservationsReportsOnce(t *testing.T) {
+	t.Setenv("LEDGER_DIR", t.TempDir())
+	t.Setenv("BEARER_TOKEN", "secret")
+	now := time.Date(2026, 10, 17, 9, 0, 0, 0, time.UTC)
+	origNow, origNotify := nowFn, notifyPreservationExpiryFn
+	t.Cleanup(func() { nowFn, notifyPreservationExpiryFn = origNow, origNotify })
+	nowFn = func() time.Time { return now }
+	var reported []string
+	notifyErr := errors.New("slack is down")
+	notifyPreservationExpiryFn = func(ticketID string, preservation preservationRecord) error {
+		if notifyErr != nil {
+			return notifyErr
+		}
+		reported = append(reported, ticketID)
+		return nil
+	}
+
+	ledger := &fileLedger{dir: stateDir("LEDGER_DIR", "ledger")}
+	for ticketID, retainUntil := range map[string]time.Time{"1": now.AddDate(0, 0, 3), "2": now.AddDate(0, 2, 0)} {
+		ledger.Update(ticketID, func(entry *ledgerEntry) (*ledgerEntry, error) {
+			return &ledgerEntry{TicketID: ticketID, Preservation: &preservationRecord{ID: "P-" + ticketID, RetainUntil: retainUntil}}, nil
+		})
+	}
+
+	check := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/preservations/check", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		ProcessTickets(rec, req)
+		return rec
+	}
+	// a failed report is not marked as sent and is retried by the next check
+	if rec := check(); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected the failed report to fail the check, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if entry, _ := ledger.Get("1"); !entry.Preservation.ExpiryReportedAt.IsZero() {
+		t.Fatalf("expected the failed report not to count, got %+v", entry.Preservation)
+	}
+	notifyErr = nil
+	if rec := check(); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"reported":1`) {
+		t.Fatalf("expected one report, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := check(); !strings.Contains(rec.Body.String(), `"reported":0`) {
+		t.Fatalf("expected no second report, got %s", rec.Body.String())
+	}
+	if len(reported) != 1 || reported[0] != "1" {
+		t.Fatalf("expected only ticket 1 to be reported, got %v", reported)
+	}
+}
+
+func TestPreservationExpiryNeedsSlack(t *testing.T) {
+	t.Setenv("SLACK_WEBHOOK_URL", "")
+	if err := sendSlackPreservationExpiry("1", preservationRecord{ID: "P-1"}); err == nil {
+		t.Fatal("expected an error when no Slack webhook is configured")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 )
 
 var (
@@ -15,9 +16,13 @@ var (
 	extractDataFn        = extractDataFromTicket
 	replyToTicketsFn     = ReplyToTickets
 	banUsersFn           = BanUsers
+	removeContentFn      = RemoveContent
+	disableContentFn     = DisableContent
 	replyToTicketFn      = ReplyToTicket
-	asyncTicketProcessor = processTicketsAsync
+	asyncTicketProcessor = processTicketJob
 	tagTicketFn          = AddTagsToTicket
+	untagTicketFn        = RemoveTagsFromTicket
+	addInternalNoteFn    = AddInternalNote
 	notifySlackFn        = SendSlackNotification
 )
 
@@ -26,6 +31,8 @@ const (
 	decisionTagBanned   = "tco-vo-decision-banned"
 	decisionTagNotFound = "tco-vo-decision-not-found"
 	decisionTagMoreInfo = "tco-vo-decision-more-info"
+	decisionTagReview   = "tco-vo-decision-review"
+	decisionTagError    = "tco-vo-decision-error"
 )
 
 // ProcessTickets handles the Cloud Function HTTP request
@@ -35,21 +42,65 @@ func ProcessTickets(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Only accept POST requests for processing
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	// Scheduler endpoint that drains jobs left behind by previous instances
+	if r.URL.Path == "/jobs/drain" {
+		DrainJobs(w, r)
 		return
 	}
 
-	// validate bearer token
-	err := validateBearerToken(r)
-	if err != nil {
-		log.Printf("Error validating bearer token: %v", err)
-		http.Error(w, "Invalid bearer token", http.StatusUnauthorized)
+	// Jobs delivered by a Pub/Sub push subscription
+	if r.URL.Path == "/jobs/push" {
+		PushJob(w, r)
+		return
+	}
+
+	// Moderator decisions on proposals made in approval mode
+	if r.URL.Path == "/approvals" {
+		HandleApproval(w, r)
+		return
+	}
+
+	// Moderators undo the measures of an order after a complaint or review
+	if r.URL.Path == "/reinstatements" {
+		HandleReinstatement(w, r)
+		return
+	}
+
+	// Export of the audit trail
+	if r.URL.Path == "/audit" {
+		ExportAudit(w, r)
+		return
+	}
+
+	// Scheduler endpoint that escalates orders close to their deadline
+	if r.URL.Path == "/deadlines/check" {
+		CheckDeadlines(w, r)
+		return
+	}
+	if r.URL.Path == "/preservations/check" {
+		CheckPreservations(w, r)
+		return
+	}
+
+	// Article 7 transparency report
+	if r.URL.Path == "/reports/transparency" {
+		ExportTransparencyReport(w, r)
+		return
+	}
+
+	// Approval buttons of the Slack notifications
+	if r.URL.Path == "/slack/interactions" {
+		HandleSlackInteraction(w, r)
 		return
 	}
 
-	// Read request body
+	// Only accept POST requests for processing
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Read request body; the signature is computed over the raw bytes
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		log.Printf("Error reading request body: %v", err)
@@ -58,6 +109,13 @@ func ProcessTickets(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
+	// validate webhook signature or bearer token
+	if err := authenticateWebhook(r, body); err != nil {
+		log.Printf("Error authenticating webhook: %v", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	// sample request body:
 	// {  "account_id": 22129848,  "detail": {    "actor_id": "8447388090494",    "assignee_id": "8447388090494",    "brand_id": "8447346621310",    "created_at": "2025-01-08T10:12:07Z",    "custom_status": "8447320465790",    "description": "ticket_info_desc_2294a6e9ece2",    "external_id": null,    "form_id": "8646151517822",    "group_id": "8447320466430",    "id": "5158",    "is_public": true,    "organization_id": "8447346622462",    "priority": "LOW",    "requester_id": "8447388090494",    "status": "OPEN",    "subject": "ticketinfo_2294a6e9ece2",    "submitter_id": "8447388090494",    "tags": [      "ticket-info-test-tag"    ],    "type": "TASK",    "updated_at": "2025-01-08T10:12:07Z",    "via": {      "channel": "web_service"    }  },  "event": {},  "id": "cbe4028c-7239-495d-b020-f22348516046",  "subject": "zen:ticket:5158",  "time": "2025-01-08T10:12:07.672717030Z",  "type": "zen:event-type:ticket.created",  "zendesk_event_version": "2022-11-06"}
 
@@ -95,6 +153,8 @@ func ProcessTickets(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	recordAudit(ticketInfo.ID, auditWebhookReceived, json.RawMessage(body))
+
 	// Try fetching the ticket individually first (may include more fields like recipient)
 	// If that fails or returns no recipient, fall back to bulk fetch
 	var ticketData []ZendeskTicket
@@ -155,9 +215,17 @@ func ProcessTickets(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Warning: No tickets matched recipient filter. Total tickets: %d", len(ticketData))
 	}
 
-	// Process each user
+	// Persist a job per ticket before acknowledging the webhook so the work
+	// survives the instance being throttled or stopped after the response.
 	for _, ticket := range correctTickets {
-		go asyncTicketProcessor(ticket)
+		if err := enqueueTicket(ticket); err != nil {
+			log.Printf("Error enqueueing ticket %s: %v", ticket.ID, err)
+			http.Error(w, "Error enqueueing ticket", http.StatusInternalServerError)
+			return
+		}
+	}
+	if len(correctTickets) > 0 {
+		processQueuedJobs()
 	}
 
 	// Send JSON response
@@ -165,28 +233,88 @@ func ProcessTickets(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func processTicketsAsync(ticket ZendeskTicket) {
+// processTicketsAsync runs the full pipeline for one ticket. The returned error
+// tells the job worker whether the job should be retried.
+func processTicketsAsync(ticket ZendeskTicket) error {
+	return processTicketJob("", ticket)
+}
 
-	result := processResult{
-		TicketID: ticket.ID,
-		Subject:  ticket.Subject,
+// processTicketJob is processTicketsAsync for the queued job with the given ID,
+// so a redelivered job can finish a decision it started.
+func processTicketJob(jobID string, ticket ZendeskTicket) error {
+	// a moderator tagged the ticket to decide on a proposal
+	if hasApprovalTag(ticket.Tags) {
+		return handleApprovalTags(jobID, ticket)
 	}
-	recordError := func(err error, context string) {
-		if err == nil {
-			return
-		}
-		if context != "" {
-			err = fmt.Errorf("%s: %w", context, err)
+	// the authority asked for the Annex II form of an order we carried out
+	if hasTag(ticket.Tags, annexIITag) {
+		return handleAnnexIIRequest(ticket)
+	}
+	// the authority asked to keep the preserved content longer
+	if hasTag(ticket.Tags, legalHoldTag) {
+		return handleLegalHoldRequest(ticket)
+	}
+	// a complaint was upheld or the order was withdrawn or annulled
+	if _, ok := reinstateTagReason(ticket.Tags); ok {
+		return handleReinstateTags(ticket)
+	}
+
+	ledger, err := openLedgerFn()
+	if err != nil {
+		return fmt.Errorf("opening processing ledger: %w", err)
+	}
+	// the authority may have answered a clarification request
+	if err := resumeDeadlineOnReply(ledger, ticket); err != nil {
+		log.Printf("Error resuming deadline of ticket %s: %v", ticket.ID, err)
+	}
+	if hasTag(ticket.Tags, complaintTag) {
+		// kept for the transparency report; the ticket itself was already handled
+		if err := recordComplaintTags(ledger, ticket); err != nil {
+			log.Printf("Error recording complaint of ticket %s: %v", ticket.ID, err)
 		}
-		if result.Error == nil {
-			result.Error = err
+	}
+	skipReason, err := beginTicket(ledger, ticket)
+	if err != nil {
+		return fmt.Errorf("claiming ticket %s: %w", ticket.ID, err)
+	}
+	if skipReason != "" {
+		log.Printf("Skipping ticket %s: %s", ticket.ID, skipReason)
+		return nil
+	}
+	if hasTag(ticket.Tags, reprocessTag) {
+		// one-shot request; drop the tag so our own updates do not trigger another run
+		if err := untagTicketFn(ticket.ID, []string{reprocessTag}); err != nil {
+			log.Printf("Error removing %s tag from ticket %s: %v", reprocessTag, ticket.ID, err)
 		}
 	}
+	if err := startDeadline(ledger, ticket); err != nil {
+		log.Printf("Error starting deadline of ticket %s: %v", ticket.ID, err)
+	}
+
+	result := processResult{
+		TicketID:   ticket.ID,
+		Subject:    ticket.Subject,
+		ReceivedAt: ticketReceivedAt(ticket),
+	}
 	defer func() {
+		result.Elapsed, _ = orderElapsed(ticket.ID)
 		if err := notifySlackFn(result); err != nil {
 			log.Printf("Error sending Slack notification: %v", err)
 		}
 	}()
+	defer func() {
+		if result.PendingApproval && result.Error == nil {
+			// the proposal already moved the ledger to awaiting approval
+			return
+		}
+		state := ledgerStateCompleted
+		if result.Error != nil {
+			state = ledgerStateFailed
+		}
+		if err := markTicketState(ledger, ticket.ID, state, result.Error); err != nil {
+			log.Printf("Error updating processing ledger for ticket %s: %v", ticket.ID, err)
+		}
+	}()
 
 	agents := loadAgentConfigs()
 	// step 1 extract data from tickets
@@ -194,50 +322,178 @@ func processTicketsAsync(ticket ZendeskTicket) {
 	attachmentPaths, err := getAttachmentsFn(ticket.ID)
 	if err != nil {
 		log.Printf("Error getting attachments: %v", err)
-		recordError(err, "getting attachments")
-		return
+		result.recordError(err, "getting attachments")
+		return result.Error
 	}
+	// downloaded attachments may contain personal data; do not leave them on disk
+	defer removeAttachmentFiles(attachmentPaths)
+	recordAudit(ticket.ID, auditAttachments, hashAttachments(attachmentPaths))
 
 	data, extractionErrors := extractDataFn(attachmentPaths, agents)
-	if len(extractionErrors) > 0 {
-		log.Printf("Error extracting data from tickets: %v", extractionErrors)
-		recordError(fmt.Errorf("error extracting data from tickets: %v", extractionErrors), "")
-		return
-	}
 	for i := range data {
 		if data[i].Data.TicketID == "" {
 			data[i].Data.TicketID = ticket.ID
 		}
+		recordAudit(ticket.ID, auditAgentOutput, map[string]interface{}{
+			"agent":     data[i].Agent,
+			"decision":  data[i].Data,
+			"rawOutput": data[i].Data.RawOutput,
+		})
+	}
+	for _, failure := range extractionErrors {
+		recordAudit(ticket.ID, auditAgentError, map[string]interface{}{
+			"agent": failure.agent,
+			"error": failure.err.Error(),
+		})
+	}
+
+	// merge the agents' results into one decision per targeted account
+	data, review, err := buildConsensus(data, extractionErrors)
+	recordAudit(ticket.ID, auditConsensus, map[string]interface{}{
+		"decisions": data,
+		"review":    review,
+		"error":     errorString(err),
+	})
+	if err != nil {
+		log.Printf("Error extracting data from tickets: %v", err)
+		result.recordError(err, "error extracting data from tickets")
+		return result.Error
+	}
+	if len(extractionErrors) > 0 {
+		log.Printf("Ignoring %d failed agents for ticket %s: %v", len(extractionErrors), ticket.ID, extractionErrors)
 	}
+	result.Review = review
+
+	// orders that cannot be carried out are answered with Annex III instead
+	data, impossible := splitImpossible(data)
 
 	// step 2 partition data by hasRequiredInfo
 	hasRequiredInfoData, noRequiredInfoData := partitionDataByHasRequiredInfo(data)
+	if lowConfidenceAction() == lowConfidenceReview {
+		var lowConfidence []agentData
+		noRequiredInfoData, lowConfidence = splitLowConfidence(noRequiredInfoData)
+		review = append(review, lowConfidence...)
+		result.Review = review
+	}
+
+	// accounts the identifiers do not resolve to unambiguously are left to a human
+	if lookupEnabled() && len(hasRequiredInfoData) > 0 {
+		var ambiguous []agentData
+		hasRequiredInfoData, ambiguous, err = resolveIdentities(hasRequiredInfoData)
+		if err != nil {
+			log.Printf("Error looking up accounts of ticket %s: %v", ticket.ID, err)
+			result.recordError(err, "looking up accounts")
+			return result.Error
+		}
+		review = append(review, ambiguous...)
+		result.Review = review
+	}
+
+	// from here on the ticket sees side effects, so a retry must not repeat them
+	if err := markTicketState(ledger, ticket.ID, ledgerStateActing, nil); err != nil {
+		log.Printf("Error updating processing ledger for ticket %s: %v", ticket.ID, err)
+		result.recordError(err, "updating processing ledger")
+		return result.Error
+	}
+
+	// agents disagreed on these; a human decides, nothing is sent or banned
+	tagTickets(review, decisionTagReview)
+	noteCandidates(review)
+
+	// in approval mode a moderator has to confirm before anything public happens
+	if approvalModeEnabled() && len(hasRequiredInfoData)+len(noRequiredInfoData)+len(impossible) > 0 {
+		if err := proposeDecisions(ledger, ticket, hasRequiredInfoData, noRequiredInfoData, impossible, review); err != nil {
+			log.Printf("Error proposing decisions for ticket %s: %v", ticket.ID, err)
+			result.recordError(err, "proposing decisions")
+			return result.Error
+		}
+		result.PendingApproval = true
+		result.Proposed = hasRequiredInfoData
+		return nil
+	}
+
+	executeDecisions(&result, hasRequiredInfoData, noRequiredInfoData, impossible)
+	return result.Error
+}
+
+// executeDecisions asks for more information where needed, answers orders that
+// cannot be carried out, carries out the orders that have all required
+// information and replies to the authority. Depending on the content named in
+// the order, the account is banned or only the content is removed or disabled.
+func executeDecisions(result *processResult, hasRequiredInfoData []agentData, noRequiredInfoData []agentData, impossible []agentData) {
 	result.MoreInfo = noRequiredInfoData
+	result.Impossible = impossible
 
 	// tag tickets that need more information so they are visible in Zendesk views
 	tagTickets(noRequiredInfoData, decisionTagMoreInfo)
 
 	// step 3 reply to tickets with more info required
-	err = replyToTicketsFn(noRequiredInfoData, "more_info_required")
+	err := replyToTicketsFn(noRequiredInfoData, "more_info_required")
 	if err != nil {
 		log.Printf("Error replying to tickets: %v", err)
-		recordError(err, "replying to tickets missing info")
+		result.recordError(err, "replying to tickets missing info")
 	}
 
-	// step 4 ban fraud users
-	banned, notFound, err := banUsersFn(hasRequiredInfoData)
+	// tell the authority why the order cannot be carried out (Annex III)
+	if len(impossible) > 0 {
+		tagTickets(impossible, decisionTagImpossible)
+		if err := replyToTicketsFn(impossible, "impossible_to_comply"); err != nil {
+			log.Printf("Error replying to tickets: %v", err)
+			result.recordError(err, "replying to impossible orders")
+		}
+	}
+
+	// step 4 ban fraud users, or act on their content only
+	ban, remove, disable := partitionByMeasure(hasRequiredInfoData)
+	bans, err := banUsersFn(ban)
 	if err != nil {
 		log.Printf("Error banning fraud users: %v", err)
-		recordError(err, "banning users")
+		result.recordError(err, "banning users")
+	}
+	banned, notFound := bans.Banned, bans.NotFound
+	if len(bans.Failed) > 0 {
+		result.Failed = bans.Failed
+		result.recordError(fmt.Errorf("%d of %d accounts could not be banned", len(bans.Failed), len(ban)), "banning users")
+		reportFailedBans(bans.Failed)
+	}
+	var removed, disabled []agentData
+	if len(remove) > 0 {
+		var contentNotFound []agentData
+		removed, contentNotFound, err = removeContentFn(remove)
+		if err != nil {
+			log.Printf("Error removing content: %v", err)
+			result.recordError(err, "removing content")
+		}
+		notFound = append(notFound, contentNotFound...)
+	}
+	if len(disable) > 0 {
+		var contentNotFound []agentData
+		disabled, contentNotFound, err = disableContentFn(disable)
+		if err != nil {
+			log.Printf("Error disabling content: %v", err)
+			result.recordError(err, "disabling content")
+		}
+		notFound = append(notFound, contentNotFound...)
 	}
 	result.Banned = banned
+	result.Removed = removed
+	result.Disabled = disabled
 	result.NotFound = notFound
+	// stop the clock before the completion reply reports the time it took
+	recordDeadlineOutcome(result)
+	actioned := map[contentMeasure][]agentData{
+		measureBanAccount:     banned,
+		measureRemoveContent:  removed,
+		measureDisableContent: disabled,
+	}
+	recordRemovals(actioned)
+	preserveRemovals(result, actioned)
 
 	tagTickets(notFound, decisionTagNotFound)
 	err = replyToTicketsFn(notFound, "user_not_found")
 	if err != nil {
 		log.Printf("Error replying to tickets: %v", err)
-		recordError(err, "replying to not-found users")
+		result.recordError(err, "replying to not-found users")
 	}
 
 	// step 5 reply to tickets with user banned
@@ -245,8 +501,40 @@ func processTicketsAsync(ticket ZendeskTicket) {
 	err = replyToTicketsFn(banned, "user_banned")
 	if err != nil {
 		log.Printf("Error replying to tickets: %v", err)
-		recordError(err, "replying to banned users")
-		return
+		result.recordError(err, "replying to banned users")
+	}
+
+	// content measures only happen for orders that name addressable content
+	if len(removed) > 0 {
+		tagTickets(removed, decisionTagContentRemoved)
+		if err := replyToTicketsFn(removed, ReplyToTicketTemplateContentRemoved); err != nil {
+			log.Printf("Error replying to tickets: %v", err)
+			result.recordError(err, "replying to removed content")
+		}
+	}
+	if len(disabled) > 0 {
+		tagTickets(disabled, decisionTagContentDisabled)
+		if err := replyToTicketsFn(disabled, ReplyToTicketTemplateContentDisabled); err != nil {
+			log.Printf("Error replying to tickets: %v", err)
+			result.recordError(err, "replying to disabled content")
+		}
+	}
+}
+
+// reportFailedBans tags the tickets of accounts the Finya API could not ban and
+// tells the moderators why. The authority is not answered; the deadline keeps
+// running so the order is escalated if nobody steps in.
+func reportFailedBans(failed []agentData) {
+	tagTickets(failed, decisionTagError)
+	for _, group := range groupByTicket(failed) {
+		var lines []string
+		for _, data := range group {
+			lines = append(lines, fmt.Sprintf("- %s: %s", formatIdentifiers(data.Data), data.Reason))
+		}
+		note := fmt.Sprintf("%s The Finya API could not ban these accounts, please ban them manually:\n%s", agentNotePrefix, strings.Join(lines, "\n"))
+		if err := addInternalNoteFn(group[0].Data.TicketID, note); err != nil {
+			log.Printf("Error adding internal note to ticket %s: %v", group[0].Data.TicketID, err)
+		}
 	}
 }
 
@@ -256,6 +544,7 @@ func partitionDataByHasRequiredInfo(dataArray []agentData) ([]agentData, []agent
 	for _, data := range dataArray {
 		hasRequiredInfo, reason := checkRequiredInfo(data)
 		if hasRequiredInfo {
+			data.Data = dropUnsureIdentifiers(data.Data)
 			hasRequiredInfoData = append(hasRequiredInfoData, data)
 		} else {
 			data.Reason = reason
@@ -266,15 +555,28 @@ func partitionDataByHasRequiredInfo(dataArray []agentData) ([]agentData, []agent
 }
 
 func checkRequiredInfo(data agentData) (bool, string) {
-	if data.Data.Email == "" && data.Data.Username == "" {
+	switch {
+	case hasAccountIdentifier(data.Data):
+		if !isConfident(data.Data, "email", data.Data.Email) && !isConfident(data.Data, "username", data.Data.Username) && !isConfident(data.Data, "userId", data.Data.UserID) {
+			return false, "the account identifiers could not be read with certainty"
+		}
+	case hasAddressableContent(data.Data):
+		// messages and pictures are named by their own IDs, no account is needed
+	default:
 		return false, "email and username are required"
 	}
 	if data.Data.AgencyName == "" {
 		return false, "agencyName is required"
 	}
+	if !isConfident(data.Data, "agencyName", data.Data.AgencyName) {
+		return false, "the issuing authority could not be read with certainty"
+	}
 	if data.Data.ReferenceNumber == "" {
 		return false, "referenceNumber is required"
 	}
+	if !isConfident(data.Data, "referenceNumber", data.Data.ReferenceNumber) {
+		return false, "the reference number could not be read with certainty"
+	}
 
 	return true, ""
 }
@@ -285,6 +587,15 @@ const (
 	ReplyToTicketTemplateMoreInfoRequired ReplyToTicketTemplate = "more_info_required"
 	ReplyToTicketTemplateUserNotFound     ReplyToTicketTemplate = "user_not_found"
 	ReplyToTicketTemplateUserBanned       ReplyToTicketTemplate = "user_banned"
+	// ReplyToTicketTemplateImpossible answers orders we cannot carry out, with the Annex III form.
+	ReplyToTicketTemplateImpossible ReplyToTicketTemplate = "impossible_to_comply"
+	// Completion replies for orders carried out on the content instead of the account.
+	ReplyToTicketTemplateContentRemoved  ReplyToTicketTemplate = "content_removed"
+	ReplyToTicketTemplateContentDisabled ReplyToTicketTemplate = "content_disabled"
+	// ReplyToTicketTemplateReinstated tells the authority that the measures of its order were undone.
+	ReplyToTicketTemplateReinstated ReplyToTicketTemplate = "reinstated"
+	// ReplyToTicketTemplatePreserved confirms a preservation that succeeded only on a retry.
+	ReplyToTicketTemplatePreserved ReplyToTicketTemplate = "preserved"
 )
 
 func ReplyToTickets(tickets []agentData, messageTemplate ReplyToTicketTemplate) error {
@@ -296,28 +607,76 @@ func ReplyToTickets(tickets []agentData, messageTemplate ReplyToTicketTemplate)
 		message = userNotFoundMessage
 	case "user_banned":
 		message = userBannedMessage
+	case "impossible_to_comply":
+		message = impossibleToComplyMessage
+	case "content_removed", "content_disabled":
+		message = contentActionMessage
+	case "reinstated":
+		message = reinstatedMessage
 	default:
 		return errors.New("invalid message template")
 	}
-	for _, ticket := range tickets {
+	// one reply per ticket, covering all accounts of the order
+	for _, group := range groupByTicket(tickets) {
 		var err error
-		message, err = buildMessage(messageTemplate, ticket)
+		message, err = buildTicketMessage(messageTemplate, group)
 		if err != nil {
 			return err
 		}
-		err = replyToTicketFn(ticket.Data.TicketID, message)
+		var attachment *ticketAttachment
+		measure, completed := templateMeasure(messageTemplate)
+		switch {
+		case completed && annexIIMode() == annexIIModeAttach:
+			form := annexIIAttachment(group, nowFn().UTC(), measure)
+			attachment = &form
+		case messageTemplate == ReplyToTicketTemplateImpossible:
+			form := annexIIIAttachment(group)
+			attachment = &form
+		}
+		var attachments []string
+		if attachment != nil {
+			attachments = append(attachments, attachment.Name)
+			err = replyWithAttachmentsFn(group[0].Data.TicketID, message, []ticketAttachment{*attachment})
+		} else {
+			err = replyToTicketFn(group[0].Data.TicketID, message)
+		}
 		if err != nil {
 			return err
 		}
+		sent := map[string]interface{}{
+			"template": messageTemplate,
+			"message":  message,
+		}
+		if len(attachments) > 0 {
+			sent["attachments"] = attachments
+		}
+		recordAudit(group[0].Data.TicketID, auditReplySent, sent)
 	}
 	return nil
 }
 
+// groupByTicket groups decisions by ticket, keeping the order tickets first appear in.
+func groupByTicket(tickets []agentData) [][]agentData {
+	var groups [][]agentData
+	index := map[string]int{}
+	for _, ticket := range tickets {
+		i, ok := index[ticket.Data.TicketID]
+		if !ok {
+			i = len(groups)
+			index[ticket.Data.TicketID] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], ticket)
+	}
+	return groups
+}
+
 // tagTickets adds a stable agent tag plus a decision-specific tag to each ticket.
 func tagTickets(tickets []agentData, decisionTag string) {
-	for _, ticket := range tickets {
-		if ticket.Data.TicketID == "" {
-			log.Printf("Skipping tag because ticket ID is empty (decision=%s). Ticket data: %+v", decisionTag, ticket.Data)
+	for _, group := range groupByTicket(tickets) {
+		ticketID := group[0].Data.TicketID
+		if ticketID == "" {
+			log.Printf("Skipping tag because ticket ID is empty (decision=%s). Ticket data: %+v", decisionTag, group[0].Data)
 			continue
 		}
 
@@ -326,10 +685,11 @@ func tagTickets(tickets []agentData, decisionTag string) {
 			tags = append(tags, decisionTag)
 		}
 
-		if err := tagTicketFn(ticket.Data.TicketID, tags); err != nil {
-			log.Printf("Error tagging ticket %s: %v", ticket.Data.TicketID, err)
+		if err := tagTicketFn(ticketID, tags); err != nil {
+			log.Printf("Error tagging ticket %s: %v", ticketID, err)
 		} else {
-			log.Printf("Successfully added tags %v to ticket %s", tags, ticket.Data.TicketID)
+			log.Printf("Successfully added tags %v to ticket %s", tags, ticketID)
+			recordAudit(ticketID, auditTagged, taggedAuditData(tags, group))
 		}
 	}
 }
@@ -12,6 +12,10 @@ import (
 
 func TestProcessTicketsEndToEnd(t *testing.T) {
 	t.Setenv("BEARER_TOKEN", "secret")
+	t.Setenv("LEDGER_DIR", t.TempDir())
+	stubLookup(t)
+	useFakeZendesk(t).addTicket(ZendeskTicket{ID: "abc", Subject: "integration"})
+	waitForDrain := syncDrain(t)
 
 	origGetAttachments := getAttachmentsFn
 	origExtractData := extractDataFn
@@ -41,8 +45,8 @@ func TestProcessTicketsEndToEnd(t *testing.T) {
 		}, nil
 	}
 
-	banUsersFn = func(data []agentData) (bannedUsers []agentData, notFoundUsers []agentData, err error) {
-		return []agentData{data[0]}, []agentData{{Data: FraudDecision{TicketID: "missing", Username: "missing", Email: "missing@example.com", AgencyName: "Agency", ReferenceNumber: "ref2"}}}, nil
+	banUsersFn = func(data []agentData) (banResults, error) {
+		return banResults{Banned: []agentData{data[0]}, NotFound: []agentData{{Data: FraudDecision{TicketID: "missing", Username: "missing", Email: "missing@example.com", AgencyName: "Agency", ReferenceNumber: "ref2"}}}}, nil
 	}
 
 	var mu sync.Mutex
@@ -65,9 +69,9 @@ func TestProcessTicketsEndToEnd(t *testing.T) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	asyncTicketProcessor = func(ticket ZendeskTicket) {
+	asyncTicketProcessor = func(jobID string, ticket ZendeskTicket) error {
 		defer wg.Done()
-		processTicketsAsync(ticket)
+		return processTicketsAsync(ticket)
 	}
 
 	body := `{"id":"abc","subject":"integration"}`
@@ -82,6 +86,7 @@ func TestProcessTicketsEndToEnd(t *testing.T) {
 	}
 
 	wg.Wait()
+	waitForDrain()
 
 	if len(replies) != 3 {
 		t.Fatalf("expected 3 reply calls, got %d", len(replies))
@@ -98,7 +103,8 @@ func TestProcessTicketsEndToEnd(t *testing.T) {
 }
 
 func TestProcessTicketsEndToEndUnauthorized(t *testing.T) {
-	// Use default BEARER_TOKEN fallback; no stubbing to ensure handler rejects missing auth.
+	// No stubbing, to ensure the handler rejects requests without auth.
+	t.Setenv("BEARER_TOKEN", "secret")
 	body := `{"id":"unauth","subject":"fail"}`
 	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
 	rr := httptest.NewRecorder()
@@ -112,6 +118,10 @@ func TestProcessTicketsEndToEndUnauthorized(t *testing.T) {
 
 func TestProcessTicketsEndToEndWithAttachmentOverHTTP(t *testing.T) {
 	t.Setenv("BEARER_TOKEN", "secret")
+	t.Setenv("LEDGER_DIR", t.TempDir())
+	stubLookup(t)
+	useFakeZendesk(t).addTicket(ZendeskTicket{ID: "ticket-789", Subject: "attachment test"})
+	waitForDrain := syncDrain(t)
 
 	tmpDir := t.TempDir()
 	attachmentPath := filepath.Join(tmpDir, "ticket-attachment.pdf")
@@ -173,11 +183,11 @@ func TestProcessTicketsEndToEndWithAttachmentOverHTTP(t *testing.T) {
 		}, nil
 	}
 
-	banUsersFn = func(data []agentData) (bannedUsers []agentData, notFoundUsers []agentData, err error) {
+	banUsersFn = func(data []agentData) (banResults, error) {
 		if len(data) != 1 || data[0].Data.Username != "alice" {
 			t.Fatalf("unexpected data passed to BanUsers: %+v", data)
 		}
-		return []agentData{data[0]}, []agentData{{
+		return banResults{Banned: []agentData{data[0]}, NotFound: []agentData{{
 			Data: FraudDecision{
 				TicketID:        "not-found",
 				Username:        "bob",
@@ -185,7 +195,7 @@ func TestProcessTicketsEndToEndWithAttachmentOverHTTP(t *testing.T) {
 				AgencyName:      "Agency One",
 				ReferenceNumber: "REF-404",
 			},
-		}}, nil
+		}}}, nil
 	}
 
 	var mu sync.Mutex
@@ -208,9 +218,9 @@ func TestProcessTicketsEndToEndWithAttachmentOverHTTP(t *testing.T) {
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	asyncTicketProcessor = func(ticket ZendeskTicket) {
+	asyncTicketProcessor = func(jobID string, ticket ZendeskTicket) error {
 		defer wg.Done()
-		processTicketsAsync(ticket)
+		return processTicketsAsync(ticket)
 	}
 
 	server := httptest.NewServer(http.HandlerFunc(ProcessTickets))
@@ -234,6 +244,7 @@ func TestProcessTicketsEndToEndWithAttachmentOverHTTP(t *testing.T) {
 	}
 
 	wg.Wait()
+	waitForDrain()
 
 	if len(replies) != 3 {
 		t.Fatalf("expected 3 reply calls, got %d", len(replies))
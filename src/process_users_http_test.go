@@ -10,6 +10,7 @@ import (
 
 func TestProcessTicketsHandler(t *testing.T) {
 	t.Setenv("BEARER_TOKEN", "secret")
+	useFakeZendesk(t).addTicket(ZendeskTicket{ID: "5158", Subject: "test"})
 
 	origAsync := asyncTicketProcessor
 	t.Cleanup(func() {
@@ -66,14 +67,17 @@ func TestProcessTicketsHandler(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			asyncCalled := make(chan ZendeskTicket, 1)
+			waitForDrain := syncDrain(t)
 
 			if tt.expectAsync {
-				asyncTicketProcessor = func(ticket ZendeskTicket) {
+				asyncTicketProcessor = func(jobID string, ticket ZendeskTicket) error {
 					asyncCalled <- ticket
+					return nil
 				}
 			} else {
-				asyncTicketProcessor = func(ticket ZendeskTicket) {
+				asyncTicketProcessor = func(jobID string, ticket ZendeskTicket) error {
 					t.Fatalf("async processor should not be called, got ticket %+v", ticket)
+					return nil
 				}
 			}
 
@@ -98,6 +102,7 @@ func TestProcessTicketsHandler(t *testing.T) {
 				case <-time.After(100 * time.Millisecond):
 					t.Fatalf("async processor was not called")
 				}
+				waitForDrain()
 			}
 		})
 	}
@@ -6,6 +6,8 @@ import (
 )
 
 func TestProcessTicketsAsyncPipeline(t *testing.T) {
+	t.Setenv("LEDGER_DIR", t.TempDir())
+
 	origGetAttachments := getAttachmentsFn
 	origExtractData := extractDataFn
 	origBanUsers := banUsersFn
@@ -19,6 +21,8 @@ func TestProcessTicketsAsyncPipeline(t *testing.T) {
 		replyToTicketsFn = origReplyToTickets
 		notifySlackFn = origNotifySlack
 	})
+	stubPreservation(t)
+	stubLookup(t)
 
 	getAttachmentsFn = func(ticketId string) ([]string, error) {
 		if ticketId != "123" {
@@ -37,11 +41,11 @@ func TestProcessTicketsAsyncPipeline(t *testing.T) {
 		}, nil
 	}
 
-	banUsersFn = func(data []agentData) (bannedUsers []agentData, notFoundUsers []agentData, err error) {
+	banUsersFn = func(data []agentData) (banResults, error) {
 		if len(data) != 1 || data[0].Data.Username != "user1" {
 			t.Fatalf("unexpected data passed to BanUsers: %+v", data)
 		}
-		return []agentData{data[0]}, []agentData{{Data: FraudDecision{TicketID: "999", Username: "missing", Email: "missing@example.com", AgencyName: "Agency", ReferenceNumber: "refX"}}}, nil
+		return banResults{Banned: []agentData{data[0]}, NotFound: []agentData{{Data: FraudDecision{TicketID: "999", Username: "missing", Email: "missing@example.com", AgencyName: "Agency", ReferenceNumber: "refX"}}}}, nil
 	}
 
 	var mu sync.Mutex
@@ -3,6 +3,7 @@ package tco_vo_agent
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
@@ -32,6 +33,18 @@ func TestCheckRequiredInfo(t *testing.T) {
 			ok:     false,
 			reason: "referenceNumber is required",
 		},
+		{
+			name:   "content-only order",
+			data:   agentData{Data: FraudDecision{ContentItems: []ContentItem{{MessageID: "m-1"}, {PictureID: "p-1"}}, AgencyName: "Agency", ReferenceNumber: "ref"}},
+			ok:     true,
+			reason: "",
+		},
+		{
+			name:   "content without IDs and no account",
+			data:   agentData{Data: FraudDecision{ContentItems: []ContentItem{{URL: "https://finya.de/p/1"}}, AgencyName: "Agency", ReferenceNumber: "ref"}},
+			ok:     false,
+			reason: "email and username are required",
+		},
 		{
 			name:   "all required fields present",
 			data:   agentData{Data: FraudDecision{Username: "user", Email: "user@example.com", AgencyName: "Agency", ReferenceNumber: "ref"}},
@@ -68,6 +81,7 @@ func TestPartitionDataByHasRequiredInfo(t *testing.T) {
 }
 
 func TestReplyToTicketsTemplates(t *testing.T) {
+	t.Setenv("LEDGER_DIR", t.TempDir())
 	orig := replyToTicketFn
 	origNow := nowFn
 	t.Cleanup(func() {
@@ -143,6 +157,10 @@ func TestReplyToTicketsTemplates(t *testing.T) {
 				"Bundeskriminalamt",
 				"username: baduser / email: bad@example.com",
 				actionTime,
+				"",
+				// nothing was preserved for this ticket, so nothing is claimed
+				preservationPendingStatement,
+				annexIIOnRequestNote,
 			),
 		},
 	}
@@ -230,3 +248,33 @@ func TestReplyToTicketsStopsOnError(t *testing.T) {
 		t.Fatalf("unexpected call order: %v", called)
 	}
 }
+
+func TestReplyToTicketsGroupsAccountsPerTicket(t *testing.T) {
+	orig := replyToTicketFn
+	t.Cleanup(func() {
+		replyToTicketFn = orig
+	})
+
+	var calls []string
+	replyToTicketFn = func(ticketId string, message string) error {
+		calls = append(calls, ticketId+"|"+message)
+		return nil
+	}
+
+	order := FraudDecision{TicketID: "900", AgencyName: "BKA", ReferenceNumber: "REF-900"}
+	first, second := order, order
+	first.Username = "one"
+	second.Email = "two@example.com"
+	second.ContentItems = []ContentItem{{URL: "https://finya.de/p/2"}, {MessageID: "m-2"}}
+
+	if err := ReplyToTickets([]agentData{{Data: first}, {Data: second}}, "user_banned"); err != nil {
+		t.Fatalf("ReplyToTickets returned error: %v", err)
+	}
+	if len(calls) != 1 {
+		t.Fatalf("expected a single reply for the ticket, got %d", len(calls))
+	}
+	want := "username: one; email: two@example.com, content: https://finya.de/p/2, message ID m-2"
+	if !strings.Contains(calls[0], want) {
+		t.Fatalf("reply does not list all accounts and content:\n%s", calls[0])
+	}
+}
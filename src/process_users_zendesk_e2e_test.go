@@ -49,7 +49,7 @@ func TestProcessTicketsZendeskE2E(t *testing.T) {
 	}
 
 	if bearerToken == "" {
-		bearerToken = "oon4at1odepaiTahS4eng3biejah3aidaeng7yahse" // default from main.go
+		bearerToken = "zendesk-e2e-test-token"
 	}
 
 	// Setup: Create test PDF attachment
@@ -75,7 +75,7 @@ func TestProcessTicketsZendeskE2E(t *testing.T) {
 	var banUsersMu sync.Mutex
 
 	// Wrap banUsersFn to track calls but use real implementation
-	banUsersFn = func(data []agentData) (bannedUsers []agentData, notFoundUsers []agentData, err error) {
+	banUsersFn = func(data []agentData) (banResults, error) {
 		banUsersMu.Lock()
 		banUsersCalled = true
 		banUsersCallData = make([]agentData, len(data))
@@ -135,12 +135,10 @@ func TestProcessTicketsZendeskE2E(t *testing.T) {
 
 	// Setup async processing with wait group
 	wg := &sync.WaitGroup{}
-	asyncTicketProcessor = func(ticket ZendeskTicket) {
+	asyncTicketProcessor = func(jobID string, ticket ZendeskTicket) error {
 		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			processTicketsAsync(ticket)
-		}()
+		defer wg.Done()
+		return processTicketsAsync(ticket)
 	}
 
 	// Cleanup: restore original functions
@@ -0,0 +1,288 @@
+package tco_vo_agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	decisionTagPrefix = "tco-vo-decision-"
+	reprocessTag      = "tco-vo-reprocess"
+
+	ledgerLockTimeout = time.Minute
+)
+
+var openLedgerFn = openLedger
+
+type ledgerState string
+
+const (
+	// ledgerStateProcessing means attachments are being fetched and extracted; nothing was sent yet.
+	ledgerStateProcessing ledgerState = "processing"
+	// ledgerStateActing means bans, tags or replies may already have been sent.
+	ledgerStateActing    ledgerState = "acting"
+	ledgerStateCompleted ledgerState = "completed"
+	ledgerStateFailed    ledgerState = "failed"
+)
+
+type ledgerTransition struct {
+	State ledgerState `json:"state"`
+	At    time.Time   `json:"at"`
+	Note  string      `json:"note,omitempty"`
+}
+
+// ledgerEntry records how far the pipeline got for a ticket.
+type ledgerEntry struct {
+	TicketID       string             `json:"ticketId"`
+	State          ledgerState        `json:"state"`
+	ActionsStarted bool               `json:"actionsStarted"`
+	Runs           int                `json:"runs"`
+	LastError      string             `json:"lastError,omitempty"`
+	CreatedAt      time.Time          `json:"createdAt"`
+	UpdatedAt      time.Time          `json:"updatedAt"`
+	History        []ledgerTransition `json:"history"`
+	// Proposal is set in approval mode until a moderator decided on it.
+	Proposal *approvalProposal `json:"proposal,omitempty"`
+	// Deadline tracks the one-hour removal deadline of the order.
+	Deadline *orderDeadline `json:"deadline,omitempty"`
+	// Complaint is set once a moderator tagged a complaint about the order.
+	Complaint *orderComplaint `json:"complaint,omitempty"`
+	// Removal records the accounts removed for the order, for the Annex II form.
+	Removal *removalRecord `json:"removal,omitempty"`
+	// Preservation is the Article 6 preservation of what was removed.
+	Preservation *preservationRecord `json:"preservation,omitempty"`
+	// PendingPreservation is set while a failed preservation waits for its retry.
+	PendingPreservation *pendingPreservation `json:"pendingPreservation,omitempty"`
+	// Reinstatement is set once the measures of the order were undone.
+	Reinstatement *reinstatementRecord `json:"reinstatement,omitempty"`
+	// JobAttempts counts the failed attempts per queued job ID, for queues
+	// that do not count deliveries themselves.
+	JobAttempts map[string]int `json:"jobAttempts,omitempty"`
+}
+
+// ticketLedger stores one entry per Zendesk ticket ID.
+type ticketLedger interface {
+	Get(ticketID string) (*ledgerEntry, error)
+	// Update applies fn to the current entry (nil if none) and stores the result.
+	// Backends without a lock may apply fn more than once when entries are
+	// written concurrently, so fn must only depend on the entry it is given.
+	Update(ticketID string, fn func(entry *ledgerEntry) (*ledgerEntry, error)) (*ledgerEntry, error)
+	// List returns all entries, e.g. for scheduled checks.
+	List() ([]*ledgerEntry, error)
+}
+
+func openLedger() (ticketLedger, error) {
+	if err := validateLedgerConfig(); err != nil {
+		return nil, err
+	}
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("LEDGER_BACKEND")))
+	switch backend {
+	case "", "file":
+		return &fileLedger{dir: stateDir("LEDGER_DIR", "ledger")}, nil
+	case "gcs":
+		return newGCSLedgerFromEnv()
+	default:
+		return nil, fmt.Errorf("unsupported LEDGER_BACKEND %s", backend)
+	}
+}
+
+// validateLedgerConfig refuses a production deployment whose ledger would
+// default to the temporary directory of a single instance: duplicate
+// deliveries, deadlines and preservations would be lost on every recycle.
+func validateLedgerConfig() error {
+	if !isProductionMode() {
+		return nil
+	}
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("LEDGER_BACKEND")))
+	if (backend == "" || backend == "file") && strings.TrimSpace(os.Getenv("LEDGER_DIR")) == "" {
+		return errors.New("LEDGER_BACKEND=gcs or a LEDGER_DIR on shared storage is required in production")
+	}
+	return nil
+}
+
+// errLedgerSkip is returned by claim functions to leave the entry untouched.
+var errLedgerSkip = errors.New("ticket already handled")
+
+// beginTicket claims the ticket for a pipeline run. It returns a non-empty skip
+// reason when the delivery is a duplicate and must not be processed again.
+func beginTicket(ledger ticketLedger, ticket ZendeskTicket) (string, error) {
+	reprocess := hasTag(ticket.Tags, reprocessTag)
+	if !reprocess {
+		for _, tag := range ticket.Tags {
+			if strings.HasPrefix(tag, decisionTagPrefix) {
+				return fmt.Sprintf("ticket already carries decision tag %s", tag), nil
+			}
+		}
+	}
+
+	var skipReason string
+	_, err := ledger.Update(ticket.ID, func(entry *ledgerEntry) (*ledgerEntry, error) {
+		now := nowFn().UTC()
+		if entry != nil {
+			inFlight := entry.State == ledgerStateProcessing || entry.State == ledgerStateActing
+			stale := now.Sub(entry.UpdatedAt) > defaultJobVisibilityAfter
+			switch {
+			case inFlight && !stale:
+				// never run twice concurrently, not even on an explicit reprocess request
+				skipReason = fmt.Sprintf("ticket is already being processed (state %s)", entry.State)
+				return nil, errLedgerSkip
+			case reprocess:
+				// explicit request, run again from scratch
+			case entry.State == ledgerStateCompleted:
+				skipReason = "ticket was already processed"
+				return nil, errLedgerSkip
+			case entry.ActionsStarted:
+				skipReason = fmt.Sprintf("a previous run already acted on the ticket (state %s); add the %s tag to run it again", entry.State, reprocessTag)
+				return nil, errLedgerSkip
+			}
+		} else {
+			entry = &ledgerEntry{TicketID: ticket.ID, CreatedAt: now}
+		}
+
+		note := ""
+		if reprocess {
+			note = "reprocess requested"
+		}
+		entry.Runs++
+		entry.ActionsStarted = false
+		entry.LastError = ""
+		entry.Proposal = nil
+		entry.setState(ledgerStateProcessing, now, note)
+		return entry, nil
+	})
+	if errors.Is(err, errLedgerSkip) {
+		return skipReason, nil
+	}
+	return "", err
+}
+
+// markTicketState moves the ticket to the given state. Errors are recorded on failed runs.
+func markTicketState(ledger ticketLedger, ticketID string, state ledgerState, cause error) error {
+	_, err := ledger.Update(ticketID, func(entry *ledgerEntry) (*ledgerEntry, error) {
+		now := nowFn().UTC()
+		if entry == nil {
+			entry = &ledgerEntry{TicketID: ticketID, CreatedAt: now}
+		}
+		if state == ledgerStateActing {
+			entry.ActionsStarted = true
+		}
+		note := ""
+		if cause != nil {
+			entry.LastError = cause.Error()
+			note = cause.Error()
+		}
+		entry.setState(state, now, note)
+		return entry, nil
+	})
+	return err
+}
+
+func (e *ledgerEntry) setState(state ledgerState, at time.Time, note string) {
+	e.State = state
+	e.UpdatedAt = at
+	e.History = append(e.History, ledgerTransition{State: state, At: at, Note: note})
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// fileLedger keeps one JSON document per ticket and serialises updates with a lock file.
+type fileLedger struct {
+	dir string
+}
+
+func (l *fileLedger) path(ticketID string) string {
+	return filepath.Join(l.dir, safeFileName(ticketID)+".json")
+}
+
+func (l *fileLedger) Get(ticketID string) (*ledgerEntry, error) {
+	var entry ledgerEntry
+	err := readJSONFile(l.path(ticketID), &entry)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (l *fileLedger) List() ([]*ledgerEntry, error) {
+	files, err := os.ReadDir(l.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*ledgerEntry
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		var entry ledgerEntry
+		if err := readJSONFile(filepath.Join(l.dir, file.Name()), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
+
+func (l *fileLedger) Update(ticketID string, fn func(entry *ledgerEntry) (*ledgerEntry, error)) (*ledgerEntry, error) {
+	unlock, err := l.lock(ticketID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	current, err := l.Get(ticketID)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := fn(current)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeJSONFile(l.path(ticketID), updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (l *fileLedger) lock(ticketID string) (func(), error) {
+	if err := os.MkdirAll(l.dir, 0o755); err != nil {
+		return nil, err
+	}
+	lockPath := l.path(ticketID) + ".lock"
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		// a crashed worker may have left its lock behind
+		if info, statErr := os.Stat(lockPath); statErr == nil && time.Since(info.ModTime()) > ledgerLockTimeout {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for ledger lock of ticket %s", ticketID)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,243 @@
+package tco_vo_agent
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBeginTicket(t *testing.T) {
+	ledger := &fileLedger{dir: t.TempDir()}
+
+	skip, err := beginTicket(ledger, ZendeskTicket{ID: "1"})
+	if err != nil || skip != "" {
+		t.Fatalf("first delivery: skip=%q err=%v, want processing", skip, err)
+	}
+
+	skip, _ = beginTicket(ledger, ZendeskTicket{ID: "1"})
+	if skip == "" {
+		t.Fatal("concurrent redelivery must be skipped")
+	}
+
+	if err := markTicketState(ledger, "1", ledgerStateCompleted, nil); err != nil {
+		t.Fatalf("markTicketState returned error: %v", err)
+	}
+	skip, _ = beginTicket(ledger, ZendeskTicket{ID: "1"})
+	if skip == "" {
+		t.Fatal("redelivery of a completed ticket must be skipped")
+	}
+
+	skip, _ = beginTicket(ledger, ZendeskTicket{ID: "1", Tags: []string{reprocessTag, decisionTagBanned}})
+	if skip != "" {
+		t.Fatalf("reprocess tag must allow another run, got skip %q", skip)
+	}
+
+	entry, _ := ledger.Get("1")
+	if entry.Runs != 2 || entry.State != ledgerStateProcessing {
+		t.Fatalf("unexpected ledger entry after reprocess: %+v", entry)
+	}
+}
+
+func TestBeginTicketSkipsDecisionTags(t *testing.T) {
+	ledger := &fileLedger{dir: t.TempDir()}
+
+	skip, _ := beginTicket(ledger, ZendeskTicket{ID: "2", Tags: []string{agentTag, decisionTagMoreInfo}})
+	if skip == "" {
+		t.Fatal("ticket with a decision tag must be skipped")
+	}
+	if entry, _ := ledger.Get("2"); entry != nil {
+		t.Fatalf("skipped ticket must not be recorded, got %+v", entry)
+	}
+}
+
+func TestBeginTicketRetriesOnlyBeforeActions(t *testing.T) {
+	ledger := &fileLedger{dir: t.TempDir()}
+
+	beginTicket(ledger, ZendeskTicket{ID: "3"})
+	markTicketState(ledger, "3", ledgerStateFailed, errors.New("openai down"))
+	if skip, _ := beginTicket(ledger, ZendeskTicket{ID: "3"}); skip != "" {
+		t.Fatalf("failure before any action must be retried, got skip %q", skip)
+	}
+
+	markTicketState(ledger, "3", ledgerStateActing, nil)
+	markTicketState(ledger, "3", ledgerStateFailed, errors.New("reply failed"))
+	if skip, _ := beginTicket(ledger, ZendeskTicket{ID: "3"}); skip == "" {
+		t.Fatal("failure after actions started must not be retried automatically")
+	}
+}
+
+func TestBeginTicketTakesOverStaleRuns(t *testing.T) {
+	ledger := &fileLedger{dir: t.TempDir()}
+	beginTicket(ledger, ZendeskTicket{ID: "4"})
+
+	origNow := nowFn
+	t.Cleanup(func() { nowFn = origNow })
+	nowFn = func() time.Time { return time.Now().Add(time.Hour) }
+
+	if skip, _ := beginTicket(ledger, ZendeskTicket{ID: "4"}); skip != "" {
+		t.Fatalf("abandoned run must be taken over, got skip %q", skip)
+	}
+}
+
+func TestProcessTicketsAsyncIsIdempotent(t *testing.T) {
+	t.Setenv("LEDGER_DIR", t.TempDir())
+
+	origGetAttachments := getAttachmentsFn
+	origExtractData := extractDataFn
+	origBanUsers := banUsersFn
+	origReplyToTickets := replyToTicketsFn
+	origTag := tagTicketFn
+	origNotifySlack := notifySlackFn
+	t.Cleanup(func() {
+		getAttachmentsFn = origGetAttachments
+		extractDataFn = origExtractData
+		banUsersFn = origBanUsers
+		replyToTicketsFn = origReplyToTickets
+		tagTicketFn = origTag
+		notifySlackFn = origNotifySlack
+	})
+	stubPreservation(t)
+	stubLookup(t)
+
+	getAttachmentsFn = func(ticketId string) ([]string, error) { return nil, nil }
+	extractDataFn = func(paths []string, agents []agentConfig) ([]agentData, []agentError) {
+		return []agentData{{Data: FraudDecision{TicketID: "dup", Username: "u", AgencyName: "A", ReferenceNumber: "R"}}}, nil
+	}
+	banCalls := 0
+	banUsersFn = func(data []agentData) (banResults, error) {
+		banCalls++
+		return banResults{Banned: data}, nil
+	}
+	replyToTicketsFn = func(tickets []agentData, messageTemplate ReplyToTicketTemplate) error { return nil }
+	tagTicketFn = func(ticketId string, tags []string) error { return nil }
+	notifySlackFn = func(result processResult) error { return nil }
+
+	for i := 0; i < 2; i++ {
+		if err := processTicketsAsync(ZendeskTicket{ID: "dup"}); err != nil {
+			t.Fatalf("processTicketsAsync returned error: %v", err)
+		}
+	}
+
+	if banCalls != 1 {
+		t.Fatalf("expected a single ban call for a redelivered ticket, got %d", banCalls)
+	}
+}
+
+func TestGCSLedger(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	generations := map[string]int64{}
+	conflicts := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/ledger-bucket/o":
+			name := r.URL.Query().Get("name")
+			match, _ := strconv.ParseInt(r.URL.Query().Get("ifGenerationMatch"), 10, 64)
+			if conflicts > 0 {
+				// another instance writes the entry first
+				conflicts--
+				objects[name] = []byte(`{"ticketId":"1","state":"failed"}`)
+				generations[name]++
+			}
+			if match != generations[name] {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			objects[name] = body
+			generations[name]++
+			w.Write([]byte(`{}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b/ledger-bucket/o":
+			var items []map[string]string
+			for name := range objects {
+				if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+					items = append(items, map[string]string{"name": name})
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/storage/v1/b/ledger-bucket/o/"):
+			name := strings.TrimPrefix(r.URL.Path, "/storage/v1/b/ledger-bucket/o/")
+			body, ok := objects[name]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("X-Goog-Generation", strconv.FormatInt(generations[name], 10))
+			w.Write(body)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("LEDGER_BACKEND", "gcs")
+	t.Setenv("LEDGER_BUCKET", "ledger-bucket")
+	t.Setenv("LEDGER_GCS_BASE_URL", server.URL)
+	t.Setenv("GCP_ACCESS_TOKEN", "gcs-token")
+
+	ledger, err := openLedger()
+	if err != nil {
+		t.Fatalf("openLedger returned error: %v", err)
+	}
+	runs := 0
+	_, err = ledger.Update("1", func(entry *ledgerEntry) (*ledgerEntry, error) {
+		runs++
+		return &ledgerEntry{TicketID: "1", State: ledgerStateProcessing, UpdatedAt: time.Now().UTC()}, nil
+	})
+	if err != nil || runs != 2 {
+		t.Fatalf("expected the update to be retried after the conflict, runs=%d err=%v", runs, err)
+	}
+
+	if skip, err := beginTicket(ledger, ZendeskTicket{ID: "1"}); err != nil || skip == "" {
+		t.Fatalf("expected the ticket in progress to be skipped, skip=%q err=%v", skip, err)
+	}
+	if skip, err := beginTicket(ledger, ZendeskTicket{ID: "2"}); err != nil || skip != "" {
+		t.Fatalf("expected a new ticket to be claimed, skip=%q err=%v", skip, err)
+	}
+	entries, err := ledger.List()
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("expected two entries, got %d (%v)", len(entries), err)
+	}
+	if _, ok := objects["ledger/2.json"]; !ok {
+		t.Fatalf("expected one object per ticket, got %v", objects)
+	}
+}
+
+func TestValidateLedgerConfig(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+	t.Setenv("LEDGER_BACKEND", "")
+	t.Setenv("LEDGER_DIR", "")
+
+	if err := validateLedgerConfig(); err == nil {
+		t.Fatal("expected production mode without a shared ledger to be rejected")
+	}
+	if _, err := openLedger(); err == nil {
+		t.Fatal("expected openLedger to refuse the instance-local ledger in production")
+	}
+
+	t.Setenv("LEDGER_DIR", "/mnt/shared/ledger")
+	if err := validateLedgerConfig(); err != nil {
+		t.Fatalf("expected an explicit LEDGER_DIR to be accepted, got %v", err)
+	}
+
+	t.Setenv("LEDGER_DIR", "")
+	t.Setenv("LEDGER_BACKEND", "gcs")
+	if err := validateLedgerConfig(); err != nil {
+		t.Fatalf("expected the gcs backend to be accepted, got %v", err)
+	}
+
+	t.Setenv("APP_ENV", "development")
+	t.Setenv("LEDGER_BACKEND", "")
+	if err := validateLedgerConfig(); err != nil {
+		t.Fatalf("development mode must not require a shared ledger, got %v", err)
+	}
+}
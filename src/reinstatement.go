@@ -0,0 +1,335 @@
+package tco_vo_agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// decisionTagReinstated marks orders whose measures were undone.
+const decisionTagReinstated = "tco-vo-decision-reinstated"
+
+// reinstateTagPrefix followed by a reason, e.g. tco-vo-reinstate-order-withdrawn,
+// lets a moderator reinstate what was removed for the order of a ticket.
+const reinstateTagPrefix = "tco-vo-reinstate-"
+
+// reinstatementReason is why the measures of an order are undone.
+type reinstatementReason string
+
+const (
+	// reinstateComplaintUpheld follows a complaint of the content provider (Article 10).
+	reinstateComplaintUpheld reinstatementReason = "complaint_upheld"
+	reinstateOrderWithdrawn  reinstatementReason = "order_withdrawn"
+	// reinstateOrderAnnulled follows a scrutiny or judicial review of the order (Articles 4 and 9).
+	reinstateOrderAnnulled reinstatementReason = "order_annulled"
+)
+
+var reinstatementReasons = []reinstatementReason{reinstateComplaintUpheld, reinstateOrderWithdrawn, reinstateOrderAnnulled}
+
+var reinstateUsersFn = ReinstateUsers
+
+var (
+	errAlreadyReinstated = errors.New("the order was already reinstated")
+	errReinstating       = errors.New("the order is still being reinstated")
+)
+
+// reinstatementClaim is how long a request may take to reinstate an order
+// before another request assumes it was interrupted and takes over.
+const reinstatementClaim = 10 * time.Minute
+
+// reinstatementRecord keeps who reinstated an order, why and with what result.
+type reinstatementRecord struct {
+	Reason       reinstatementReason `json:"reason"`
+	RequestedBy  string              `json:"requestedBy"`
+	Note         string              `json:"note,omitempty"`
+	RequestedAt  time.Time           `json:"requestedAt"`
+	ReinstatedAt time.Time           `json:"reinstatedAt,omitempty"`
+	Reinstated   []FraudDecision     `json:"reinstated,omitempty"`
+	Failed       []FraudDecision     `json:"failed,omitempty"`
+	// ClaimedAt is set while a request is reinstating the order.
+	ClaimedAt time.Time `json:"claimedAt,omitempty"`
+}
+
+// pending returns the decisions of the removal that were not reinstated yet.
+func (r *reinstatementRecord) pending(removal *removalRecord) []removedDecision {
+	done := map[string]bool{}
+	for _, decision := range r.Reinstated {
+		done[formatIdentifiers(decision)] = true
+	}
+	var pending []removedDecision
+	for _, decision := range removal.Decisions {
+		if !done[formatIdentifiers(decision.FraudDecision)] {
+			pending = append(pending, decision)
+		}
+	}
+	return pending
+}
+
+type reinstatementRequest struct {
+	TicketID  string `json:"ticketId"`
+	Reason    string `json:"reason"`
+	Moderator string `json:"moderator"`
+	Note      string `json:"note,omitempty"`
+}
+
+// parseReinstatementReason accepts the reasons in snake or kebab case.
+func parseReinstatementReason(raw string) (reinstatementReason, bool) {
+	normalized := strings.NewReplacer("-", "_", " ", "_").Replace(strings.ToLower(strings.TrimSpace(raw)))
+	for _, reason := range reinstatementReasons {
+		if normalized == string(reason) {
+			return reason, true
+		}
+	}
+	return "", false
+}
+
+// describe returns the wording of the reason used in the reply to the authority.
+func (r reinstatementReason) describe() string {
+	switch r {
+	case reinstateComplaintUpheld:
+		return "the decision to uphold the complaint of the content provider (Article 10)"
+	case reinstateOrderWithdrawn:
+		return "the withdrawal of the removal order"
+	case reinstateOrderAnnulled:
+		return "the annulment of the removal order on review"
+	default:
+		return "a review of the removal order"
+	}
+}
+
+// tag is the moderator tag that selects the reason.
+func (r reinstatementReason) tag() string {
+	return reinstateTagPrefix + strings.ReplaceAll(string(r), "_", "-")
+}
+
+// reinstateTagReason returns the reason of the first reinstate tag of a ticket.
+func reinstateTagReason(tags []string) (reinstatementReason, bool) {
+	for _, tag := range tags {
+		if raw, ok := strings.CutPrefix(tag, reinstateTagPrefix); ok {
+			if reason, ok := parseReinstatementReason(raw); ok {
+				return reason, true
+			}
+		}
+	}
+	return "", false
+}
+
+// handleReinstateTags reinstates the order of a ticket tagged
+// tco-vo-reinstate-<reason>, then removes the tag.
+func handleReinstateTags(ticket ZendeskTicket) error {
+	reason, _ := reinstateTagReason(ticket.Tags)
+	_, err := reinstateTicket(ticket.ID, reason, "Zendesk tag", "")
+	switch {
+	case errors.Is(err, errAlreadyReinstated):
+		// our own tag updates arrive while the reinstate tag is still present
+		log.Printf("Ignoring %s tag on ticket %s: %v", reason.tag(), ticket.ID, err)
+	case errors.Is(err, errNoRemoval):
+		log.Printf("Ignoring %s tag on ticket %s: %v", reason.tag(), ticket.ID, err)
+		if err := addInternalNoteFn(ticket.ID, fmt.Sprintf("%s Nothing was reinstated: %v.", agentNotePrefix, err)); err != nil {
+			log.Printf("Error adding internal note to ticket %s: %v", ticket.ID, err)
+		}
+	case err != nil:
+		return err
+	}
+
+	if err := untagTicketFn(ticket.ID, []string{reason.tag()}); err != nil {
+		log.Printf("Error removing %s tag from ticket %s: %v", reason.tag(), ticket.ID, err)
+	}
+	return nil
+}
+
+// reinstateTicket undoes the measures recorded for the order of a ticket,
+// tags the ticket, answers the authority and sends the result to Slack. An
+// order is reinstated once; a repeated request only retries the accounts that
+// failed before. When the Finya API cannot be reached the claim is released so
+// the request can be repeated, and a claim older than reinstatementClaim is
+// taken over, since its request was interrupted.
+func reinstateTicket(ticketID string, reason reinstatementReason, moderator string, note string) (*processResult, error) {
+	ledger, err := openLedgerFn()
+	if err != nil {
+		return nil, fmt.Errorf("opening processing ledger: %w", err)
+	}
+
+	var decisions []removedDecision
+	_, err = ledger.Update(ticketID, func(entry *ledgerEntry) (*ledgerEntry, error) {
+		if entry == nil || entry.Removal == nil {
+			return nil, errNoRemoval
+		}
+		now := nowFn().UTC()
+		record := entry.Reinstatement
+		switch {
+		case record == nil:
+			record = &reinstatementRecord{Reason: reason, RequestedBy: moderator, Note: note, RequestedAt: now}
+		case !record.ClaimedAt.IsZero() && now.Sub(record.ClaimedAt) < reinstatementClaim:
+			return nil, errReinstating
+		case record.ClaimedAt.IsZero() && len(record.Failed) == 0:
+			return nil, errAlreadyReinstated
+		}
+		decisions = record.pending(entry.Removal)
+		if len(decisions) == 0 {
+			return nil, errAlreadyReinstated
+		}
+		record.ClaimedAt = now
+		entry.Reinstatement = record
+		return entry, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reinstated, failed, err := reinstateUsersFn(decisions, reason)
+	if err != nil {
+		_, releaseErr := ledger.Update(ticketID, func(entry *ledgerEntry) (*ledgerEntry, error) {
+			if entry == nil || entry.Reinstatement == nil {
+				return nil, errLedgerSkip
+			}
+			if len(entry.Reinstatement.Reinstated) == 0 && len(entry.Reinstatement.Failed) == 0 {
+				// nothing happened yet, so the next request starts afresh
+				entry.Reinstatement = nil
+			} else {
+				entry.Reinstatement.ClaimedAt = time.Time{}
+			}
+			return entry, nil
+		})
+		if releaseErr != nil && !errors.Is(releaseErr, errLedgerSkip) {
+			log.Printf("Error releasing reinstatement of ticket %s: %v", ticketID, releaseErr)
+		}
+		return nil, fmt.Errorf("reinstating order: %w", err)
+	}
+
+	now := nowFn().UTC()
+	_, err = ledger.Update(ticketID, func(entry *ledgerEntry) (*ledgerEntry, error) {
+		if entry == nil || entry.Reinstatement == nil {
+			return nil, errLedgerSkip
+		}
+		entry.Reinstatement.ReinstatedAt = now
+		entry.Reinstatement.ClaimedAt = time.Time{}
+		// the retried accounts either succeeded now or failed again
+		entry.Reinstatement.Failed = nil
+		for _, data := range reinstated {
+			entry.Reinstatement.Reinstated = append(entry.Reinstatement.Reinstated, data.Data)
+		}
+		for _, data := range failed {
+			entry.Reinstatement.Failed = append(entry.Reinstatement.Failed, data.Data)
+		}
+		return entry, nil
+	})
+	if err != nil && !errors.Is(err, errLedgerSkip) {
+		log.Printf("Error recording reinstatement of ticket %s: %v", ticketID, err)
+	}
+	if reason == reinstateComplaintUpheld {
+		// counted in the transparency report like a tagged complaint
+		complaint := ZendeskTicket{ID: ticketID, Tags: []string{complaintTag, complaintUpheldTag}}
+		if err := recordComplaintTags(ledger, complaint); err != nil {
+			log.Printf("Error recording complaint of ticket %s: %v", ticketID, err)
+		}
+	}
+	recordAudit(ticketID, auditReinstated, map[string]interface{}{
+		"reason":     reason,
+		"moderator":  moderator,
+		"note":       note,
+		"reinstated": len(reinstated),
+		"failed":     len(failed),
+	})
+
+	for i := range reinstated {
+		reinstated[i].Reason = reason.describe()
+	}
+	result := &processResult{
+		TicketID:      ticketID,
+		Reinstated:    reinstated,
+		Failed:        failed,
+		ReinstatedFor: reason,
+		DecidedBy:     moderator,
+	}
+	defer func() {
+		if err := notifySlackFn(*result); err != nil {
+			log.Printf("Error sending Slack notification: %v", err)
+		}
+	}()
+
+	if len(failed) > 0 {
+		result.recordError(fmt.Errorf("%d of %d accounts could not be reinstated", len(failed), len(decisions)), "reinstating order")
+		tagTickets(failed, decisionTagError)
+		var lines []string
+		for _, data := range failed {
+			lines = append(lines, fmt.Sprintf("- %s: %s", formatIdentifiers(data.Data), data.Reason))
+		}
+		message := fmt.Sprintf("%s The Finya API could not reinstate these accounts. Add the %s tag again to retry them, or reinstate them manually:\n%s", agentNotePrefix, reason.tag(), strings.Join(lines, "\n"))
+		if err := addInternalNoteFn(ticketID, message); err != nil {
+			log.Printf("Error adding internal note to ticket %s: %v", ticketID, err)
+		}
+	}
+	if len(reinstated) > 0 {
+		tagTickets(reinstated, decisionTagReinstated)
+		if err := replyToTicketsFn(reinstated, ReplyToTicketTemplateReinstated); err != nil {
+			log.Printf("Error replying to tickets: %v", err)
+			result.recordError(err, "replying to reinstated order")
+		}
+	}
+	return result, nil
+}
+
+// HandleReinstatement reinstates the order of a ticket on behalf of a moderator.
+func HandleReinstatement(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := validateBearerToken(r); err != nil {
+		log.Printf("Error validating bearer token: %v", err)
+		http.Error(w, "Invalid bearer token", http.StatusUnauthorized)
+		return
+	}
+
+	var request reinstatementRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid reinstatement request", http.StatusBadRequest)
+		return
+	}
+	reason, ok := parseReinstatementReason(request.Reason)
+	if !ok {
+		http.Error(w, "reason must be complaint_upheld, order_withdrawn or order_annulled", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(request.TicketID) == "" || strings.TrimSpace(request.Moderator) == "" {
+		http.Error(w, "ticketId and moderator are required", http.StatusBadRequest)
+		return
+	}
+
+	result, err := reinstateTicket(request.TicketID, reason, request.Moderator, request.Note)
+	switch {
+	case errors.Is(err, errNoRemoval):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, errAlreadyReinstated):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, errReinstating):
+		w.Header().Set("Retry-After", strconv.Itoa(int(reinstatementClaim/time.Second)))
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		log.Printf("Error reinstating ticket %s: %v", request.TicketID, err)
+		http.Error(w, "Error reinstating order", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"ticketId":   request.TicketID,
+		"reason":     reason,
+		"reinstated": len(result.Reinstated),
+		"failed":     len(result.Failed),
+	}
+	if result.Error != nil {
+		response["error"] = result.Error.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,222 @@
+package tco_vo_agent
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordRemoval stores a removal for the ticket, as executeDecisions does.
+func recordRemoval(t *testing.T, ticketID string, decisions ...removedDecision) {
+	t.Helper()
+	_, err := (&fileLedger{dir: stateDir("LEDGER_DIR", "ledger")}).Update(ticketID, func(entry *ledgerEntry) (*ledgerEntry, error) {
+		return &ledgerEntry{TicketID: ticketID, State: ledgerStateCompleted, Removal: &removalRecord{Decisions: decisions, RemovedAt: time.Now().UTC()}}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReinstateUsersUndoesEachMeasure(t *testing.T) {
+	t.Setenv("FINYA_API_KEY", "finya-key")
+	var items []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tco/reinstate" {
+			http.NotFound(w, r)
+			return
+		}
+		var body struct {
+			Reason string                   `json:"reason"`
+			Users  []map[string]interface{} `json:"users"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		items = body.Users
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "data": map[string]interface{}{
+			"reinstated": []map[string]string{{"correlationId": body.Users[0]["correlationId"].(string)}},
+			"not_found":  []map[string]string{{"correlationId": body.Users[1]["correlationId"].(string)}},
+		}})
+	}))
+	defer server.Close()
+	t.Setenv("FINYA_API_URL", server.URL)
+
+	decisions := []removedDecision{
+		{FraudDecision: FraudDecision{TicketID: "1", Username: "jane", FinyaUserID: "501"}},
+		{FraudDecision: FraudDecision{TicketID: "1", Username: "joe", ContentItems: []ContentItem{{MessageID: "m-1"}}}, Measure: measureRemoveContent},
+	}
+	reinstated, failed, err := ReinstateUsers(decisions, reinstateOrderWithdrawn)
+	if err != nil {
+		t.Fatalf("ReinstateUsers returned error: %v", err)
+	}
+	if items[0]["measure"] != string(measureBanAccount) || items[1]["measure"] != string(measureRemoveContent) || len(items[1]["contentIds"].([]interface{})) != 1 {
+		t.Fatalf("unexpected request items: %+v", items)
+	}
+	if len(reinstated) != 1 || reinstated[0].Data.FinyaUserID != "501" {
+		t.Fatalf("expected jane to be reinstated, got %+v", reinstated)
+	}
+	if len(failed) != 1 || failed[0].Data.Username != "joe" || !strings.Contains(failed[0].Reason, "no longer exists") {
+		t.Fatalf("expected the missing message to fail, got %+v", failed)
+	}
+}
+
+func TestReinstateTagUndoesTheOrder(t *testing.T) {
+	stubs := stubApprovalPipeline(t)
+	origReinstate := reinstateUsersFn
+	t.Cleanup(func() { reinstateUsersFn = origReinstate })
+	var calls int
+	reinstateUsersFn = func(decisions []removedDecision, reason reinstatementReason) ([]agentData, []agentData, error) {
+		calls++
+		return []agentData{{Data: decisions[0].FraudDecision}}, nil, nil
+	}
+	recordRemoval(t, "7", removedDecision{FraudDecision: FraudDecision{TicketID: "7", Username: "jane", AgencyName: "Bundeskriminalamt", ReferenceNumber: "REF-1"}})
+
+	tags := []string{decisionTagBanned, reinstateComplaintUpheld.tag()}
+	for i := 0; i < 2; i++ {
+		// the second run is the update caused by our own tags
+		if err := processTicketsAsync(ZendeskTicket{ID: "7", Tags: tags}); err != nil {
+			t.Fatalf("processTicketsAsync returned error: %v", err)
+		}
+	}
+	if calls != 1 || len(stubs.banned) != 0 {
+		t.Fatalf("expected one reinstatement and no ban, calls=%d banned=%+v", calls, stubs.banned)
+	}
+	if len(stubs.replies) != 1 || stubs.replies[0] != ReplyToTicketTemplateReinstated || !hasTag(stubs.tags, decisionTagReinstated) {
+		t.Fatalf("expected the reinstated reply and tag, replies=%v tags=%v", stubs.replies, stubs.tags)
+	}
+	if !hasTag(stubs.untags, reinstateComplaintUpheld.tag()) {
+		t.Fatalf("expected the reinstate tag to be removed, got %v", stubs.untags)
+	}
+	text := buildSlackText(stubs.slack[0])
+	if !strings.Contains(text, "Order reinstated by Zendesk tag") || !strings.Contains(text, "*Reinstated*: username: jane") {
+		t.Fatalf("unexpected Slack text:\n%s", text)
+	}
+
+	entry, _ := (&fileLedger{dir: stateDir("LEDGER_DIR", "ledger")}).Get("7")
+	if entry.Reinstatement == nil || len(entry.Reinstatement.Reinstated) != 1 || entry.Reinstatement.ReinstatedAt.IsZero() {
+		t.Fatalf("expected the reinstatement in the ledger, got %+v", entry.Reinstatement)
+	}
+	if entry.Complaint == nil || entry.Complaint.Outcome != complaintOutcomeUpheld {
+		t.Fatalf("expected the complaint to be upheld, got %+v", entry.Complaint)
+	}
+
+	message, err := buildTicketMessage(ReplyToTicketTemplateReinstated, []agentData{{Data: entry.Reinstatement.Reinstated[0], Reason: reinstateComplaintUpheld.describe()}})
+	if err != nil || !strings.Contains(message, "Following the decision to uphold the complaint") || !strings.Contains(message, "(Ref: REF-1)") {
+		t.Fatalf("unexpected message (%v):\n%s", err, message)
+	}
+}
+
+func TestHandleReinstatement(t *testing.T) {
+	t.Setenv("BEARER_TOKEN", "secret")
+	stubs := stubApprovalPipeline(t)
+	origReinstate := reinstateUsersFn
+	t.Cleanup(func() { reinstateUsersFn = origReinstate })
+	reinstateUsersFn = func(decisions []removedDecision, reason reinstatementReason) ([]agentData, []agentData, error) {
+		return nil, nil, errors.New("connection refused")
+	}
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/reinstatements", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		ProcessTickets(rec, req)
+		return rec
+	}
+	if rec := post(`{"ticketId":"7","reason":"changed_mind","moderator":"alice"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown reason, got %d", rec.Code)
+	}
+	if rec := post(`{"ticketId":"7","reason":"order_withdrawn","moderator":"alice"}`); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without a removal, got %d", rec.Code)
+	}
+
+	recordRemoval(t, "7", removedDecision{FraudDecision: FraudDecision{TicketID: "7", Username: "jane"}})
+	if rec := post(`{"ticketId":"7","reason":"order_withdrawn","moderator":"alice"}`); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 when the Finya API fails, got %d", rec.Code)
+	}
+
+	// the failed attempt must not block a retry
+	reinstateUsersFn = func(decisions []removedDecision, reason reinstatementReason) ([]agentData, []agentData, error) {
+		failed := agentData{Data: decisions[0].FraudDecision, Reason: "account was deleted"}
+		return nil, []agentData{failed}, nil
+	}
+	rec := post(`{"ticketId":"7","reason":"order-annulled","moderator":"alice"}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"failed":1`) {
+		t.Fatalf("expected the failed account to be reported, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(stubs.replies) != 0 || !hasTag(stubs.tags, decisionTagError) || !strings.Contains(stubs.notes[0], "account was deleted") {
+		t.Fatalf("expected only the error tag and a note, replies=%v tags=%v notes=%q", stubs.replies, stubs.tags, stubs.notes)
+	}
+	if !strings.Contains(buildSlackText(stubs.slack[0]), "*Reinstatement failed*: username: jane: account was deleted") {
+		t.Fatalf("unexpected Slack text:\n%s", buildSlackText(stubs.slack[0]))
+	}
+
+	// a second request retries only the account that failed
+	var retried []removedDecision
+	reinstateUsersFn = func(decisions []removedDecision, reason reinstatementReason) ([]agentData, []agentData, error) {
+		retried = decisions
+		return []agentData{{Data: decisions[0].FraudDecision}}, nil, nil
+	}
+	recordRemoval(t, "8", removedDecision{FraudDecision: FraudDecision{TicketID: "8", Username: "joe"}}, removedDecision{FraudDecision: FraudDecision{TicketID: "8", Username: "ann"}})
+	ledger := &fileLedger{dir: stateDir("LEDGER_DIR", "ledger")}
+	_, err := ledger.Update("8", func(entry *ledgerEntry) (*ledgerEntry, error) {
+		entry.Reinstatement = &reinstatementRecord{
+			Reason:       reinstateOrderAnnulled,
+			ReinstatedAt: time.Now().UTC(),
+			Reinstated:   []FraudDecision{{TicketID: "8", Username: "joe"}},
+			Failed:       []FraudDecision{{TicketID: "8", Username: "ann"}},
+		}
+		return entry, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to prepare ledger: %v", err)
+	}
+	if rec := post(`{"ticketId":"8","reason":"order_annulled","moderator":"alice"}`); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"reinstated":1`) {
+		t.Fatalf("expected the failed account to be retried, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(retried) != 1 || retried[0].Username != "ann" {
+		t.Fatalf("expected only the failed account to be retried, got %+v", retried)
+	}
+	entry, _ := ledger.Get("8")
+	if len(entry.Reinstatement.Reinstated) != 2 || len(entry.Reinstatement.Failed) != 0 {
+		t.Fatalf("expected both accounts to be reinstated, got %+v", entry.Reinstatement)
+	}
+	if rec := post(`{"ticketId":"8","reason":"order_annulled","moderator":"alice"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 once everything was reinstated, got %d", rec.Code)
+	}
+}
+
+func TestReinstatementTakesOverStaleClaim(t *testing.T) {
+	stubApprovalPipeline(t)
+	origReinstate := reinstateUsersFn
+	t.Cleanup(func() { reinstateUsersFn = origReinstate })
+	calls := 0
+	reinstateUsersFn = func(decisions []removedDecision, reason reinstatementReason) ([]agentData, []agentData, error) {
+		calls++
+		return []agentData{{Data: decisions[0].FraudDecision}}, nil, nil
+	}
+
+	// a request claimed the order, then its instance stopped before the Finya call
+	recordRemoval(t, "9", removedDecision{FraudDecision: FraudDecision{TicketID: "9", Username: "jane"}})
+	ledger := &fileLedger{dir: stateDir("LEDGER_DIR", "ledger")}
+	claimedAt := time.Now().UTC()
+	_, err := ledger.Update("9", func(entry *ledgerEntry) (*ledgerEntry, error) {
+		entry.Reinstatement = &reinstatementRecord{Reason: reinstateOrderWithdrawn, RequestedAt: claimedAt, ClaimedAt: claimedAt}
+		return entry, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to prepare ledger: %v", err)
+	}
+
+	if _, err := reinstateTicket("9", reinstateOrderWithdrawn, "alice", ""); !errors.Is(err, errReinstating) {
+		t.Fatalf("expected the running reinstatement to be left alone, got %v", err)
+	}
+	origNow := nowFn
+	t.Cleanup(func() { nowFn = origNow })
+	nowFn = func() time.Time { return claimedAt.Add(reinstatementClaim + time.Minute) }
+	result, err := reinstateTicket("9", reinstateOrderWithdrawn, "alice", "")
+	if err != nil || len(result.Reinstated) != 1 || calls != 1 {
+		t.Fatalf("expected the stale claim to be taken over, got %+v, %v (calls=%d)", result, err, calls)
+	}
+}
@@ -0,0 +1,135 @@
+package tco_vo_agent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Slack action IDs of the approval buttons.
+const (
+	slackActionApprove     = "tco_approve"
+	slackActionReject      = "tco_reject"
+	slackActionRequestInfo = "tco_request_info"
+)
+
+// buildSlackBlocks renders the result as Block Kit blocks. Proposals awaiting
+// approval get buttons that are handled by HandleSlackInteraction.
+func buildSlackBlocks(result processResult) []map[string]interface{} {
+	lines := strings.Split(buildSlackText(result), "\n")
+	blocks := []map[string]interface{}{
+		slackSection(lines[0]),
+	}
+
+	if order, ok := firstDecision(result); ok {
+		fields := []map[string]interface{}{
+			slackField("Agency", fallbackValue(order.Data.AgencyName, "N/A")),
+			slackField("Reference", fallbackValue(order.Data.ReferenceNumber, "N/A")),
+			slackField("Order date", fallbackValue(order.Data.Date, "not provided")),
+		}
+		if result.PendingApproval || len(result.Review) > 0 {
+			fields = append(fields, slackField("Deadline", formatDeadline(result.deadlineElapsed(), nowFn())))
+		} else if result.Elapsed > 0 {
+			fields = append(fields, slackField("Elapsed", formatMinutes(result.Elapsed)))
+		}
+		blocks = append(blocks, map[string]interface{}{"type": "section", "fields": fields})
+	}
+
+	failedTitle := "Ban failed"
+	if result.ReinstatedFor != "" {
+		failedTitle = "Reinstatement failed"
+	}
+	for _, bucket := range []struct {
+		title     string
+		decisions []agentData
+	}{
+		{"Proposed bans", result.Proposed},
+		{"Banned", result.Banned},
+		{"Reinstated", result.Reinstated},
+		{failedTitle, result.Failed},
+		{"Content removed", result.Removed},
+		{"Access disabled", result.Disabled},
+		{"Not found", result.NotFound},
+		{"Need more info", result.MoreInfo},
+		{"Impossible to comply", result.Impossible},
+	} {
+		if len(bucket.decisions) == 0 {
+			continue
+		}
+		var entries []string
+		for _, decision := range bucket.decisions {
+			entry := "• " + formatIdentifiers(decision.Data)
+			if decision.Reason != "" {
+				entry += " – " + decision.Reason
+			}
+			entries = append(entries, entry)
+		}
+		blocks = append(blocks, slackSection(fmt.Sprintf("*%s*\n%s", bucket.title, strings.Join(entries, "\n"))))
+	}
+	if len(result.Review) > 0 {
+		blocks = append(blocks, slackSection("*Needs review*: "+summarizeReview(result.Review)))
+	}
+
+	if result.PendingApproval {
+		blocks = append(blocks, map[string]interface{}{
+			"type":     "actions",
+			"block_id": "tco_approval",
+			"elements": []map[string]interface{}{
+				slackButton(slackActionApprove, "Approve", result.TicketID, "primary"),
+				slackButton(slackActionReject, "Reject", result.TicketID, "danger"),
+				slackButton(slackActionRequestInfo, "Request info", result.TicketID, ""),
+			},
+		})
+	}
+
+	context := fmt.Sprintf("Ticket %s", fallbackValue(result.TicketID, "unknown"))
+	if subject := strings.TrimSpace(result.Subject); subject != "" {
+		context += " · " + subject
+	}
+	blocks = append(blocks, map[string]interface{}{
+		"type":     "context",
+		"elements": []map[string]interface{}{{"type": "mrkdwn", "text": context}},
+	})
+	return blocks
+}
+
+// firstDecision returns a decision carrying the order details shared by all accounts.
+func firstDecision(result processResult) (agentData, bool) {
+	for _, bucket := range [][]agentData{result.Proposed, result.Banned, result.Reinstated, result.Failed, result.Removed, result.Disabled, result.NotFound, result.MoreInfo, result.Impossible, result.Review} {
+		if len(bucket) > 0 {
+			return bucket[0], true
+		}
+	}
+	return agentData{}, false
+}
+
+func slackSection(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "section",
+		"text": map[string]interface{}{"type": "mrkdwn", "text": text},
+	}
+}
+
+func slackField(label, value string) map[string]interface{} {
+	return map[string]interface{}{"type": "mrkdwn", "text": fmt.Sprintf("*%s*\n%s", label, value)}
+}
+
+func slackButton(actionID, label, ticketID, style string) map[string]interface{} {
+	button := map[string]interface{}{
+		"type":      "button",
+		"action_id": actionID,
+		"text":      map[string]interface{}{"type": "plain_text", "text": label},
+		"value":     ticketID,
+	}
+	if style != "" {
+		button["style"] = style
+	}
+	if actionID == slackActionApprove {
+		button["confirm"] = map[string]interface{}{
+			"title":   map[string]interface{}{"type": "plain_text", "text": "Carry out the order?"},
+			"text":    map[string]interface{}{"type": "mrkdwn", "text": "The accounts will be banned and the authority will be notified."},
+			"confirm": map[string]interface{}{"type": "plain_text", "text": "Approve"},
+			"deny":    map[string]interface{}{"type": "plain_text", "text": "Cancel"},
+		}
+	}
+	return button
+}
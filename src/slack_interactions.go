@@ -0,0 +1,230 @@
+package tco_vo_agent
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	slackSignatureHeader = "X-Slack-Signature"
+	slackTimestampHeader = "X-Slack-Request-Timestamp"
+)
+
+var (
+	handleSlackActionFn = handleSlackAction
+	decideApprovalFn    = decideApprovalJob
+	postSlackMessageFn  = postSlackMessage
+)
+
+// slackInteraction is the part of a Slack block_actions payload we use.
+type slackInteraction struct {
+	Type string `json:"type"`
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+		Name     string `json:"name"`
+	} `json:"user"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+	ResponseURL string `json:"response_url"`
+}
+
+// slackActionJob is a moderator's button click waiting in the job queue.
+type slackActionJob struct {
+	Interaction slackInteraction `json:"interaction"`
+	Decision    approvalDecision `json:"decision"`
+}
+
+// HandleSlackInteraction receives the approval buttons of our Slack messages.
+// The decision is persisted as a job before responding and carried out by the
+// worker; its outcome is posted back to the message, because Slack expects an
+// answer within three seconds.
+func HandleSlackInteraction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := verifySlackSignature(r, body); err != nil {
+		log.Printf("Error verifying Slack signature: %v", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "Invalid interaction payload", http.StatusBadRequest)
+		return
+	}
+	var interaction slackInteraction
+	if err := json.Unmarshal([]byte(form.Get("payload")), &interaction); err != nil {
+		http.Error(w, "Invalid interaction payload", http.StatusBadRequest)
+		return
+	}
+	if interaction.Type != "block_actions" || len(interaction.Actions) == 0 {
+		// nothing for us, but Slack must not retry
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	action := interaction.Actions[0]
+	decision, ok := slackActionDecisions[action.ActionID]
+	if !ok || strings.TrimSpace(action.Value) == "" {
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+	if !isSlackModerator(interaction.User.ID) {
+		log.Printf("Slack user %s is not allowed to decide on ticket %s", interaction.User.ID, action.Value)
+		text := "You are not allowed to decide on TCO orders."
+		if len(slackModerators()) == 0 {
+			text = "Decisions from Slack are disabled until SLACK_MODERATORS is configured; please decide in Zendesk."
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"response_type":    "ephemeral",
+			"replace_original": false,
+			"text":             text,
+		})
+		return
+	}
+
+	job := newTicketJob(ZendeskTicket{ID: action.Value})
+	job.SlackAction = &slackActionJob{Interaction: interaction, Decision: decision}
+	if err := enqueueJob(job); err != nil {
+		log.Printf("Error enqueuing Slack decision for ticket %s: %v", action.Value, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"response_type":    "ephemeral",
+			"replace_original": false,
+			"text":             fmt.Sprintf("Ticket %s: the decision could not be recorded, please try again.", action.Value),
+		})
+		return
+	}
+
+	processQueuedJobs()
+	w.WriteHeader(http.StatusOK)
+}
+
+var slackActionDecisions = map[string]approvalDecision{
+	slackActionApprove:     approvalApprove,
+	slackActionReject:      approvalReject,
+	slackActionRequestInfo: approvalRequestInfo,
+}
+
+// handleSlackAction carries out a queued button click and updates the
+// original message so the buttons cannot be used twice. It returns an error
+// when the decision should be retried by the job queue.
+func handleSlackAction(jobID string, interaction slackInteraction, ticketID string, decision approvalDecision) error {
+	moderator := fmt.Sprintf("%s (Slack %s)", fallbackValue(interaction.User.Username, interaction.User.Name), interaction.User.ID)
+
+	reply := map[string]interface{}{"replace_original": true}
+	result, err := decideApprovalFn(jobID, ticketID, decision, moderator, "", "")
+	var retryErr error
+	switch {
+	case errors.Is(err, errNoPendingApproval), errors.Is(err, errApprovalDecided):
+		reply = map[string]interface{}{
+			"response_type":    "ephemeral",
+			"replace_original": false,
+			"text":             fmt.Sprintf("Ticket %s: %v", ticketID, err),
+		}
+	case err != nil:
+		log.Printf("Error deciding approval for ticket %s from Slack: %v", ticketID, err)
+		retryErr = fmt.Errorf("deciding approval from Slack: %w", err)
+		reply = map[string]interface{}{
+			"response_type":    "ephemeral",
+			"replace_original": false,
+			"text":             fmt.Sprintf("Ticket %s: the decision could not be carried out yet and is retried automatically.", ticketID),
+		}
+	default:
+		reply["text"] = buildSlackText(*result)
+		reply["blocks"] = buildSlackBlocks(*result)
+	}
+
+	if interaction.ResponseURL != "" {
+		if err := postSlackMessageFn(interaction.ResponseURL, reply); err != nil {
+			log.Printf("Error answering Slack interaction for ticket %s: %v", ticketID, err)
+		}
+	}
+	return retryErr
+}
+
+// verifySlackSignature checks Slack's v0 request signature, an HMAC-SHA256 of
+// "v0:<timestamp>:<body>" with the app's signing secret, and rejects stale requests.
+func verifySlackSignature(r *http.Request, body []byte) error {
+	secret := strings.TrimSpace(os.Getenv("SLACK_SIGNING_SECRET"))
+	if secret == "" {
+		return errors.New("SLACK_SIGNING_SECRET is not set")
+	}
+
+	signature := r.Header.Get(slackSignatureHeader)
+	timestamp := r.Header.Get(slackTimestampHeader)
+	if signature == "" || timestamp == "" {
+		return errors.New("missing Slack signature headers")
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid Slack request timestamp %q", timestamp)
+	}
+	age := nowFn().Sub(time.Unix(seconds, 0))
+	if age < 0 {
+		age = -age
+	}
+	if age > defaultWebhookMaxAge {
+		return fmt.Errorf("Slack request timestamp %s is outside the %s replay window", timestamp, defaultWebhookMaxAge)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:", timestamp)
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return errors.New("invalid Slack signature")
+	}
+	return nil
+}
+
+// isSlackModerator reports whether the Slack user is listed in SLACK_MODERATORS.
+// Nobody may decide from Slack while the list is unset.
+func isSlackModerator(userID string) bool {
+	for _, id := range slackModerators() {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// slackModerators reads SLACK_MODERATORS, a comma-separated list of Slack user IDs.
+func slackModerators() []string {
+	var ids []string
+	for _, id := range strings.Split(os.Getenv("SLACK_MODERATORS"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,231 @@
+package tco_vo_agent
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signedSlackRequest(t *testing.T, secret string, at time.Time, payload string) *http.Request {
+	t.Helper()
+	body := url.Values{"payload": {payload}}.Encode()
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+
+	req := httptest.NewRequest(http.MethodPost, "/slack/interactions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(slackTimestampHeader, timestamp)
+	req.Header.Set(slackSignatureHeader, "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestHandleSlackInteraction(t *testing.T) {
+	t.Setenv("SLACK_SIGNING_SECRET", "slack-secret")
+	now := time.Date(2025, 1, 8, 10, 30, 0, 0, time.UTC)
+	origNow := nowFn
+	origHandle := handleSlackActionFn
+	t.Cleanup(func() {
+		nowFn = origNow
+		handleSlackActionFn = origHandle
+	})
+	nowFn = func() time.Time { return now }
+	t.Setenv("SLACK_MODERATORS", "U1")
+	t.Setenv("JOB_QUEUE_DIR", t.TempDir())
+	wait := syncDrain(t)
+
+	type call struct {
+		ticketID string
+		decision approvalDecision
+		user     string
+	}
+	calls := make(chan call, 1)
+	handleSlackActionFn = func(jobID string, interaction slackInteraction, ticketID string, decision approvalDecision) error {
+		calls <- call{ticketID, decision, interaction.User.ID}
+		return nil
+	}
+
+	payload := `{"type":"block_actions","user":{"id":"U1","username":"alice"},"actions":[{"action_id":"tco_request_info","value":"5158"}],"response_url":"https://hooks.slack.test/r"}`
+
+	rec := httptest.NewRecorder()
+	ProcessTickets(rec, signedSlackRequest(t, "slack-secret", now, payload))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	wait()
+	select {
+	case got := <-calls:
+		if got != (call{"5158", approvalRequestInfo, "U1"}) {
+			t.Fatalf("unexpected action %+v", got)
+		}
+	default:
+		t.Fatal("action was not carried out")
+	}
+	if queue, err := openJobQueue(); err != nil {
+		t.Fatalf("openJobQueue returned error: %v", err)
+	} else if left, _ := queue.Receive(); left != nil {
+		t.Fatalf("the decision must be acknowledged once carried out, got %+v", left)
+	}
+
+	for name, req := range map[string]*http.Request{
+		"wrong secret": signedSlackRequest(t, "other", now, payload),
+		"stale":        signedSlackRequest(t, "slack-secret", now.Add(-10*time.Minute), payload),
+	} {
+		rec := httptest.NewRecorder()
+		HandleSlackInteraction(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401, got %d", name, rec.Code)
+		}
+	}
+
+	t.Setenv("SLACK_MODERATORS", "U2,U3")
+	rec = httptest.NewRecorder()
+	HandleSlackInteraction(rec, signedSlackRequest(t, "slack-secret", now, payload))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "not allowed") {
+		t.Fatalf("expected an ephemeral refusal, got %d: %s", rec.Code, rec.Body.String())
+	}
+	select {
+	case got := <-calls:
+		t.Fatalf("a non-moderator must not decide, got %+v", got)
+	default:
+	}
+
+	// without a moderator list nobody decides from Slack
+	t.Setenv("SLACK_MODERATORS", "")
+	rec = httptest.NewRecorder()
+	HandleSlackInteraction(rec, signedSlackRequest(t, "slack-secret", now, payload))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "SLACK_MODERATORS") {
+		t.Fatalf("expected an ephemeral refusal, got %d: %s", rec.Code, rec.Body.String())
+	}
+	select {
+	case got := <-calls:
+		t.Fatalf("nobody may decide without SLACK_MODERATORS, got %+v", got)
+	default:
+	}
+}
+
+func TestHandleSlackInteractionRefusesUnqueuedDecision(t *testing.T) {
+	t.Setenv("SLACK_SIGNING_SECRET", "slack-secret")
+	t.Setenv("SLACK_MODERATORS", "U1")
+	now := time.Date(2025, 1, 8, 10, 30, 0, 0, time.UTC)
+	origNow := nowFn
+	origOpen := openJobQueueFn
+	origDrain := drainJobsFn
+	t.Cleanup(func() {
+		nowFn = origNow
+		openJobQueueFn = origOpen
+		drainJobsFn = origDrain
+	})
+	nowFn = func() time.Time { return now }
+	openJobQueueFn = func() (jobQueue, error) { return nil, errors.New("queue unavailable") }
+	drainJobsFn = func() int {
+		t.Error("nothing may be processed when the decision was not queued")
+		return 0
+	}
+
+	payload := `{"type":"block_actions","user":{"id":"U1","username":"alice"},"actions":[{"action_id":"tco_approve","value":"5158"}]}`
+	rec := httptest.NewRecorder()
+	HandleSlackInteraction(rec, signedSlackRequest(t, "slack-secret", now, payload))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "could not be recorded") {
+		t.Fatalf("expected an ephemeral failure, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHandleSlackActionUpdatesMessage(t *testing.T) {
+	origDecide := decideApprovalFn
+	origPost := postSlackMessageFn
+	t.Cleanup(func() {
+		decideApprovalFn = origDecide
+		postSlackMessageFn = origPost
+	})
+
+	var posted []map[string]interface{}
+	postSlackMessageFn = func(url string, payload map[string]interface{}) error {
+		posted = append(posted, payload)
+		return nil
+	}
+	decided := false
+	decideApprovalFn = func(jobID string, ticketID string, decision approvalDecision, moderator string, note string, reason impossibilityReason) (*processResult, error) {
+		if ticketID == "5159" {
+			return nil, errors.New("ledger unavailable")
+		}
+		if decided {
+			return nil, errApprovalDecided
+		}
+		decided = true
+		if moderator != "alice (Slack U1)" {
+			t.Fatalf("unexpected moderator %q", moderator)
+		}
+		return &processResult{TicketID: ticketID, Decision: decision, DecidedBy: moderator, Banned: []agentData{{Data: FraudDecision{Username: "jane"}}}}, nil
+	}
+
+	var interaction slackInteraction
+	interaction.User.ID = "U1"
+	interaction.User.Username = "alice"
+	interaction.ResponseURL = "https://hooks.slack.test/r"
+
+	if err := handleSlackAction("job-1", interaction, "5158", approvalApprove); err != nil {
+		t.Fatalf("handleSlackAction returned error: %v", err)
+	}
+	if err := handleSlackAction("job-2", interaction, "5158", approvalApprove); err != nil {
+		t.Fatalf("a decided ticket must not be retried, got %v", err)
+	}
+	if err := handleSlackAction("job-3", interaction, "5159", approvalApprove); err == nil {
+		t.Fatal("expected an error so the job queue retries the decision")
+	}
+
+	if len(posted) != 3 {
+		t.Fatalf("expected three responses, got %d", len(posted))
+	}
+	if posted[0]["replace_original"] != true || !strings.Contains(posted[0]["text"].(string), "approved by alice") {
+		t.Fatalf("expected the original message to be replaced, got %+v", posted[0])
+	}
+	encoded, _ := json.Marshal(posted[0]["blocks"])
+	if strings.Contains(string(encoded), slackActionApprove) {
+		t.Fatalf("buttons must be removed after the decision, got %s", encoded)
+	}
+	if posted[1]["response_type"] != "ephemeral" {
+		t.Fatalf("expected an ephemeral answer to the second click, got %+v", posted[1])
+	}
+	if !strings.Contains(posted[2]["text"].(string), "retried automatically") {
+		t.Fatalf("expected the moderator to be told about the retry, got %+v", posted[2])
+	}
+}
+
+func TestBuildSlackBlocksForPendingApproval(t *testing.T) {
+	origNow := nowFn
+	t.Cleanup(func() { nowFn = origNow })
+	received := time.Date(2025, 1, 8, 10, 0, 0, 0, time.UTC)
+	nowFn = func() time.Time { return received.Add(18 * time.Minute) }
+
+	result := processResult{
+		TicketID:        "5158",
+		PendingApproval: true,
+		ReceivedAt:      received,
+		Proposed: []agentData{{Data: FraudDecision{
+			Username:        "jane",
+			AgencyName:      "Bundeskriminalamt",
+			ReferenceNumber: "REF-1",
+			ContentItems:    []ContentItem{{URL: "https://finya.de/p/1"}},
+		}}},
+	}
+	encoded, err := json.Marshal(buildSlackBlocks(result))
+	if err != nil {
+		t.Fatalf("failed to marshal blocks: %v", err)
+	}
+	blocks := string(encoded)
+	for _, want := range []string{"Bundeskriminalamt", "REF-1", "https://finya.de/p/1", "42 min left (due 11:00 UTC)", slackActionApprove, slackActionReject, slackActionRequestInfo} {
+		if !strings.Contains(blocks, want) {
+			t.Fatalf("expected %q in blocks, got %s", want, blocks)
+		}
+	}
+}
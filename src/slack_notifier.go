@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 )
 
 type processResult struct {
@@ -16,10 +17,45 @@ type processResult struct {
 	Banned   []agentData
 	NotFound []agentData
 	MoreInfo []agentData
-	Error    error
+	Review   []agentData
+	// Failed holds the accounts the Finya API could not ban.
+	Failed []agentData
+	// Removed and Disabled hold the orders carried out on the content only.
+	Removed  []agentData
+	Disabled []agentData
+	// Impossible holds the decisions answered with Annex III.
+	Impossible []agentData
+	// Reinstated holds the accounts whose measures were undone, and
+	// ReinstatedFor why; Failed then holds those that could not be reinstated.
+	Reinstated    []agentData
+	ReinstatedFor reinstatementReason
+	// Proposed holds the bans awaiting a moderator in approval mode.
+	Proposed        []agentData
+	PendingApproval bool
+	// Decision and DecidedBy are set when a moderator decided on a proposal.
+	Decision  approvalDecision
+	DecidedBy string
+	// ReceivedAt is when the order arrived; the removal deadline runs from here.
+	ReceivedAt time.Time
+	// Elapsed is the deadline time used, without pauses for clarification.
+	Elapsed time.Duration
+	Error   error
 }
 
-// SendSlackNotification posts a short summary to the configured Slack webhook.
+// recordError keeps the first error of a run, prefixed with what was being done.
+func (r *processResult) recordError(err error, context string) {
+	if err == nil {
+		return
+	}
+	if context != "" {
+		err = fmt.Errorf("%s: %w", context, err)
+	}
+	if r.Error == nil {
+		r.Error = err
+	}
+}
+
+// SendSlackNotification posts a summary to the configured Slack webhook.
 // If SLACK_WEBHOOK_URL is not set, the function is a no-op.
 func SendSlackNotification(result processResult) error {
 	webhookURL := strings.TrimSpace(os.Getenv("SLACK_WEBHOOK_URL"))
@@ -27,17 +63,22 @@ func SendSlackNotification(result processResult) error {
 		return nil
 	}
 
-	text := buildSlackText(result)
-
-	payload := map[string]string{
-		"text": text,
+	// the text is the fallback for notifications and clients without Block Kit
+	payload := map[string]interface{}{
+		"text":   buildSlackText(result),
+		"blocks": buildSlackBlocks(result),
 	}
+	return postSlackMessage(webhookURL, payload)
+}
+
+// postSlackMessage posts a message payload to an incoming webhook or response URL.
+func postSlackMessage(url string, payload map[string]interface{}) error {
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
 
-	resp, err := http.Post(webhookURL, "application/json", bytes.NewBuffer(body))
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
 	if err != nil {
 		return err
 	}
@@ -55,7 +96,19 @@ func buildSlackText(result processResult) string {
 	status := ":white_check_mark: Ticket processed"
 	if result.Error != nil {
 		status = fmt.Sprintf(":warning: Ticket processing ended with errors: %v", result.Error)
-	} else if len(result.Banned)+len(result.NotFound)+len(result.MoreInfo) == 0 {
+	} else if result.ReinstatedFor != "" {
+		status = fmt.Sprintf(":recycle: Order reinstated by %s", result.DecidedBy)
+	} else if result.PendingApproval {
+		status = ":hourglass_flowing_sand: Ticket awaiting approval"
+	} else if result.Decision == approvalReject {
+		status = fmt.Sprintf(":no_entry: Proposal rejected by %s", result.DecidedBy)
+	} else if result.Decision == approvalRequestInfo {
+		status = fmt.Sprintf(":question: Clarification requested by %s", result.DecidedBy)
+	} else if result.Decision == approvalImpossible {
+		status = fmt.Sprintf(":no_entry_sign: Order declared impossible to comply with by %s", result.DecidedBy)
+	} else if result.Decision == approvalApprove {
+		status = fmt.Sprintf(":white_check_mark: Proposal approved by %s", result.DecidedBy)
+	} else if len(result.Banned)+len(result.Removed)+len(result.Disabled)+len(result.NotFound)+len(result.MoreInfo)+len(result.Impossible)+len(result.Review) == 0 {
 		status = ":information_source: Ticket processed with no actions"
 	}
 	if result.TicketID != "" {
@@ -66,12 +119,52 @@ func buildSlackText(result processResult) string {
 	if strings.TrimSpace(result.Subject) != "" {
 		lines = append(lines, fmt.Sprintf("*Subject*: %s", strings.TrimSpace(result.Subject)))
 	}
+	if result.Elapsed > 0 {
+		lines = append(lines, fmt.Sprintf("*Elapsed*: %s of %s", formatMinutes(result.Elapsed), formatMinutes(removalDeadline)))
+	}
+
+	if result.PendingApproval {
+		lines = append(lines,
+			fmt.Sprintf("*Proposed bans*: %s", summarizeDecisions(result.Proposed)),
+			fmt.Sprintf("Approve with the Zendesk tag `%s` or reject with `%s`.", approveTag, rejectTag),
+		)
+		if len(result.Review) > 0 {
+			lines = append(lines, fmt.Sprintf("*Needs review*: %s", summarizeReview(result.Review)))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	if result.ReinstatedFor != "" {
+		lines = append(lines,
+			fmt.Sprintf("*Reason*: %s", result.ReinstatedFor.describe()),
+			fmt.Sprintf("*Reinstated*: %s", summarizeDecisions(result.Reinstated)),
+		)
+		if len(result.Failed) > 0 {
+			lines = append(lines, fmt.Sprintf("*Reinstatement failed*: %s", summarizeReview(result.Failed)))
+		}
+		return strings.Join(lines, "\n")
+	}
 
 	lines = append(lines,
 		fmt.Sprintf("*Banned*: %s", summarizeDecisions(result.Banned)),
 		fmt.Sprintf("*Not found*: %s", summarizeDecisions(result.NotFound)),
 		fmt.Sprintf("*Need more info*: %s", summarizeDecisions(result.MoreInfo)),
 	)
+	if len(result.Failed) > 0 {
+		lines = append(lines, fmt.Sprintf("*Ban failed*: %s", summarizeReview(result.Failed)))
+	}
+	if len(result.Removed) > 0 {
+		lines = append(lines, fmt.Sprintf("*Content removed*: %s", summarizeDecisions(result.Removed)))
+	}
+	if len(result.Disabled) > 0 {
+		lines = append(lines, fmt.Sprintf("*Access disabled*: %s", summarizeDecisions(result.Disabled)))
+	}
+	if len(result.Impossible) > 0 {
+		lines = append(lines, fmt.Sprintf("*Impossible to comply*: %s", summarizeDecisions(result.Impossible)))
+	}
+	if len(result.Review) > 0 {
+		lines = append(lines, fmt.Sprintf("*Needs review*: %s", summarizeReview(result.Review)))
+	}
 
 	return strings.Join(lines, "\n")
 }
@@ -99,3 +192,11 @@ func summarizeDecisions(decisions []agentData) string {
 
 	return strings.Join(entries, "; ")
 }
+
+func summarizeReview(decisions []agentData) string {
+	var entries []string
+	for _, decision := range decisions {
+		entries = append(entries, fmt.Sprintf("%s: %s", formatIdentifiers(decision.Data), decision.Reason))
+	}
+	return strings.Join(entries, "; ")
+}
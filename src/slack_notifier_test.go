@@ -27,12 +27,18 @@ func TestSendSlackNotificationPostsPayload(t *testing.T) {
 		}
 		defer r.Body.Close()
 
-		var payload map[string]string
+		var payload struct {
+			Text   string            `json:"text"`
+			Blocks []json.RawMessage `json:"blocks"`
+		}
 		if err := json.Unmarshal(body, &payload); err != nil {
 			t.Fatalf("failed to unmarshal payload: %v", err)
 		}
+		if len(payload.Blocks) == 0 {
+			t.Fatalf("expected Block Kit blocks in payload, got %s", body)
+		}
 
-		receivedText = payload["text"]
+		receivedText = payload.Text
 		w.WriteHeader(http.StatusOK)
 	}))
 	defer server.Close()
@@ -0,0 +1,510 @@
+package tco_vo_agent
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tags moderators add in Zendesk when a content provider complains about a
+// removal (Article 10) and once the complaint was decided.
+const (
+	complaintTag          = "tco-vo-complaint"
+	complaintUpheldTag    = "tco-vo-complaint-upheld"
+	complaintDismissedTag = "tco-vo-complaint-dismissed"
+
+	complaintOutcomeUpheld    = "upheld"
+	complaintOutcomeDismissed = "dismissed"
+)
+
+// Sources of the transparency report.
+const (
+	ReportSourceHistory = "history"
+	ReportSourceZendesk = "zendesk"
+)
+
+// outcomeFailed counts orders whose last run failed; the other outcomes are
+// the suffixes of the decision tags.
+const outcomeFailed = "failed"
+
+var (
+	searchZendeskTicketsFn = SearchZendeskTickets
+	getTicketTagsFn        = GetTicketTags
+)
+
+// reportContentTypes lists the supported report formats.
+var reportContentTypes = map[string]string{
+	"json":     "application/json",
+	"csv":      "text/csv; charset=utf-8",
+	"markdown": "text/markdown; charset=utf-8",
+}
+
+// orderComplaint is a complaint against the action taken on an order.
+type orderComplaint struct {
+	ReceivedAt time.Time `json:"receivedAt"`
+	Outcome    string    `json:"outcome,omitempty"`
+	DecidedAt  time.Time `json:"decidedAt,omitempty"`
+}
+
+// taggedAudit is recorded when a ticket gets its decision tag, so the report
+// can count accounts and content items per decision.
+type taggedAudit struct {
+	Tags         []string `json:"tags"`
+	Accounts     int      `json:"accounts"`
+	ContentItems int      `json:"contentItems"`
+	Authority    string   `json:"authority,omitempty"`
+}
+
+func taggedAuditData(tags []string, group []agentData) taggedAudit {
+	data := taggedAudit{Tags: tags, Accounts: len(group)}
+	for _, decision := range group {
+		data.ContentItems += len(decision.Data.ContentItems)
+		if data.Authority == "" {
+			data.Authority = strings.TrimSpace(decision.Data.AgencyName)
+		}
+	}
+	return data
+}
+
+func complaintOutcome(tags []string) string {
+	switch {
+	case hasTag(tags, complaintUpheldTag):
+		return complaintOutcomeUpheld
+	case hasTag(tags, complaintDismissedTag):
+		return complaintOutcomeDismissed
+	}
+	return ""
+}
+
+// recordComplaintTags keeps the complaint of a tagged ticket in the processing
+// ledger. The first outcome tag decides the complaint.
+func recordComplaintTags(ledger ticketLedger, ticket ZendeskTicket) error {
+	outcome := complaintOutcome(ticket.Tags)
+	_, err := ledger.Update(ticket.ID, func(entry *ledgerEntry) (*ledgerEntry, error) {
+		now := nowFn().UTC()
+		if entry == nil {
+			entry = &ledgerEntry{TicketID: ticket.ID, CreatedAt: now, UpdatedAt: now}
+		}
+		if entry.Complaint != nil && (entry.Complaint.Outcome != "" || outcome == "") {
+			return nil, errLedgerSkip
+		}
+		if entry.Complaint == nil {
+			entry.Complaint = &orderComplaint{ReceivedAt: now}
+		}
+		if outcome != "" {
+			entry.Complaint.Outcome = outcome
+			entry.Complaint.DecidedAt = now
+		}
+		return entry, nil
+	})
+	if errors.Is(err, errLedgerSkip) {
+		return nil
+	}
+	return err
+}
+
+// TransparencyReport aggregates the orders received in [From, To) for the
+// yearly transparency report of Article 7.
+type TransparencyReport struct {
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	Source      string    `json:"source"`
+	// Orders is the number of removal orders received.
+	Orders            int            `json:"orders"`
+	OrdersByAuthority map[string]int `json:"ordersByAuthority,omitempty"`
+	// Outcomes counts orders per decision. An order naming several accounts
+	// counts once for every decision taken on them.
+	Outcomes map[string]int `json:"outcomes"`
+	// Accounts counts the targeted accounts per decision; only the history knows them.
+	Accounts            map[string]int  `json:"accounts,omitempty"`
+	ContentItemsRemoved int             `json:"contentItemsRemoved"`
+	ActedWithinDeadline int             `json:"actedWithinDeadline"`
+	ActedAfterDeadline  int             `json:"actedAfterDeadline"`
+	Complaints          ComplaintCounts `json:"complaints"`
+}
+
+// ComplaintCounts summarises the complaints about orders in the report period.
+type ComplaintCounts struct {
+	Received  int `json:"received"`
+	Upheld    int `json:"upheld"`
+	Dismissed int `json:"dismissed"`
+	Pending   int `json:"pending"`
+}
+
+func (c *ComplaintCounts) add(outcome string) {
+	c.Received++
+	switch outcome {
+	case complaintOutcomeUpheld:
+		c.Upheld++
+	case complaintOutcomeDismissed:
+		c.Dismissed++
+	default:
+		c.Pending++
+	}
+}
+
+// BuildTransparencyReport aggregates the orders received in [from, to), either
+// from the processing history (ledger and audit trail) or from the decision
+// tags of the Zendesk tickets.
+func BuildTransparencyReport(source string, from, to time.Time) (*TransparencyReport, error) {
+	if !to.After(from) {
+		return nil, errors.New("the report period must end after it starts")
+	}
+	if source == "" {
+		source = ReportSourceHistory
+	}
+	report := &TransparencyReport{
+		From:              from.UTC(),
+		To:                to.UTC(),
+		GeneratedAt:       nowFn().UTC(),
+		Source:            source,
+		OrdersByAuthority: map[string]int{},
+		Outcomes:          map[string]int{},
+		Accounts:          map[string]int{},
+	}
+
+	var err error
+	switch source {
+	case ReportSourceHistory:
+		err = report.addHistory()
+	case ReportSourceZendesk:
+		err = report.addZendeskTickets()
+	default:
+		err = fmt.Errorf("unsupported report source %s", source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (r *TransparencyReport) inPeriod(at time.Time) bool {
+	return !at.Before(r.From) && at.Before(r.To)
+}
+
+func (r *TransparencyReport) addHistory() error {
+	ledger, err := openLedgerFn()
+	if err != nil {
+		return fmt.Errorf("opening processing ledger: %w", err)
+	}
+	entries, err := ledger.List()
+	if err != nil {
+		return fmt.Errorf("listing processing ledger: %w", err)
+	}
+	store, err := openAuditStoreFn()
+	if err != nil {
+		return fmt.Errorf("opening audit store: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.Runs == 0 {
+			// only a complaint was recorded, the order itself was never processed
+			continue
+		}
+		receivedAt := entry.CreatedAt
+		if entry.Deadline != nil && !entry.Deadline.ReceivedAt.IsZero() {
+			receivedAt = entry.Deadline.ReceivedAt
+		}
+		if !r.inPeriod(receivedAt) {
+			continue
+		}
+
+		events, err := store.List(auditFilter{TicketID: entry.TicketID})
+		if err != nil {
+			return fmt.Errorf("listing audit events of ticket %s: %w", entry.TicketID, err)
+		}
+		// a reprocessed ticket may be tagged again; its last tagging counts
+		authority := ""
+		tagged := map[string]taggedAudit{}
+		for _, event := range events {
+			if event.Type != auditTagged {
+				continue
+			}
+			var data taggedAudit
+			if err := json.Unmarshal(event.Data, &data); err != nil {
+				return fmt.Errorf("corrupt audit event %s: %w", event.ID, err)
+			}
+			if data.Authority != "" {
+				authority = data.Authority
+			}
+			for _, tag := range data.Tags {
+				if outcome, ok := strings.CutPrefix(tag, decisionTagPrefix); ok {
+					tagged[outcome] = data
+				}
+			}
+		}
+
+		outcomes := map[string]bool{}
+		for outcome, data := range tagged {
+			outcomes[outcome] = true
+			r.Accounts[outcome] += data.Accounts
+			switch decisionTagPrefix + outcome {
+			case decisionTagBanned, decisionTagContentRemoved, decisionTagContentDisabled:
+				r.ContentItemsRemoved += data.ContentItems
+			}
+		}
+		if entry.Proposal != nil {
+			switch {
+			case entry.Proposal.Decision == approvalReject:
+				outcomes[strings.TrimPrefix(decisionTagRejected, decisionTagPrefix)] = true
+			case entry.Proposal.Decision == "" && entry.State == ledgerStateAwaitingApproval:
+				outcomes[strings.TrimPrefix(decisionTagPendingApproval, decisionTagPrefix)] = true
+			}
+		}
+		if entry.State == ledgerStateFailed {
+			outcomes[outcomeFailed] = true
+		}
+
+		r.Orders++
+		if authority == "" {
+			authority = "unknown"
+		}
+		r.OrdersByAuthority[authority]++
+		for outcome := range outcomes {
+			r.Outcomes[outcome]++
+		}
+		if deadline := entry.Deadline; deadline != nil && deadline.Outcome == deadlineOutcomeActed {
+			if deadline.elapsed(deadline.StoppedAt) <= removalDeadline {
+				r.ActedWithinDeadline++
+			} else {
+				r.ActedAfterDeadline++
+			}
+		}
+		if entry.Complaint != nil {
+			r.Complaints.add(entry.Complaint.Outcome)
+		}
+	}
+	return nil
+}
+
+// addZendeskTickets counts the tickets the agent handled in the period. Tags
+// are read per ticket because the search index may lag behind recent changes.
+func (r *TransparencyReport) addZendeskTickets() error {
+	// search dates are whole days; the exact period is applied below
+	query := fmt.Sprintf("tags:%s created>%s created<%s",
+		agentTag, r.From.AddDate(0, 0, -1).Format(time.DateOnly), r.To.AddDate(0, 0, 1).Format(time.DateOnly))
+	tickets, err := searchZendeskTicketsFn(query)
+	if err != nil {
+		return err
+	}
+
+	for _, ticket := range tickets {
+		createdAt, err := time.Parse(time.RFC3339, ticket.CreatedAt)
+		if err != nil {
+			log.Printf("Skipping ticket %s in transparency report: invalid created_at %q", ticket.ID, ticket.CreatedAt)
+			continue
+		}
+		if !r.inPeriod(createdAt) {
+			continue
+		}
+		tags, err := getTicketTagsFn(ticket.ID)
+		if err != nil {
+			return err
+		}
+
+		r.Orders++
+		for _, tag := range tags {
+			if outcome, ok := strings.CutPrefix(tag, decisionTagPrefix); ok {
+				r.Outcomes[outcome]++
+			}
+		}
+		if hasTag(tags, complaintTag) {
+			r.Complaints.add(complaintOutcome(tags))
+		}
+	}
+	return nil
+}
+
+type reportRow struct {
+	Section string
+	Metric  string
+	Value   int
+}
+
+// rows lists the figures of the report in a stable order.
+func (r *TransparencyReport) rows() []reportRow {
+	rows := []reportRow{{"Orders", "Removal orders received", r.Orders}}
+	for _, authority := range sortedKeys(r.OrdersByAuthority) {
+		rows = append(rows, reportRow{"Orders", "Orders issued by " + authority, r.OrdersByAuthority[authority]})
+	}
+	for _, outcome := range sortedKeys(r.Outcomes) {
+		rows = append(rows, reportRow{"Action taken", "Orders with outcome " + outcome, r.Outcomes[outcome]})
+	}
+	for _, outcome := range sortedKeys(r.Accounts) {
+		rows = append(rows, reportRow{"Action taken", "Accounts with outcome " + outcome, r.Accounts[outcome]})
+	}
+	return append(rows,
+		reportRow{"Action taken", "Content items removed", r.ContentItemsRemoved},
+		reportRow{"Action taken", "Orders acted on within one hour", r.ActedWithinDeadline},
+		reportRow{"Action taken", "Orders acted on after one hour", r.ActedAfterDeadline},
+		reportRow{"Complaints", "Complaints received", r.Complaints.Received},
+		reportRow{"Complaints", "Complaints upheld", r.Complaints.Upheld},
+		reportRow{"Complaints", "Complaints dismissed", r.Complaints.Dismissed},
+		reportRow{"Complaints", "Complaints pending", r.Complaints.Pending},
+	)
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Write renders the report as json, csv or markdown.
+func (r *TransparencyReport) Write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"section", "metric", "value"})
+		for _, row := range r.rows() {
+			writer.Write([]string{row.Section, row.Metric, strconv.Itoa(row.Value)})
+		}
+		writer.Flush()
+		return writer.Error()
+	case "markdown":
+		return r.writeMarkdown(w)
+	default:
+		return fmt.Errorf("unsupported report format %s", format)
+	}
+}
+
+func (r *TransparencyReport) writeMarkdown(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("# Transparency report (Regulation (EU) 2021/784, Article 7)\n\n")
+	fmt.Fprintf(&b, "- Period: %s to %s (exclusive)\n", formatReportTime(r.From), formatReportTime(r.To))
+	fmt.Fprintf(&b, "- Generated: %s from the %s\n", r.GeneratedAt.Format(time.RFC3339), map[string]string{
+		ReportSourceHistory: "processing history",
+		ReportSourceZendesk: "Zendesk decision tags",
+	}[r.Source])
+
+	section := ""
+	for _, row := range r.rows() {
+		if row.Section != section {
+			section = row.Section
+			fmt.Fprintf(&b, "\n## %s\n\n| Metric | Value |\n| --- | ---: |\n", section)
+		}
+		fmt.Fprintf(&b, "| %s | %d |\n", strings.ReplaceAll(row.Metric, "|", `\|`), row.Value)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatReportTime(t time.Time) string {
+	if t.Equal(t.Truncate(24 * time.Hour)) {
+		return t.Format(time.DateOnly)
+	}
+	return t.Format(time.RFC3339)
+}
+
+// ParseReportPeriod turns the year, from and to parameters into a period.
+// Dates may be given as YYYY-MM-DD or RFC 3339; a date given for to is
+// included. Without parameters the previous calendar year is used.
+func ParseReportPeriod(year, from, to string) (time.Time, time.Time, error) {
+	year, from, to = strings.TrimSpace(year), strings.TrimSpace(from), strings.TrimSpace(to)
+	if year != "" {
+		if from != "" || to != "" {
+			return time.Time{}, time.Time{}, errors.New("use either year or from and to")
+		}
+		y, err := strconv.Atoi(year)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid year %q", year)
+		}
+		start := time.Date(y, time.January, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(1, 0, 0), nil
+	}
+	if from == "" && to == "" {
+		start := time.Date(nowFn().UTC().Year()-1, time.January, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(1, 0, 0), nil
+	}
+	if from == "" || to == "" {
+		return time.Time{}, time.Time{}, errors.New("from and to must be given together")
+	}
+
+	start, _, err := parseReportTime(from)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid from: %w", err)
+	}
+	end, dateOnly, err := parseReportTime(to)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid to: %w", err)
+	}
+	if dateOnly {
+		end = end.AddDate(0, 0, 1)
+	}
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, errors.New("to must not be before from")
+	}
+	return start, end, nil
+}
+
+func parseReportTime(raw string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.DateOnly, raw); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	return t, false, err
+}
+
+// ExportTransparencyReport returns the transparency report. Query parameters:
+// year, or from and to; format (json, csv or markdown) and source (history or
+// zendesk).
+func ExportTransparencyReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := validateBearerToken(r); err != nil {
+		log.Printf("Error validating bearer token: %v", err)
+		http.Error(w, "Invalid bearer token", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	from, to, err := ParseReportPeriod(query.Get("year"), query.Get("from"), query.Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := strings.ToLower(strings.TrimSpace(query.Get("format")))
+	if format == "" {
+		format = "json"
+	}
+	contentType, ok := reportContentTypes[format]
+	if !ok {
+		http.Error(w, "format must be json, csv or markdown", http.StatusBadRequest)
+		return
+	}
+	source := strings.ToLower(strings.TrimSpace(query.Get("source")))
+	if source != "" && source != ReportSourceHistory && source != ReportSourceZendesk {
+		http.Error(w, "source must be history or zendesk", http.StatusBadRequest)
+		return
+	}
+
+	report, err := BuildTransparencyReport(source, from, to)
+	if err != nil {
+		log.Printf("Error building transparency report: %v", err)
+		http.Error(w, "Error building transparency report", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if err := report.Write(w, format); err != nil {
+		log.Printf("Error writing transparency report: %v", err)
+	}
+}
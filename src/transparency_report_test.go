@@ -0,0 +1,153 @@
+package tco_vo_agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransparencyReportFromHistory(t *testing.T) {
+	t.Setenv("LEDGER_DIR", t.TempDir())
+	t.Setenv("AUDIT_DIR", t.TempDir())
+	t.Setenv("BEARER_TOKEN", "secret")
+
+	origNow := nowFn
+	origGetAttachments := getAttachmentsFn
+	origExtractData := extractDataFn
+	origBanUsers := banUsersFn
+	origReply := replyToTicketFn
+	origTag := tagTicketFn
+	origNotifySlack := notifySlackFn
+	t.Cleanup(func() {
+		nowFn = origNow
+		getAttachmentsFn = origGetAttachments
+		extractDataFn = origExtractData
+		banUsersFn = origBanUsers
+		replyToTicketFn = origReply
+		tagTicketFn = origTag
+		notifySlackFn = origNotifySlack
+	})
+	stubPreservation(t)
+	stubLookup(t)
+
+	getAttachmentsFn = func(ticketId string) ([]string, error) { return nil, nil }
+	banUsersFn = func(data []agentData) (banResults, error) { return banResults{Banned: data}, nil }
+	replyToTicketFn = func(ticketId string, message string) error { return nil }
+	tagTicketFn = func(ticketId string, tags []string) error { return nil }
+	notifySlackFn = func(result processResult) error { return nil }
+
+	process := func(id string, createdAt time.Time, decisions ...FraudDecision) {
+		t.Helper()
+		nowFn = func() time.Time { return createdAt.Add(20 * time.Minute) }
+		extractDataFn = func(paths []string, agents []agentConfig) ([]agentData, []agentError) {
+			var data []agentData
+			for _, decision := range decisions {
+				data = append(data, agentData{Data: decision})
+			}
+			return data, nil
+		}
+		if err := processTicketsAsync(ZendeskTicket{ID: id, CreatedAt: createdAt.Format(time.RFC3339)}); err != nil {
+			t.Fatalf("processTicketsAsync returned error: %v", err)
+		}
+	}
+	march := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	items := []ContentItem{{URL: "https://finya.de/p/1"}, {URL: "https://finya.de/p/2"}}
+	process("1", march,
+		FraudDecision{Username: "jane", AgencyName: "BKA", ReferenceNumber: "REF-1", ContentItems: items},
+		FraudDecision{Username: "joe", AgencyName: "BKA", ReferenceNumber: "REF-1", ContentItems: items[:1]})
+	process("2", march.AddDate(0, 0, 1), FraudDecision{Username: "jim", ReferenceNumber: "REF-2"})
+	process("3", time.Date(2024, 12, 31, 23, 0, 0, 0, time.UTC), FraudDecision{Username: "old", AgencyName: "BKA", ReferenceNumber: "REF-3"})
+
+	// the account holder complains, a moderator dismisses the complaint later
+	processed := []string{agentTag, decisionTagBanned}
+	for _, tags := range [][]string{{complaintTag}, {complaintTag, complaintDismissedTag}} {
+		if err := processTicketsAsync(ZendeskTicket{ID: "1", Tags: append(tags, processed...)}); err != nil {
+			t.Fatalf("processTicketsAsync returned error: %v", err)
+		}
+	}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/reports/transparency?"+query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		ProcessTickets(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 for %s, got %d: %s", query, rec.Code, rec.Body.String())
+		}
+		return rec
+	}
+
+	var report TransparencyReport
+	if err := json.Unmarshal(get("year=2025").Body.Bytes(), &report); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+	if report.Orders != 2 || report.OrdersByAuthority["BKA"] != 1 || report.OrdersByAuthority["unknown"] != 1 {
+		t.Fatalf("expected two orders in 2025, got %+v", report)
+	}
+	if report.Outcomes["banned"] != 1 || report.Outcomes["more-info"] != 1 || report.Accounts["banned"] != 2 {
+		t.Fatalf("unexpected outcomes %v and accounts %v", report.Outcomes, report.Accounts)
+	}
+	if report.ContentItemsRemoved != 3 || report.ActedWithinDeadline != 1 || report.ActedAfterDeadline != 0 {
+		t.Fatalf("unexpected action figures %+v", report)
+	}
+	if report.Complaints != (ComplaintCounts{Received: 1, Dismissed: 1}) {
+		t.Fatalf("unexpected complaints %+v", report.Complaints)
+	}
+
+	rec := get("from=2025-01-01&to=2025-12-31&format=csv")
+	if !strings.Contains(rec.Body.String(), "Action taken,Content items removed,3\n") {
+		t.Fatalf("unexpected CSV report:\n%s", rec.Body.String())
+	}
+	rec = get("year=2025&format=markdown")
+	if rec.Header().Get("Content-Type") != "text/markdown; charset=utf-8" || !strings.Contains(rec.Body.String(), "| Orders issued by BKA | 1 |") {
+		t.Fatalf("unexpected Markdown report:\n%s", rec.Body.String())
+	}
+}
+
+func TestTransparencyReportFromZendeskTags(t *testing.T) {
+	fake := useFakeZendesk(t)
+	fake.addTicket(ZendeskTicket{ID: "11", CreatedAt: "2025-02-01T10:00:00Z", Tags: []string{agentTag, decisionTagBanned, complaintTag, complaintUpheldTag}})
+	fake.addTicket(ZendeskTicket{ID: "12", CreatedAt: "2025-06-01T10:00:00Z", Tags: []string{agentTag, decisionTagNotFound, complaintTag}})
+	fake.addTicket(ZendeskTicket{ID: "13", CreatedAt: "2026-01-01T00:00:00Z", Tags: []string{agentTag, decisionTagBanned}})
+
+	from, to, _ := ParseReportPeriod("2025", "", "")
+	report, err := BuildTransparencyReport(ReportSourceZendesk, from, to)
+	if err != nil {
+		t.Fatalf("BuildTransparencyReport returned error: %v", err)
+	}
+	if report.Orders != 2 || report.Outcomes["banned"] != 1 || report.Outcomes["not-found"] != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if report.Complaints != (ComplaintCounts{Received: 2, Upheld: 1, Pending: 1}) {
+		t.Fatalf("unexpected complaints %+v", report.Complaints)
+	}
+}
+
+func TestParseReportPeriod(t *testing.T) {
+	origNow := nowFn
+	t.Cleanup(func() { nowFn = origNow })
+	nowFn = func() time.Time { return time.Date(2026, 2, 3, 0, 0, 0, 0, time.UTC) }
+
+	cases := []struct {
+		year, from, to string
+		wantFrom       string
+		wantTo         string
+	}{
+		{"", "", "", "2025-01-01T00:00:00Z", "2026-01-01T00:00:00Z"},
+		{"2024", "", "", "2024-01-01T00:00:00Z", "2025-01-01T00:00:00Z"},
+		{"", "2025-04-01", "2025-06-30", "2025-04-01T00:00:00Z", "2025-07-01T00:00:00Z"},
+		{"", "2025-04-01T12:00:00Z", "2025-04-02T12:00:00Z", "2025-04-01T12:00:00Z", "2025-04-02T12:00:00Z"},
+	}
+	for _, c := range cases {
+		from, to, err := ParseReportPeriod(c.year, c.from, c.to)
+		if err != nil || from.Format(time.RFC3339) != c.wantFrom || to.Format(time.RFC3339) != c.wantTo {
+			t.Errorf("ParseReportPeriod(%q, %q, %q) = %s, %s, %v", c.year, c.from, c.to, from, to, err)
+		}
+	}
+	if _, _, err := ParseReportPeriod("", "2025-04-01", ""); err == nil {
+		t.Errorf("expected an error without to")
+	}
+}
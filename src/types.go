@@ -15,14 +15,60 @@ type UserData struct {
 	MaksedUserName string                 `json:"maksedUserName,omitempty"`
 }
 
-// FraudDecision represents the fraud decision result from OpenAI
+// FraudDecision represents the fraud decision result from OpenAI. A removal
+// order may target several accounts and content items; Username and Email hold
+// the account a decision entry is about once the order is split per account.
 type FraudDecision struct {
-	TicketID        string `json:"ticketId"`
-	Username        string `json:"username"`
-	Email           string `json:"email"`
-	AgencyName      string `json:"agencyName"`
-	ReferenceNumber string `json:"referenceNumber"`
-	Date            string `json:"date"`
+	TicketID        string          `json:"ticketId"`
+	Username        string          `json:"username"`
+	Email           string          `json:"email"`
+	UserID          string          `json:"userId,omitempty"`
+	AgencyName      string          `json:"agencyName"`
+	ReferenceNumber string          `json:"referenceNumber"`
+	Date            string          `json:"date"`
+	Accounts        []TargetAccount `json:"accounts,omitempty"`
+	ContentItems    []ContentItem   `json:"contentItems,omitempty"`
+	Evidence        []FieldEvidence `json:"evidence,omitempty"`
+	// ImpossibilityReason is set when the order cannot be carried out (Annex
+	// III): force_majeure, technical or manifest_error.
+	ImpossibilityReason      string `json:"impossibilityReason,omitempty"`
+	ImpossibilityExplanation string `json:"impossibilityExplanation,omitempty"`
+	// FinyaUserID is the Finya user the identifiers resolved to when banning.
+	FinyaUserID string `json:"finyaUserId,omitempty"`
+	// RawOutput is the model's answer as received, kept for the audit trail.
+	RawOutput string `json:"-"`
+}
+
+// FieldEvidence tells where the model read an extracted value and how sure it
+// is. Field is the JSON name of the field (e.g. "referenceNumber", "username"),
+// Value the extracted value, Page is 1-based and 0 when unknown.
+type FieldEvidence struct {
+	Field      string  `json:"field"`
+	Value      string  `json:"value"`
+	Confidence float64 `json:"confidence"`
+	Snippet    string  `json:"snippet"`
+	Page       int     `json:"page"`
+	Source     string  `json:"source"`
+}
+
+// TargetAccount is an account named in a removal order.
+type TargetAccount struct {
+	Username   string `json:"username"`
+	Email      string `json:"email"`
+	UserID     string `json:"userId"`
+	ProfileURL string `json:"profileUrl"`
+}
+
+// ContentItem is a piece of content named in a removal order. Account refers to
+// the username, email or user ID of the account it belongs to, if known. Kind
+// is message, profile_text or picture when the order says what the content is.
+type ContentItem struct {
+	Kind        string `json:"kind"`
+	URL         string `json:"url"`
+	MessageID   string `json:"messageId"`
+	PictureID   string `json:"pictureId"`
+	Description string `json:"description"`
+	Account     string `json:"account"`
 }
 
 // OpenAIResponse represents the response structure from OpenAI
@@ -0,0 +1,107 @@
+package tco_vo_agent
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	zendeskSignatureHeader          = "X-Zendesk-Webhook-Signature"
+	zendeskSignatureTimestampHeader = "X-Zendesk-Webhook-Signature-Timestamp"
+
+	defaultWebhookMaxAge = 5 * time.Minute
+)
+
+// isProductionMode reports whether APP_ENV marks this deployment as production.
+func isProductionMode() bool {
+	env := strings.ToLower(strings.TrimSpace(os.Getenv("APP_ENV")))
+	return env == "production" || env == "prod"
+}
+
+// validateAuthConfig fails when a production deployment would accept
+// unauthenticated webhooks, or could not serve its scheduler and admin
+// endpoints, which all require the bearer token.
+func validateAuthConfig() error {
+	if !isProductionMode() {
+		return nil
+	}
+	if strings.TrimSpace(os.Getenv("BEARER_TOKEN")) == "" {
+		return errors.New("BEARER_TOKEN is not set; the scheduler and admin endpoints would refuse every request")
+	}
+	return nil
+}
+
+// authenticateWebhook accepts a request carrying a valid Zendesk signature or,
+// when BEARER_TOKEN is configured, a matching bearer token.
+func authenticateWebhook(r *http.Request, body []byte) error {
+	secret := strings.TrimSpace(os.Getenv("ZENDESK_WEBHOOK_SECRET"))
+	if secret != "" && r.Header.Get(zendeskSignatureHeader) != "" {
+		return verifyZendeskSignature(r, body, secret)
+	}
+
+	if strings.TrimSpace(os.Getenv("BEARER_TOKEN")) != "" {
+		return validateBearerToken(r)
+	}
+
+	if secret != "" {
+		return errors.New("missing webhook signature")
+	}
+	return errors.New("no webhook authentication configured: set ZENDESK_WEBHOOK_SECRET or BEARER_TOKEN")
+}
+
+// verifyZendeskSignature checks the HMAC-SHA256 signature Zendesk computes over
+// the signature timestamp followed by the raw body, and rejects stale timestamps.
+func verifyZendeskSignature(r *http.Request, body []byte, secret string) error {
+	signature := r.Header.Get(zendeskSignatureHeader)
+	timestamp := r.Header.Get(zendeskSignatureTimestampHeader)
+	if signature == "" || timestamp == "" {
+		return errors.New("missing webhook signature headers")
+	}
+
+	signedAt, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return fmt.Errorf("invalid webhook signature timestamp %q: %w", timestamp, err)
+	}
+	age := nowFn().Sub(signedAt)
+	if age < 0 {
+		age = -age
+	}
+	if maxAge := webhookMaxAge(); age > maxAge {
+		return fmt.Errorf("webhook signature timestamp %s is outside the %s replay window", timestamp, maxAge)
+	}
+
+	provided, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid webhook signature encoding: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write(body)
+	if !hmac.Equal(provided, mac.Sum(nil)) {
+		return errors.New("invalid webhook signature")
+	}
+
+	return nil
+}
+
+func webhookMaxAge() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("ZENDESK_WEBHOOK_MAX_AGE"))
+	if raw == "" {
+		return defaultWebhookMaxAge
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid ZENDESK_WEBHOOK_MAX_AGE %q, using %s", raw, defaultWebhookMaxAge)
+		return defaultWebhookMaxAge
+	}
+	return d
+}
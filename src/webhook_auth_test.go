@@ -0,0 +1,127 @@
+package tco_vo_agent
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signZendeskRequest(req *http.Request, secret, timestamp, body string) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + body))
+	req.Header.Set(zendeskSignatureHeader, base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	req.Header.Set(zendeskSignatureTimestampHeader, timestamp)
+}
+
+func TestAuthenticateWebhook(t *testing.T) {
+	origNow := nowFn
+	t.Cleanup(func() { nowFn = origNow })
+	now := time.Date(2025, 1, 8, 10, 12, 7, 0, time.UTC)
+	nowFn = func() time.Time { return now }
+
+	body := `{"id":"5158"}`
+	fresh := now.Add(-time.Minute).Format(time.RFC3339)
+	stale := now.Add(-time.Hour).Format(time.RFC3339)
+
+	tests := []struct {
+		name    string
+		secret  string
+		bearer  string
+		prepare func(req *http.Request)
+		wantErr string
+	}{
+		{
+			name:   "valid signature",
+			secret: "shh",
+			prepare: func(req *http.Request) {
+				signZendeskRequest(req, "shh", fresh, body)
+			},
+		},
+		{
+			name:   "signature with wrong secret",
+			secret: "shh",
+			prepare: func(req *http.Request) {
+				signZendeskRequest(req, "other", fresh, body)
+			},
+			wantErr: "invalid webhook signature",
+		},
+		{
+			name:   "replayed signature",
+			secret: "shh",
+			prepare: func(req *http.Request) {
+				signZendeskRequest(req, "shh", stale, body)
+			},
+			wantErr: "replay window",
+		},
+		{
+			name:    "secret configured but request unsigned",
+			secret:  "shh",
+			prepare: func(req *http.Request) {},
+			wantErr: "missing webhook signature",
+		},
+		{
+			name:   "bearer token still accepted",
+			secret: "shh",
+			bearer: "token",
+			prepare: func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer token")
+			},
+		},
+		{
+			name:    "nothing configured",
+			prepare: func(req *http.Request) {},
+			wantErr: "no webhook authentication configured",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ZENDESK_WEBHOOK_SECRET", tt.secret)
+			t.Setenv("BEARER_TOKEN", tt.bearer)
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+			tt.prepare(req)
+
+			err := authenticateWebhook(req, []byte(body))
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("authenticateWebhook returned error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("authenticateWebhook error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateAuthConfig(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+	t.Setenv("ZENDESK_WEBHOOK_SECRET", "")
+	t.Setenv("BEARER_TOKEN", "")
+
+	if err := validateAuthConfig(); err == nil {
+		t.Fatal("expected production mode without credentials to be rejected")
+	}
+
+	// the scheduler and admin endpoints only accept the bearer token
+	t.Setenv("ZENDESK_WEBHOOK_SECRET", "shh")
+	if err := validateAuthConfig(); err == nil {
+		t.Fatal("expected a signing secret without BEARER_TOKEN to be rejected")
+	}
+
+	t.Setenv("BEARER_TOKEN", "secret")
+	if err := validateAuthConfig(); err != nil {
+		t.Fatalf("expected the bearer token to be sufficient, got %v", err)
+	}
+
+	t.Setenv("APP_ENV", "development")
+	t.Setenv("ZENDESK_WEBHOOK_SECRET", "")
+	t.Setenv("BEARER_TOKEN", "")
+	if err := validateAuthConfig(); err != nil {
+		t.Fatalf("development mode must not require credentials, got %v", err)
+	}
+}
@@ -6,19 +6,35 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
+const (
+	defaultZendeskTimeout     = 30 * time.Second
+	defaultAttachmentMaxBytes = 25 << 20
+	maxAttachmentRedirects    = 5
+	tcoViewTitle              = "TCO - Handled Tickets"
+)
+
+// zendeskTransport is shared by all Zendesk clients so connections are reused across calls.
+var zendeskTransport http.RoundTripper = http.DefaultTransport.(*http.Transport).Clone()
+
 type ZendeskTicket struct {
-	ID          string `json:"id"`
-	Subject     string `json:"subject"`
-	Description string `json:"description"`
-	Status      string `json:"status"`
-	CreatedAt   string `json:"created_at"`
-	UpdatedAt   string `json:"updated_at"`
-	Recipient   *string `json:"recipient,omitempty"`
+	ID          string   `json:"id"`
+	Subject     string   `json:"subject"`
+	Description string   `json:"description"`
+	Status      string   `json:"status"`
+	CreatedAt   string   `json:"created_at"`
+	UpdatedAt   string   `json:"updated_at"`
+	Recipient   *string  `json:"recipient,omitempty"`
+	RequesterID string   `json:"requester_id,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
 }
 
 // UnmarshalJSON implements custom unmarshaling to handle ID as both number and string
@@ -26,7 +42,8 @@ func (z *ZendeskTicket) UnmarshalJSON(data []byte) error {
 	// Use an alias to avoid infinite recursion
 	type Alias ZendeskTicket
 	aux := &struct {
-		ID interface{} `json:"id"` // Accept ID as any type
+		ID          interface{} `json:"id"` // Accept ID as any type
+		RequesterID interface{} `json:"requester_id"`
 		*Alias
 	}{
 		Alias: (*Alias)(z),
@@ -36,21 +53,27 @@ func (z *ZendeskTicket) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
-	// Convert ID to string regardless of input type
-	switch v := aux.ID.(type) {
+	z.ID = zendeskID(aux.ID)
+	z.RequesterID = zendeskID(aux.RequesterID)
+	return nil
+}
+
+// zendeskID converts an ID that may be decoded as number or string into its string form.
+func zendeskID(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
 	case string:
-		z.ID = v
+		return v
 	case float64:
-		z.ID = fmt.Sprintf("%.0f", v) // Convert number to string without decimals
+		return fmt.Sprintf("%.0f", v) // Convert number to string without decimals
 	case int:
-		z.ID = fmt.Sprintf("%d", v)
+		return fmt.Sprintf("%d", v)
 	case int64:
-		z.ID = fmt.Sprintf("%d", v)
+		return fmt.Sprintf("%d", v)
 	default:
-		z.ID = fmt.Sprintf("%v", v) // Fallback for any other type
+		return fmt.Sprintf("%v", v) // Fallback for any other type
 	}
-
-	return nil
 }
 
 type Attachment struct {
@@ -64,57 +87,63 @@ type Attachment struct {
 	} `json:"thumbnails"`
 }
 
-func FetchZendeskTickets(ticketIds []string) ([]ZendeskTicket, error) {
+// ZendeskComment is a ticket comment including its attachments.
+type ZendeskComment struct {
+	ID          int64        `json:"id"`
+	AuthorID    int64        `json:"author_id"`
+	Body        string       `json:"body"`
+	Public      bool         `json:"public"`
+	CreatedAt   string       `json:"created_at"`
+	Attachments []Attachment `json:"attachments"`
+}
 
-	apiKey := os.Getenv("ZENDESK_API_KEY")
-	if apiKey == "" {
-		return nil, errors.New("ZENDESK_API_KEY is not set")
-	}
-	userEmail := os.Getenv("ZENDESK_USER")
-	if userEmail == "" {
-		return nil, errors.New("ZENDESK_USER is not set")
-	}
-	domain := os.Getenv("ZENDESK_DOMAIN")
-	if domain == "" {
-		return nil, errors.New("ZENDESK_DOMAIN is not set")
-	}
-	headers := map[string]string{
-		"Content-Type": "application/json",
-	}
-	url := fmt.Sprintf("https://%s.zendesk.com/api/v2/tickets.json?ids=%s", domain, strings.Join(ticketIds, ","))
+// ZendeskView is a view as returned by the views listing.
+type ZendeskView struct {
+	ID    string
+	Title string
+}
 
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	for k, v := range headers {
-		req.Header.Set(k, v)
-	}
-	req.SetBasicAuth(userEmail+"/token", apiKey)
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+// ZendeskClient talks to the Zendesk REST API with a fixed set of credentials.
+type ZendeskClient struct {
+	// BaseURL is the scheme and host of the Zendesk instance, e.g. https://example.zendesk.com.
+	BaseURL    string
+	User       string
+	APIKey     string
+	HTTPClient *http.Client
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+// zendeskAPIError is returned when Zendesk answers with a non-2xx status.
+type zendeskAPIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Body       string
+}
 
-	var response struct {
-		Tickets []ZendeskTicket `json:"tickets"`
-	}
-	if err := json.Unmarshal(body, &response); err != nil {
-		return nil, err
-	}
+func (e *zendeskAPIError) Error() string {
+	return fmt.Sprintf("zendesk %s %s: status %d: %s", e.Method, e.Path, e.StatusCode, e.Body)
+}
 
-	return response.Tickets, nil
+// NewZendeskClient returns a client for the given instance using the shared transport.
+func NewZendeskClient(baseURL, user, apiKey string, timeout time.Duration) *ZendeskClient {
+	if timeout <= 0 {
+		timeout = defaultZendeskTimeout
+	}
+	return &ZendeskClient{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		User:    user,
+		APIKey:  apiKey,
+		HTTPClient: &http.Client{
+			Transport: zendeskTransport,
+			Timeout:   timeout,
+		},
+	}
 }
 
-// FetchZendeskTicket fetches a single ticket by ID, which may include more fields than bulk fetch
-func FetchZendeskTicket(ticketId string) (*ZendeskTicket, error) {
+// NewZendeskClientFromEnv builds a client from ZENDESK_API_KEY, ZENDESK_USER and
+// ZENDESK_DOMAIN. ZENDESK_BASE_URL replaces the domain based URL, e.g. to point
+// at a local fake, and ZENDESK_TIMEOUT sets the request timeout.
+func NewZendeskClientFromEnv() (*ZendeskClient, error) {
 	apiKey := os.Getenv("ZENDESK_API_KEY")
 	if apiKey == "" {
 		return nil, errors.New("ZENDESK_API_KEY is not set")
@@ -123,624 +152,684 @@ func FetchZendeskTicket(ticketId string) (*ZendeskTicket, error) {
 	if userEmail == "" {
 		return nil, errors.New("ZENDESK_USER is not set")
 	}
-	domain := os.Getenv("ZENDESK_DOMAIN")
-	if domain == "" {
-		return nil, errors.New("ZENDESK_DOMAIN is not set")
+
+	baseURL := strings.TrimSpace(os.Getenv("ZENDESK_BASE_URL"))
+	if baseURL == "" {
+		domain := os.Getenv("ZENDESK_DOMAIN")
+		if domain == "" {
+			return nil, errors.New("ZENDESK_DOMAIN is not set")
+		}
+		baseURL = fmt.Sprintf("https://%s.zendesk.com", domain)
 	}
-	headers := map[string]string{
-		"Content-Type": "application/json",
+
+	timeout := defaultZendeskTimeout
+	if raw := strings.TrimSpace(os.Getenv("ZENDESK_TIMEOUT")); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ZENDESK_TIMEOUT %q: %w", raw, err)
+		}
+		timeout = d
 	}
-	url := fmt.Sprintf("https://%s.zendesk.com/api/v2/tickets/%s.json", domain, ticketId)
 
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
+	return NewZendeskClient(baseURL, userEmail, apiKey, timeout), nil
+}
+
+// do sends a JSON request to path (relative to BaseURL) and decodes the JSON response into out.
+func (c *ZendeskClient) do(method, path string, payload interface{}, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		jsonBody, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewBuffer(jsonBody)
 	}
-	for k, v := range headers {
-		req.Header.Set(k, v)
+	return c.send(method, path, "application/json", body, out)
+}
+
+// send is do for a body of any content type.
+func (c *ZendeskClient) send(method, path, contentType string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequest(method, c.BaseURL+path, body)
+	if err != nil {
+		return err
 	}
-	req.SetBasicAuth(userEmail+"/token", apiKey)
-	resp, err := client.Do(req)
+	req.Header.Set("Content-Type", contentType)
+	req.SetBasicAuth(c.User+"/token", c.APIKey)
+
+	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	if resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("failed to fetch ticket %s: status %d: %s", ticketId, resp.StatusCode, string(body))
+		return &zendeskAPIError{Method: method, Path: path, StatusCode: resp.StatusCode, Body: string(respBody)}
 	}
 
+	if out != nil && len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return fmt.Errorf("failed to parse response of %s %s: %w", method, path, err)
+		}
+	}
+	return nil
+}
+
+// GetTicket fetches a single ticket, which may include more fields than the bulk fetch.
+func (c *ZendeskClient) GetTicket(ticketId string) (*ZendeskTicket, error) {
 	var response struct {
 		Ticket ZendeskTicket `json:"ticket"`
 	}
-	if err := json.Unmarshal(body, &response); err != nil {
-		return nil, fmt.Errorf("failed to parse ticket response: %w", err)
+	if err := c.do("GET", fmt.Sprintf("/api/v2/tickets/%s.json", url.PathEscape(ticketId)), nil, &response); err != nil {
+		return nil, err
 	}
-
 	return &response.Ticket, nil
 }
 
-func processAttachments(ticketId string, attachmentData []byte) ([]string, error) {
-	var attachments []Attachment
-	if err := json.Unmarshal(attachmentData, &attachments); err != nil {
+// GetTickets fetches several tickets in one request.
+func (c *ZendeskClient) GetTickets(ticketIds []string) ([]ZendeskTicket, error) {
+	var response struct {
+		Tickets []ZendeskTicket `json:"tickets"`
+	}
+	path := "/api/v2/tickets/show_many.json?ids=" + url.QueryEscape(strings.Join(ticketIds, ","))
+	if err := c.do("GET", path, nil, &response); err != nil {
 		return nil, err
 	}
+	return response.Tickets, nil
+}
 
-	var attachmentPaths []string
-	for _, attachment := range attachments {
-		if attachment.ContentType == "application/pdf" {
-			tempFile, err := os.CreateTemp("", ticketId+"-attachment-*.pdf") // attachments are binary pdfs, write to temp file and return the path
-			if err != nil {
-				return nil, err
-			}
-			defer tempFile.Close()
-			if _, err := tempFile.Write(attachmentData); err != nil {
-				return nil, err
-			}
-			attachmentPaths = append(attachmentPaths, tempFile.Name())
-		}
+// CreateTicket creates a task ticket addressed to recipientEmail.
+func (c *ZendeskClient) CreateTicket(subject, description, recipientEmail string) (*ZendeskTicket, error) {
+	body := map[string]interface{}{
+		"ticket": map[string]interface{}{
+			"subject":   subject,
+			"comment":   map[string]interface{}{"body": description},
+			"recipient": recipientEmail,
+			"type":      "task",
+			"priority":  "normal",
+			"status":    "open",
+		},
+	}
+	var response struct {
+		Ticket ZendeskTicket `json:"ticket"`
+	}
+	if err := c.do("POST", "/api/v2/tickets.json", body, &response); err != nil {
+		return nil, err
 	}
+	return &response.Ticket, nil
+}
 
-	return attachmentPaths, nil
+// DeleteTicket deletes a ticket.
+func (c *ZendeskClient) DeleteTicket(ticketId string) error {
+	return c.do("DELETE", fmt.Sprintf("/api/v2/tickets/%s.json", url.PathEscape(ticketId)), nil, nil)
 }
 
-func GetAttachments(ticketId string) ([]string, error) {
-	apiKey := os.Getenv("ZENDESK_API_KEY")
-	if apiKey == "" {
-		return nil, errors.New("ZENDESK_API_KEY is not set")
-	}
-	userEmail := os.Getenv("ZENDESK_USER")
-	if userEmail == "" {
-		return nil, errors.New("ZENDESK_USER is not set")
+// AddComment adds a public reply or an internal note to a ticket.
+func (c *ZendeskClient) AddComment(ticketId, message string, public bool) error {
+	return c.AddCommentWithUploads(ticketId, message, public, nil)
+}
+
+// AddCommentWithUploads adds a comment carrying the files of the given upload tokens.
+func (c *ZendeskClient) AddCommentWithUploads(ticketId, message string, public bool, uploads []string) error {
+	comment := map[string]interface{}{
+		"body":   message,
+		"public": public,
 	}
-	domain := os.Getenv("ZENDESK_DOMAIN")
-	if domain == "" {
-		return nil, errors.New("ZENDESK_DOMAIN is not set")
+	if len(uploads) > 0 {
+		comment["uploads"] = uploads
 	}
-	url := fmt.Sprintf("https://%s.zendesk.com/api/v2/tickets/%s/attachments.json", domain, ticketId)
-	headers := map[string]string{
-		"Content-Type": "application/json",
+	body := map[string]interface{}{
+		"ticket": map[string]interface{}{
+			"comment": comment,
+		},
 	}
+	return c.do("PUT", fmt.Sprintf("/api/v2/tickets/%s.json", url.PathEscape(ticketId)), body, nil)
+}
 
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
+// Upload stores a file in Zendesk and returns the token to attach it to a comment.
+func (c *ZendeskClient) Upload(fileName, contentType string, data []byte) (string, error) {
+	var response struct {
+		Upload struct {
+			Token string `json:"token"`
+		} `json:"upload"`
 	}
-	for k, v := range headers {
-		req.Header.Set(k, v)
+	path := "/api/v2/uploads.json?filename=" + url.QueryEscape(fileName)
+	if err := c.send("POST", path, contentType, bytes.NewReader(data), &response); err != nil {
+		return "", err
 	}
-	req.SetBasicAuth(userEmail+"/token", apiKey)
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	if response.Upload.Token == "" {
+		return "", fmt.Errorf("upload of %s returned no token", fileName)
 	}
+	return response.Upload.Token, nil
+}
 
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
+// GetComments returns all comments of a ticket in chronological order.
+func (c *ZendeskClient) GetComments(ticketId string) ([]map[string]interface{}, error) {
+	var response struct {
+		Comments []map[string]interface{} `json:"comments"`
+	}
+	if err := c.do("GET", fmt.Sprintf("/api/v2/tickets/%s/comments.json", url.PathEscape(ticketId)), nil, &response); err != nil {
 		return nil, err
 	}
-
-	return processAttachments(ticketId, body)
-	// response:	{  "attachments": [    {      "content_type": "text/plain",      "content_url": "https://company.zendesk.com/attachments/crash.log",      "file_name": "crash.log",      "id": 498483,      "size": 2532,      "thumbnails": []    }  ],  "author_id": 123123,  "body": "Thanks for your help!",  "created_at": "2009-07-20T22:55:29Z",  "id": 1274,  "metadata": {    "system": {      "client": "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_12_6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/61.0.3163.100 Safari/537.36",      "ip_address": "1.1.1.1",      "latitude": -37.000000000001,      "location": "Melbourne, 07, Australia",      "longitude": 144.0000000000002    },    "via": {      "channel": "web",      "source": {        "from": {},        "rel": "web_widget",        "to": {}      }    }  },  "public": true,  "type": "Comment"}
-
+	return response.Comments, nil
 }
 
-func ReplyToTicket(ticketId string, message string) error {
-	apiKey := os.Getenv("ZENDESK_API_KEY")
-	if apiKey == "" {
-		return errors.New("ZENDESK_API_KEY is not set")
+// GetTags returns the tags of a ticket.
+func (c *ZendeskClient) GetTags(ticketId string) ([]string, error) {
+	var response struct {
+		Tags []string `json:"tags"`
 	}
-	userEmail := os.Getenv("ZENDESK_USER")
-	if userEmail == "" {
-		return errors.New("ZENDESK_USER is not set")
+	if err := c.do("GET", fmt.Sprintf("/api/v2/tickets/%s/tags.json", url.PathEscape(ticketId)), nil, &response); err != nil {
+		return nil, err
 	}
-	domain := os.Getenv("ZENDESK_DOMAIN")
-	if domain == "" {
-		return errors.New("ZENDESK_DOMAIN is not set")
+	return response.Tags, nil
+}
+
+// AddTags adds tags to a ticket, keeping the tags it already has.
+func (c *ZendeskClient) AddTags(ticketId string, tags []string) error {
+	if len(tags) == 0 {
+		return nil
 	}
+	body := map[string]interface{}{"tags": tags}
+	return c.do("PUT", fmt.Sprintf("/api/v2/tickets/%s/tags.json", url.PathEscape(ticketId)), body, nil)
+}
 
-	url := fmt.Sprintf("https://%s.zendesk.com/api/v2/tickets/%s.json", domain, ticketId)
-	headers := map[string]string{
-		"Content-Type": "application/json",
+// RemoveTags removes tags from a ticket.
+func (c *ZendeskClient) RemoveTags(ticketId string, tags []string) error {
+	if len(tags) == 0 {
+		return nil
 	}
-	body := map[string]interface{}{
-		"ticket": map[string]interface{}{
-			"comment": map[string]interface{}{
-				"body":   message,
-				"public": true,
-			},
-		},
+	body := map[string]interface{}{"tags": tags}
+	return c.do("DELETE", fmt.Sprintf("/api/v2/tickets/%s/tags.json", url.PathEscape(ticketId)), body, nil)
+}
+
+// ListViews returns the views visible to the API user.
+func (c *ZendeskClient) ListViews() ([]ZendeskView, error) {
+	var response struct {
+		Views []struct {
+			ID    interface{} `json:"id"` // ID can be number or string
+			Title string      `json:"title"`
+		} `json:"views"`
 	}
-	jsonBody, err := json.Marshal(body)
-	if err != nil {
-		return err
+	if err := c.do("GET", "/api/v2/views.json", nil, &response); err != nil {
+		return nil, err
 	}
-	client := &http.Client{}
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return err
+
+	views := make([]ZendeskView, 0, len(response.Views))
+	for _, view := range response.Views {
+		views = append(views, ZendeskView{ID: zendeskID(view.ID), Title: view.Title})
 	}
-	for k, v := range headers {
-		req.Header.Set(k, v)
+	return views, nil
+}
+
+// ExecuteView runs a view and returns the IDs of the tickets it contains.
+func (c *ZendeskClient) ExecuteView(viewID string) ([]string, error) {
+	// The view execute response format: rows contain ticket objects with nested id
+	var response struct {
+		Rows []struct {
+			Ticket struct {
+				ID interface{} `json:"id"` // ID is nested in ticket object
+			} `json:"ticket"`
+			TicketID interface{} `json:"ticket_id"` // Fallback: direct ticket_id field
+			ID       interface{} `json:"id"`        // Fallback: direct id field
+		} `json:"rows"`
 	}
-	req.SetBasicAuth(userEmail+"/token", apiKey)
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+	if err := c.do("GET", fmt.Sprintf("/api/v2/views/%s/execute.json", url.PathEscape(viewID)), nil, &response); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode >= 300 {
-		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to add comment to ticket %s: status %d: %s", ticketId, resp.StatusCode, string(respBody))
+	var ids []string
+	for _, row := range response.Rows {
+		// Try ticket.id first (the actual format), then ticket_id, then id
+		var idValue interface{}
+		if row.Ticket.ID != nil {
+			idValue = row.Ticket.ID
+		} else if row.TicketID != nil {
+			idValue = row.TicketID
+		} else if row.ID != nil {
+			idValue = row.ID
+		}
+		if idValue == nil {
+			continue
+		}
+		ids = append(ids, zendeskID(idValue))
 	}
-
-	return nil
+	return ids, nil
 }
 
-// AddTagsToTicket appends the provided tags to the given ticket.
-func AddTagsToTicket(ticketId string, tags []string) error {
-	if len(tags) == 0 {
-		return nil
+// ListComments returns all comments of a ticket, following pagination.
+func (c *ZendeskClient) ListComments(ticketId string) ([]ZendeskComment, error) {
+	var comments []ZendeskComment
+	path := fmt.Sprintf("/api/v2/tickets/%s/comments.json", url.PathEscape(ticketId))
+	for path != "" {
+		var response struct {
+			Comments []ZendeskComment `json:"comments"`
+			NextPage *string          `json:"next_page"`
+		}
+		if err := c.do("GET", path, nil, &response); err != nil {
+			return nil, err
+		}
+		comments = append(comments, response.Comments...)
+
+		path = ""
+		if response.NextPage != nil && *response.NextPage != "" {
+			next, err := url.Parse(*response.NextPage)
+			if err != nil {
+				return nil, fmt.Errorf("invalid next_page %q: %w", *response.NextPage, err)
+			}
+			path = next.RequestURI()
+		}
 	}
+	return comments, nil
+}
 
-	apiKey := os.Getenv("ZENDESK_API_KEY")
-	if apiKey == "" {
-		return errors.New("ZENDESK_API_KEY is not set")
+// SearchTickets returns all tickets matching a Zendesk search query. It uses the
+// export endpoint, which is not capped at 1000 results like the regular search.
+func (c *ZendeskClient) SearchTickets(query string) ([]ZendeskTicket, error) {
+	var tickets []ZendeskTicket
+	params := url.Values{"query": {query}, "filter[type]": {"ticket"}, "page[size]": {"1000"}}
+	path := "/api/v2/search/export.json?" + params.Encode()
+	for path != "" {
+		var response struct {
+			Results []ZendeskTicket `json:"results"`
+			Meta    struct {
+				HasMore bool `json:"has_more"`
+			} `json:"meta"`
+			Links struct {
+				Next *string `json:"next"`
+			} `json:"links"`
+		}
+		if err := c.do("GET", path, nil, &response); err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, response.Results...)
+
+		path = ""
+		if response.Meta.HasMore && response.Links.Next != nil && *response.Links.Next != "" {
+			next, err := url.Parse(*response.Links.Next)
+			if err != nil {
+				return nil, fmt.Errorf("invalid next link %q: %w", *response.Links.Next, err)
+			}
+			path = next.RequestURI()
+		}
 	}
-	userEmail := os.Getenv("ZENDESK_USER")
-	if userEmail == "" {
-		return errors.New("ZENDESK_USER is not set")
+	return tickets, nil
+}
+
+// ListAttachments returns the attachments of all comments of a ticket.
+func (c *ZendeskClient) ListAttachments(ticketId string) ([]Attachment, error) {
+	comments, err := c.ListComments(ticketId)
+	if err != nil {
+		return nil, err
 	}
-	domain := os.Getenv("ZENDESK_DOMAIN")
-	if domain == "" {
-		return errors.New("ZENDESK_DOMAIN is not set")
+	var attachments []Attachment
+	for _, comment := range comments {
+		attachments = append(attachments, comment.Attachments...)
 	}
+	return attachments, nil
+}
 
-	url := fmt.Sprintf("https://%s.zendesk.com/api/v2/tickets/%s.json", domain, ticketId)
-	headers := map[string]string{
-		"Content-Type": "application/json",
+// DownloadAttachment fetches the content of an attachment, refusing anything
+// larger than maxBytes. Credentials are only sent to the Zendesk host itself;
+// redirects to the storage host are followed without them.
+func (c *ZendeskClient) DownloadAttachment(attachment Attachment, maxBytes int64) ([]byte, error) {
+	if attachment.ContentURL == "" {
+		return nil, errors.New("attachment has no content_url")
 	}
-	body := map[string]interface{}{
-		"ticket": map[string]interface{}{
-			"tags": tags,
-		},
+	if maxBytes > 0 && int64(attachment.Size) > maxBytes {
+		return nil, fmt.Errorf("attachment is %d bytes, limit is %d", attachment.Size, maxBytes)
 	}
 
-	jsonBody, err := json.Marshal(body)
+	req, err := http.NewRequest("GET", attachment.ContentURL, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	client := &http.Client{}
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return err
+	if base, err := url.Parse(c.BaseURL); err == nil && req.URL.Host == base.Host {
+		req.SetBasicAuth(c.User+"/token", c.APIKey)
 	}
-	for k, v := range headers {
-		req.Header.Set(k, v)
+
+	client := *c.HTTPClient
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxAttachmentRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxAttachmentRedirects)
+		}
+		return nil
 	}
-	req.SetBasicAuth(userEmail+"/token", apiKey)
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 300 {
-		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to tag ticket %s: status %d: %s", ticketId, resp.StatusCode, string(respBody))
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("download returned status %d: %s", resp.StatusCode, string(body))
 	}
 
-	return nil
-}
-
-// CreateZendeskTicket creates a new ticket in Zendesk via API.
-// Returns the created ticket ID and the full ticket object.
-func CreateZendeskTicket(subject, description, recipientEmail string) (string, *ZendeskTicket, error) {
-	apiKey := os.Getenv("ZENDESK_API_KEY")
-	if apiKey == "" {
-		return "", nil, errors.New("ZENDESK_API_KEY is not set")
+	reader := io.Reader(resp.Body)
+	if maxBytes > 0 {
+		reader = io.LimitReader(resp.Body, maxBytes+1)
 	}
-	userEmail := os.Getenv("ZENDESK_USER")
-	if userEmail == "" {
-		return "", nil, errors.New("ZENDESK_USER is not set")
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
 	}
-	domain := os.Getenv("ZENDESK_DOMAIN")
-	if domain == "" {
-		return "", nil, errors.New("ZENDESK_DOMAIN is not set")
+	if maxBytes > 0 && int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("attachment exceeds the limit of %d bytes", maxBytes)
 	}
+	return data, nil
+}
 
-	url := fmt.Sprintf("https://%s.zendesk.com/api/v2/tickets.json", domain)
-	headers := map[string]string{
-		"Content-Type": "application/json",
+func FetchZendeskTickets(ticketIds []string) ([]ZendeskTicket, error) {
+	client, err := NewZendeskClientFromEnv()
+	if err != nil {
+		return nil, err
 	}
+	return client.GetTickets(ticketIds)
+}
 
-	body := map[string]interface{}{
-		"ticket": map[string]interface{}{
-			"subject":     subject,
-			"comment":     map[string]interface{}{"body": description},
-			"recipient":   recipientEmail,
-			"type":        "task",
-			"priority":    "normal",
-			"status":      "open",
-		},
+// FetchZendeskTicket fetches a single ticket by ID, which may include more fields than bulk fetch
+func FetchZendeskTicket(ticketId string) (*ZendeskTicket, error) {
+	client, err := NewZendeskClientFromEnv()
+	if err != nil {
+		return nil, err
 	}
-
-	jsonBody, err := json.Marshal(body)
+	ticket, err := client.GetTicket(ticketId)
 	if err != nil {
-		return "", nil, err
+		return nil, fmt.Errorf("failed to fetch ticket %s: %w", ticketId, err)
 	}
+	return ticket, nil
+}
 
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
+// GetAttachments downloads the attachments of all ticket comments and runs them
+// through the ingestion layer, together with the ticket description and comment
+// bodies. It returns the resulting temp file paths; callers remove them with
+// removeAttachmentFiles.
+func GetAttachments(ticketId string) ([]string, error) {
+	client, err := NewZendeskClientFromEnv()
 	if err != nil {
-		return "", nil, err
-	}
-	for k, v := range headers {
-		req.Header.Set(k, v)
+		return nil, err
 	}
-	req.SetBasicAuth(userEmail+"/token", apiKey)
-
-	resp, err := client.Do(req)
+	comments, err := client.ListComments(ticketId)
 	if err != nil {
-		return "", nil, err
+		return nil, fmt.Errorf("failed to list attachments of ticket %s: %w", ticketId, err)
 	}
-	defer resp.Body.Close()
 
-	respBody, err := io.ReadAll(resp.Body)
+	attachmentPaths, err := writeTicketText(ticketId, comments)
 	if err != nil {
-		return "", nil, err
+		return nil, fmt.Errorf("failed to store text of ticket %s: %w", ticketId, err)
 	}
 
-	if resp.StatusCode >= 300 {
-		return "", nil, fmt.Errorf("failed to create ticket: status %d: %s", resp.StatusCode, string(respBody))
-	}
+	maxBytes := attachmentMaxBytes()
+	for _, comment := range comments {
+		for _, attachment := range comment.Attachments {
+			if !isIngestible(attachment.ContentType, attachment.FileName) {
+				log.Printf("Skipping attachment %d (%s) of ticket %s: unsupported content type %s", attachment.ID, attachment.FileName, ticketId, attachment.ContentType)
+				continue
+			}
+			data, err := client.DownloadAttachment(attachment, maxBytes)
+			if err != nil {
+				removeAttachmentFiles(attachmentPaths)
+				return nil, fmt.Errorf("failed to download attachment %d (%s): %w", attachment.ID, attachment.FileName, err)
+			}
 
-	var response struct {
-		Ticket ZendeskTicket `json:"ticket"`
-	}
-	if err := json.Unmarshal(respBody, &response); err != nil {
-		return "", nil, fmt.Errorf("failed to parse ticket response: %w", err)
+			paths, err := ingestAttachment(ticketId, attachment.FileName, attachment.ContentType, data)
+			if err != nil {
+				log.Printf("Skipping attachment %d (%s) of ticket %s: %v", attachment.ID, attachment.FileName, ticketId, err)
+				continue
+			}
+			attachmentPaths = append(attachmentPaths, paths...)
+		}
 	}
 
-	return response.Ticket.ID, &response.Ticket, nil
+	return attachmentPaths, nil
 }
 
-// DeleteZendeskTicket deletes a ticket from Zendesk.
-func DeleteZendeskTicket(ticketId string) error {
-	apiKey := os.Getenv("ZENDESK_API_KEY")
-	if apiKey == "" {
-		return errors.New("ZENDESK_API_KEY is not set")
-	}
-	userEmail := os.Getenv("ZENDESK_USER")
-	if userEmail == "" {
-		return errors.New("ZENDESK_USER is not set")
-	}
-	domain := os.Getenv("ZENDESK_DOMAIN")
-	if domain == "" {
-		return errors.New("ZENDESK_DOMAIN is not set")
+// removeAttachmentFiles deletes temp files created by GetAttachments.
+func removeAttachmentFiles(paths []string) {
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Error removing attachment file %s: %v", path, err)
+		}
 	}
+}
 
-	url := fmt.Sprintf("https://%s.zendesk.com/api/v2/tickets/%s.json", domain, ticketId)
-	headers := map[string]string{
-		"Content-Type": "application/json",
+func attachmentMaxBytes() int64 {
+	raw := strings.TrimSpace(os.Getenv("ZENDESK_ATTACHMENT_MAX_BYTES"))
+	if raw == "" {
+		return defaultAttachmentMaxBytes
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n <= 0 {
+		log.Printf("Invalid ZENDESK_ATTACHMENT_MAX_BYTES %q, using %d", raw, defaultAttachmentMaxBytes)
+		return defaultAttachmentMaxBytes
 	}
+	return n
+}
 
-	client := &http.Client{}
-	req, err := http.NewRequest("DELETE", url, nil)
+func ReplyToTicket(ticketId string, message string) error {
+	client, err := NewZendeskClientFromEnv()
 	if err != nil {
 		return err
 	}
-	for k, v := range headers {
-		req.Header.Set(k, v)
+	if err := client.AddComment(ticketId, message, true); err != nil {
+		return fmt.Errorf("failed to add comment to ticket %s: %w", ticketId, err)
 	}
-	req.SetBasicAuth(userEmail+"/token", apiKey)
+	return nil
+}
 
-	resp, err := client.Do(req)
+// ticketAttachment is a file sent along with a reply.
+type ticketAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// ReplyToTicketWithAttachments adds a public comment carrying the given files.
+func ReplyToTicketWithAttachments(ticketId string, message string, attachments []ticketAttachment) error {
+	client, err := NewZendeskClientFromEnv()
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 300 {
-		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to delete ticket %s: status %d: %s", ticketId, resp.StatusCode, string(respBody))
+	var uploads []string
+	for _, attachment := range attachments {
+		token, err := client.Upload(attachment.Name, attachment.ContentType, attachment.Data)
+		if err != nil {
+			return fmt.Errorf("failed to upload %s for ticket %s: %w", attachment.Name, ticketId, err)
+		}
+		uploads = append(uploads, token)
+	}
+	if err := client.AddCommentWithUploads(ticketId, message, true, uploads); err != nil {
+		return fmt.Errorf("failed to add comment to ticket %s: %w", ticketId, err)
 	}
-
 	return nil
 }
 
-// GetTicketComments retrieves all comments for a ticket.
-func GetTicketComments(ticketId string) ([]map[string]interface{}, error) {
-	apiKey := os.Getenv("ZENDESK_API_KEY")
-	if apiKey == "" {
-		return nil, errors.New("ZENDESK_API_KEY is not set")
-	}
-	userEmail := os.Getenv("ZENDESK_USER")
-	if userEmail == "" {
-		return nil, errors.New("ZENDESK_USER is not set")
+// AddInternalNote adds a private comment that only agents can see.
+func AddInternalNote(ticketId string, message string) error {
+	client, err := NewZendeskClientFromEnv()
+	if err != nil {
+		return err
 	}
-	domain := os.Getenv("ZENDESK_DOMAIN")
-	if domain == "" {
-		return nil, errors.New("ZENDESK_DOMAIN is not set")
+	if err := client.AddComment(ticketId, message, false); err != nil {
+		return fmt.Errorf("failed to add internal note to ticket %s: %w", ticketId, err)
 	}
+	return nil
+}
 
-	url := fmt.Sprintf("https://%s.zendesk.com/api/v2/tickets/%s/comments.json", domain, ticketId)
-	headers := map[string]string{
-		"Content-Type": "application/json",
+// AddTagsToTicket appends the provided tags to the given ticket.
+func AddTagsToTicket(ticketId string, tags []string) error {
+	if len(tags) == 0 {
+		return nil
 	}
-
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
+	client, err := NewZendeskClientFromEnv()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	for k, v := range headers {
-		req.Header.Set(k, v)
+	if err := client.AddTags(ticketId, tags); err != nil {
+		return fmt.Errorf("failed to tag ticket %s: %w", ticketId, err)
 	}
-	req.SetBasicAuth(userEmail+"/token", apiKey)
+	return nil
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+// RemoveTagsFromTicket removes the provided tags from the given ticket.
+func RemoveTagsFromTicket(ticketId string, tags []string) error {
+	if len(tags) == 0 {
+		return nil
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+	client, err := NewZendeskClientFromEnv()
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	if resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("failed to get comments for ticket %s: status %d: %s", ticketId, resp.StatusCode, string(body))
+	if err := client.RemoveTags(ticketId, tags); err != nil {
+		return fmt.Errorf("failed to remove tags from ticket %s: %w", ticketId, err)
 	}
+	return nil
+}
 
-	var response struct {
-		Comments []map[string]interface{} `json:"comments"`
+// CreateZendeskTicket creates a new ticket in Zendesk via API.
+// Returns the created ticket ID and the full ticket object.
+func CreateZendeskTicket(subject, description, recipientEmail string) (string, *ZendeskTicket, error) {
+	client, err := NewZendeskClientFromEnv()
+	if err != nil {
+		return "", nil, err
 	}
-	if err := json.Unmarshal(body, &response); err != nil {
-		return nil, fmt.Errorf("failed to parse comments response: %w", err)
+	ticket, err := client.CreateTicket(subject, description, recipientEmail)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create ticket: %w", err)
 	}
-
-	return response.Comments, nil
+	return ticket.ID, ticket, nil
 }
 
-// GetTicketTags retrieves tags for a ticket.
-func GetTicketTags(ticketId string) ([]string, error) {
-	apiKey := os.Getenv("ZENDESK_API_KEY")
-	if apiKey == "" {
-		return nil, errors.New("ZENDESK_API_KEY is not set")
-	}
-	userEmail := os.Getenv("ZENDESK_USER")
-	if userEmail == "" {
-		return nil, errors.New("ZENDESK_USER is not set")
-	}
-	domain := os.Getenv("ZENDESK_DOMAIN")
-	if domain == "" {
-		return nil, errors.New("ZENDESK_DOMAIN is not set")
+// DeleteZendeskTicket deletes a ticket from Zendesk.
+func DeleteZendeskTicket(ticketId string) error {
+	client, err := NewZendeskClientFromEnv()
+	if err != nil {
+		return err
 	}
-
-	url := fmt.Sprintf("https://%s.zendesk.com/api/v2/tickets/%s.json", domain, ticketId)
-	headers := map[string]string{
-		"Content-Type": "application/json",
+	if err := client.DeleteTicket(ticketId); err != nil {
+		return fmt.Errorf("failed to delete ticket %s: %w", ticketId, err)
 	}
+	return nil
+}
 
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
+// ListTicketComments returns all comments of a ticket in chronological order.
+func ListTicketComments(ticketId string) ([]ZendeskComment, error) {
+	client, err := NewZendeskClientFromEnv()
 	if err != nil {
 		return nil, err
 	}
-	for k, v := range headers {
-		req.Header.Set(k, v)
+	comments, err := client.ListComments(ticketId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list comments of ticket %s: %w", ticketId, err)
 	}
-	req.SetBasicAuth(userEmail+"/token", apiKey)
+	return comments, nil
+}
 
-	resp, err := client.Do(req)
+// GetTicketComments retrieves all comments for a ticket.
+func GetTicketComments(ticketId string) ([]map[string]interface{}, error) {
+	client, err := NewZendeskClientFromEnv()
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	comments, err := client.GetComments(ticketId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get comments for ticket %s: %w", ticketId, err)
+	}
+	return comments, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
+// GetTicketTags retrieves tags for a ticket.
+func GetTicketTags(ticketId string) ([]string, error) {
+	client, err := NewZendeskClientFromEnv()
 	if err != nil {
 		return nil, err
 	}
-
-	if resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("failed to get ticket %s: status %d: %s", ticketId, resp.StatusCode, string(body))
+	tags, err := client.GetTags(ticketId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tags of ticket %s: %w", ticketId, err)
 	}
+	return tags, nil
+}
 
-
-	var response struct {
-		Ticket struct {
-			Tags []string `json:"tags"`
-		} `json:"ticket"`
+// SearchZendeskTickets returns all tickets matching a Zendesk search query.
+func SearchZendeskTickets(query string) ([]ZendeskTicket, error) {
+	client, err := NewZendeskClientFromEnv()
+	if err != nil {
+		return nil, err
 	}
-	if err := json.Unmarshal(body, &response); err != nil {
-		return nil, fmt.Errorf("failed to parse ticket response: %w", err)
+	tickets, err := client.SearchTickets(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search tickets: %w", err)
 	}
-
-	return response.Ticket.Tags, nil
+	return tickets, nil
 }
 
 // IsTicketInTCOView checks if a ticket appears in the TCO view by querying the view directly.
 // First finds the view by name "TCO - Handled Tickets", then executes it and checks if the ticket is in the results.
 func IsTicketInTCOView(ticketId string) (bool, error) {
-	apiKey := os.Getenv("ZENDESK_API_KEY")
-	if apiKey == "" {
-		return false, errors.New("ZENDESK_API_KEY is not set")
-	}
-	userEmail := os.Getenv("ZENDESK_USER")
-	if userEmail == "" {
-		return false, errors.New("ZENDESK_USER is not set")
-	}
-	domain := os.Getenv("ZENDESK_DOMAIN")
-	if domain == "" {
-		return false, errors.New("ZENDESK_DOMAIN is not set")
-	}
-
-	client := &http.Client{}
-
-	// Step 1: Find the TCO view by name
-	viewsURL := fmt.Sprintf("https://%s.zendesk.com/api/v2/views.json", domain)
-	req, err := http.NewRequest("GET", viewsURL, nil)
-	if err != nil {
-		return false, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.SetBasicAuth(userEmail+"/token", apiKey)
-
-	resp, err := client.Do(req)
+	client, err := NewZendeskClientFromEnv()
 	if err != nil {
 		return false, err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	// Step 1: Find the TCO view by name
+	views, err := client.ListViews()
 	if err != nil {
-		return false, err
-	}
-
-	if resp.StatusCode >= 300 {
-		return false, fmt.Errorf("failed to list views: status %d: %s", resp.StatusCode, string(body))
-	}
-
-	var viewsResponse struct {
-		Views []struct {
-			ID    interface{} `json:"id"` // ID can be number or string
-			Title string       `json:"title"`
-		} `json:"views"`
-	}
-	if err := json.Unmarshal(body, &viewsResponse); err != nil {
-		return false, fmt.Errorf("failed to parse views response: %w", err)
+		return false, fmt.Errorf("failed to list views: %w", err)
 	}
 
-	// Find the TCO view
 	var viewID string
-	for _, view := range viewsResponse.Views {
-		if view.Title == "TCO - Handled Tickets" {
-			switch v := view.ID.(type) {
-			case string:
-				viewID = v
-			case float64:
-				viewID = fmt.Sprintf("%.0f", v)
-			case int:
-				viewID = fmt.Sprintf("%d", v)
-			case int64:
-				viewID = fmt.Sprintf("%d", v)
-			default:
-				viewID = fmt.Sprintf("%v", v)
-			}
+	for _, view := range views {
+		if view.Title == tcoViewTitle {
+			viewID = view.ID
 			break
 		}
 	}
 
 	if viewID == "" {
 		// Log available view titles for debugging
-		viewTitles := make([]string, 0, len(viewsResponse.Views))
-		for _, view := range viewsResponse.Views {
+		viewTitles := make([]string, 0, len(views))
+		for _, view := range views {
 			viewTitles = append(viewTitles, view.Title)
 		}
-		return false, fmt.Errorf("TCO view 'TCO - Handled Tickets' not found. Available views: %v", viewTitles)
+		return false, fmt.Errorf("TCO view '%s' not found. Available views: %v", tcoViewTitle, viewTitles)
 	}
 
 	// Step 2: Execute the view to get tickets
-	executeURL := fmt.Sprintf("https://%s.zendesk.com/api/v2/views/%s/execute.json", domain, viewID)
-	req, err = http.NewRequest("GET", executeURL, nil)
-	if err != nil {
-		return false, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.SetBasicAuth(userEmail+"/token", apiKey)
-
-	resp, err = client.Do(req)
-	if err != nil {
-		return false, err
-	}
-	defer resp.Body.Close()
-
-	body, err = io.ReadAll(resp.Body)
+	ticketIDs, err := client.ExecuteView(viewID)
 	if err != nil {
-		return false, err
-	}
-
-	if resp.StatusCode >= 300 {
-		return false, fmt.Errorf("failed to execute view: status %d: %s", resp.StatusCode, string(body))
+		return false, fmt.Errorf("failed to execute view: %w", err)
 	}
-
-	// The view execute response format: rows contain ticket objects with nested id
-	var executeResponse struct {
-		Rows []struct {
-			Ticket struct {
-				ID interface{} `json:"id"` // ID is nested in ticket object
-			} `json:"ticket"`
-			TicketID interface{} `json:"ticket_id"` // Fallback: direct ticket_id field
-			ID       interface{} `json:"id"`        // Fallback: direct id field
-		} `json:"rows"`
-		// Some views return tickets directly
-	
-	}
-	if err := json.Unmarshal(body, &executeResponse); err != nil {
-		return false, fmt.Errorf("failed to parse view execute response: %w, body: %s", err, string(body))
-	}
-
-	// Check if our ticket ID is in the rows (most common format)
-	for _, row := range executeResponse.Rows {
-		var rowTicketID string
-		// Try ticket.id first (the actual format), then ticket_id, then id
-		var idValue interface{}
-		if row.Ticket.ID != nil {
-			idValue = row.Ticket.ID
-		} else if row.TicketID != nil {
-			idValue = row.TicketID
-		} else if row.ID != nil {
-			idValue = row.ID
-		}
-		if idValue == nil {
-			continue
-		}
-		switch v := idValue.(type) {
-		case string:
-			rowTicketID = v
-		case float64:
-			rowTicketID = fmt.Sprintf("%.0f", v)
-		case int:
-			rowTicketID = fmt.Sprintf("%d", v)
-		case int64:
-			rowTicketID = fmt.Sprintf("%d", v)
-		default:
-			rowTicketID = fmt.Sprintf("%v", v)
-		}
-		if rowTicketID == ticketId {
+	for _, id := range ticketIDs {
+		if id == ticketId {
 			return true, nil
 		}
 	}
 
 	// Ticket not found in view. Check if ticket meets view criteria to provide helpful error
-	tags, _ := GetTicketTags(ticketId)
+	tags, err := client.GetTags(ticketId)
+	if err != nil {
+		log.Printf("Error getting tags of ticket %s: %v", ticketId, err)
+	}
 	hasAgentTag := false
 	hasDecisionTag := false
 	for _, tag := range tags {
-		if tag == "tco-vo" {
+		if tag == agentTag {
 			hasAgentTag = true
 		}
-		if tag == "tco-vo-decision-banned" || tag == "tco-vo-decision-not-found" || tag == "tco-vo-decision-more-info" {
+		if strings.HasPrefix(tag, decisionTagPrefix) {
 			hasDecisionTag = true
 		}
 	}
-	
+
 	if !hasAgentTag {
-		return false, fmt.Errorf("ticket not in TCO view: missing required tag 'tco-vo'. Current tags: %v", tags)
+		return false, fmt.Errorf("ticket not in TCO view: missing required tag '%s'. Current tags: %v", agentTag, tags)
 	}
 	if !hasDecisionTag {
 		return false, fmt.Errorf("ticket not in TCO view: missing decision tag. Current tags: %v", tags)
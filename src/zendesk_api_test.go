@@ -0,0 +1,330 @@
+package tco_vo_agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeZendesk is an in-memory stand-in for the Zendesk API.
+type fakeZendesk struct {
+	mu       sync.Mutex
+	tickets  map[string]ZendeskTicket
+	comments map[string][]map[string]interface{}
+	tags     map[string][]string
+	files    map[string][]byte
+	// uploads holds the files uploaded for comments, by upload token.
+	uploads  map[string][]byte
+	requests []string
+	// storageAuth records the Authorization header seen by the storage host.
+	storageAuth []string
+	url         string
+}
+
+func newFakeZendesk() *fakeZendesk {
+	return &fakeZendesk{
+		tickets:  map[string]ZendeskTicket{},
+		comments: map[string][]map[string]interface{}{},
+		tags:     map[string][]string{},
+		files:    map[string][]byte{},
+		uploads:  map[string][]byte{},
+	}
+}
+
+// useFakeZendesk starts a fake Zendesk and points the client configuration at it.
+func useFakeZendesk(t *testing.T) *fakeZendesk {
+	t.Helper()
+
+	fake := newFakeZendesk()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	fake.url = server.URL
+
+	t.Setenv("ZENDESK_BASE_URL", server.URL)
+	t.Setenv("ZENDESK_API_KEY", "test-key")
+	t.Setenv("ZENDESK_USER", "agent@example.com")
+	return fake
+}
+
+func (f *fakeZendesk) addTicket(ticket ZendeskTicket) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tickets[ticket.ID] = ticket
+	f.tags[ticket.ID] = append([]string{}, ticket.Tags...)
+}
+
+// addAttachment adds a comment carrying a file. The content URL redirects to a
+// storage path, like Zendesk does, so tests can check credentials are not forwarded.
+func (f *fakeZendesk) addAttachment(ticketID, fileName, contentType string, content []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[fileName] = content
+	f.comments[ticketID] = append(f.comments[ticketID], map[string]interface{}{
+		"body":   "see attachment",
+		"public": true,
+		"attachments": []map[string]interface{}{{
+			"id":           len(f.files),
+			"file_name":    fileName,
+			"content_type": contentType,
+			"content_url":  f.url + "/attachments/token/" + fileName,
+			"size":         len(content),
+		}},
+	})
+}
+
+func (f *fakeZendesk) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+
+	if name, ok := strings.CutPrefix(r.URL.Path, "/storage/"); ok {
+		f.storageAuth = append(f.storageAuth, r.Header.Get("Authorization"))
+		w.Write(f.files[name])
+		return
+	}
+
+	if user, pass, ok := r.BasicAuth(); !ok || user != "agent@example.com/token" || pass != "test-key" {
+		http.Error(w, `{"error":"Couldn't authenticate you"}`, http.StatusUnauthorized)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	var payload map[string]interface{}
+	json.Unmarshal(body, &payload)
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v2/")
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/attachments/token/"):
+		// real Zendesk redirects to a different storage host
+		http.Redirect(w, r, strings.Replace(f.url, "127.0.0.1", "localhost", 1)+"/storage/"+strings.TrimPrefix(r.URL.Path, "/attachments/token/"), http.StatusFound)
+
+	case path == "tickets/show_many.json":
+		var tickets []ZendeskTicket
+		for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+			if ticket, ok := f.tickets[id]; ok {
+				tickets = append(tickets, ticket)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"tickets": tickets})
+
+	case strings.HasSuffix(path, "/tags.json"):
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "tickets/"), "/tags.json")
+		var tags []string
+		if raw, ok := payload["tags"].([]interface{}); ok {
+			for _, tag := range raw {
+				tags = append(tags, tag.(string))
+			}
+		}
+		switch r.Method {
+		case http.MethodPut:
+			for _, tag := range tags {
+				if !hasTag(f.tags[id], tag) {
+					f.tags[id] = append(f.tags[id], tag)
+				}
+			}
+		case http.MethodDelete:
+			var kept []string
+			for _, tag := range f.tags[id] {
+				if !hasTag(tags, tag) {
+					kept = append(kept, tag)
+				}
+			}
+			f.tags[id] = kept
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"tags": f.tags[id]})
+
+	case strings.HasSuffix(path, "/comments.json"):
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "tickets/"), "/comments.json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"comments": f.comments[id]})
+
+	case strings.HasPrefix(path, "tickets/") && strings.HasSuffix(path, ".json"):
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "tickets/"), ".json")
+		ticket, ok := f.tickets[id]
+		if !ok {
+			http.Error(w, `{"error":"RecordNotFound"}`, http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			ticket.Tags = f.tags[id]
+			json.NewEncoder(w).Encode(map[string]interface{}{"ticket": ticket})
+		case http.MethodPut:
+			if update, ok := payload["ticket"].(map[string]interface{}); ok {
+				if comment, ok := update["comment"].(map[string]interface{}); ok {
+					f.comments[id] = append(f.comments[id], comment)
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"ticket": ticket})
+		case http.MethodDelete:
+			delete(f.tickets, id)
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	case path == "uploads.json":
+		token := fmt.Sprintf("token-%d-%s", len(f.uploads)+1, r.URL.Query().Get("filename"))
+		f.uploads[token] = body
+		json.NewEncoder(w).Encode(map[string]interface{}{"upload": map[string]string{"token": token}})
+
+	case path == "search/export.json":
+		// one ticket per page, to exercise the cursor
+		var ids []string
+		for id := range f.tickets {
+			if hasTag(f.tags[id], agentTag) && id > r.URL.Query().Get("page[after]") {
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+		response := map[string]interface{}{"results": []ZendeskTicket{}, "meta": map[string]bool{"has_more": false}}
+		if len(ids) > 0 {
+			response["results"] = []ZendeskTicket{f.tickets[ids[0]]}
+			query := r.URL.Query()
+			query.Set("page[after]", ids[0])
+			response["meta"] = map[string]bool{"has_more": len(ids) > 1}
+			response["links"] = map[string]string{"next": f.url + r.URL.Path + "?" + query.Encode()}
+		}
+		json.NewEncoder(w).Encode(response)
+
+	case path == "views.json":
+		fmt.Fprintf(w, `{"views":[{"id":1,"title":"Other"},{"id":360001,"title":%q}]}`, tcoViewTitle)
+
+	case path == "views/360001/execute.json":
+		var rows []string
+		for id := range f.tickets {
+			if hasTag(f.tags[id], agentTag) {
+				rows = append(rows, fmt.Sprintf(`{"ticket":{"id":%s}}`, id))
+			}
+		}
+		fmt.Fprintf(w, `{"rows":[%s]}`, strings.Join(rows, ","))
+
+	default:
+		http.Error(w, `{"error":"InvalidEndpoint"}`, http.StatusNotFound)
+	}
+}
+
+func TestZendeskClientFromEnv(t *testing.T) {
+	t.Setenv("ZENDESK_API_KEY", "k")
+	t.Setenv("ZENDESK_USER", "u@example.com")
+	t.Setenv("ZENDESK_DOMAIN", "acme")
+	t.Setenv("ZENDESK_BASE_URL", "")
+	t.Setenv("ZENDESK_TIMEOUT", "5s")
+
+	client, err := NewZendeskClientFromEnv()
+	if err != nil {
+		t.Fatalf("NewZendeskClientFromEnv returned error: %v", err)
+	}
+	if client.BaseURL != "https://acme.zendesk.com" {
+		t.Fatalf("BaseURL = %q, want https://acme.zendesk.com", client.BaseURL)
+	}
+	if client.HTTPClient.Timeout.String() != "5s" || client.HTTPClient.Transport != zendeskTransport {
+		t.Fatalf("unexpected HTTP client: %+v", client.HTTPClient)
+	}
+
+	t.Setenv("ZENDESK_BASE_URL", "http://localhost:9999/")
+	client, _ = NewZendeskClientFromEnv()
+	if client.BaseURL != "http://localhost:9999" {
+		t.Fatalf("BaseURL override = %q, want http://localhost:9999", client.BaseURL)
+	}
+
+	t.Setenv("ZENDESK_API_KEY", "")
+	if _, err := NewZendeskClientFromEnv(); err == nil || !strings.Contains(err.Error(), "ZENDESK_API_KEY") {
+		t.Fatalf("expected missing API key error, got %v", err)
+	}
+}
+
+func TestZendeskHelpersAgainstFake(t *testing.T) {
+	fake := useFakeZendesk(t)
+	fake.addTicket(ZendeskTicket{ID: "5158", Subject: "order", Tags: []string{"existing"}})
+
+	ticket, err := FetchZendeskTicket("5158")
+	if err != nil || ticket.Subject != "order" {
+		t.Fatalf("FetchZendeskTicket = %+v, %v", ticket, err)
+	}
+
+	tickets, err := FetchZendeskTickets([]string{"5158", "404"})
+	if err != nil || len(tickets) != 1 {
+		t.Fatalf("FetchZendeskTickets = %+v, %v", tickets, err)
+	}
+
+	if _, err := FetchZendeskTicket("404"); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	if err := AddTagsToTicket("5158", []string{agentTag, decisionTagBanned}); err != nil {
+		t.Fatalf("AddTagsToTicket returned error: %v", err)
+	}
+	if err := RemoveTagsFromTicket("5158", []string{decisionTagBanned}); err != nil {
+		t.Fatalf("RemoveTagsFromTicket returned error: %v", err)
+	}
+	tags, err := GetTicketTags("5158")
+	if err != nil || !reflect.DeepEqual(tags, []string{"existing", agentTag}) {
+		t.Fatalf("GetTicketTags = %v, %v", tags, err)
+	}
+
+	if err := ReplyToTicket("5158", "hello"); err != nil {
+		t.Fatalf("ReplyToTicket returned error: %v", err)
+	}
+	comments, err := GetTicketComments("5158")
+	if err != nil || len(comments) != 1 || comments[0]["body"] != "hello" || comments[0]["public"] != true {
+		t.Fatalf("GetTicketComments = %+v, %v", comments, err)
+	}
+
+	inView, err := IsTicketInTCOView("5158")
+	if err != nil || !inView {
+		t.Fatalf("IsTicketInTCOView = %v, %v", inView, err)
+	}
+}
+
+func TestGetAttachmentsDownloadsContent(t *testing.T) {
+	fake := useFakeZendesk(t)
+	fake.addTicket(ZendeskTicket{ID: "77"})
+	fake.addAttachment("77", "order.pdf", "application/pdf", []byte("%PDF-1.4 removal order"))
+	fake.addAttachment("77", "notes.bin", "application/octet-stream", []byte("ignored"))
+
+	paths, err := GetAttachments("77")
+	if err != nil {
+		t.Fatalf("GetAttachments returned error: %v", err)
+	}
+	t.Cleanup(func() { removeAttachmentFiles(paths) })
+
+	// the comment bodies come first, then the PDF; notes.bin sniffs as neither
+	if len(paths) != 2 || !strings.HasSuffix(paths[0], ".txt") || !strings.HasSuffix(paths[1], ".pdf") {
+		t.Fatalf("unexpected attachment paths: %v", paths)
+	}
+	content, err := os.ReadFile(paths[1])
+	if err != nil || string(content) != "%PDF-1.4 removal order" {
+		t.Fatalf("attachment content = %q, %v; want the file itself", content, err)
+	}
+	if text, _ := os.ReadFile(paths[0]); !strings.Contains(string(text), "see attachment") {
+		t.Fatalf("ticket text = %q, want the comment bodies", text)
+	}
+	if len(fake.storageAuth) != 2 || fake.storageAuth[0] != "" || fake.storageAuth[1] != "" {
+		t.Fatalf("credentials must not be forwarded to the storage host, got %q", fake.storageAuth)
+	}
+
+	removeAttachmentFiles(paths)
+	if _, err := os.Stat(paths[1]); !os.IsNotExist(err) {
+		t.Fatalf("expected attachment file to be removed, stat err = %v", err)
+	}
+}
+
+func TestGetAttachmentsEnforcesSizeLimit(t *testing.T) {
+	fake := useFakeZendesk(t)
+	fake.addTicket(ZendeskTicket{ID: "78"})
+	fake.addAttachment("78", "huge.pdf", "application/pdf", []byte(strings.Repeat("x", 64)))
+	t.Setenv("ZENDESK_ATTACHMENT_MAX_BYTES", "16")
+
+	if _, err := GetAttachments("78"); err == nil || !strings.Contains(err.Error(), "limit") {
+		t.Fatalf("expected size limit error, got %v", err)
+	}
+}